@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/articleio"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const usage = `Usage:
+  articles export -format jsonl|markdown|csv [-out path]
+  articles import -format jsonl|markdown|csv -in path [-on-conflict skip|overwrite|fail]
+
+Markdown exports and imports use a directory with one file per article.
+JSONL and CSV use a single file, or stdout/stdin when no path is given.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Keep stdout free for exported data
+	logger.DualLog = log.New(os.Stderr, "", log.LstdFlags)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	_, err = database.InitDB(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "jsonl", "export format")
+	out := flags.String("out", "", "output file, or directory for markdown")
+	flags.Parse(args)
+
+	format, err := articleio.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	records, err := articleio.Export()
+	if err != nil {
+		return fmt.Errorf("error exporting articles: %v", err)
+	}
+
+	if format == articleio.FormatMarkdown {
+		if *out == "" {
+			return fmt.Errorf("markdown export needs an -out directory")
+		}
+		err = articleio.WriteMarkdownDir(*out, records)
+	} else {
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if format == articleio.FormatCSV {
+			err = articleio.WriteCSV(w, records)
+		} else {
+			err = articleio.WriteJSONL(w, records)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error writing articles: %v", err)
+	}
+
+	log.Printf("Exported %d articles", len(records))
+	return nil
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	formatName := flags.String("format", "jsonl", "import format")
+	in := flags.String("in", "", "input file, or directory for markdown")
+	conflict := flags.String("on-conflict", "skip", "what to do when a slug already exists")
+	flags.Parse(args)
+
+	format, err := articleio.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	policy, err := articleio.ParseConflictPolicy(*conflict)
+	if err != nil {
+		return err
+	}
+
+	var records []articleio.Record
+	if format == articleio.FormatMarkdown {
+		if *in == "" {
+			return fmt.Errorf("markdown import needs an -in directory")
+		}
+		records, err = articleio.ReadMarkdownDir(*in)
+	} else {
+		var r io.Reader = os.Stdin
+		if *in != "" {
+			f, err := os.Open(*in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		if format == articleio.FormatCSV {
+			records, err = articleio.ReadCSV(r)
+		} else {
+			records, err = articleio.ReadJSONL(r)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error reading articles: %v", err)
+	}
+
+	result, err := articleio.Import(records, policy)
+	if err != nil {
+		return fmt.Errorf("error importing articles: %v", err)
+	}
+	for _, itemErr := range result.Errors {
+		log.Printf("Skipped %q: %s", itemErr.Slug, itemErr.Message)
+	}
+	log.Printf("Imported articles: %d created, %d updated, %d skipped, %d failed", result.Created, result.Updated, result.Skipped, len(result.Errors))
+	return nil
+}
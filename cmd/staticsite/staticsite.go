@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// Renders the published articles to plain files for a mirror that runs
+// without the Go server. Run it from the repository root so the templates
+// and static assets are found.
+func main() {
+	out := flag.String("out", "public", "output directory")
+	staticDir := flag.String("static", "static", "static assets directory to copy")
+	baseURL := flag.String("base-url", "", "public URL of the mirror, e.g. https://example.com")
+	flag.Parse()
+
+	logger.InitLogger(os.Stderr)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	_, err = database.InitDB(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	err = internal.ExportStaticSite(internal.StaticSiteOptions{
+		OutDir:    *out,
+		StaticDir: *staticDir,
+		BaseURL:   *baseURL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to export static site: %v", err)
+	}
+}
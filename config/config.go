@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -13,6 +15,15 @@ type Configuration struct {
 	Image         ImageConfig
 	Migration     MigrationConfig
 	JWT           JWTConfig
+	Comments      CommentsConfig
+	Ratings       RatingsConfig
+	Trash         TrashConfig
+	Batch         BatchConfig
+	Analytics     AnalyticsConfig
+	Recurrence    RecurrenceConfig
+	Mail          MailConfig
+	Accounts      AccountsConfig
+	OIDC          OIDCConfig
 }
 
 type DatabaseConfig struct {
@@ -58,5 +69,165 @@ type MigrationConfig struct {
 }
 
 type JWTConfig struct {
+	// Phrase is an HS256 secret to sign tokens with when no Keys are
+	// configured. Without either, the JWT_SECRET environment variable is
+	// used.
 	Phrase string
+	// Keys are the keys tokens are signed and verified with. Keeping an old
+	// key listed after signing with a new one lets the tokens it signed
+	// run out; it can be listed by its public key alone.
+	Keys []JWTKeyConfig `mapstructure:"keys"`
+	// SigningKey is the ID of the key that signs new tokens, by default the
+	// first of Keys
+	SigningKey string `mapstructure:"signing_key"`
+	// Issuer and Audience are written into access tokens, and tokens that
+	// don't carry them are rejected
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+	// TTL is how long an access token stays valid
+	TTL time.Duration `mapstructure:"ttl"`
+	// RefreshTTL is how long a session lasts without being refreshed
+	RefreshTTL time.Duration `mapstructure:"refresh_ttl"`
+	// Cookie names the cookie signing in stores the access token in, so the
+	// site's own pages, which call the API from the browser, are signed in.
+	// Tokens are also read from it. It defaults to access_token.
+	Cookie string `mapstructure:"cookie"`
+}
+
+// JWTKeyConfig is a token signing key, read from a file.
+type JWTKeyConfig struct {
+	// ID is sent in the kid header of the tokens the key signs
+	ID string `mapstructure:"id"`
+	// Algorithm is HS256, RS256 or EdDSA
+	Algorithm string `mapstructure:"algorithm"`
+	// File holds the HS256 secret, or a PEM private key, or a PEM public
+	// key for a key that only verifies
+	File string `mapstructure:"file"`
+}
+
+type CommentsConfig struct {
+	// GuestRateLimit is how many comments a guest IP may post per
+	// GuestRateWindow
+	GuestRateLimit  int           `mapstructure:"guest_rate_limit"`
+	GuestRateWindow time.Duration `mapstructure:"guest_rate_window"`
+	// MemberRateLimit is how many comments a signed-in user may post per
+	// MemberRateWindow
+	MemberRateLimit  int           `mapstructure:"member_rate_limit"`
+	MemberRateWindow time.Duration `mapstructure:"member_rate_window"`
+}
+
+type RatingsConfig struct {
+	// GuestKey is a secret that guests' IP addresses are hashed with, so
+	// their ratings can be told apart without storing the addresses. When
+	// it's unset a random key is used, and guests can rate again after a
+	// restart.
+	GuestKey string `mapstructure:"guest_key"`
+}
+
+type TrashConfig struct {
+	// RetentionDays is how long deleted articles and tasks can be restored
+	// before they are purged
+	RetentionDays int `mapstructure:"retention_days"`
+	// PurgeInterval is how often the purge job runs
+	PurgeInterval time.Duration `mapstructure:"purge_interval"`
+}
+
+type BatchConfig struct {
+	// MaxSize is the most articles a bulk mutation may change at once
+	MaxSize int `mapstructure:"max_size"`
+}
+
+type AnalyticsConfig struct {
+	// FlushInterval is how often recorded article views are written
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// MaxPending forces an early write once this many article/day counts
+	// are waiting
+	MaxPending int `mapstructure:"max_pending"`
+}
+
+type RecurrenceConfig struct {
+	// Interval is how often recurring task templates are checked for tasks
+	// to generate
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type MailConfig struct {
+	// Driver is how email is sent: smtp, file (written to Dir) or log, the
+	// default, which prints it
+	Driver string `mapstructure:"driver"`
+	// From is the sender address
+	From string     `mapstructure:"from"`
+	Dir  string     `mapstructure:"dir"`
+	SMTP SMTPConfig `mapstructure:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type AccountsConfig struct {
+	// PasswordResetTTL is how long a password reset link works
+	PasswordResetTTL time.Duration `mapstructure:"password_reset_ttl"`
+	// PasswordResetURL is the page that resets a password. The token from
+	// the email is added to it as the token parameter.
+	PasswordResetURL string `mapstructure:"password_reset_url"`
+	// RequireVerifiedEmail stops users who haven't verified their email
+	// address from generating or publishing articles
+	RequireVerifiedEmail bool `mapstructure:"require_verified_email"`
+	// VerifyEmailTTL is how long an email verification link works
+	VerifyEmailTTL time.Duration `mapstructure:"verify_email_ttl"`
+	// VerifyEmailURL is the page that verifies an email address, by default
+	// this server's /verify-email. The token is added as its token
+	// parameter.
+	VerifyEmailURL string `mapstructure:"verify_email_url"`
+	// MaxLoginFailures is how many failed sign-ins in a row lock an
+	// account out for LockoutDuration. Each further failure doubles the
+	// lockout, up to MaxLockoutDuration.
+	MaxLoginFailures int `mapstructure:"max_login_failures"`
+	// MaxIPLoginFailures does the same for failures from one IP address,
+	// whichever accounts they were for
+	MaxIPLoginFailures int           `mapstructure:"max_ip_login_failures"`
+	LockoutDuration    time.Duration `mapstructure:"lockout_duration"`
+	MaxLockoutDuration time.Duration `mapstructure:"max_lockout_duration"`
+	// AdminEmail is the address of an account made an admin when the
+	// server starts, so a new install can be managed. Register the account,
+	// then restart the server.
+	AdminEmail string `mapstructure:"admin_email"`
+}
+
+type OIDCConfig struct {
+	// Providers are the OpenID Connect providers users can sign in with,
+	// such as the company's identity provider
+	Providers []OIDCProviderConfig `mapstructure:"providers"`
+	// RegisterUsers creates an account for someone who signs in with a
+	// provider under a verified email address no one here has. Otherwise
+	// only existing users can sign in with a provider.
+	RegisterUsers bool `mapstructure:"register_users"`
+	// LoginRedirectURL is the page the browser is sent to after signing in
+	// with a provider, by default /. The tokens, or an error, are added to
+	// its fragment.
+	LoginRedirectURL string `mapstructure:"login_redirect_url"`
+}
+
+// OIDCProviderConfig is how this server is registered with a provider.
+// An account at the provider is linked to the user with the same email
+// address the first time it signs in, if the provider has verified the
+// address, so list only providers trusted to verify them.
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs and linked accounts, and should
+	// not change
+	Name string `mapstructure:"name"`
+	// Issuer is the provider's issuer URL, which its metadata is
+	// discovered under
+	Issuer       string `mapstructure:"issuer"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// RedirectURL is this server's /auth/oidc/{name}/callback, as
+	// registered with the provider
+	RedirectURL string `mapstructure:"redirect_url"`
+	// Scopes are requested as well as openid; by default email and profile
+	Scopes []string `mapstructure:"scopes"`
 }
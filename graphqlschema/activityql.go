@@ -0,0 +1,95 @@
+package graphqlschema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// activityResolver adapts a function of the source activity entry into a
+// field resolver.
+func activityResolver(resolve func(activity database.Activity) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		activity, ok := p.Source.(database.Activity)
+		if !ok {
+			return nil, fmt.Errorf("expected type database.Activity but got %T", p.Source)
+		}
+		return resolve(activity)
+	}
+}
+
+var ActivitySubjectEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ActivitySubject",
+	Values: graphql.EnumValueConfigMap{
+		"TASK":    &graphql.EnumValueConfig{Value: database.ActivityTask},
+		"ARTICLE": &graphql.EnumValueConfig{Value: database.ActivityArticle},
+		"USER":    &graphql.EnumValueConfig{Value: database.ActivityUser},
+	},
+})
+
+var ActivityType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Activity",
+	Description: "A change to a task, article or user",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"subjectType": &graphql.Field{
+			Type: ActivitySubjectEnum,
+		},
+		"subjectId": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"subjectTitle": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The title of the task or article, or the name of the user, when it changed",
+		},
+		"action": &graphql.Field{
+			Type:        graphql.String,
+			Description: "created, updated, moved, deleted, restored, published or unpublished",
+		},
+		"detail": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The status a task was moved to, or recurring for a task generated from a template",
+		},
+		"summary": &graphql.Field{
+			Type: graphql.String,
+			Resolve: activityResolver(func(activity database.Activity) (interface{}, error) {
+				return activity.Summary(), nil
+			}),
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: activityResolver(func(activity database.Activity) (interface{}, error) {
+				return activity.CreatedAt.Format(time.RFC3339), nil
+			}),
+		},
+	},
+})
+
+var ActivityQueryField = &graphql.Field{
+	Type:        graphql.NewList(ActivityType),
+	Description: "The activity log, newest first",
+	Args: graphql.FieldConfigArgument{
+		"limit": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: fmt.Sprintf("At most %d, 50 by default", internal.MaxActivityPageSize),
+		},
+		"after": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "The id of the last entry of the previous page",
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		requested, _ := params.Args["limit"].(int)
+		limit, err := internal.ActivityPageSize(requested)
+		if err != nil {
+			return nil, err
+		}
+		after, _ := params.Args["after"].(int)
+		return database.GetActivity(limit, int64(after))
+	},
+}
@@ -0,0 +1,80 @@
+package graphqlschema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var ViewPeriodEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ViewPeriod",
+	Values: graphql.EnumValueConfigMap{
+		"DAY":      &graphql.EnumValueConfig{Value: "day"},
+		"WEEK":     &graphql.EnumValueConfig{Value: "week"},
+		"MONTH":    &graphql.EnumValueConfig{Value: "month"},
+		"YEAR":     &graphql.EnumValueConfig{Value: "year"},
+		"ALL_TIME": &graphql.EnumValueConfig{Value: "all"},
+	},
+})
+
+// periodStart returns the first day counted for a period, ending today.
+func periodStart(period string, now time.Time) (time.Time, error) {
+	switch period {
+	case "day":
+		return now, nil
+	case "week":
+		return now.AddDate(0, 0, -6), nil
+	case "month":
+		return now.AddDate(0, -1, 1), nil
+	case "year":
+		return now.AddDate(-1, 0, 1), nil
+	case "all":
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown period: %s", period)
+}
+
+var PopularArticleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PopularArticle",
+	Fields: graphql.Fields{
+		"article": &graphql.Field{
+			Type: ArticleType,
+		},
+		"views": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+var PopularArticlesQueryField = &graphql.Field{
+	Type:        graphql.NewList(PopularArticleType),
+	Description: "The most viewed published articles over a period",
+	Args: graphql.FieldConfigArgument{
+		"period": &graphql.ArgumentConfig{
+			Type:         ViewPeriodEnum,
+			DefaultValue: "week",
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 10,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		period, ok := params.Args["period"].(string)
+		if !ok {
+			period = "week"
+		}
+		limit, _ := params.Args["limit"].(int)
+		if limit <= 0 || limit > 100 {
+			return nil, fmt.Errorf("limit must be between 1 and 100")
+		}
+
+		since, err := periodStart(period, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		return database.GetPopularArticles(since, limit)
+	},
+}
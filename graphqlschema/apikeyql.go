@@ -0,0 +1,113 @@
+package graphqlschema
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var APIKeyType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "APIKey",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"prefix": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The start of the key, to tell keys apart",
+		},
+		"scopes": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Permissions the key may use, such as \"articles:create\"",
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"expiresAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"lastUsedAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"expired": &graphql.Field{
+			Type: graphql.Boolean,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return !params.Source.(database.APIKey).Active(time.Now()), nil
+			},
+		},
+	},
+})
+
+var NewAPIKeyType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "NewAPIKey",
+	Fields: graphql.Fields{
+		"key": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "Send in the X-API-Key header, or as a bearer token. It is only shown this once.",
+		},
+		"apiKey": &graphql.Field{
+			Type: APIKeyType,
+		},
+	},
+})
+
+var APIKeysQueryField = &graphql.Field{
+	Type:        graphql.NewList(APIKeyType),
+	Description: "The signed-in user's API keys, newest first",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.ListAPIKeys(params.Context)
+	},
+}
+
+var CreateAPIKeyField = &graphql.Field{
+	Type:        NewAPIKeyType,
+	Description: "Make an API key for scripts, limited to some of the permissions of the user's role",
+	Args: graphql.FieldConfigArgument{
+		"name": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"scopes": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+		},
+		"expiresInDays": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			Description:  "At most 365",
+			DefaultValue: 90,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		name, _ := params.Args["name"].(string)
+		var scopes []string
+		for _, scope := range params.Args["scopes"].([]interface{}) {
+			scopes = append(scopes, scope.(string))
+		}
+		days, _ := params.Args["expiresInDays"].(int)
+		// CreateAPIKey takes 0 to mean the default
+		if days <= 0 {
+			days = -1
+		}
+		return internal.CreateAPIKey(params.Context, name, scopes, time.Duration(days)*24*time.Hour)
+	},
+}
+
+var RevokeAPIKeyField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Stop one of the signed-in user's API keys working",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		if err := internal.RevokeAPIKey(params.Context, int64(id)); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
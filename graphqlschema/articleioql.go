@@ -0,0 +1,215 @@
+package graphqlschema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/pkg/articleio"
+)
+
+var ArticleFormatEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ArticleFormat",
+	Values: graphql.EnumValueConfigMap{
+		"JSONL":    &graphql.EnumValueConfig{Value: articleio.FormatJSONL},
+		"MARKDOWN": &graphql.EnumValueConfig{Value: articleio.FormatMarkdown},
+		"CSV":      &graphql.EnumValueConfig{Value: articleio.FormatCSV},
+	},
+})
+
+var ConflictPolicyEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ConflictPolicy",
+	Values: graphql.EnumValueConfigMap{
+		"SKIP":      &graphql.EnumValueConfig{Value: articleio.ConflictSkip},
+		"OVERWRITE": &graphql.EnumValueConfig{Value: articleio.ConflictOverwrite},
+		"FAIL":      &graphql.EnumValueConfig{Value: articleio.ConflictFail},
+	},
+})
+
+// ExportedFile is one Markdown file of an export
+type ExportedFile struct {
+	Name    string
+	Content string
+}
+
+// ArticleExport carries JSONL and CSV exports in Data and Markdown exports in
+// Files, since a directory can't be returned as a single string.
+type ArticleExport struct {
+	Format articleio.Format
+	Count  int
+	Data   string
+	Files  []ExportedFile
+}
+
+var ExportedFileType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ExportedFile",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"content": &graphql.Field{
+			Type: graphql.String,
+		},
+	},
+})
+
+var ArticleExportType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ArticleExport",
+	Fields: graphql.Fields{
+		"format": &graphql.Field{
+			Type: ArticleFormatEnum,
+		},
+		"count": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"data": &graphql.Field{
+			Type: graphql.String,
+		},
+		"files": &graphql.Field{
+			Type: graphql.NewList(ExportedFileType),
+		},
+	},
+})
+
+var ImportErrorType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ImportError",
+	Fields: graphql.Fields{
+		"slug": &graphql.Field{
+			Type: graphql.String,
+		},
+		"message": &graphql.Field{
+			Type: graphql.String,
+		},
+	},
+})
+
+var ImportResultType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ImportResult",
+	Fields: graphql.Fields{
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"updated": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"skipped": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"errors": &graphql.Field{
+			Type: graphql.NewList(ImportErrorType),
+		},
+	},
+})
+
+var ExportedFileInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ExportedFileInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
+		"content": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+})
+
+var ExportArticlesField = &graphql.Field{
+	Type:        ArticleExportType,
+	Description: "Export all articles as JSONL, CSV or Markdown files",
+	Args: graphql.FieldConfigArgument{
+		"format": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(ArticleFormatEnum),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		format, _ := params.Args["format"].(articleio.Format)
+
+		records, err := articleio.Export()
+		if err != nil {
+			return nil, err
+		}
+
+		export := ArticleExport{Format: format, Count: len(records)}
+		var buf bytes.Buffer
+		switch format {
+		case articleio.FormatMarkdown:
+			for _, record := range records {
+				data, err := articleio.MarshalMarkdown(record)
+				if err != nil {
+					return nil, err
+				}
+				export.Files = append(export.Files, ExportedFile{Name: articleio.MarkdownFileName(record), Content: string(data)})
+			}
+		case articleio.FormatCSV:
+			err = articleio.WriteCSV(&buf, records)
+		default:
+			err = articleio.WriteJSONL(&buf, records)
+		}
+		if err != nil {
+			return nil, err
+		}
+		export.Data = buf.String()
+
+		return export, nil
+	},
+}
+
+var ImportArticlesField = &graphql.Field{
+	Type:        ImportResultType,
+	Description: "Import articles, matching existing ones by slug",
+	Args: graphql.FieldConfigArgument{
+		"format": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(ArticleFormatEnum),
+		},
+		"data": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "JSONL or CSV payload",
+		},
+		"files": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(ExportedFileInputType),
+			Description: "Markdown files with front matter",
+		},
+		"onConflict": &graphql.ArgumentConfig{
+			Type:         ConflictPolicyEnum,
+			DefaultValue: articleio.ConflictSkip,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		format, _ := params.Args["format"].(articleio.Format)
+		data, _ := params.Args["data"].(string)
+		files, _ := params.Args["files"].([]interface{})
+		policy, ok := params.Args["onConflict"].(articleio.ConflictPolicy)
+		if !ok {
+			policy = articleio.ConflictSkip
+		}
+
+		var records []articleio.Record
+		var err error
+		switch format {
+		case articleio.FormatMarkdown:
+			for _, f := range files {
+				file, _ := f.(map[string]interface{})
+				name, _ := file["name"].(string)
+				content, _ := file["content"].(string)
+				record, err := articleio.UnmarshalMarkdown([]byte(content))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				if record.Slug == "" {
+					record.Slug = strings.TrimSuffix(name, ".md")
+				}
+				records = append(records, record)
+			}
+		case articleio.FormatCSV:
+			records, err = articleio.ReadCSV(strings.NewReader(data))
+		default:
+			records, err = articleio.ReadJSONL(strings.NewReader(data))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return articleio.Import(records, policy)
+	},
+}
@@ -1,9 +1,11 @@
 package graphqlschema
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
 	"github.com/rmacdiarmid/gptback/pkg/database"
 	"github.com/spf13/viper"
 )
@@ -15,15 +17,18 @@ var ArticleType = graphql.NewObject(
 			"id": &graphql.Field{
 				Type: graphql.Int,
 			},
+			"slug": &graphql.Field{
+				Type: graphql.String,
+			},
 			"title": &graphql.Field{
 				Type: graphql.String,
 			},
 			"image": &graphql.Field{
 				Type: graphql.String,
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					article, ok := p.Source.(database.Article)
-					if !ok {
-						return nil, fmt.Errorf("expected type database.Article but got %T", p.Source)
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
 					}
 					baseURL := viper.GetString("storage.baseURL")
 					imageURL := fmt.Sprintf("%s%s", baseURL, article.Image)
@@ -36,10 +41,111 @@ var ArticleType = graphql.NewObject(
 			"text": &graphql.Field{ // Make sure this field is included
 				Type: graphql.String,
 			},
+			"authorId": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"prompt": &graphql.Field{
+				Type: graphql.String,
+			},
+			"model": &graphql.Field{
+				Type: graphql.String,
+			},
+			"published": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"tags": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					return database.GetArticleTags(article.ID)
+				},
+			},
+			"deletedAt": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					return formatOptionalTime(article.DeletedAt), nil
+				},
+			},
+			"viewCount": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Views recorded so far; recent views are written in batches",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					return database.GetArticleViewCount(article.ID)
+				},
+			},
+			"averageRating": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					average, _, err := database.GetArticleRating(article.ID)
+					return average, err
+				},
+			},
+			"ratingCount": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					_, count, err := database.GetArticleRating(article.ID)
+					return count, err
+				},
+			},
+			"author": &graphql.Field{
+				Type: AuthorType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					article, err := articleFromSource(p.Source)
+					if err != nil {
+						return nil, err
+					}
+					if article.AuthorID == 0 {
+						return nil, nil
+					}
+					return database.GetUserByID(article.AuthorID)
+				},
+			},
 		},
 	},
 )
 
+// articleFromSource accepts both the values and the pointers returned by the
+// article functions in the database package.
+func articleFromSource(source interface{}) (database.Article, error) {
+	switch article := source.(type) {
+	case database.Article:
+		return article, nil
+	case *database.Article:
+		return *article, nil
+	}
+	return database.Article{}, fmt.Errorf("expected type database.Article but got %T", source)
+}
+
+// readableArticles drops the articles the request in ctx may not see.
+func readableArticles(ctx context.Context, articles []database.Article) []database.Article {
+	readable := articles[:0]
+	for _, article := range articles {
+		if internal.CanReadArticle(ctx, article) {
+			readable = append(readable, article)
+		}
+	}
+	return readable
+}
+
 var createArticleMutationField = &graphql.Field{
 	Type: ArticleType,
 	Args: graphql.FieldConfigArgument{
@@ -62,7 +168,13 @@ var createArticleMutationField = &graphql.Field{
 		preview, _ := params.Args["preview"].(string)
 		text, _ := params.Args["text"].(string)
 
-		newArticleID, err := database.CreateArticle(title, image, preview, text) // Add the 'text' parameter here
+		var newArticleID int64
+		var err error
+		if user, ok := internal.UserFromContext(params.Context); ok {
+			newArticleID, err = database.CreateAuthoredArticle(user.UserId, title, image, preview, text)
+		} else {
+			newArticleID, err = database.CreateArticle(title, image, preview, text) // Add the 'text' parameter here
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,168 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var BatchItemResultType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "BatchItemResult",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"ok": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"error": &graphql.Field{
+			Type: graphql.String,
+		},
+	},
+})
+
+var ArticleUpdateInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ArticleUpdateInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"id": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"title": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
+		"image": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
+		"preview": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
+		"text": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
+	},
+})
+
+var batchIDsArgument = &graphql.ArgumentConfig{
+	Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+}
+
+func batchIDs(args map[string]interface{}) []int64 {
+	list, _ := args["ids"].([]interface{})
+	ids := make([]int64, 0, len(list))
+	for _, id := range list {
+		n, _ := id.(int)
+		ids = append(ids, int64(n))
+	}
+	return ids
+}
+
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+var PublishArticlesField = &graphql.Field{
+	Type:        graphql.NewList(BatchItemResultType),
+	Description: "Publish or unpublish several articles in one transaction",
+	Args: graphql.FieldConfigArgument{
+		"ids": batchIDsArgument,
+		"published": &graphql.ArgumentConfig{
+			Type:         graphql.Boolean,
+			DefaultValue: true,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		published, ok := params.Args["published"].(bool)
+		if !ok {
+			published = true
+		}
+		if published {
+			if err := internal.RequireVerifiedEmail(params.Context); err != nil {
+				return nil, err
+			}
+		}
+		return internal.RunArticleBatch(params.Context, batchIDs(params.Args), func(batch *database.ArticleBatch, _ int, article database.Article) error {
+			return batch.SetPublished(article.ID, published)
+		})
+	},
+}
+
+var DeleteArticlesField = &graphql.Field{
+	Type:        graphql.NewList(BatchItemResultType),
+	Description: "Move several articles to the trash in one transaction",
+	Args: graphql.FieldConfigArgument{
+		"ids": batchIDsArgument,
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.RunArticleBatch(params.Context, batchIDs(params.Args), func(batch *database.ArticleBatch, _ int, article database.Article) error {
+			return batch.DeleteArticle(article.ID)
+		})
+	},
+}
+
+var TagArticlesField = &graphql.Field{
+	Type:        graphql.NewList(BatchItemResultType),
+	Description: "Add and remove tags on several articles in one transaction",
+	Args: graphql.FieldConfigArgument{
+		"ids": batchIDsArgument,
+		"add": &graphql.ArgumentConfig{
+			Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+		},
+		"remove": &graphql.ArgumentConfig{
+			Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		add := stringList(params.Args["add"])
+		remove := stringList(params.Args["remove"])
+		return internal.RunArticleBatch(params.Context, batchIDs(params.Args), func(batch *database.ArticleBatch, _ int, article database.Article) error {
+			if err := batch.RemoveTags(article.ID, remove); err != nil {
+				return err
+			}
+			return batch.AddTags(article.ID, add)
+		})
+	},
+}
+
+var UpdateArticlesField = &graphql.Field{
+	Type:        graphql.NewList(BatchItemResultType),
+	Description: "Update several articles in one transaction; omitted fields are left unchanged",
+	Args: graphql.FieldConfigArgument{
+		"articles": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(ArticleUpdateInputType))),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		inputs, _ := params.Args["articles"].([]interface{})
+		ids := make([]int64, len(inputs))
+		changes := make([]database.ArticleChanges, len(inputs))
+		for i, in := range inputs {
+			input, _ := in.(map[string]interface{})
+			id, _ := input["id"].(int)
+			ids[i] = int64(id)
+			changes[i] = database.ArticleChanges{
+				Title:   optionalString(input, "title"),
+				Image:   optionalString(input, "image"),
+				Preview: optionalString(input, "preview"),
+				Text:    optionalString(input, "text"),
+			}
+		}
+		return internal.RunArticleBatch(params.Context, ids, func(batch *database.ArticleBatch, i int, article database.Article) error {
+			return batch.UpdateArticle(article.ID, changes[i])
+		})
+	},
+}
+
+func optionalString(input map[string]interface{}, key string) *string {
+	s, ok := input[key].(string)
+	if !ok {
+		return nil
+	}
+	return &s
+}
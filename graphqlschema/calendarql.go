@@ -0,0 +1,35 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+)
+
+var CreateCalendarFeedField = &graphql.Field{
+	Type: graphql.String,
+	Description: "Create a calendar feed of task due dates for the signed-in user and return its path, " +
+		"which includes a secret token and is only shown once. Any previous feed stops working. " +
+		"Add status, priority or assignee (a user ID, none or me) parameters to filter it.",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		user, err := internal.RequireSessionUser(params.Context)
+		if err != nil {
+			return nil, err
+		}
+		return internal.NewCalendarToken(user.UserId)
+	},
+}
+
+var RevokeCalendarFeedField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Stop the signed-in user's calendar feed working",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		user, err := internal.RequireSessionUser(params.Context)
+		if err != nil {
+			return nil, err
+		}
+		if err := internal.RevokeCalendarToken(user.UserId); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
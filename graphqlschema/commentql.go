@@ -0,0 +1,213 @@
+package graphqlschema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var CommentStatusEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "CommentStatus",
+	Values: graphql.EnumValueConfigMap{
+		"PENDING":  &graphql.EnumValueConfig{Value: database.CommentPending},
+		"APPROVED": &graphql.EnumValueConfig{Value: database.CommentApproved},
+		"SPAM":     &graphql.EnumValueConfig{Value: database.CommentSpam},
+	},
+})
+
+var CommentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Comment",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"articleId": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"parentId": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"authorId": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"author": &graphql.Field{
+			Type: AuthorType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				comment, ok := p.Source.(database.Comment)
+				if !ok {
+					return nil, fmt.Errorf("expected type database.Comment but got %T", p.Source)
+				}
+				if comment.AuthorID == 0 {
+					return nil, nil
+				}
+				return database.GetUserByID(comment.AuthorID)
+			},
+		},
+		"authorName": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				comment, ok := p.Source.(database.Comment)
+				if !ok {
+					return nil, fmt.Errorf("expected type database.Comment but got %T", p.Source)
+				}
+				return internal.CommentAuthorName(comment), nil
+			},
+		},
+		"body": &graphql.Field{
+			Type: graphql.String,
+		},
+		"status": &graphql.Field{
+			Type: CommentStatusEnum,
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				comment, ok := p.Source.(database.Comment)
+				if !ok {
+					return nil, fmt.Errorf("expected type database.Comment but got %T", p.Source)
+				}
+				return comment.CreatedAt.Format(time.RFC3339), nil
+			},
+		},
+	},
+})
+
+// The replies field refers to CommentType itself, so it is added once the
+// type exists.
+func init() {
+	CommentType.AddFieldConfig("replies", &graphql.Field{
+		Type:        graphql.NewList(CommentType),
+		Description: "Approved replies to this comment",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			comment, ok := p.Source.(database.Comment)
+			if !ok {
+				return nil, fmt.Errorf("expected type database.Comment but got %T", p.Source)
+			}
+			comments, err := database.GetCommentsByArticle(comment.ArticleID, database.CommentApproved)
+			if err != nil {
+				return nil, err
+			}
+			var replies []database.Comment
+			for _, reply := range comments {
+				if reply.ParentID == comment.ID {
+					replies = append(replies, reply)
+				}
+			}
+			return replies, nil
+		},
+	})
+}
+
+var CommentsQueryField = &graphql.Field{
+	Type:        graphql.NewList(CommentType),
+	Description: "Approved top-level comments on an article; use replies for the rest of each thread",
+	Args: graphql.FieldConfigArgument{
+		"articleId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		articleID, _ := params.Args["articleId"].(int)
+
+		comments, err := database.GetCommentsByArticle(int64(articleID), database.CommentApproved)
+		if err != nil {
+			return nil, err
+		}
+		var topLevel []database.Comment
+		for _, comment := range comments {
+			if comment.ParentID == 0 {
+				topLevel = append(topLevel, comment)
+			}
+		}
+		return topLevel, nil
+	},
+}
+
+var CommentQueueQueryField = &graphql.Field{
+	Type:        graphql.NewList(CommentType),
+	Description: "Comments awaiting moderation, for editors",
+	Args: graphql.FieldConfigArgument{
+		"status": &graphql.ArgumentConfig{
+			Type:         CommentStatusEnum,
+			DefaultValue: database.CommentPending,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if _, err := internal.RequirePermission(params.Context, internal.PermModerateComments); err != nil {
+			return nil, err
+		}
+		status, ok := params.Args["status"].(string)
+		if !ok {
+			status = database.CommentPending
+		}
+		return database.GetCommentsByStatus(status)
+	},
+}
+
+var CreateCommentField = &graphql.Field{
+	Type:        CommentType,
+	Description: "Comment on an article; guests must give a name and are held for moderation",
+	Args: graphql.FieldConfigArgument{
+		"articleId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"parentId": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"guestName": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"body": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		articleID, _ := params.Args["articleId"].(int)
+		parentID, _ := params.Args["parentId"].(int)
+		guestName, _ := params.Args["guestName"].(string)
+		body, _ := params.Args["body"].(string)
+
+		return internal.PostComment(params.Context, int64(articleID), int64(parentID), guestName, body)
+	},
+}
+
+var ModerateCommentField = &graphql.Field{
+	Type: CommentType,
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"status": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(CommentStatusEnum),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		status, _ := params.Args["status"].(string)
+
+		return internal.ModerateComment(params.Context, int64(id), status)
+	},
+}
+
+var DeleteCommentField = &graphql.Field{
+	Type: graphql.Boolean,
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+
+		if _, err := internal.RequirePermission(params.Context, internal.PermModerateComments); err != nil {
+			return nil, err
+		}
+		if err := database.DeleteComment(int64(id)); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
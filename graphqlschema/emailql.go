@@ -0,0 +1,31 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+)
+
+var VerifyEmailField = &graphql.Field{
+	Type:        UserType,
+	Description: "Verify an email address with the token from a verification email",
+	Args: graphql.FieldConfigArgument{
+		"token": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		token, _ := params.Args["token"].(string)
+		return internal.VerifyEmail(token)
+	},
+}
+
+var ResendVerificationEmailField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Send the signed-in user another email to verify their address",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if err := internal.ResendVerificationEmail(params.Context); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
@@ -0,0 +1,44 @@
+package graphqlschema
+
+import (
+	"net/http"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// RejectMutationsOnGET wraps the GraphQL handler so GET and HEAD requests
+// can only run queries. The handler runs whatever operation is in the query
+// string, and browsers send the auth cookie with links and images from
+// other sites, so a mutation in a URL would run as whoever followed it.
+// Queries that don't parse are passed on for the handler to report.
+func RejectMutationsOnGET(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if hasMutation(r.URL.Query().Get("query")) {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "mutations must be sent with POST", http.StatusMethodNotAllowed)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasMutation reports whether a GraphQL document defines any operation
+// other than a query.
+func hasMutation(query string) bool {
+	if query == "" {
+		return false
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok && op.Operation != ast.OperationTypeQuery {
+			return true
+		}
+	}
+	return false
+}
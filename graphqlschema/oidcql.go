@@ -0,0 +1,61 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+)
+
+var IdentityProviderType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "IdentityProvider",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"loginUrl": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "Where to send the browser to sign in with the provider",
+		},
+	},
+})
+
+// identityProvider is what IdentityProviderType resolves from
+type identityProvider struct {
+	Name     string
+	LoginURL string
+}
+
+var IdentityProvidersQueryField = &graphql.Field{
+	Type:        graphql.NewList(IdentityProviderType),
+	Description: "The identity providers users can sign in with",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		var providers []identityProvider
+		for _, name := range internal.IdentityProviders() {
+			providers = append(providers, identityProvider{Name: name, LoginURL: internal.OIDCLoginPath(name)})
+		}
+		return providers, nil
+	},
+}
+
+var LinkedIdentityType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "LinkedIdentity",
+	Fields: graphql.Fields{
+		"provider": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The address the provider gave when the account was linked",
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+	},
+})
+
+var LinkedIdentitiesQueryField = &graphql.Field{
+	Type:        graphql.NewList(LinkedIdentityType),
+	Description: "The identity provider accounts the signed-in user can sign in with",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.LinkedIdentities(params.Context)
+	},
+}
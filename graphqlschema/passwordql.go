@@ -0,0 +1,44 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+)
+
+var RequestPasswordResetField = &graphql.Field{
+	Type:        graphql.NewNonNull(graphql.Boolean),
+	Description: "Email a password reset link to the account with this address, if there is one. The result doesn't say whether there is.",
+	Args: graphql.FieldConfigArgument{
+		"email": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		email, _ := params.Args["email"].(string)
+		if err := internal.RequestPasswordReset(params.Context, email); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
+
+var ResetPasswordField = &graphql.Field{
+	Type:        graphql.NewNonNull(graphql.Boolean),
+	Description: "Set a new password with the token from a reset email. Every session of the account is ended.",
+	Args: graphql.FieldConfigArgument{
+		"token": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"newPassword": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		token, _ := params.Args["token"].(string)
+		password, _ := params.Args["newPassword"].(string)
+		if err := internal.ResetPassword(params.Context, token, password); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
@@ -0,0 +1,123 @@
+package graphqlschema
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var RatingGroupEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "RatingGroup",
+	Values: graphql.EnumValueConfigMap{
+		"PROMPT":           &graphql.EnumValueConfig{Value: database.GroupByPrompt},
+		"MODEL":            &graphql.EnumValueConfig{Value: database.GroupByModel},
+		"PROMPT_AND_MODEL": &graphql.EnumValueConfig{Value: database.GroupByPromptAndModel},
+	},
+})
+
+var ArticleRatingType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ArticleRating",
+	Fields: graphql.Fields{
+		"articleId": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"average": &graphql.Field{
+			Type: graphql.Float,
+		},
+		"count": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+// ArticleRating is returned by rateArticle with the article's new totals.
+type ArticleRating struct {
+	ArticleID int64
+	Average   float64
+	Count     int
+}
+
+var PromptRatingType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PromptRating",
+	Fields: graphql.Fields{
+		"prompt": &graphql.Field{
+			Type: graphql.String,
+		},
+		"model": &graphql.Field{
+			Type: graphql.String,
+		},
+		"articles": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"ratings": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"average": &graphql.Field{
+			Type: graphql.Float,
+		},
+	},
+})
+
+var RateArticleField = &graphql.Field{
+	Type:        ArticleRatingType,
+	Description: "Rate an article from 1 to 5, or with thumbsUp; rating again replaces the earlier score",
+	Args: graphql.FieldConfigArgument{
+		"articleId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"score": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"thumbsUp": &graphql.ArgumentConfig{
+			Type: graphql.Boolean,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		articleID, _ := params.Args["articleId"].(int)
+		score, hasScore := params.Args["score"].(int)
+		thumbsUp, hasThumb := params.Args["thumbsUp"].(bool)
+		if hasScore == hasThumb {
+			return nil, fmt.Errorf("give either a score or thumbsUp")
+		}
+		if hasThumb {
+			score = internal.ThumbScore(thumbsUp)
+		}
+
+		if err := internal.RateArticle(params.Context, int64(articleID), score); err != nil {
+			return nil, err
+		}
+		average, count, err := database.GetArticleRating(int64(articleID))
+		if err != nil {
+			return nil, err
+		}
+		return ArticleRating{ArticleID: int64(articleID), Average: average, Count: count}, nil
+	},
+}
+
+var PromptRatingReportField = &graphql.Field{
+	Type:        graphql.NewList(PromptRatingType),
+	Description: "Prompts and models ranked by the average rating of the articles they generated, for editors",
+	Args: graphql.FieldConfigArgument{
+		"groupBy": &graphql.ArgumentConfig{
+			Type:         RatingGroupEnum,
+			DefaultValue: database.GroupByPromptAndModel,
+		},
+		"minRatings": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 1,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if _, err := internal.RequirePermission(params.Context, internal.PermViewReports); err != nil {
+			return nil, err
+		}
+		groupBy, ok := params.Args["groupBy"].(string)
+		if !ok {
+			groupBy = database.GroupByPromptAndModel
+		}
+		minRatings, _ := params.Args["minRatings"].(int)
+		return database.GetPromptRatings(groupBy, minRatings)
+	},
+}
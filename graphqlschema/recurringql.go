@@ -0,0 +1,228 @@
+package graphqlschema
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// taskTemplateResolver adapts a function of the source template into a
+// field resolver.
+func taskTemplateResolver(resolve func(template database.TaskTemplate) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		template, ok := p.Source.(database.TaskTemplate)
+		if !ok {
+			return nil, fmt.Errorf("expected type database.TaskTemplate but got %T", p.Source)
+		}
+		return resolve(template)
+	}
+}
+
+var TaskTemplateType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "TaskTemplate",
+	Description: "A recurring task; a task is generated for each occurrence of its rule",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"title": &graphql.Field{
+			Type: graphql.String,
+		},
+		"description": &graphql.Field{
+			Type: graphql.String,
+		},
+		"priority": &graphql.Field{
+			Type: TaskPriorityEnum,
+		},
+		"assigneeId": &graphql.Field{
+			Type: graphql.Int,
+			Resolve: taskTemplateResolver(func(template database.TaskTemplate) (interface{}, error) {
+				if template.AssigneeID == 0 {
+					return nil, nil
+				}
+				return template.AssigneeID, nil
+			}),
+		},
+		"rrule": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The recurrence rule, an RFC 5545 RRULE with FREQ (DAILY, WEEKLY or MONTHLY), INTERVAL, BYDAY, COUNT and UNTIL",
+			Resolve: taskTemplateResolver(func(template database.TaskTemplate) (interface{}, error) {
+				return template.Rule, nil
+			}),
+		},
+		"startDate": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskTemplateResolver(func(template database.TaskTemplate) (interface{}, error) {
+				return template.StartDate.Format(database.TaskDueDateFormat), nil
+			}),
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskTemplateResolver(func(template database.TaskTemplate) (interface{}, error) {
+				return template.CreatedAt.Format(time.RFC3339), nil
+			}),
+		},
+		"updatedAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskTemplateResolver(func(template database.TaskTemplate) (interface{}, error) {
+				return template.UpdatedAt.Format(time.RFC3339), nil
+			}),
+		},
+	},
+})
+
+var TaskTemplatesQueryField = &graphql.Field{
+	Type:        graphql.NewList(TaskTemplateType),
+	Description: "List the recurring task templates",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return database.GetTaskTemplates()
+	},
+}
+
+// applyTaskTemplateArgs copies the template fields given as mutation
+// arguments onto template and validates the result. An assigneeId of 0
+// removes the assignee.
+func applyTaskTemplateArgs(template *database.TaskTemplate, args map[string]interface{}) error {
+	if title, ok := args["title"].(string); ok {
+		template.Title = title
+	}
+	if description, ok := args["description"].(string); ok {
+		template.Description = description
+	}
+	if priority, ok := args["priority"].(string); ok {
+		template.Priority = priority
+	}
+	if assignee, ok := args["assigneeId"].(int); ok {
+		template.AssigneeID = int64(assignee)
+	}
+	if rule, ok := args["rrule"].(string); ok {
+		template.Rule = rule
+	}
+	if start, ok := args["startDate"].(string); ok {
+		date, err := internal.ParseTaskDate(start)
+		if err != nil {
+			return fmt.Errorf("startDate: %v", err)
+		}
+		template.StartDate = date
+	}
+
+	validated, err := internal.ValidateTaskTemplate(*template)
+	if err != nil {
+		return err
+	}
+	*template = validated
+	return nil
+}
+
+// taskTemplateMutationArgs are the editable template fields, with the
+// title, rule and start date required when creating a template.
+func taskTemplateMutationArgs(create bool) graphql.FieldConfigArgument {
+	required := func(t graphql.Input) graphql.Input {
+		if create {
+			return graphql.NewNonNull(t)
+		}
+		return t
+	}
+	return graphql.FieldConfigArgument{
+		"title": &graphql.ArgumentConfig{
+			Type: required(graphql.String),
+		},
+		"description": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"priority": &graphql.ArgumentConfig{
+			Type: TaskPriorityEnum,
+		},
+		"assigneeId": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "A user ID, or 0 for nobody",
+		},
+		"rrule": &graphql.ArgumentConfig{
+			Type:        required(graphql.String),
+			Description: "An RFC 5545 RRULE such as FREQ=WEEKLY;INTERVAL=2;BYDAY=MO",
+		},
+		"startDate": &graphql.ArgumentConfig{
+			Type:        required(graphql.String),
+			Description: "The first day the rule can occur on, as YYYY-MM-DD",
+		},
+	}
+}
+
+var CreateTaskTemplateField = &graphql.Field{
+	Type:        TaskTemplateType,
+	Description: "Create a recurring task; its first task is generated straight away",
+	Args:        taskTemplateMutationArgs(true),
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		template := database.TaskTemplate{Priority: database.TaskPriorityMedium}
+		if err := applyTaskTemplateArgs(&template, params.Args); err != nil {
+			return nil, err
+		}
+
+		id, err := database.CreateTaskTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		template, err = database.GetTaskTemplate(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := internal.MaterializeTaskTemplate(template, time.Now()); err != nil {
+			return nil, err
+		}
+		return template, nil
+	},
+}
+
+var UpdateTaskTemplateField = &graphql.Field{
+	Type:        TaskTemplateType,
+	Description: "Update a recurring task; omitted fields are left unchanged, and tasks already generated aren't changed",
+	Args: func() graphql.FieldConfigArgument {
+		args := taskTemplateMutationArgs(false)
+		args["id"] = &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		}
+		return args
+	}(),
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		template, err := database.GetTaskTemplate(int64(id))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("task template %d not found", id)
+			}
+			return nil, err
+		}
+		if err := applyTaskTemplateArgs(&template, params.Args); err != nil {
+			return nil, err
+		}
+
+		if err := database.UpdateTaskTemplate(template); err != nil {
+			return nil, err
+		}
+		return database.GetTaskTemplate(int64(id))
+	},
+}
+
+var DeleteTaskTemplateField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Stop a task recurring; the tasks already generated are kept",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		if err := database.DeleteTaskTemplate(int64(id)); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("task template %d not found", id)
+			}
+			return nil, err
+		}
+		return true, nil
+	},
+}
@@ -37,6 +37,9 @@ var Query = graphql.NewObject(graphql.ObjectConfig{
 					if err != nil {
 						return nil, err
 					}
+					if !internal.CanReadArticle(p.Context, article) {
+						return nil, nil
+					}
 					fmt.Printf("Resolver: article: %+v\n", article) // Add this line
 					return article, nil
 				}
@@ -45,16 +48,43 @@ var Query = graphql.NewObject(graphql.ObjectConfig{
 		},
 		"articles": &graphql.Field{
 			Type:        graphql.NewList(ArticleType),
-			Description: "List of articles",
+			Description: "List of articles, optionally only those by one author. Unpublished articles are only listed for those who may edit them.",
+			Args: graphql.FieldConfigArgument{
+				"authorId": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-				articles, err := database.GetArticles()
+				var articles []database.Article
+				var err error
+				if authorID, ok := p.Args["authorId"].(int); ok {
+					articles, err = database.GetArticlesByAuthor(int64(authorID))
+				} else {
+					articles, err = database.GetArticles()
+				}
 				if err != nil {
 					return nil, err
 				}
-				return articles, nil
+				return readableArticles(p.Context, articles), nil
 			},
 		},
-		"frontendLog": ReadFrontendLogField,
+		"comments":           CommentsQueryField,
+		"commentQueue":       CommentQueueQueryField,
+		"promptRatingReport": PromptRatingReportField,
+		"trash":              TrashQueryField,
+		"popularArticles":    PopularArticlesQueryField,
+		"activity":           signedInOnly(ActivityQueryField),
+		"me":                 MeQueryField,
+		"can":                CanQueryField,
+		"sessions":           SessionsQueryField,
+		"loginAttempts":      authorized(internal.PermManageUsers, LoginAttemptsQueryField),
+		"apiKeys":            APIKeysQueryField,
+		"identityProviders":  IdentityProvidersQueryField,
+		"linkedIdentities":   LinkedIdentitiesQueryField,
+		"task":               signedInOnly(TaskQueryField),
+		"tasks":              signedInOnly(TasksQueryField),
+		"taskTemplates":      signedInOnly(TaskTemplatesQueryField),
+		"frontendLog":        ReadFrontendLogField,
 		"frontendLogs": &graphql.Field{
 			Type:        graphql.NewList(FrontendLogType),
 			Description: "List of frontend logs",
@@ -72,8 +102,8 @@ var Query = graphql.NewObject(graphql.ObjectConfig{
 var Mutation = graphql.NewObject(graphql.ObjectConfig{
 	Name: "Mutation",
 	Fields: graphql.Fields{
-		"createArticle": createArticleMutationField,
-		"updateArticle": &graphql.Field{
+		"createArticle": authorized(internal.PermCreateArticles, createArticleMutationField),
+		"updateArticle": authorized(internal.PermEditOwnArticles, &graphql.Field{
 			Type:        ArticleType,
 			Description: "Update an existing article",
 			Args: graphql.FieldConfigArgument{
@@ -100,16 +130,24 @@ var Mutation = graphql.NewObject(graphql.ObjectConfig{
 				preview, _ := p.Args["preview"].(string)
 				text, _ := p.Args["text"].(string)
 
+				article, err := database.ReadArticle(int64(id))
+				if err != nil {
+					return nil, err
+				}
+				if err := internal.AuthorizeArticleEdit(p.Context, article); err != nil {
+					return nil, err
+				}
+
 				updatedArticle, err := database.UpdateArticle(int64(id), title, image, preview, text)
 				if err != nil {
 					return nil, err
 				}
 				return updatedArticle, nil
 			},
-		},
-		"deleteArticle": &graphql.Field{
+		}),
+		"deleteArticle": authorized(internal.PermEditOwnArticles, &graphql.Field{
 			Type:        graphql.Boolean,
-			Description: "Delete an article by ID",
+			Description: "Move an article to the trash by ID",
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
 					Type: graphql.NewNonNull(graphql.Int),
@@ -118,16 +156,47 @@ var Mutation = graphql.NewObject(graphql.ObjectConfig{
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				id, _ := p.Args["id"].(int)
 
-				err := database.DeleteArticle(int64(id))
+				article, err := database.ReadArticle(int64(id))
+				if err != nil {
+					return nil, err
+				}
+				if err := internal.AuthorizeArticleEdit(p.Context, article); err != nil {
+					return nil, err
+				}
+
+				err = database.DeleteArticle(int64(id))
 				if err != nil {
 					return nil, err
 				}
 				return true, nil
 			},
-		},
-		"createFrontendLog": CreateFrontendLogField,
-		"updateFrontendLog": UpdateFrontendLogField,
-		"deleteFrontendLog": DeleteFrontendLogField,
+		}),
+		"exportArticles":     authorized(internal.PermExportArticles, ExportArticlesField),
+		"importArticles":     authorized(internal.PermImportArticles, ImportArticlesField),
+		"createComment":      CreateCommentField,
+		"moderateComment":    authorized(internal.PermModerateComments, ModerateCommentField),
+		"deleteComment":      authorized(internal.PermModerateComments, DeleteCommentField),
+		"rateArticle":        RateArticleField,
+		"restoreArticle":     authorized(internal.PermEditOwnArticles, RestoreArticleField),
+		"createTask":         authorized(internal.PermWriteTasks, CreateTaskField),
+		"updateTask":         authorized(internal.PermWriteTasks, UpdateTaskField),
+		"deleteTask":         authorized(internal.PermWriteTasks, DeleteTaskField),
+		"moveTask":           authorized(internal.PermWriteTasks, MoveTaskField),
+		"createTaskTemplate": authorized(internal.PermWriteTasks, CreateTaskTemplateField),
+		"updateTaskTemplate": authorized(internal.PermWriteTasks, UpdateTaskTemplateField),
+		"deleteTaskTemplate": authorized(internal.PermWriteTasks, DeleteTaskTemplateField),
+		"restoreTask":        authorized(internal.PermWriteTasks, RestoreTaskField),
+		"createCalendarFeed": CreateCalendarFeedField,
+		"revokeCalendarFeed": RevokeCalendarFeedField,
+		"publishArticles":    authorized(internal.PermEditOwnArticles, PublishArticlesField),
+		"deleteArticles":     authorized(internal.PermEditOwnArticles, DeleteArticlesField),
+		"tagArticles":        authorized(internal.PermEditOwnArticles, TagArticlesField),
+		"updateArticles":     authorized(internal.PermEditOwnArticles, UpdateArticlesField),
+		"createFrontendLog":  CreateFrontendLogField,
+		"updateFrontendLog":  authorized(internal.PermManageLogs, UpdateFrontendLogField),
+		"deleteFrontendLog":  authorized(internal.PermManageLogs, DeleteFrontendLogField),
+		"setUserRole":        authorized(internal.PermManageUsers, SetUserRoleField),
+		"resetTwoFactor":     authorized(internal.PermManageUsers, ResetTwoFactorField),
 		"register": &graphql.Field{
 			Type: graphql.Int,
 			Args: graphql.FieldConfigArgument{
@@ -141,18 +210,25 @@ var Mutation = graphql.NewObject(graphql.ObjectConfig{
 			},
 		},
 
-		"login": &graphql.Field{
-			Type: graphql.String,
-			Args: graphql.FieldConfigArgument{
-				"input": &graphql.ArgumentConfig{
-					Type: graphql.NewNonNull(LoginInputType),
-				},
-			},
-			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				input := params.Args["input"].(map[string]interface{})
-				return internal.LoginUser(input)
-			},
-		},
+		"login":         LoginMutation,
+		"refreshToken":  RefreshTokenField,
+		"logout":        LogoutField,
+		"logoutAll":     LogoutAllField,
+		"revokeSession": RevokeSessionField,
+
+		"requestPasswordReset": RequestPasswordResetField,
+		"resetPassword":        ResetPasswordField,
+
+		"verifyEmail":             VerifyEmailField,
+		"resendVerificationEmail": ResendVerificationEmailField,
+
+		"enrollTwoFactor":  EnrollTwoFactorField,
+		"confirmTwoFactor": ConfirmTwoFactorField,
+		"disableTwoFactor": DisableTwoFactorField,
+		"verifyTwoFactor":  VerifyTwoFactorField,
+
+		"createAPIKey": CreateAPIKeyField,
+		"revokeAPIKey": RevokeAPIKeyField,
 	},
 })
 
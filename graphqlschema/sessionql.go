@@ -0,0 +1,195 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var TokenPairType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TokenPair",
+	Fields: graphql.Fields{
+		"accessToken": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Send as a bearer token with each request",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return nullIfEmpty(params.Source.(internal.TokenPair).AccessToken), nil
+			},
+		},
+		"expiresAt": &graphql.Field{
+			Type:        graphql.DateTime,
+			Description: "When the access token expires",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if tokens := params.Source.(internal.TokenPair); tokens.AccessToken != "" {
+					return tokens.ExpiresAt, nil
+				}
+				return nil, nil
+			},
+		},
+		"refreshToken": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Exchange with refreshToken for new tokens. It can only be used once.",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return nullIfEmpty(params.Source.(internal.TokenPair).RefreshToken), nil
+			},
+		},
+		"twoFactorToken": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Set instead of the other tokens when a code is needed too; exchange it with the code with verifyTwoFactor",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return nullIfEmpty(params.Source.(internal.TokenPair).TwoFactorToken), nil
+			},
+		},
+	},
+})
+
+// nullIfEmpty is null in place of an empty string.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var SessionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Session",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"userAgent": &graphql.Field{
+			Type: graphql.String,
+		},
+		"ipAddress": &graphql.Field{
+			Type: graphql.String,
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"lastUsedAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"expiresAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+		"current": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Whether this is the session the request was made in",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return params.Source.(database.Session).ID == internal.SessionIDFromContext(params.Context), nil
+			},
+		},
+	},
+})
+
+var SessionsQueryField = &graphql.Field{
+	Type:        graphql.NewList(SessionType),
+	Description: "The signed-in user's active sessions, one per device, most recently used first",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.ListSessions(params.Context)
+	},
+}
+
+var RefreshTokenField = &graphql.Field{
+	Type:        TokenPairType,
+	Description: "Exchange a refresh token for new tokens",
+	Args: graphql.FieldConfigArgument{
+		"refreshToken": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		token, _ := params.Args["refreshToken"].(string)
+		return internal.RefreshSession(params.Context, token)
+	},
+}
+
+var LogoutField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "End the current session",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if err := internal.Logout(params.Context); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
+
+var LogoutAllField = &graphql.Field{
+	Type:        graphql.Int,
+	Description: "End all of the signed-in user's sessions, on every device, and return how many there were",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.LogoutAll(params.Context)
+	},
+}
+
+var RevokeSessionField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "End one of the signed-in user's sessions, such as one on a lost device",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(string)
+		if err := internal.RevokeSession(params.Context, id); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
+
+var LoginAttemptType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "LoginAttempt",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"email": &graphql.Field{
+			Type: graphql.String,
+		},
+		"userId": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "Null when the address doesn't belong to anyone",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if id := params.Source.(database.LoginAttempt).UserID; id != 0 {
+					return id, nil
+				}
+				return nil, nil
+			},
+		},
+		"ipAddress": &graphql.Field{
+			Type: graphql.String,
+		},
+		"userAgent": &graphql.Field{
+			Type: graphql.String,
+		},
+		"outcome": &graphql.Field{
+			Type:        graphql.String,
+			Description: "succeeded, needs_code, bad_code, bad_password, unknown_user or locked_out",
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.DateTime,
+		},
+	},
+})
+
+var LoginAttemptsQueryField = &graphql.Field{
+	Type:        graphql.NewList(LoginAttemptType),
+	Description: "Recent sign-in attempts, newest first, optionally for one email address, for admins",
+	Args: graphql.FieldConfigArgument{
+		"email": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 50,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		email, _ := params.Args["email"].(string)
+		limit, _ := params.Args["limit"].(int)
+		return internal.LoginAttempts(params.Context, email, limit)
+	},
+}
@@ -0,0 +1,448 @@
+package graphqlschema
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+var TaskStatusEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "TaskStatus",
+	Values: graphql.EnumValueConfigMap{
+		"TODO":  &graphql.EnumValueConfig{Value: database.TaskTodo},
+		"DOING": &graphql.EnumValueConfig{Value: database.TaskDoing},
+		"DONE":  &graphql.EnumValueConfig{Value: database.TaskDone},
+	},
+})
+
+var TaskPriorityEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "TaskPriority",
+	Values: graphql.EnumValueConfigMap{
+		"LOW":    &graphql.EnumValueConfig{Value: database.TaskPriorityLow},
+		"MEDIUM": &graphql.EnumValueConfig{Value: database.TaskPriorityMedium},
+		"HIGH":   &graphql.EnumValueConfig{Value: database.TaskPriorityHigh},
+	},
+})
+
+var TaskSortEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name:        "TaskSort",
+	Description: "Task list orders; statuses and priorities sort in workflow order, and tasks without a due date sort last by due date",
+	Values: graphql.EnumValueConfigMap{
+		"ID":         &graphql.EnumValueConfig{Value: database.TaskSortID},
+		"TITLE":      &graphql.EnumValueConfig{Value: database.TaskSortTitle},
+		"STATUS":     &graphql.EnumValueConfig{Value: database.TaskSortStatus},
+		"PRIORITY":   &graphql.EnumValueConfig{Value: database.TaskSortPriority},
+		"DUE_DATE":   &graphql.EnumValueConfig{Value: database.TaskSortDueDate},
+		"CREATED_AT": &graphql.EnumValueConfig{Value: database.TaskSortCreatedAt},
+		"UPDATED_AT": &graphql.EnumValueConfig{Value: database.TaskSortUpdatedAt},
+		"RANK":       &graphql.EnumValueConfig{Value: database.TaskSortRank, Description: "The order on the task board"},
+	},
+})
+
+// taskResolver adapts a function of the source task into a field resolver.
+func taskResolver(resolve func(task database.Task) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		task, ok := p.Source.(database.Task)
+		if !ok {
+			return nil, fmt.Errorf("expected type database.Task but got %T", p.Source)
+		}
+		return resolve(task)
+	}
+}
+
+var TaskType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Task",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"title": &graphql.Field{
+			Type: graphql.String,
+		},
+		"description": &graphql.Field{
+			Type: graphql.String,
+		},
+		"status": &graphql.Field{
+			Type: TaskStatusEnum,
+		},
+		"priority": &graphql.Field{
+			Type: TaskPriorityEnum,
+		},
+		"dueDate": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The day the task is due, as YYYY-MM-DD",
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				if task.DueDate == nil {
+					return nil, nil
+				}
+				return task.DueDate.Format(database.TaskDueDateFormat), nil
+			}),
+		},
+		"assigneeId": &graphql.Field{
+			Type: graphql.Int,
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				if task.AssigneeID == 0 {
+					return nil, nil
+				}
+				return task.AssigneeID, nil
+			}),
+		},
+		"assignee": &graphql.Field{
+			Type: AuthorType,
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				if task.AssigneeID == 0 {
+					return nil, nil
+				}
+				return database.GetUserByID(task.AssigneeID)
+			}),
+		},
+		"rank": &graphql.Field{
+			Type:        graphql.Float,
+			Description: "Orders the tasks within a board column, lowest first",
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				return task.CreatedAt.Format(time.RFC3339), nil
+			}),
+		},
+		"updatedAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				return task.UpdatedAt.Format(time.RFC3339), nil
+			}),
+		},
+		"deletedAt": &graphql.Field{
+			Type: graphql.String,
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				return formatOptionalTime(task.DeletedAt), nil
+			}),
+		},
+		"occurrenceDate": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The occurrence of its recurring template the task was generated for, as YYYY-MM-DD",
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				if task.OccurrenceDate == nil {
+					return nil, nil
+				}
+				return task.OccurrenceDate.Format(database.TaskDueDateFormat), nil
+			}),
+		},
+		"template": &graphql.Field{
+			Type:        TaskTemplateType,
+			Description: "The recurring template the task was generated from",
+			Resolve: taskResolver(func(task database.Task) (interface{}, error) {
+				if task.TemplateID == 0 {
+					return nil, nil
+				}
+				return database.GetTaskTemplate(task.TemplateID)
+			}),
+		},
+	},
+})
+
+// formatOptionalTime formats t as RFC 3339, or returns nil when it's unset.
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+var TaskQueryField = &graphql.Field{
+	Type:        TaskType,
+	Description: "Get a task by ID",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		task, err := database.ReadTask(id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return task, err
+	},
+}
+
+// optionalTimeArg parses an optional date or time argument.
+func optionalTimeArg(args map[string]interface{}, name string, parse func(string) (time.Time, error)) (*time.Time, error) {
+	value, _ := args[name].(string)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &t, nil
+}
+
+var TasksQueryField = &graphql.Field{
+	Type:        graphql.NewList(TaskType),
+	Description: "List tasks matching every filter given, optionally only those whose title or description contains query. After bounds are inclusive and before bounds exclusive; dates are YYYY-MM-DD and times RFC 3339 or a date.",
+	Args: graphql.FieldConfigArgument{
+		"query": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"status": &graphql.ArgumentConfig{
+			Type: TaskStatusEnum,
+		},
+		"priority": &graphql.ArgumentConfig{
+			Type: TaskPriorityEnum,
+		},
+		"assigneeId": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"unassigned": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Only list tasks assigned to nobody",
+		},
+		"dueAfter": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"dueBefore": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"createdAfter": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"createdBefore": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"updatedAfter": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"updatedBefore": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"sort": &graphql.ArgumentConfig{
+			Type:        TaskSortEnum,
+			Description: "Defaults to ID",
+		},
+		"descending": &graphql.ArgumentConfig{
+			Type:         graphql.Boolean,
+			DefaultValue: false,
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: internal.MaxTaskPageSize,
+		},
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		query, _ := params.Args["query"].(string)
+		limit, _ := params.Args["limit"].(int)
+		offset, _ := params.Args["offset"].(int)
+		if limit < 1 || limit > internal.MaxTaskPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", internal.MaxTaskPageSize)
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+
+		filter := database.TaskFilter{Query: strings.TrimSpace(query), Limit: limit, Offset: offset}
+		filter.Status, _ = params.Args["status"].(string)
+		filter.Priority, _ = params.Args["priority"].(string)
+		if assignee, ok := params.Args["assigneeId"].(int); ok {
+			filter.AssigneeID = int64(assignee)
+		}
+		filter.Unassigned, _ = params.Args["unassigned"].(bool)
+		filter.Sort, _ = params.Args["sort"].(string)
+		filter.Descending, _ = params.Args["descending"].(bool)
+
+		for _, arg := range []struct {
+			name  string
+			bound **time.Time
+			parse func(string) (time.Time, error)
+		}{
+			{"dueAfter", &filter.DueAfter, internal.ParseTaskDate},
+			{"dueBefore", &filter.DueBefore, internal.ParseTaskDate},
+			{"createdAfter", &filter.CreatedAfter, internal.ParseTaskTime},
+			{"createdBefore", &filter.CreatedBefore, internal.ParseTaskTime},
+			{"updatedAfter", &filter.UpdatedAfter, internal.ParseTaskTime},
+			{"updatedBefore", &filter.UpdatedBefore, internal.ParseTaskTime},
+		} {
+			t, err := optionalTimeArg(params.Args, arg.name, arg.parse)
+			if err != nil {
+				return nil, err
+			}
+			*arg.bound = t
+		}
+
+		tasks, _, err := database.ListTasks(filter)
+		return tasks, err
+	},
+}
+
+// applyTaskArgs copies the task fields given as mutation arguments onto
+// task. An empty dueDate clears the due date and an assigneeId of 0
+// unassigns the task.
+func applyTaskArgs(task *database.Task, args map[string]interface{}) error {
+	if title, ok := args["title"].(string); ok {
+		task.Title = title
+	}
+	if description, ok := args["description"].(string); ok {
+		task.Description = description
+	}
+	if status, ok := args["status"].(string); ok {
+		task.Status = status
+	}
+	if priority, ok := args["priority"].(string); ok {
+		task.Priority = priority
+	}
+	if due, ok := args["dueDate"].(string); ok {
+		if due == "" {
+			task.DueDate = nil
+		} else {
+			date, err := internal.ParseTaskDate(due)
+			if err != nil {
+				return fmt.Errorf("dueDate: %v", err)
+			}
+			task.DueDate = &date
+		}
+	}
+	if assignee, ok := args["assigneeId"].(int); ok {
+		task.AssigneeID = int64(assignee)
+	}
+	return internal.ValidateTask(*task)
+}
+
+// taskMutationArgs are the editable task fields, with the title required
+// when creating a task.
+func taskMutationArgs(titleType graphql.Input) graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"title": &graphql.ArgumentConfig{
+			Type: titleType,
+		},
+		"description": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"status": &graphql.ArgumentConfig{
+			Type: TaskStatusEnum,
+		},
+		"priority": &graphql.ArgumentConfig{
+			Type: TaskPriorityEnum,
+		},
+		"dueDate": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "YYYY-MM-DD, or empty for no due date",
+		},
+		"assigneeId": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "A user ID, or 0 for nobody",
+		},
+	}
+}
+
+var CreateTaskField = &graphql.Field{
+	Type:        TaskType,
+	Description: "Create a new task; status defaults to TODO and priority to MEDIUM",
+	Args:        taskMutationArgs(graphql.NewNonNull(graphql.String)),
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		task := database.Task{Status: database.TaskTodo, Priority: database.TaskPriorityMedium}
+		if err := applyTaskArgs(&task, params.Args); err != nil {
+			return nil, err
+		}
+
+		id, err := database.CreateTaskRecord(task)
+		if err != nil {
+			return nil, err
+		}
+		return database.ReadTask(int(id))
+	},
+}
+
+var UpdateTaskField = &graphql.Field{
+	Type:        TaskType,
+	Description: "Update a task; omitted fields are left unchanged",
+	Args: func() graphql.FieldConfigArgument {
+		args := taskMutationArgs(graphql.String)
+		args["id"] = &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		}
+		return args
+	}(),
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		task, err := database.ReadTask(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("task %d not found", id)
+			}
+			return nil, err
+		}
+		if err := applyTaskArgs(&task, params.Args); err != nil {
+			return nil, err
+		}
+
+		if err := database.UpdateTaskRecord(task); err != nil {
+			return nil, err
+		}
+		return database.ReadTask(id)
+	},
+}
+
+var DeleteTaskField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Move a task to the trash by ID",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		if err := database.DeleteTask(database.DB, id); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("task %d not found", id)
+			}
+			return nil, err
+		}
+		return true, nil
+	},
+}
+
+var MoveTaskField = &graphql.Field{
+	Type:        TaskType,
+	Description: "Move a task on the board to the column for status, directly before beforeId and after afterId; with neither it goes to the bottom of the column",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"status": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(TaskStatusEnum),
+		},
+		"beforeId": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+		"afterId": &graphql.ArgumentConfig{
+			Type: graphql.Int,
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		status, _ := params.Args["status"].(string)
+		beforeID, _ := params.Args["beforeId"].(int)
+		afterID, _ := params.Args["afterId"].(int)
+
+		err := internal.MoveTask(int64(id), status, int64(beforeID), int64(afterID))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("task %d not found", id)
+			}
+			return nil, err
+		}
+		return database.ReadTask(id)
+	},
+}
@@ -0,0 +1,72 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// Trash lists everything that has been deleted but not yet purged.
+type Trash struct {
+	Articles []database.Article
+	Tasks    []database.Task
+}
+
+var TrashType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Trash",
+	Fields: graphql.Fields{
+		"articles": &graphql.Field{
+			Type: graphql.NewList(ArticleType),
+		},
+		"tasks": &graphql.Field{
+			Type: graphql.NewList(TaskType),
+		},
+	},
+})
+
+var TrashQueryField = &graphql.Field{
+	Type:        TrashType,
+	Description: "Deleted articles and tasks that can still be restored, for editors",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if _, err := internal.RequirePermission(params.Context, internal.PermViewTrash); err != nil {
+			return nil, err
+		}
+		articles, err := database.GetDeletedArticles()
+		if err != nil {
+			return nil, err
+		}
+		tasks, err := database.GetDeletedTasks()
+		if err != nil {
+			return nil, err
+		}
+		return Trash{Articles: articles, Tasks: tasks}, nil
+	},
+}
+
+var RestoreArticleField = &graphql.Field{
+	Type:        ArticleType,
+	Description: "Restore a deleted article from the trash",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		return internal.RestoreArticle(params.Context, int64(id))
+	},
+}
+
+var RestoreTaskField = &graphql.Field{
+	Type:        TaskType,
+	Description: "Restore a deleted task from the trash",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		id, _ := params.Args["id"].(int)
+		return internal.RestoreTask(int64(id))
+	},
+}
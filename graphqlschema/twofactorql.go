@@ -0,0 +1,94 @@
+package graphqlschema
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/rmacdiarmid/gptback/internal"
+)
+
+var TwoFactorEnrollmentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TwoFactorEnrollment",
+	Fields: graphql.Fields{
+		"secret": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "For typing into an authenticator app",
+		},
+		"uri": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "otpauth:// URI to show as a QR code",
+		},
+	},
+})
+
+var EnrollTwoFactorField = &graphql.Field{
+	Type:        TwoFactorEnrollmentType,
+	Description: "Start setting up two-factor authentication. It is turned on by confirmTwoFactor.",
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		return internal.EnrollTwoFactor(params.Context)
+	},
+}
+
+var ConfirmTwoFactorField = &graphql.Field{
+	Type:        graphql.NewList(graphql.String),
+	Description: "Turn on two-factor authentication with a code from the newly set up app. Returns recovery codes, which are shown only this once.",
+	Args: graphql.FieldConfigArgument{
+		"code": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		code, _ := params.Args["code"].(string)
+		return internal.ConfirmTwoFactor(params.Context, code)
+	},
+}
+
+var DisableTwoFactorField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Turn off two-factor authentication, with a code or recovery code",
+	Args: graphql.FieldConfigArgument{
+		"code": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		code, _ := params.Args["code"].(string)
+		if err := internal.DisableTwoFactor(params.Context, code); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
+
+var VerifyTwoFactorField = &graphql.Field{
+	Type:        TokenPairType,
+	Description: "Finish signing in with the twoFactorToken from login and a code from the app, or a recovery code",
+	Args: graphql.FieldConfigArgument{
+		"twoFactorToken": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"code": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		token, _ := params.Args["twoFactorToken"].(string)
+		code, _ := params.Args["code"].(string)
+		return internal.CompleteTwoFactorLogin(params.Context, token, code)
+	},
+}
+
+var ResetTwoFactorField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Turn off two-factor authentication for a user who has lost their app, for admins",
+	Args: graphql.FieldConfigArgument{
+		"userId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		userID, _ := params.Args["userId"].(int)
+		if err := internal.ResetTwoFactor(params.Context, int64(userID)); err != nil {
+			return nil, err
+		}
+		return true, nil
+	},
+}
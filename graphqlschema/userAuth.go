@@ -15,9 +15,133 @@ var UserType = graphql.NewObject(graphql.ObjectConfig{
 		"email": &graphql.Field{
 			Type: graphql.String,
 		},
+		"role": &graphql.Field{
+			Type: RoleEnum,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return internal.UserRole(p.Source.(database.User))
+			},
+		},
+		"emailVerified": &graphql.Field{
+			Type: graphql.Boolean,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return internal.EmailVerified(p.Source.(database.User))
+			},
+		},
+		"twoFactorEnabled": &graphql.Field{
+			Type: graphql.Boolean,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return internal.TwoFactorEnabled(p.Source.(database.User).UserId)
+			},
+		},
 	},
 })
 
+// AuthorType is the public side of a user, as shown on their author page,
+// for the people named on articles, comments and tasks. Their email
+// address, role and sign-in settings stay private.
+var AuthorType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Author",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.Int,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(database.User).UserId, nil
+			},
+		},
+		"displayName": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(database.User).DisplayName(), nil
+			},
+		},
+	},
+})
+
+var RoleEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "Role",
+	Values: graphql.EnumValueConfigMap{
+		"ADMIN":  &graphql.EnumValueConfig{Value: database.RoleAdmin},
+		"EDITOR": &graphql.EnumValueConfig{Value: database.RoleEditor},
+		"AUTHOR": &graphql.EnumValueConfig{Value: database.RoleAuthor},
+		"VIEWER": &graphql.EnumValueConfig{Value: database.RoleViewer},
+	},
+})
+
+// ViewerType is the signed-in user and what they may do.
+var ViewerType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Viewer",
+	Fields: graphql.Fields{
+		"user": &graphql.Field{
+			Type: UserType,
+		},
+		"role": &graphql.Field{
+			Type: RoleEnum,
+		},
+		"permissions": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Permissions of the user's role, such as \"articles:create\"",
+		},
+	},
+})
+
+type viewer struct {
+	User        database.User
+	Role        int64
+	Permissions []internal.Permission
+}
+
+var MeQueryField = &graphql.Field{
+	Type:        ViewerType,
+	Description: "The signed-in user, or null",
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		user, ok := internal.UserFromContext(p.Context)
+		if !ok {
+			return nil, nil
+		}
+		roleID, err := internal.UserRole(user)
+		if err != nil {
+			return nil, err
+		}
+		return viewer{User: user, Role: roleID, Permissions: internal.RolePermissions(roleID)}, nil
+	},
+}
+
+var CanQueryField = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Whether the signed-in user has a permission",
+	Args: graphql.FieldConfigArgument{
+		"permission": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		permission, _ := p.Args["permission"].(string)
+		user, ok := internal.UserFromContext(p.Context)
+		return ok && internal.Can(user, internal.Permission(permission)), nil
+	},
+}
+
+var SetUserRoleField = &graphql.Field{
+	Type:        UserType,
+	Description: "Give a user another role, for admins",
+	Args: graphql.FieldConfigArgument{
+		"userId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"role": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(RoleEnum),
+		},
+	},
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		userID, _ := p.Args["userId"].(int)
+		roleID, _ := p.Args["role"].(int64)
+		if err := internal.SetUserRole(p.Context, int64(userID), roleID); err != nil {
+			return nil, err
+		}
+		return database.GetUserByID(int64(userID))
+	},
+}
+
 var RegisterInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 	Name: "RegisterInput",
 	Fields: graphql.InputObjectConfigFieldMap{
@@ -69,7 +193,8 @@ var RegisterMutation = &graphql.Field{
 }
 
 var LoginMutation = &graphql.Field{
-	Type: graphql.String,
+	Type:        TokenPairType,
+	Description: "Sign in, starting a session on this device",
 	Args: graphql.FieldConfigArgument{
 		"input": &graphql.ArgumentConfig{
 			Type: graphql.NewNonNull(LoginInputType),
@@ -77,7 +202,35 @@ var LoginMutation = &graphql.Field{
 	},
 	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 		input := p.Args["input"].(map[string]interface{})
-		token, err := internal.LoginUser(input)
-		return token, err
+		tokens, err := internal.LoginUser(p.Context, input)
+		return tokens, err
 	},
 }
+
+// authorized returns a copy of field whose resolver refuses to run unless
+// the signed-in user's role has permission.
+func authorized(permission internal.Permission, field *graphql.Field) *graphql.Field {
+	guarded := *field
+	resolve := field.Resolve
+	guarded.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+		if _, err := internal.RequirePermission(p.Context, permission); err != nil {
+			return nil, err
+		}
+		return resolve(p)
+	}
+	return &guarded
+}
+
+// signedInOnly returns a copy of field whose resolver refuses to run for
+// anonymous requests.
+func signedInOnly(field *graphql.Field) *graphql.Field {
+	guarded := *field
+	resolve := field.Resolve
+	guarded.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+		if _, err := internal.RequireUser(p.Context); err != nil {
+			return nil, err
+		}
+		return resolve(p)
+	}
+	return &guarded
+}
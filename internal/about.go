@@ -65,30 +65,3 @@ func CreateAboutTaskHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("Task created successfully")
 	w.WriteHeader(http.StatusCreated)
 }
-
-func GetTasksHandler(w http.ResponseWriter, r *http.Request) {
-	logger.DualLog.Println("Starting the GetTasksHandler function...")
-	defer logger.DualLog.Println("Exiting the GetTasksHandler function.")
-
-	// Get all tasks from the database
-	tasks, err := database.ReadAllTasks()
-	if err != nil {
-		logger.DualLog.Println(fmt.Sprintf("Error reading all tasks from database: %v", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Marshal the tasks data into JSON
-	jsonData, err := json.Marshal(tasks)
-	if err != nil {
-		logger.DualLog.Println(fmt.Sprintf("Error marshalling tasks data into JSON: %v", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Set the content type header and write the JSON data
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
-
-	logger.DualLog.Println("Tasks data successfully sent as JSON response")
-}
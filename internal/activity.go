@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const (
+	defaultActivityPageSize = 50
+	// MaxActivityPageSize is the most activity entries returned at once
+	MaxActivityPageSize = 100
+	// recentActivitySize is how many entries the task list page shows
+	recentActivitySize = 10
+)
+
+// activityPollInterval is how often the activity stream checks for new
+// entries.
+var activityPollInterval = 2 * time.Second
+
+// ActivityEntry is the JSON representation of an activity entry, as sent by
+// the activity stream.
+type ActivityEntry struct {
+	ID          int64     `json:"id"`
+	SubjectType string    `json:"subjectType"`
+	SubjectID   int64     `json:"subjectId"`
+	Action      string    `json:"action"`
+	Detail      string    `json:"detail,omitempty"`
+	Summary     string    `json:"summary"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func activityFromDatabase(a database.Activity) ActivityEntry {
+	return ActivityEntry{
+		ID:          a.ID,
+		SubjectType: a.SubjectType,
+		SubjectID:   a.SubjectID,
+		Action:      a.Action,
+		Detail:      a.Detail,
+		Summary:     a.Summary(),
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+// ActivityPageSize checks a requested number of activity entries, with 0
+// meaning the default.
+func ActivityPageSize(limit int) (int, error) {
+	if limit == 0 {
+		return defaultActivityPageSize, nil
+	}
+	if limit < 1 || limit > MaxActivityPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", MaxActivityPageSize)
+	}
+	return limit, nil
+}
+
+// ActivityHandler renders a page of the activity log, newest first. The
+// after parameter is the ID of the last entry of the previous page.
+func ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	var after int64
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		after, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || after < 0 {
+			http.Error(w, "after must be an activity ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := database.GetActivity(defaultActivityPageSize, after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Entries": entries,
+	}
+	if len(entries) == defaultActivityPageSize {
+		data["Next"] = entries[len(entries)-1].ID
+	}
+	RenderTemplateWithData(w, "base.gohtml", "activityContent", data)
+}
+
+// activityStreamStart works out which entry a stream follows on from: the
+// Last-Event-ID sent by a reconnecting browser, else the since parameter,
+// else the newest entry, so only new activity is sent.
+func activityStreamStart(r *http.Request) (int64, error) {
+	for _, value := range []string{r.Header.Get("Last-Event-ID"), r.URL.Query().Get("since")} {
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			return 0, fmt.Errorf("invalid activity ID %q", value)
+		}
+		return id, nil
+	}
+	return database.GetLatestActivityID()
+}
+
+// ActivityStreamHandler sends new activity as server-sent events until the
+// client goes away. Each event is an "activity" event whose ID is the entry
+// ID and whose data is an ActivityEntry.
+func ActivityStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	last, err := activityStreamStart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(activityPollInterval)
+	defer ticker.Stop()
+	for {
+		entries, err := database.GetActivitySince(last, MaxActivityPageSize)
+		if err != nil {
+			logger.DualLog.Printf("Error reading activity for stream: %v", err)
+			return
+		}
+		for _, entry := range entries {
+			data, err := json.Marshal(activityFromDatabase(entry))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: activity\ndata: %s\n\n", entry.ID, data); err != nil {
+				return
+			}
+			last = entry.ID
+		}
+		if len(entries) > 0 {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestActivityLog(t *testing.T) {
+	since, err := database.GetLatestActivityID()
+	if err != nil {
+		t.Fatalf("Failed to read the latest activity: %v", err)
+	}
+
+	id, err := database.CreateTaskRecord(database.Task{Title: "Activity task"})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	other, err := database.CreateTaskRecord(database.Task{Title: "Activity neighbour"})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if err := MoveTask(id, database.TaskDoing, 0, 0); err != nil {
+		t.Fatalf("Failed to move task: %v", err)
+	}
+	// Reordering within a column isn't recorded
+	if err := MoveTask(other, database.TaskTodo, 0, 0); err != nil {
+		t.Fatalf("Failed to move task: %v", err)
+	}
+	if err := database.DeleteTask(database.DB, int(id)); err != nil {
+		t.Fatalf("Failed to delete task: %v", err)
+	}
+	if _, err := RestoreTask(id); err != nil {
+		t.Fatalf("Failed to restore task: %v", err)
+	}
+
+	entries, err := database.GetActivitySince(since, MaxActivityPageSize)
+	if err != nil {
+		t.Fatalf("Failed to read activity: %v", err)
+	}
+	var summaries []string
+	for _, entry := range entries {
+		summaries = append(summaries, entry.Summary())
+	}
+	want := []string{
+		`Task "Activity task" created`,
+		`Task "Activity neighbour" created`,
+		`Task "Activity task" moved to doing`,
+		`Task "Activity task" deleted`,
+		`Task "Activity task" restored`,
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("Activity is %q, want %q", summaries, want)
+	}
+
+	// The log pages backwards from the newest entry
+	page, err := database.GetActivity(2, 0)
+	if err != nil || len(page) != 2 || page[0].ID != entries[4].ID {
+		t.Fatalf("The first page of activity is wrong: %+v, %v", page, err)
+	}
+	page, err = database.GetActivity(2, page[1].ID)
+	if err != nil || len(page) != 2 || page[0].ID != entries[2].ID {
+		t.Errorf("The second page of activity is wrong: %+v, %v", page, err)
+	}
+
+	// The stream sends everything after the entry it follows on from. A
+	// reconnecting browser's Last-Event-ID takes precedence over since.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", fmt.Sprintf("/activity/stream?since=%d", since), nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(entries[2].ID))
+	rr := httptest.NewRecorder()
+	ActivityStreamHandler(rr, req)
+
+	if got := rr.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("The stream's content type is %q", got)
+	}
+	body := rr.Body.String()
+	if strings.Count(body, "event: activity\n") != 2 {
+		t.Errorf("The stream should send two entries:\n%s", body)
+	}
+	if !strings.Contains(body, fmt.Sprintf("id: %d\nevent: activity\ndata: {\"id\":%d,\"subjectType\":\"task\",\"subjectId\":%d,\"action\":\"deleted\",\"summary\":\"Task \\\"Activity task\\\" deleted\"", entries[3].ID, entries[3].ID, id)) {
+		t.Errorf("The stream doesn't send the deletion:\n%s", body)
+	}
+}
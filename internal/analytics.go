@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const (
+	defaultViewFlushInterval = time.Minute
+	defaultMaxPendingViews   = 1000
+)
+
+var botUserAgent = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|archiver|facebookexternalhit|preview|headless|curl|wget|python|go-http-client|java/|httpclient|libwww`)
+
+// IsBot reports whether a user agent belongs to a crawler or script rather
+// than a reader. Requests without a user agent are treated as bots.
+func IsBot(userAgent string) bool {
+	return userAgent == "" || botUserAgent.MatchString(userAgent)
+}
+
+// ViewRecorder counts article views in memory and writes them to the
+// database in batches, so rendering an article doesn't wait on SQLite.
+//
+// Readers are never stored. Each view is keyed by a hash of the client IP and
+// user agent with a salt that changes daily and only lives in memory, so
+// repeat views by the same reader on the same day are counted once and the
+// hashes can't be linked to an address or across days. At most maxSeen
+// hashes are kept; past that the oldest are forgotten, and those readers'
+// next views count again.
+type ViewRecorder struct {
+	mu         sync.Mutex
+	pending    map[database.ArticleDay]int
+	seen       map[string]time.Time
+	maxSeen    int
+	day        string
+	salt       []byte
+	maxPending int
+	now        func() time.Time
+}
+
+func NewViewRecorder(maxPending int) *ViewRecorder {
+	return &ViewRecorder{
+		pending:    map[database.ArticleDay]int{},
+		seen:       map[string]time.Time{},
+		maxSeen:    maxTrackedKeys,
+		maxPending: maxPending,
+		now:        time.Now,
+	}
+}
+
+var articleViews = NewViewRecorder(defaultMaxPendingViews)
+
+// Record counts a view of an article unless it comes from a bot or the same
+// reader already viewed the article today.
+func (v *ViewRecorder) Record(r *http.Request, articleID int64) {
+	userAgent := r.UserAgent()
+	if IsBot(userAgent) {
+		return
+	}
+
+	v.mu.Lock()
+	now := v.now()
+	day := now.UTC().Format(database.ViewDayFormat)
+	if day != v.day {
+		v.rotate(day)
+	}
+	sum := sha256.Sum256(append(append([]byte{}, v.salt...), ClientIPFromContext(r.Context())+"\x00"+userAgent...))
+	visitor := fmt.Sprintf("%x:%d", sum, articleID)
+	if _, ok := v.seen[visitor]; ok {
+		v.mu.Unlock()
+		return
+	}
+	if len(v.seen) >= v.maxSeen {
+		keys := make([]string, 0, len(v.seen))
+		for key := range v.seen {
+			keys = append(keys, key)
+		}
+		dropOldestKeys(keys, func(key string) time.Time { return v.seen[key] }, func(key string) { delete(v.seen, key) })
+	}
+	v.seen[visitor] = now
+	v.pending[database.ArticleDay{ArticleID: articleID, Day: day}]++
+	full := len(v.pending) >= v.maxPending
+	v.mu.Unlock()
+
+	if full {
+		go v.Flush()
+	}
+}
+
+// rotate starts a new day with a fresh salt. The caller holds v.mu.
+func (v *ViewRecorder) rotate(day string) {
+	v.day = day
+	v.seen = map[string]time.Time{}
+	v.salt = make([]byte, 32)
+	if _, err := rand.Read(v.salt); err != nil {
+		logger.DualLog.Printf("Error generating view salt: %v", err)
+	}
+}
+
+// Flush writes the pending counts to the database. Counts that fail to write
+// are kept for the next flush.
+func (v *ViewRecorder) Flush() error {
+	v.mu.Lock()
+	pending := v.pending
+	v.pending = map[database.ArticleDay]int{}
+	v.mu.Unlock()
+
+	if err := database.AddArticleViews(pending); err != nil {
+		v.mu.Lock()
+		for key, views := range pending {
+			v.pending[key] += views
+		}
+		v.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// StartViewRecorder flushes recorded views every FlushInterval until ctx is
+// cancelled. Call FlushArticleViews on shutdown to write the rest.
+func StartViewRecorder(ctx context.Context, cfg config.AnalyticsConfig) {
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		interval = defaultViewFlushInterval
+	}
+	if cfg.MaxPending > 0 {
+		articleViews.mu.Lock()
+		articleViews.maxPending = cfg.MaxPending
+		articleViews.mu.Unlock()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				FlushArticleViews()
+			}
+		}
+	}()
+}
+
+// FlushArticleViews writes the views recorded since the last flush.
+func FlushArticleViews() {
+	if err := articleViews.Flush(); err != nil {
+		logger.DualLog.Printf("Error flushing article views: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestIsBot(t *testing.T) {
+	for userAgent, want := range map[string]bool{
+		"": true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": true,
+		"curl/7.88.1": true,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 13_4) AppleWebKit/605.1.15 Version/16.5 Safari": false,
+	} {
+		if got := IsBot(userAgent); got != want {
+			t.Errorf("IsBot(%q) = %v, want %v", userAgent, got, want)
+		}
+	}
+}
+
+func TestViewRecorder(t *testing.T) {
+	articleID, err := database.CreateArticle("Viewed Article", "viewed.jpg", "Viewed preview", "Viewed text")
+	if err != nil {
+		t.Fatalf("Failed to create article for testing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(articleID)
+	})
+
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	recorder := NewViewRecorder(100)
+	recorder.now = func() time.Time { return now }
+
+	view := func(ip, userAgent string) {
+		req := httptest.NewRequest("GET", "/articles/viewed-article", nil)
+		req.Header.Set("User-Agent", userAgent)
+		recorder.Record(req.WithContext(context.WithValue(req.Context(), clientIPContextKey, ip)), articleID)
+	}
+	browser := "Mozilla/5.0 (X11; Linux x86_64) Firefox/115.0"
+
+	view("1.2.3.4", browser)
+	view("1.2.3.4", browser)
+	view("5.6.7.8", browser)
+	view("9.9.9.9", "Googlebot/2.1")
+	now = now.Add(24 * time.Hour)
+	view("1.2.3.4", browser)
+
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	views, err := database.GetArticleViewCount(articleID)
+	if err != nil {
+		t.Fatalf("GetArticleViewCount failed: %v", err)
+	}
+	if views != 3 {
+		t.Errorf("Recorded %d views, want 3: one per reader per day, without bots", views)
+	}
+
+	popular, err := database.GetPopularArticles(now, 10)
+	if err != nil {
+		t.Fatalf("GetPopularArticles failed: %v", err)
+	}
+	if len(popular) != 1 || popular[0].Article.ID != articleID || popular[0].Views != 1 {
+		t.Errorf("GetPopularArticles only counting the last day returned %+v", popular)
+	}
+
+	// Readers with new user agents can't grow the recorder without limit
+	recorder = NewViewRecorder(100)
+	recorder.now = func() time.Time { return now }
+	recorder.maxSeen = 4
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		view("1.2.3.4", fmt.Sprintf("%s rotated/%d", browser, i))
+	}
+	if len(recorder.seen) > 4 {
+		t.Errorf("ViewRecorder kept %d readers, over its maximum", len(recorder.seen))
+	}
+}
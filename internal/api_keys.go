@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const (
+	// APIKeyHeader is the header scripts send their API key in. It can also
+	// be sent as a bearer token.
+	APIKeyHeader = "X-API-Key"
+	// apiKeyMarker starts every API key, to tell them from access tokens
+	// and to make leaked keys easy to search for
+	apiKeyMarker = "gpt_"
+	// maxAPIKeyNameLength is the longest name a key can be given
+	maxAPIKeyNameLength = 100
+
+	defaultAPIKeyTTL = 90 * 24 * time.Hour
+	maxAPIKeyTTL     = 365 * 24 * time.Hour
+)
+
+const apiKeyContextKey contextKey = "apiKey"
+
+// ErrInvalidAPIKey is returned for an API key that is unknown, revoked or
+// expired.
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+// ErrNeedsSession is returned for account changes that an API key can't
+// make, however it is scoped, so a leaked key can't take over the account.
+var ErrNeedsSession = errors.New("sign in with your password to do this")
+
+// NewAPIKey is a newly made API key, with the key itself, which is only
+// ever shown this once.
+type NewAPIKey struct {
+	Key    string
+	APIKey database.APIKey
+}
+
+// CreateAPIKey makes an API key for the signed-in user that can use the
+// given permissions of their role until it expires after ttl, or after 90
+// days if ttl is 0.
+func CreateAPIKey(ctx context.Context, name string, scopes []string, ttl time.Duration) (NewAPIKey, error) {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return NewAPIKey{}, fmt.Errorf("an API key needs a name of up to %d characters", maxAPIKeyNameLength)
+	}
+	if ttl == 0 {
+		ttl = defaultAPIKeyTTL
+	}
+	if ttl < 0 || ttl > maxAPIKeyTTL {
+		return NewAPIKey{}, fmt.Errorf("an API key can last at most %d days", int(maxAPIKeyTTL.Hours()/24))
+	}
+	scopes, err = validScopes(user, scopes)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+
+	prefix := make([]byte, 6)
+	if _, err := rand.Read(prefix); err != nil {
+		return NewAPIKey{}, err
+	}
+	secret, err := newSecretToken()
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	now := time.Now()
+	key := database.APIKey{
+		UserID:    user.UserId,
+		Name:      name,
+		Prefix:    hex.EncodeToString(prefix),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	plain := apiKeyMarker + key.Prefix + "_" + secret
+	key.KeyHash = hashToken(plain)
+	if key.ID, err = database.CreateAPIKey(key); err != nil {
+		return NewAPIKey{}, err
+	}
+	return NewAPIKey{Key: plain, APIKey: key}, nil
+}
+
+// validScopes checks a key is only given permissions its user's role has,
+// and returns them sorted without repeats.
+func validScopes(user database.User, scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("an API key needs at least one scope")
+	}
+	roleID, err := UserRole(user)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var valid []string
+	for _, scope := range scopes {
+		if !RoleHas(roleID, Permission(scope)) {
+			return nil, fmt.Errorf("your role doesn't have the %q permission", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+	sort.Strings(valid)
+	return valid, nil
+}
+
+// ListAPIKeys returns the signed-in user's API keys that haven't been
+// revoked, including expired ones.
+func ListAPIKeys(ctx context.Context) ([]database.APIKey, error) {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetAPIKeys(user.UserId)
+}
+
+// RevokeAPIKey stops one of the signed-in user's API keys working.
+func RevokeAPIKey(ctx context.Context, id int64) error {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return err
+	}
+	err = database.RevokeAPIKey(user.UserId, id)
+	if err == sql.ErrNoRows {
+		return errors.New("there is no such API key to revoke")
+	}
+	return err
+}
+
+// isAPIKey reports whether a token from a request is an API key rather than
+// an access token.
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyMarker)
+}
+
+// authenticateAPIKey returns the user an API key belongs to. The key is
+// found by its prefix and then its hash is compared, so the lookup doesn't
+// depend on the secret part.
+func authenticateAPIKey(plain string) (database.User, database.APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(plain, apiKeyMarker), "_")
+	if !ok || !isAPIKey(plain) {
+		return database.User{}, database.APIKey{}, ErrInvalidAPIKey
+	}
+	key, err := database.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		return database.User{}, database.APIKey{}, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(plain))) != 1 {
+		return database.User{}, database.APIKey{}, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if !key.Active(now) {
+		return database.User{}, database.APIKey{}, ErrInvalidAPIKey
+	}
+	user, err := database.GetUserByID(key.UserID)
+	if err != nil {
+		return database.User{}, database.APIKey{}, ErrInvalidAPIKey
+	}
+	database.TouchAPIKey(key.ID, now)
+	return user, key, nil
+}
+
+// WithAPIKey returns a copy of ctx recording that the request was made with
+// an API key, which limits it to the key's scopes.
+func WithAPIKey(ctx context.Context, key database.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
+
+// APIKeyFromContext returns the API key the request was made with, if it
+// was.
+func APIKeyFromContext(ctx context.Context) (database.APIKey, bool) {
+	if ctx == nil {
+		return database.APIKey{}, false
+	}
+	key, ok := ctx.Value(apiKeyContextKey).(database.APIKey)
+	return key, ok
+}
+
+// apiKeyAllows reports whether a request made with an API key may use a
+// permission. Requests made with an access token aren't limited.
+func apiKeyAllows(ctx context.Context, permission Permission) bool {
+	key, ok := APIKeyFromContext(ctx)
+	if !ok {
+		return true
+	}
+	for _, scope := range key.Scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireSessionUser is RequireUser for the account itself, such as making
+// or revoking API keys and seeing or ending sessions, which have to be done
+// after signing in rather than with an API key.
+func RequireSessionUser(ctx context.Context) (database.User, error) {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	if _, ok := APIKeyFromContext(ctx); ok {
+		return database.User{}, ErrNeedsSession
+	}
+	return user, nil
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestAPIKeys(t *testing.T) {
+	author := createUserWithRole(t, "api-keys@example.com", database.RoleAuthor)
+	ctx := WithSession(WithUser(context.Background(), author), "session")
+
+	if _, err := CreateAPIKey(ctx, "CI", []string{string(PermModerateComments)}, 0); err == nil {
+		t.Errorf("A key was given a permission the user's role doesn't have")
+	}
+	if _, err := CreateAPIKey(ctx, "CI", nil, 0); err == nil {
+		t.Errorf("A key without scopes was made")
+	}
+	if _, err := CreateAPIKey(ctx, "CI", []string{string(PermCreateArticles)}, 2*maxAPIKeyTTL); err == nil {
+		t.Errorf("A key lasting too long was made")
+	}
+	created, err := CreateAPIKey(ctx, " CI ", []string{string(PermCreateArticles), string(PermCreateArticles)}, 0)
+	if err != nil {
+		t.Fatalf("CreateAPIKey returned error: %v", err)
+	}
+	if created.APIKey.Name != "CI" || len(created.APIKey.Scopes) != 1 || !isAPIKey(created.Key) {
+		t.Errorf("Unexpected key: %+v", created)
+	}
+	if created.APIKey.KeyHash == created.Key {
+		t.Errorf("The key was stored as is")
+	}
+
+	// The key signs requests in, as a header or as a bearer token, limited to
+	// its scopes
+	var seen context.Context
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r.Context() }))
+	serve := func(header, value string) int {
+		seen = nil
+		req := httptest.NewRequest("POST", "/graphql", nil)
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	for header, value := range map[string]string{APIKeyHeader: created.Key, "Authorization": "Bearer " + created.Key} {
+		if code := serve(header, value); code != http.StatusOK {
+			t.Fatalf("The key in %s was rejected: %d", header, code)
+		}
+		if user, ok := UserFromContext(seen); !ok || user.UserId != author.UserId {
+			t.Errorf("The key in %s didn't sign in its user", header)
+		}
+	}
+	if _, err := RequirePermission(seen, PermCreateArticles); err != nil {
+		t.Errorf("The key couldn't use its scope: %v", err)
+	}
+	if _, err := RequirePermission(seen, PermWriteTasks); err != ErrForbidden {
+		t.Errorf("The key used a permission outside its scopes: %v", err)
+	}
+	if _, err := CreateAPIKey(seen, "another", []string{string(PermCreateArticles)}, 0); err != ErrNeedsSession {
+		t.Errorf("A key made another key: %v", err)
+	}
+	if _, err := EnrollTwoFactor(seen); err != ErrNeedsSession {
+		t.Errorf("A key changed two-factor authentication: %v", err)
+	}
+	if err := RevokeAPIKey(seen, created.APIKey.ID); err != ErrNeedsSession {
+		t.Errorf("A key revoked a key: %v", err)
+	}
+	if _, err := ListSessions(seen); err != ErrNeedsSession {
+		t.Errorf("A key listed its user's sessions: %v", err)
+	}
+	if err := RevokeSession(seen, "session"); err != ErrNeedsSession {
+		t.Errorf("A key ended a session: %v", err)
+	}
+	if _, err := LogoutAll(seen); err != ErrNeedsSession {
+		t.Errorf("A key ended its user's sessions: %v", err)
+	}
+
+	keys, err := ListAPIKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("ListAPIKeys returned %+v, %v", keys, err)
+	}
+
+	for name, bad := range map[string]string{
+		"unknown prefix": "gpt_000000000000_secret",
+		"wrong secret":   created.Key[:len(created.Key)-2] + "xx",
+		"without prefix": "gpt_",
+	} {
+		if code := serve(APIKeyHeader, bad); code != http.StatusUnauthorized {
+			t.Errorf("A key with %s returned %d", name, code)
+		}
+	}
+
+	// Expired and revoked keys stop working
+	expiring, err := CreateAPIKey(ctx, "expiring", []string{string(PermCreateArticles)}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if code := serve(APIKeyHeader, expiring.Key); code != http.StatusUnauthorized {
+		t.Errorf("An expired key returned %d", code)
+	}
+	if err := RevokeAPIKey(ctx, created.APIKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey returned error: %v", err)
+	}
+	if code := serve(APIKeyHeader, created.Key); code != http.StatusUnauthorized {
+		t.Errorf("A revoked key returned %d", code)
+	}
+	other := createUserWithRole(t, "api-keys-other@example.com", database.RoleAuthor)
+	if err := RevokeAPIKey(WithUser(context.Background(), other), expiring.APIKey.ID); err == nil {
+		t.Errorf("A user revoked someone else's key")
+	}
+}
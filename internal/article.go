@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func ArticleHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("ArticleHandler called")
+	defer logger.DualLog.Println("ArticleHandler exited")
+
+	slug := mux.Vars(r)["slug"]
+	article, err := database.GetArticleBySlug(slug)
+	if err == sql.ErrNoRows || (err == nil && !article.Published) {
+		NotFoundHandler(w, r)
+		return
+	}
+	if err != nil {
+		logger.DualLog.Printf("Error fetching article %s: %v", slug, err)
+		http.Error(w, "Error fetching article", http.StatusInternalServerError)
+		return
+	}
+
+	articleViews.Record(r, article.ID)
+
+	_, signedIn := UserFromContext(r.Context())
+
+	data := articlePageData(article)
+	data["CommentsOpen"] = true
+	data["CommentStatus"] = r.URL.Query().Get("comment")
+	data["SignedIn"] = signedIn
+	if threads, ok := data["Comments"].([]*CommentThread); ok {
+		openCommentReplies(threads, "/articles/"+article.Slug+"/comments", !signedIn)
+	}
+
+	// Only generated articles are rated, since ratings evaluate prompts
+	if article.Prompt != "" {
+		average, count, err := database.GetArticleRating(article.ID)
+		if err != nil {
+			logger.DualLog.Printf("Error fetching rating of article %d: %v", article.ID, err)
+		}
+		score, err := database.GetRaterScore(article.ID, raterKey(r.Context()))
+		if err != nil {
+			logger.DualLog.Printf("Error fetching score of article %d: %v", article.ID, err)
+		}
+		data["RatingsOpen"] = true
+		data["AverageRating"] = average
+		data["RatingCount"] = count
+		data["YourScore"] = score
+		data["RatingScores"] = []int{1, 2, 3, 4, 5}
+	}
+
+	RenderTemplateWithData(w, "base.gohtml", "articleContent", data)
+}
+
+// articlePageData builds the data for the article template with its approved
+// comments. The author is only set when the article has one, so the template
+// can test for it.
+func articlePageData(article database.Article) map[string]interface{} {
+	data := map[string]interface{}{
+		"ContentTemplateName": "article",
+		"Article":             article,
+	}
+	if article.AuthorID != 0 {
+		author, err := database.GetUserByID(article.AuthorID)
+		if err != nil {
+			logger.DualLog.Printf("Error fetching author %d of article %d: %v", article.AuthorID, article.ID, err)
+		} else {
+			data["Author"] = author
+		}
+	}
+
+	tags, err := database.GetArticleTags(article.ID)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching tags of article %d: %v", article.ID, err)
+	}
+	var links []tagGroup
+	for _, tag := range tags {
+		if slug := database.Slugify(tag); slug != "" {
+			links = append(links, tagGroup{Name: tag, Path: tagPath(slug)})
+		}
+	}
+	data["Tags"] = links
+
+	comments, err := database.GetCommentsByArticle(article.ID, database.CommentApproved)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching comments of article %d: %v", article.ID, err)
+	}
+	data["Comments"] = buildCommentThreads(comments)
+	return data
+}
+
+func AuthorHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("AuthorHandler called")
+	defer logger.DualLog.Println("AuthorHandler exited")
+
+	authorID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	author, err := database.GetUserByID(authorID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			NotFoundHandler(w, r)
+			return
+		}
+		logger.DualLog.Printf("Error fetching author %d: %v", authorID, err)
+		http.Error(w, "Error fetching author", http.StatusInternalServerError)
+		return
+	}
+
+	articles, err := database.GetArticlesByAuthor(authorID)
+	if err != nil {
+		http.Error(w, "Error fetching articles", http.StatusInternalServerError)
+		return
+	}
+	published := articles[:0]
+	for _, article := range articles {
+		if article.Published {
+			published = append(published, article)
+		}
+	}
+
+	RenderTemplateWithData(w, "base.gohtml", "authorContent", authorPageData(author, published))
+}
+
+func authorPageData(author database.User, articles []database.Article) map[string]interface{} {
+	return map[string]interface{}{
+		"ContentTemplateName": "author",
+		"Author":              author,
+		"Articles":            articles,
+	}
+}
+
+// tagGroup is a tag page: the articles whose tags have the same slug.
+type tagGroup struct {
+	Name     string
+	Path     string
+	Articles []database.Article
+}
+
+// tagPath is the page of a tag slug. Tags are free text, so pages are
+// addressed by slug, which is always safe in URLs and file names.
+func tagPath(slug string) string {
+	return "/tags/" + slug
+}
+
+// groupArticlesByTag groups articles by the slugs of their tags, ordered by
+// slug. Tags without a slug, such as "++", get no page.
+func groupArticlesByTag(articles []database.Article, tags map[int64][]string) []tagGroup {
+	groups := map[string]*tagGroup{}
+	var slugs []string
+	for _, article := range articles {
+		for _, tag := range tags[article.ID] {
+			slug := database.Slugify(tag)
+			if slug == "" {
+				continue
+			}
+			group, ok := groups[slug]
+			if !ok {
+				group = &tagGroup{Name: tag, Path: tagPath(slug)}
+				groups[slug] = group
+				slugs = append(slugs, slug)
+			}
+			// Tags like "go" and "Go!" share a slug; list articles once
+			if n := len(group.Articles); n == 0 || group.Articles[n-1].ID != article.ID {
+				group.Articles = append(group.Articles, article)
+			}
+		}
+	}
+	sort.Strings(slugs)
+
+	grouped := make([]tagGroup, 0, len(slugs))
+	for _, slug := range slugs {
+		grouped = append(grouped, *groups[slug])
+	}
+	return grouped
+}
+
+// TagHandler lists the published articles with a tag.
+func TagHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("TagHandler called")
+	defer logger.DualLog.Println("TagHandler exited")
+
+	articles, err := database.GetPublishedArticles()
+	if err != nil {
+		http.Error(w, "Error fetching articles", http.StatusInternalServerError)
+		return
+	}
+	tags, err := database.GetAllArticleTags()
+	if err != nil {
+		http.Error(w, "Error fetching tags", http.StatusInternalServerError)
+		return
+	}
+
+	path := tagPath(mux.Vars(r)["tag"])
+	for _, group := range groupArticlesByTag(articles, tags) {
+		if group.Path == path {
+			RenderTemplateWithData(w, "base.gohtml", "tagContent", tagPageData(group))
+			return
+		}
+	}
+	NotFoundHandler(w, r)
+}
+
+func tagPageData(group tagGroup) map[string]interface{} {
+	return map[string]interface{}{
+		"ContentTemplateName": "tag",
+		"Tag":                 group.Name,
+		"Articles":            group.Articles,
+	}
+}
@@ -1,13 +1,70 @@
 package internal
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/rmacdiarmid/GPTSite/logger"
-	"github.com/rmacdiarmid/GPTSite/pkg/database"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
 )
 
+const (
+	// generationPurpose is added to the audience of the token that carries
+	// a generated article's prompt and model to the accept form
+	generationPurpose = "#generation"
+	// generationTTL is how long a generated article can wait to be accepted
+	generationTTL = 24 * time.Hour
+)
+
+var errBadGeneration = errors.New("the generated article can't be verified; please generate it again")
+
+// signGeneration signs the prompt and model an article's text was generated
+// with, so they can go through the accept form without the submitter being
+// able to change them, or attach them to other text.
+func signGeneration(prompt, model, text string) (string, error) {
+	if authKeys == nil {
+		return "", errNoKeys
+	}
+	return authKeys.Sign(jwt.MapClaims{
+		"prompt": prompt,
+		"model":  model,
+		"text":   generatedTextDigest(text),
+		"iss":    authConfig.Issuer,
+		"aud":    authConfig.Audience + generationPurpose,
+		"exp":    time.Now().Add(generationTTL).Unix(),
+	})
+}
+
+// parseGeneration returns the prompt and model signed by signGeneration for
+// text.
+func parseGeneration(token, text string) (string, string, error) {
+	if authKeys == nil {
+		return "", "", errNoKeys
+	}
+	parsed, err := jwt.Parse(token, authKeys.Keyfunc)
+	if err != nil {
+		return "", "", errBadGeneration
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid || !claims.VerifyExpiresAt(time.Now().Unix(), true) ||
+		!claims.VerifyIssuer(authConfig.Issuer, true) || !hasAudience(claims, authConfig.Audience+generationPurpose) ||
+		claims["text"] != generatedTextDigest(text) {
+		return "", "", errBadGeneration
+	}
+	prompt, _ := claims["prompt"].(string)
+	model, _ := claims["model"].(string)
+	return prompt, model, nil
+}
+
+// generatedTextDigest hashes an article's text for its generation token.
+// Browsers send the text back from the form with CRLF line endings.
+func generatedTextDigest(text string) string {
+	return hashToken(strings.ReplaceAll(text, "\r\n", "\n"))
+}
+
 func ArticleGeneratorHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("Starting the ArticleGeneratorHandler function...")
 	defer logger.DualLog.Println("Exiting the ArticleGeneratorHandler function.")
@@ -51,6 +108,13 @@ func GenerateArticleHandler(w http.ResponseWriter, r *http.Request) {
 	// Generate the preview by taking the first 25 words of the articleText
 	preview := generatePreview(articleText, 25)
 
+	generation, err := signGeneration(prompt, ChatGPTModel, articleText)
+	if err != nil {
+		logger.DualLog.Printf("Error signing generated article: %v", err)
+		http.Error(w, "Error generating article", http.StatusInternalServerError)
+		return
+	}
+
 	data := map[string]interface{}{
 		"Content":     "article_generator.gohtml",
 		"Generated":   true,
@@ -58,6 +122,9 @@ func GenerateArticleHandler(w http.ResponseWriter, r *http.Request) {
 		"ImageURL":    imageURL,
 		"ArticleText": articleText,
 		"Preview":     preview,
+		"Prompt":      prompt,
+		"Model":       ChatGPTModel,
+		"Generation":  generation,
 	}
 
 	RenderTemplateWithData(w, "base.gohtml", "articleGeneratorContent", data)
@@ -77,10 +144,30 @@ func AcceptArticleHandler(w http.ResponseWriter, r *http.Request) {
 	imageURL := r.FormValue("image_url")
 	articleText := r.FormValue("article_text")
 
+	// The prompt and model come signed from GenerateArticleHandler, since
+	// the rating report ranks them
+	prompt, model, err := parseGeneration(r.FormValue("generation"), articleText)
+	if err != nil {
+		logger.DualLog.Printf("Error verifying generated article: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Generate the preview by taking the first 25 words of the articleText
 	preview := generatePreview(articleText, 25)
 
-	_, err := database.InsertArticle(title, imageURL, preview, articleText)
+	article := database.Article{
+		Title:   title,
+		Image:   imageURL,
+		Preview: preview,
+		Text:    articleText,
+		Prompt:  prompt,
+		Model:   model,
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		article.AuthorID = user.UserId
+	}
+	_, err = database.CreateArticleRecord(article)
 	if err != nil {
 		// Handle error
 		logger.DualLog.Printf("Error uploading article: %v", err)
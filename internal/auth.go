@@ -1,15 +1,27 @@
 package internal
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/logger"
 	"github.com/rmacdiarmid/gptback/pkg/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned for a wrong email address or password,
+// without saying which.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// ErrLoginLocked is returned while too many failed sign-ins keep an account
+// or IP address from signing in.
+var ErrLoginLocked = errors.New("too many failed sign-in attempts; try again later")
+
 func IsEmailUnique(email string) (bool, error) {
 	_, err := database.GetUserByEmail(email)
 	if err == nil {
@@ -19,8 +31,12 @@ func IsEmailUnique(email string) (bool, error) {
 }
 
 func RegisterUser(input map[string]interface{}) (int64, error) {
+	email, err := NormalizeEmail(input["email"].(string))
+	if err != nil {
+		return 0, err
+	}
+
 	// Check if the email is unique
-	email := input["email"].(string)
 	unique, err := IsEmailUnique(email)
 	if !unique {
 		return 0, err
@@ -51,40 +67,127 @@ func RegisterUser(input map[string]interface{}) (int64, error) {
 		return 0, fmt.Errorf("error creating user: %v", err)
 	}
 
+	user.UserId = userID
+	if err := SendVerificationEmail(context.Background(), user); err != nil {
+		// They can have it sent again once signed in
+		logger.DualLog.Printf("Error sending verification email to user %d: %v", userID, err)
+	}
+
 	return userID, nil
 }
 
-func LoginUser(input map[string]interface{}) (string, error) {
-	email := input["email"].(string)
+// LoginUser checks a user's email address and password and starts a
+// session for them on the device that made the request in ctx. Users with
+// two-factor authentication get a TwoFactorToken instead, to exchange with
+// a code for their session.
+//
+// Whether the address or the password was wrong, the error is the same,
+// and takes as long, so sign-in can't be used to find out who has an
+// account. Too many failures lock the account, or the client's IP address,
+// out for a while. Every attempt is recorded.
+func LoginUser(ctx context.Context, input map[string]interface{}) (TokenPair, error) {
+	email := strings.ToLower(strings.TrimSpace(input["email"].(string)))
 	password := input["password"].(string)
+	ip := ClientIPFromContext(ctx)
+	attempt := database.LoginAttempt{Email: email, IPAddress: ip, UserAgent: UserAgentFromContext(ctx)}
+
+	_, accountLocked := accountLoginThrottle.Locked(email)
+	_, ipLocked := ipLoginThrottle.Locked(ip)
+	if accountLocked || (ipLocked && ip != "") {
+		recordLoginAttempt(attempt, database.LoginLockedOut)
+		return TokenPair{}, ErrLoginLocked
+	}
 
-	// Retrieve user data from the database
-	// Assuming you have a function `database.GetUserByEmail` that takes the email and returns the user data
 	user, err := database.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return TokenPair{}, fmt.Errorf("error retrieving user: %v", err)
+	}
 	if err != nil {
-		return "", fmt.Errorf("error retrieving user: %v", err)
+		// Take as long as checking a real password would
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		loginFailed(attempt, database.LoginNoSuchUser)
+		return TokenPair{}, ErrInvalidCredentials
 	}
+	attempt.UserID = user.UserId
 
 	// Compare the provided password with the stored password hash
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return "", fmt.Errorf("invalid password")
+		loginFailed(attempt, database.LoginBadPassword)
+		return TokenPair{}, ErrInvalidCredentials
 	}
+	accountLoginThrottle.Success(email)
 
-	// Create a JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": user.UserId,
-		"email":  user.Email,
-		"exp":    time.Now().Add(time.Hour * 24).Unix(),
-	})
+	twoFactor, err := TwoFactorEnabled(user.UserId)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error checking two-factor authentication: %v", err)
+	}
+	if twoFactor {
+		token, err := issueTwoFactorToken(user)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		recordLoginAttempt(attempt, database.LoginNeedsCode)
+		return TokenPair{TwoFactorToken: token}, nil
+	}
 
-	// Sign and return the token
-	// Assuming you have an environment variable `JWT_SECRET` with your JWT secret
-	jwtSecret := os.Getenv("JWT_SECRET")
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+	tokens, err := StartSession(ctx, user)
 	if err != nil {
-		return "", fmt.Errorf("error signing token: %v", err)
+		return TokenPair{}, fmt.Errorf("error starting session: %v", err)
 	}
+	recordLoginAttempt(attempt, database.LoginSucceeded)
 
-	return signedToken, nil
+	return tokens, nil
+}
+
+// loginFailed counts a failed sign-in against the account and the IP
+// address, and records it.
+func loginFailed(attempt database.LoginAttempt, outcome string) {
+	accountLoginThrottle.Failure(attempt.Email)
+	// Requests that didn't come through the client IP middleware have no
+	// address to share a lockout
+	if attempt.IPAddress != "" {
+		ipLoginThrottle.Failure(attempt.IPAddress)
+	}
+	recordLoginAttempt(attempt, outcome)
+}
+
+// recordLoginAttempt adds an attempt to the audit log. Failing to record it
+// doesn't stop anyone signing in.
+func recordLoginAttempt(attempt database.LoginAttempt, outcome string) {
+	attempt.Outcome = outcome
+	attempt.CreatedAt = time.Now()
+	if outcome != database.LoginSucceeded {
+		logger.DualLog.Printf("Sign-in as %s from %s: %s", attempt.Email, attempt.IPAddress, outcome)
+	}
+	database.RecordLoginAttempt(attempt)
+}
+
+// maxLoginAttempts is the most audit records LoginAttempts returns
+const maxLoginAttempts = 500
+
+// LoginAttempts returns recent sign-in attempts, newest first, for one email
+// address or for everyone, for the admin in ctx.
+func LoginAttempts(ctx context.Context, email string, limit int) ([]database.LoginAttempt, error) {
+	if _, err := RequirePermission(ctx, PermManageUsers); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxLoginAttempts {
+		limit = maxLoginAttempts
+	}
+	return database.GetLoginAttempts(strings.ToLower(strings.TrimSpace(email)), limit)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is a hash of no one's password, to compare passwords
+// against for unknown users. It has the same cost as real ones.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not anyone's password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
 }
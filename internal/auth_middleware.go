@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/keyset"
+)
+
+type contextKey string
+
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
+	// responseContextKey holds the response to the request, so signing in
+	// and out can set the auth cookie wherever it happens, such as in a
+	// GraphQL resolver
+	responseContextKey contextKey = "response"
+)
+
+const (
+	defaultTokenIssuer   = "gptback"
+	defaultTokenAudience = "gptback"
+	defaultTokenTTL      = 15 * time.Minute
+	defaultRefreshTTL    = 30 * 24 * time.Hour
+	defaultAuthCookie    = "access_token"
+	// defaultKeyID names the key made from the phrase or JWT_SECRET
+	defaultKeyID = "default"
+)
+
+// ErrForbidden is returned when the current user may not modify a resource.
+var ErrForbidden = errors.New("not allowed to modify this resource")
+
+// ErrUnauthenticated is returned when an action needs a signed-in user and
+// there is none.
+var ErrUnauthenticated = errors.New("you must be signed in to do this")
+
+var errNoKeys = errors.New("signing keys are not configured")
+
+var authConfig = config.JWTConfig{
+	Issuer:     defaultTokenIssuer,
+	Audience:   defaultTokenAudience,
+	TTL:        defaultTokenTTL,
+	RefreshTTL: defaultRefreshTTL,
+	Cookie:     defaultAuthCookie,
+}
+
+// authKeys signs and verifies access tokens
+var authKeys *keyset.KeySet
+
+// ConfigureAuth applies the token settings from the config file and loads
+// the signing keys. Unset values keep their defaults. It is an error for no
+// key to be configured.
+func ConfigureAuth(cfg config.JWTConfig) error {
+	if cfg.Issuer == "" {
+		cfg.Issuer = defaultTokenIssuer
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = defaultTokenAudience
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultTokenTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = defaultRefreshTTL
+	}
+	if cfg.Cookie == "" {
+		cfg.Cookie = defaultAuthCookie
+	}
+	keys, err := loadKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	authConfig = cfg
+	authKeys = keys
+	logger.DualLog.Printf("Signing access tokens with key %q", keys.SigningKeyID())
+	return nil
+}
+
+// loadKeySet reads the configured keys. Without any, the phrase or the
+// JWT_SECRET environment variable is used as an HS256 secret.
+func loadKeySet(cfg config.JWTConfig) (*keyset.KeySet, error) {
+	var keys []keyset.Key
+	for _, k := range cfg.Keys {
+		key, err := keyset.LoadKey(k.ID, k.Algorithm, k.File)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWT key %q: %v", k.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		secret := cfg.Phrase
+		if secret == "" {
+			secret = os.Getenv("JWT_SECRET")
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("no JWT signing key is configured: set jwt.keys, jwt.phrase or JWT_SECRET")
+		}
+		keys = append(keys, keyset.NewHMACKey(defaultKeyID, []byte(secret)))
+	}
+	return keyset.New(cfg.SigningKey, keys)
+}
+
+// JWKSHandler publishes the public keys access tokens are verified with,
+// so other services can verify them too. Keys being retired stay listed
+// until they are removed from the config.
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if authKeys == nil {
+		http.Error(w, "Signing keys are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(authKeys.JWKS())
+}
+
+// AccessClaims identify who an access token was issued to.
+type AccessClaims struct {
+	UserID int64
+	// SessionID is the session the token belongs to; revoking the session
+	// revokes the token
+	SessionID string
+}
+
+// IssueToken signs an access token for a user's session, valid for the
+// configured TTL, and returns it with its expiry. Its role claim tells
+// clients what the user may do; the server itself checks the user's
+// current role.
+func IssueToken(user database.User, sessionID string) (string, time.Time, error) {
+	if authKeys == nil {
+		return "", time.Time{}, errNoKeys
+	}
+	roleID, err := UserRole(user)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(authConfig.TTL)
+	signed, err := authKeys.Sign(jwt.MapClaims{
+		"userId": user.UserId,
+		"email":  user.Email,
+		"role":   database.RoleNames[roleID],
+		"sid":    sessionID,
+		"iss":    authConfig.Issuer,
+		"aud":    authConfig.Audience,
+		"iat":    now.Unix(),
+		"exp":    expiresAt.Unix(),
+	})
+	return signed, expiresAt, err
+}
+
+// ParseToken validates a token issued by IssueToken and returns its claims.
+// The token must be signed by one of our keys, named by its kid header,
+// must not have expired, must name our issuer and audience, and must belong
+// to a session.
+func ParseToken(tokenString string) (AccessClaims, error) {
+	if authKeys == nil {
+		return AccessClaims{}, errNoKeys
+	}
+	token, err := jwt.Parse(tokenString, authKeys.Keyfunc)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return AccessClaims{}, fmt.Errorf("invalid token")
+	}
+	// Valid only checks exp when it's present
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return AccessClaims{}, fmt.Errorf("token has no expiry")
+	}
+	if !claims.VerifyIssuer(authConfig.Issuer, true) {
+		return AccessClaims{}, fmt.Errorf("token was issued by %v", claims["iss"])
+	}
+	if !hasAudience(claims, authConfig.Audience) {
+		return AccessClaims{}, fmt.Errorf("token is not meant for %s", authConfig.Audience)
+	}
+	userID, ok := claims["userId"].(float64)
+	if !ok {
+		return AccessClaims{}, fmt.Errorf("token has no user ID")
+	}
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return AccessClaims{}, fmt.Errorf("token has no session")
+	}
+	return AccessClaims{UserID: int64(userID), SessionID: sessionID}, nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of them,
+// includes audience.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestToken finds the access token of a request, in its Authorization
+// header or else in the configured cookie. It reports whether the token
+// came from the header.
+func requestToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+	}
+	cookie, err := r.Cookie(authConfig.Cookie)
+	if err != nil {
+		return "", false
+	}
+	// Browsers send cookies with requests made by other sites too, so a
+	// cookie only signs in requests that can't change anything or that
+	// come from our own pages
+	if !safeMethod(r.Method) && !sameOrigin(r) {
+		return "", false
+	}
+	return cookie.Value, false
+}
+
+// setAuthCookie stores an access token in the auth cookie of the response
+// to the request in ctx, so the site's own pages are signed in with it.
+// The cookie runs out with the token.
+func setAuthCookie(ctx context.Context, token string, expiresAt time.Time) {
+	w, ok := ctx.Value(responseContextKey).(http.ResponseWriter)
+	if !ok {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     authConfig.Cookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearAuthCookie removes the auth cookie when signing out.
+func clearAuthCookie(ctx context.Context) {
+	if w, ok := ctx.Value(responseContextKey).(http.ResponseWriter); ok {
+		http.SetCookie(w, &http.Cookie{Name: authConfig.Cookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: true})
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin reports whether a request was made by one of our own pages,
+// going by the headers browsers add. Requests without them weren't made by
+// a browser.
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	return true
+}
+
+// AuthMiddleware puts the user identified by a valid access token, from
+// the Authorization header or the auth cookie, into the request context.
+// API keys, from the X-API-Key header or as bearer tokens, sign in their
+// user too, limited to the key's scopes. Requests without a token pass
+// through anonymously. A bearer token or API key that fails validation is
+// rejected with 401, so clients know to sign in again; a stale cookie is
+// ignored. Signing in or out during the request sets or clears the cookie.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), responseContextKey, w))
+		token, bearer := requestToken(r)
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			token, bearer = key, true
+		}
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isAPIKey(token) {
+			user, key, err := authenticateAPIKey(token)
+			if err != nil {
+				logger.DualLog.Printf("Rejecting API key: %v", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithAPIKey(WithUser(r.Context(), user), key)))
+			return
+		}
+
+		user, sessionID, err := authenticate(token)
+		if err != nil {
+			logger.DualLog.Printf("Rejecting access token: %v", err)
+			if bearer {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			r = r.WithContext(WithSession(WithUser(r.Context(), user), sessionID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the user an access token was issued to and their
+// session, which must still be active.
+func authenticate(token string) (database.User, string, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return database.User{}, "", err
+	}
+	session, err := database.GetSession(claims.SessionID)
+	if err != nil || session.UserID != claims.UserID || !session.Active(time.Now()) {
+		return database.User{}, "", fmt.Errorf("session %s has ended", claims.SessionID)
+	}
+	user, err := database.GetUserByID(claims.UserID)
+	if err != nil {
+		return database.User{}, "", fmt.Errorf("unknown user %d: %v", claims.UserID, err)
+	}
+	return user, session.ID, nil
+}
+
+// RequireUser returns the signed-in user, or ErrUnauthenticated.
+func RequireUser(ctx context.Context) (database.User, error) {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return database.User{}, ErrUnauthenticated
+	}
+	return user, nil
+}
+
+// RequireAuth only lets requests from signed-in users through to next;
+// others get 401.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := RequireUser(r.Context()); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user database.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user, if any. GraphQL resolvers
+// pass ResolveParams.Context, which is nil when a query is run directly.
+func UserFromContext(ctx context.Context) (database.User, bool) {
+	if ctx == nil {
+		return database.User{}, false
+	}
+	user, ok := ctx.Value(userContextKey).(database.User)
+	return user, ok
+}
+
+// WithSession returns a copy of ctx carrying the ID of the session the
+// request was made in.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionContextKey, sessionID)
+}
+
+// SessionIDFromContext returns the ID of the current session, or an empty
+// string.
+func SessionIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	sessionID, _ := ctx.Value(sessionContextKey).(string)
+	return sessionID
+}
+
+// AuthorizeArticleEdit allows the article's author, and users who may edit
+// any article, to update or delete an article. Articles without an author,
+// such as those written before authorship or imported, count as someone
+// else's. With an API key, the key's scopes have to allow it too.
+func AuthorizeArticleEdit(ctx context.Context, article database.Article) error {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return err
+	}
+	allowed := func(permission Permission) bool {
+		return Can(user, permission) && apiKeyAllows(ctx, permission)
+	}
+	if article.AuthorID != 0 && article.AuthorID == user.UserId && allowed(PermEditOwnArticles) {
+		return nil
+	}
+	if allowed(PermEditAnyArticle) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// CanReadArticle reports whether the request in ctx may see an article.
+// Unpublished articles are hidden like on the site, except from the users
+// who may edit them.
+func CanReadArticle(ctx context.Context, article database.Article) bool {
+	return article.Published || AuthorizeArticleEdit(ctx, article) == nil
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/keyset"
+)
+
+// testAuthConfig is what the tests sign access tokens with
+var testAuthConfig = config.JWTConfig{Phrase: "test secret"}
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := testAuthConfig
+	cfg.Issuer, cfg.Audience, cfg.Cookie = "test-issuer", "test-audience", "access_token"
+	if err := ConfigureAuth(cfg); err != nil {
+		t.Fatalf("ConfigureAuth returned error: %v", err)
+	}
+	defer ConfigureAuth(testAuthConfig)
+
+	userID, err := database.CreateUser(database.User{Email: "bearer@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	tokens, err := StartSession(context.Background(), database.User{UserId: userID, Email: "bearer@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+	token := tokens.AccessToken
+	session, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+
+	// The handler reports who it sees as signed in
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := UserFromContext(r.Context()); ok {
+			w.Write([]byte(user.Email))
+		}
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	withBearer := func(token string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	signWith := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString([]byte("test secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	sign := func(claims jwt.MapClaims) string {
+		return signWith(defaultKeyID, claims)
+	}
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"userId": userID, "sid": session.SessionID, "iss": "test-issuer", "aud": "test-audience", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	if rr := serve(withBearer(token)); rr.Code != http.StatusOK || rr.Body.String() != "bearer@example.com" {
+		t.Errorf("A valid bearer token wasn't accepted: %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := serve(withBearer(sign(claims(jwt.MapClaims{"aud": []interface{}{"other", "test-audience"}})))); rr.Body.String() != "bearer@example.com" {
+		t.Errorf("A token for several audiences including ours wasn't accepted: %d", rr.Code)
+	}
+	if rr := serve(httptest.NewRequest("GET", "/", nil)); rr.Code != http.StatusOK || rr.Body.String() != "" {
+		t.Errorf("A request without a token should pass through anonymously")
+	}
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string]string{
+		"expired":         sign(claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+		"without expiry":  sign(claims(jwt.MapClaims{"exp": nil})),
+		"wrong issuer":    sign(claims(jwt.MapClaims{"iss": "someone-else"})),
+		"wrong audience":  sign(claims(jwt.MapClaims{"aud": "another-site"})),
+		"unknown user":    sign(claims(jwt.MapClaims{"userId": 999999})),
+		"without session": sign(claims(jwt.MapClaims{"sid": nil})),
+		"unknown session": sign(claims(jwt.MapClaims{"sid": "no-such-session"})),
+		"without key ID":  signWith("", claims(nil)),
+		"unknown key ID":  signWith("retired", claims(nil)),
+		"wrong signature": token[:len(token)-2] + "xx",
+		"unsigned":        unsigned,
+	} {
+		rr := serve(withBearer(bad))
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("A bearer token %s returned %d", name, rr.Code)
+		}
+	}
+
+	// The cookie signs in requests, except changes from other sites
+	withCookie := func(method, token string) *http.Request {
+		req := httptest.NewRequest(method, "http://example.com/", nil)
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+		return req
+	}
+	if rr := serve(withCookie("GET", token)); rr.Body.String() != "bearer@example.com" {
+		t.Errorf("The auth cookie wasn't accepted")
+	}
+	req := withCookie("POST", token)
+	req.Header.Set("Origin", "http://example.com")
+	if rr := serve(req); rr.Body.String() != "bearer@example.com" {
+		t.Errorf("The auth cookie wasn't accepted from our own page")
+	}
+	req = withCookie("POST", token)
+	req.Header.Set("Origin", "http://evil.example")
+	if rr := serve(req); rr.Body.String() != "" {
+		t.Errorf("The auth cookie was accepted from another site")
+	}
+	if rr := serve(withCookie("GET", "stale")); rr.Code != http.StatusOK || rr.Body.String() != "" {
+		t.Errorf("A stale cookie should be ignored, got %d", rr.Code)
+	}
+
+	// Signing in sets the cookie, so our own pages are signed in too, and
+	// signing out clears it
+	signIn := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := StartSession(r.Context(), database.User{UserId: userID, Email: "bearer@example.com"}); err != nil {
+			t.Errorf("Failed to start session: %v", err)
+		}
+	}))
+	rr := httptest.NewRecorder()
+	signIn.ServeHTTP(rr, httptest.NewRequest("POST", "/graphql", nil))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "access_token" || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("Signing in set the cookies %v", cookies)
+	}
+	if rr := serve(withCookie("GET", cookies[0].Value)); rr.Body.String() != "bearer@example.com" {
+		t.Errorf("The cookie set when signing in wasn't accepted")
+	}
+	signOut := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Logout(r.Context()); err != nil {
+			t.Errorf("Logout returned error: %v", err)
+		}
+	}))
+	rr = httptest.NewRecorder()
+	signOut.ServeHTTP(rr, withCookie("GET", cookies[0].Value))
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Signing out set the cookies %v", cookies)
+	}
+
+	// RequireAuth turns anonymous requests away
+	protected := RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, httptest.NewRequest("POST", "/accept-article", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("RequireAuth let an anonymous request through: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, signedIn(t, httptest.NewRequest("POST", "/accept-article", nil)))
+	if rr.Code != http.StatusOK {
+		t.Errorf("RequireAuth turned away a signed-in user: %d", rr.Code)
+	}
+}
+
+func TestConfigureAuthKeys(t *testing.T) {
+	defer ConfigureAuth(testAuthConfig)
+
+	t.Setenv("JWT_SECRET", "")
+	if err := ConfigureAuth(config.JWTConfig{}); err == nil {
+		t.Errorf("ConfigureAuth accepted a config without a signing key")
+	}
+	if err := ConfigureAuth(config.JWTConfig{Keys: []config.JWTKeyConfig{{ID: "missing", Algorithm: keyset.EdDSA, File: "no-such-file.pem"}}}); err == nil {
+		t.Errorf("ConfigureAuth accepted a key file that doesn't exist")
+	}
+
+	// Rotate from the test secret to an Ed25519 key, keeping the secret to
+	// verify tokens it signed
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	edFile := filepath.Join(dir, "ed.pem")
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(edFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretFile, []byte("test secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := database.CreateUser(database.User{Email: "rotation@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	old, err := StartSession(context.Background(), database.User{UserId: userID})
+	if err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+	err = ConfigureAuth(config.JWTConfig{
+		SigningKey: "2024-ed",
+		Keys: []config.JWTKeyConfig{
+			{ID: defaultKeyID, Algorithm: keyset.HS256, File: secretFile},
+			{ID: "2024-ed", Algorithm: keyset.EdDSA, File: edFile},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureAuth returned error: %v", err)
+	}
+	if _, err := ParseToken(old.AccessToken); err != nil {
+		t.Errorf("A token signed by the retiring key was rejected: %v", err)
+	}
+	tokens, err := StartSession(context.Background(), database.User{UserId: userID})
+	if err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+	token, _ := jwt.Parse(tokens.AccessToken, nil)
+	if token.Header["kid"] != "2024-ed" || token.Header["alg"] != "EdDSA" {
+		t.Errorf("A new token was signed with %v %v", token.Header["kid"], token.Header["alg"])
+	}
+	if _, err := ParseToken(tokens.AccessToken); err != nil {
+		t.Errorf("A token signed by the new key was rejected: %v", err)
+	}
+
+	// Only the public key is published
+	rr := httptest.NewRecorder()
+	JWKSHandler(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var jwks keyset.JWKS
+	if err := json.NewDecoder(rr.Body).Decode(&jwks); err != nil {
+		t.Fatalf("The JWKS wasn't JSON: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "2024-ed" || jwks.Keys[0].X == "" {
+		t.Errorf("The JWKS should list just the Ed25519 key: %+v", jwks.Keys)
+	}
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const defaultMaxBatchSize = 100
+
+var maxBatchSize = defaultMaxBatchSize
+
+// ConfigureBatches applies the bulk operation settings from the config file.
+func ConfigureBatches(cfg config.BatchConfig) {
+	if cfg.MaxSize > 0 {
+		maxBatchSize = cfg.MaxSize
+	}
+}
+
+// BatchItemResult reports what happened to one article of a bulk operation.
+type BatchItemResult struct {
+	ID    int64
+	OK    bool
+	Error string
+}
+
+// errBatchRolledBack is reported for items that succeeded but were undone
+// because another item failed.
+const errBatchRolledBack = "not applied: another item in the batch failed"
+
+// ArticleBatchOp changes the index-th article of a batch.
+type ArticleBatchOp func(batch *database.ArticleBatch, index int, article database.Article) error
+
+// RunArticleBatch applies op to each article in one transaction, on behalf
+// of the user in ctx. Every article must exist and be editable by the user.
+// If any item fails the whole batch is rolled back; the results say which
+// items failed and why. The returned error is only set when the batch
+// couldn't run at all.
+func RunArticleBatch(ctx context.Context, ids []int64, op ArticleBatchOp) ([]BatchItemResult, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no articles given")
+	}
+	if len(ids) > maxBatchSize {
+		return nil, fmt.Errorf("at most %d articles can be changed at once, got %d", maxBatchSize, len(ids))
+	}
+
+	// Permissions are checked before the transaction starts, since they are
+	// read outside it
+	results := make([]BatchItemResult, len(ids))
+	failed := false
+	for i, id := range ids {
+		results[i] = BatchItemResult{ID: id, OK: true}
+		if err := authorizeBatchItem(ctx, id); err != nil {
+			results[i] = BatchItemResult{ID: id, Error: err.Error()}
+			failed = true
+		}
+	}
+	if failed {
+		return rolledBack(results), nil
+	}
+
+	batch, err := database.BeginArticleBatch()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		article, err := batch.ReadArticle(id)
+		if err == nil {
+			err = op(batch, i, article)
+		}
+		if err != nil {
+			results[i] = BatchItemResult{ID: id, Error: err.Error()}
+			failed = true
+		}
+	}
+
+	if failed {
+		if err := batch.Rollback(); err != nil {
+			return nil, err
+		}
+		logger.DualLog.Printf("Rolled back batch of %d articles", len(ids))
+		return rolledBack(results), nil
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, err
+	}
+	logger.DualLog.Printf("Applied batch to %d articles", len(ids))
+	return results, nil
+}
+
+func authorizeBatchItem(ctx context.Context, id int64) error {
+	article, err := database.ReadArticle(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("article %d not found", id)
+		}
+		return err
+	}
+	return AuthorizeArticleEdit(ctx, article)
+}
+
+// rolledBack marks the items that succeeded as not applied.
+func rolledBack(results []BatchItemResult) []BatchItemResult {
+	for i := range results {
+		if results[i].OK {
+			results[i] = BatchItemResult{ID: results[i].ID, Error: errBatchRolledBack}
+		}
+	}
+	return results
+}
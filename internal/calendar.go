@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/ical"
+)
+
+const (
+	// CalendarFeedPath is where the task calendar is served
+	CalendarFeedPath = "/tasks.ics"
+	// maxCalendarEvents is the most tasks a calendar feed includes
+	maxCalendarEvents = 1000
+	// taskUIDDomain qualifies event UIDs. It doesn't depend on the host
+	// the feed was fetched from, so the UIDs stay the same wherever the
+	// site is served.
+	taskUIDDomain = "tasks.gptback"
+)
+
+// NewCalendarToken generates a calendar feed token for a user, replacing
+// their previous one, and returns the path of their feed. The token is only
+// stored hashed, so the path can't be shown again.
+func NewCalendarToken(userID int64) (string, error) {
+	token, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	if err := database.SetCalendarToken(userID, hashToken(token)); err != nil {
+		return "", err
+	}
+	return CalendarFeedPath + "?token=" + token, nil
+}
+
+// RevokeCalendarToken stops a user's calendar feed working.
+func RevokeCalendarToken(userID int64) error {
+	err := database.DeleteCalendarToken(userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("there is no calendar feed to revoke")
+	}
+	return err
+}
+
+// taskEvent converts a task with a due date into an all-day calendar event.
+func taskEvent(task database.Task, assignees map[int64]string) ical.Event {
+	details := []string{"Status: " + task.Status, "Priority: " + task.Priority}
+	if name, ok := assignees[task.AssigneeID]; ok {
+		details = append(details, "Assignee: "+name)
+	}
+	description := strings.Join(details, "\n")
+	if task.Description != "" {
+		description = task.Description + "\n\n" + description
+	}
+
+	summary := task.Title
+	if task.Status == database.TaskDone {
+		summary = "✓ " + summary
+	}
+	return ical.Event{
+		UID:         fmt.Sprintf("task-%d@%s", task.ID, taskUIDDomain),
+		Date:        *task.DueDate,
+		Summary:     summary,
+		Description: description,
+		Categories:  []string{task.Status, task.Priority},
+		Modified:    task.UpdatedAt,
+	}
+}
+
+// CalendarFeedHandler serves the tasks with due dates as an iCalendar feed
+// for calendar apps to subscribe to. The token parameter, from
+// NewCalendarToken, identifies the user in place of a login, since calendar
+// apps can't sign in. The tasks are filtered by the query parameters
+// described at parseTaskFilter, with "assignee=me" for the user's own tasks;
+// paging and sorting parameters are ignored.
+func CalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	token := query.Get("token")
+	if token == "" {
+		http.Error(w, "A calendar token is required", http.StatusUnauthorized)
+		return
+	}
+	user, err := database.GetUserByCalendarToken(hashToken(token))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.DualLog.Printf("Error checking calendar token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid calendar token", http.StatusUnauthorized)
+		return
+	}
+
+	filters := url.Values{}
+	for name, values := range query {
+		switch name {
+		case "token", "sort", "limit", "offset":
+		default:
+			filters[name] = values
+		}
+	}
+	mine := filters.Get("assignee") == "me"
+	if mine {
+		filters.Del("assignee")
+	}
+	filter, err := parseTaskFilter(filters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if mine {
+		filter.AssigneeID = user.UserId
+	}
+	filter.HasDueDate = true
+	filter.Sort = database.TaskSortDueDate
+	filter.Limit = maxCalendarEvents
+
+	tasks, _, err := database.ListTasks(filter)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	users, err := database.GetUsers()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	assignees := make(map[int64]string, len(users))
+	for _, u := range users {
+		assignees[u.UserId] = u.DisplayName()
+	}
+
+	calendar := ical.Calendar{
+		ProductID: "-//gptback//Tasks//EN",
+		Name:      "Tasks",
+	}
+	for _, task := range tasks {
+		calendar.Events = append(calendar.Events, taskEvent(task, assignees))
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	if err := calendar.Encode(w); err != nil {
+		logger.DualLog.Printf("Error writing calendar feed for user %d: %v", user.UserId, err)
+	}
+}
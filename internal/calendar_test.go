@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	userID, err := database.CreateUser(database.User{Email: "calendar@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	path, err := NewCalendarToken(userID)
+	if err != nil {
+		t.Fatalf("Failed to create calendar token: %v", err)
+	}
+
+	due := time.Date(2031, 5, 17, 0, 0, 0, 0, time.UTC)
+	mine, err := database.CreateTaskRecord(database.Task{Title: "Calendar mine", Description: "Mine, all mine", Priority: database.TaskPriorityHigh, DueDate: &due, AssigneeID: userID})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	done, err := database.CreateTaskRecord(database.Task{Title: "Calendar done", Status: database.TaskDone, DueDate: &due})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if _, err := database.CreateTaskRecord(database.Task{Title: "Calendar undated"}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	fetch := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		CalendarFeedHandler(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := fetch(path + "&q=calendar")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("The feed returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/calendar; charset=utf-8" {
+		t.Errorf("The feed's content type is %q", got)
+	}
+	// Long lines are folded
+	body := strings.ReplaceAll(rr.Body.String(), "\r\n ", "")
+	for _, want := range []string{
+		fmt.Sprintf("UID:task-%d@tasks.gptback\r\n", mine),
+		"DTSTART;VALUE=DATE:20310517\r\n",
+		`DESCRIPTION:Mine\, all mine\n\nStatus: todo\nPriority: high\nAssignee: calendar`,
+		fmt.Sprintf("UID:task-%d@tasks.gptback\r\n", done),
+		"SUMMARY:✓ Calendar done\r\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("The feed doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Calendar undated") {
+		t.Errorf("Tasks without a due date should be left out")
+	}
+
+	// Filters narrow the feed down
+	body = fetch(path + "&q=calendar&assignee=me").Body.String()
+	if strings.Count(body, "BEGIN:VEVENT") != 1 || !strings.Contains(body, "Calendar mine") {
+		t.Errorf("assignee=me should only include the user's task:\n%s", body)
+	}
+	body = fetch(path + "&q=calendar&status=done").Body.String()
+	if strings.Count(body, "BEGIN:VEVENT") != 1 || !strings.Contains(body, "Calendar done") {
+		t.Errorf("status=done should only include the done task:\n%s", body)
+	}
+	if rr := fetch(path + "&status=later"); rr.Code != http.StatusBadRequest {
+		t.Errorf("An invalid filter returned %d", rr.Code)
+	}
+
+	// A new token replaces the old one, and revoking it stops the feed
+	if rr := fetch(CalendarFeedPath + "?token=wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("A wrong token returned %d", rr.Code)
+	}
+	newPath, err := NewCalendarToken(userID)
+	if err != nil {
+		t.Fatalf("Failed to replace calendar token: %v", err)
+	}
+	if rr := fetch(path); rr.Code != http.StatusUnauthorized {
+		t.Errorf("A replaced token returned %d", rr.Code)
+	}
+	if err := RevokeCalendarToken(userID); err != nil {
+		t.Fatalf("Failed to revoke calendar token: %v", err)
+	}
+	if rr := fetch(newPath); rr.Code != http.StatusUnauthorized {
+		t.Errorf("A revoked token returned %d", rr.Code)
+	}
+}
@@ -12,6 +12,10 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// ChatGPTModel is the model articles are generated with. It's stored with
+// each accepted article so ratings can be compared across models.
+const ChatGPTModel = "gpt-3.5-turbo"
+
 func LoadAPIKey() (string, error) {
 	var config struct {
 		OpenAI_API_Key string `yaml:"openai_api_key"`
@@ -55,7 +59,7 @@ func ChatGPTRequest(prompt string) (string, error) {
 	client := &http.Client{}
 
 	data := map[string]interface{}{
-		"model":       "gpt-3.5-turbo",
+		"model":       ChatGPTModel,
 		"messages":    []map[string]string{{"role": "user", "content": prompt}},
 		"temperature": 0.7,
 	}
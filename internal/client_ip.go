@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+const (
+	clientIPContextKey  contextKey = "clientIP"
+	userAgentContextKey contextKey = "userAgent"
+)
+
+// ClientIPMiddleware records the address and user agent of the connecting
+// client in the request context so GraphQL resolvers can see them.
+func ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey, clientIP(r))
+		ctx = context.WithValue(ctx, userAgentContextKey, r.UserAgent())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIPFromContext returns the client address stored by
+// ClientIPMiddleware, or an empty string.
+func ClientIPFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+	return ip
+}
+
+// UserAgentFromContext returns the client's user agent stored by
+// ClientIPMiddleware, or an empty string.
+func UserAgentFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	agent, _ := ctx.Value(userAgentContextKey).(string)
+	return agent
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
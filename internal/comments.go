@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const maxCommentLength = 5000
+
+// ErrCommentRateLimited is returned when someone posts too many comments.
+var ErrCommentRateLimited = errors.New("too many comments, please try again later")
+
+// CommentModerator decides the status a new comment is stored with. It runs
+// before the comment is saved, so Comment.ID is not yet set.
+type CommentModerator func(comment database.Comment) (string, error)
+
+var commentModerator CommentModerator = defaultCommentModerator
+
+var guestCommentLimiter = NewRateLimiter(5, 10*time.Minute)
+
+// memberCommentLimiter limits signed-in users by account, since anyone can
+// register one
+var memberCommentLimiter = NewRateLimiter(20, 10*time.Minute)
+
+// SetCommentModerator replaces the moderation hook, for example with a
+// spam filter or an external moderation service.
+func SetCommentModerator(moderator CommentModerator) {
+	commentModerator = moderator
+}
+
+// ConfigureComments applies the comment settings from the config file.
+func ConfigureComments(cfg config.CommentsConfig) {
+	if cfg.GuestRateLimit > 0 && cfg.GuestRateWindow > 0 {
+		guestCommentLimiter = NewRateLimiter(cfg.GuestRateLimit, cfg.GuestRateWindow)
+	}
+	if cfg.MemberRateLimit > 0 && cfg.MemberRateWindow > 0 {
+		memberCommentLimiter = NewRateLimiter(cfg.MemberRateLimit, cfg.MemberRateWindow)
+	}
+}
+
+// defaultCommentModerator publishes comments from users whose role lets
+// them write articles straight away, and holds the rest for review. Anyone
+// can register, so being signed in isn't enough on its own.
+func defaultCommentModerator(comment database.Comment) (string, error) {
+	if comment.AuthorID != 0 && Can(database.User{UserId: comment.AuthorID}, PermCreateArticles) {
+		return database.CommentApproved, nil
+	}
+	return database.CommentPending, nil
+}
+
+// PostComment validates and stores a comment from the user in ctx, or from a
+// guest identified by guestName when nobody is signed in.
+func PostComment(ctx context.Context, articleID, parentID int64, guestName, body string) (database.Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return database.Comment{}, fmt.Errorf("comment cannot be empty")
+	}
+	if len(body) > maxCommentLength {
+		return database.Comment{}, fmt.Errorf("comment is longer than %d characters", maxCommentLength)
+	}
+
+	if _, err := database.ReadArticle(articleID); err != nil {
+		if err == sql.ErrNoRows {
+			return database.Comment{}, fmt.Errorf("article %d not found", articleID)
+		}
+		return database.Comment{}, err
+	}
+
+	if parentID != 0 {
+		parent, err := database.GetComment(parentID)
+		if err != nil || parent.ArticleID != articleID || parent.Status != database.CommentApproved {
+			return database.Comment{}, fmt.Errorf("cannot reply to comment %d", parentID)
+		}
+	}
+
+	comment := database.Comment{ArticleID: articleID, ParentID: parentID, Body: body}
+	if user, ok := UserFromContext(ctx); ok {
+		comment.AuthorID = user.UserId
+		if !memberCommentLimiter.Allow(strconv.FormatInt(user.UserId, 10)) {
+			return database.Comment{}, ErrCommentRateLimited
+		}
+	} else {
+		comment.GuestName = strings.TrimSpace(guestName)
+		if comment.GuestName == "" {
+			return database.Comment{}, fmt.Errorf("a name is required to comment as a guest")
+		}
+		if !guestCommentLimiter.Allow(ClientIPFromContext(ctx)) {
+			return database.Comment{}, ErrCommentRateLimited
+		}
+	}
+
+	status, err := commentModerator(comment)
+	if err != nil {
+		return database.Comment{}, fmt.Errorf("error moderating comment: %v", err)
+	}
+	comment.Status = status
+
+	id, err := database.CreateComment(comment)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	return database.GetComment(id)
+}
+
+// ModerateComment sets a comment's status on behalf of an editor.
+func ModerateComment(ctx context.Context, id int64, status string) (database.Comment, error) {
+	if _, err := RequirePermission(ctx, PermModerateComments); err != nil {
+		return database.Comment{}, err
+	}
+	switch status {
+	case database.CommentPending, database.CommentApproved, database.CommentSpam:
+	default:
+		return database.Comment{}, fmt.Errorf("unknown comment status: %s", status)
+	}
+
+	if err := database.UpdateCommentStatus(id, status); err != nil {
+		return database.Comment{}, err
+	}
+	return database.GetComment(id)
+}
+
+// CommentAuthorName is the name shown next to a comment.
+func CommentAuthorName(comment database.Comment) string {
+	if comment.AuthorID == 0 {
+		return comment.GuestName
+	}
+	user, err := database.GetUserByID(comment.AuthorID)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching author %d of comment %d: %v", comment.AuthorID, comment.ID, err)
+		return "unknown"
+	}
+	return user.DisplayName()
+}
+
+// CommentThread is a comment with its replies, for rendering.
+type CommentThread struct {
+	database.Comment
+	AuthorName string
+	Replies    []*CommentThread
+	// ReplyAction is the form target for replies, empty when the page
+	// doesn't accept comments
+	ReplyAction string
+	// AskName is set when replies are posted by a guest
+	AskName bool
+}
+
+// openCommentReplies enables the reply form on every comment in threads.
+func openCommentReplies(threads []*CommentThread, action string, askName bool) {
+	for _, thread := range threads {
+		thread.ReplyAction = action
+		thread.AskName = askName
+		openCommentReplies(thread.Replies, action, askName)
+	}
+}
+
+// buildCommentThreads nests comments under their parents. Replies whose
+// parent isn't in the list (for example because it's not approved) are
+// dropped.
+func buildCommentThreads(comments []database.Comment) []*CommentThread {
+	byID := map[int64]*CommentThread{}
+	for _, comment := range comments {
+		byID[comment.ID] = &CommentThread{Comment: comment, AuthorName: CommentAuthorName(comment)}
+	}
+
+	var roots []*CommentThread
+	for _, comment := range comments {
+		thread := byID[comment.ID]
+		if comment.ParentID == 0 {
+			roots = append(roots, thread)
+		} else if parent, ok := byID[comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, thread)
+		}
+	}
+	return roots
+}
+
+// PostCommentHandler accepts the comment form on an article page.
+func PostCommentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("PostCommentHandler called")
+	defer logger.DualLog.Println("PostCommentHandler exited")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := mux.Vars(r)["slug"]
+	article, err := database.GetArticleBySlug(slug)
+	if err != nil {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	parentID, _ := strconv.ParseInt(r.FormValue("parent_id"), 10, 64)
+	comment, err := PostComment(r.Context(), article.ID, parentID, r.FormValue("name"), r.FormValue("body"))
+	if err == ErrCommentRateLimited {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		logger.DualLog.Printf("Error posting comment on %s: %v", slug, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/articles/%s?comment=%s#comments", slug, comment.Status), http.StatusSeeOther)
+}
+
+type queuedComment struct {
+	database.Comment
+	AuthorName   string
+	ArticleTitle string
+	ArticleSlug  string
+}
+
+// CommentQueueHandler shows pending comments to editors.
+func CommentQueueHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("CommentQueueHandler called")
+	defer logger.DualLog.Println("CommentQueueHandler exited")
+
+	if _, err := RequirePermission(r.Context(), PermModerateComments); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = database.CommentPending
+	}
+	comments, err := database.GetCommentsByStatus(status)
+	if err != nil {
+		http.Error(w, "Error fetching comments", http.StatusInternalServerError)
+		return
+	}
+
+	queue := make([]queuedComment, 0, len(comments))
+	for _, comment := range comments {
+		entry := queuedComment{Comment: comment, AuthorName: CommentAuthorName(comment)}
+		if article, err := database.ReadArticle(comment.ArticleID); err == nil {
+			entry.ArticleTitle = article.Title
+			entry.ArticleSlug = article.Slug
+		}
+		queue = append(queue, entry)
+	}
+
+	data := map[string]interface{}{
+		"ContentTemplateName": "adminComments",
+		"Status":              status,
+		"Comments":            queue,
+	}
+
+	RenderTemplateWithData(w, "base.gohtml", "adminCommentsContent", data)
+}
+
+// ModerateCommentHandler applies an editor's decision from the queue.
+func ModerateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("ModerateCommentHandler called")
+	defer logger.DualLog.Println("ModerateCommentHandler exited")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := RequirePermission(r.Context(), PermModerateComments); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	action := r.FormValue("action")
+	if action == "delete" {
+		err = database.DeleteComment(id)
+	} else {
+		_, err = ModerateComment(r.Context(), id, action)
+	}
+	if err != nil {
+		logger.DualLog.Printf("Error moderating comment %d: %v", id, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/comments", http.StatusSeeOther)
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if !limiter.Allow("1.2.3.4") || !limiter.Allow("1.2.3.4") {
+		t.Fatalf("RateLimiter rejected events within the limit")
+	}
+	if limiter.Allow("1.2.3.4") {
+		t.Errorf("RateLimiter allowed an event over the limit")
+	}
+	if !limiter.Allow("5.6.7.8") {
+		t.Errorf("RateLimiter applied one key's limit to another")
+	}
+
+	now = now.Add(61 * time.Second)
+	if !limiter.Allow("1.2.3.4") {
+		t.Errorf("RateLimiter did not allow events after the window passed")
+	}
+
+	// Keys without recent events are cleared away when new keys come in
+	now = now.Add(2 * time.Minute)
+	limiter.Allow("9.9.9.9")
+	if len(limiter.events) != 1 {
+		t.Errorf("RateLimiter kept %d keys after their events expired", len(limiter.events))
+	}
+
+	// And there is a limit to how many it keeps
+	limiter.maxKeys = 4
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		now = now.Add(time.Second)
+		limiter.Allow(key)
+	}
+	if len(limiter.events) > 4 {
+		t.Errorf("RateLimiter kept %d keys, over its maximum", len(limiter.events))
+	}
+	if _, ok := limiter.events["e"]; !ok {
+		t.Errorf("RateLimiter dropped the newest key")
+	}
+}
+
+func TestGuestCommentsAreModerated(t *testing.T) {
+	articleID, err := database.CreateArticle("Commented Article", "commented.jpg", "Commented preview", "Commented text")
+	if err != nil {
+		t.Fatalf("Failed to create article for testing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(articleID)
+	})
+	article, _ := database.ReadArticle(articleID)
+
+	comment, err := PostComment(context.Background(), articleID, 0, "Guest", "First!")
+	if err != nil {
+		t.Fatalf("PostComment returned error: %v", err)
+	}
+	if comment.Status != database.CommentPending {
+		t.Errorf("Guest comment has status %s, want %s", comment.Status, database.CommentPending)
+	}
+
+	if _, err := PostComment(context.Background(), articleID, comment.ID, "Guest", "Replying to myself"); err == nil {
+		t.Errorf("PostComment allowed a reply to an unapproved comment")
+	}
+
+	renderArticle := func() string {
+		req := httptest.NewRequest("GET", "/articles/"+article.Slug, nil)
+		req = mux.SetURLVars(req, map[string]string{"slug": article.Slug})
+		rr := httptest.NewRecorder()
+		ArticleHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("ArticleHandler returned wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+		}
+		return rr.Body.String()
+	}
+
+	if strings.Contains(renderArticle(), "First!") {
+		t.Errorf("Pending comment was shown on the article page")
+	}
+
+	if err := database.UpdateCommentStatus(comment.ID, database.CommentApproved); err != nil {
+		t.Fatalf("Failed to approve comment: %v", err)
+	}
+	if !strings.Contains(renderArticle(), "First!") {
+		t.Errorf("Approved comment was not shown on the article page")
+	}
+}
+
+func TestMemberComments(t *testing.T) {
+	articleID, err := database.CreateArticle("Member Commented Article", "member.jpg", "Member preview", "Member text")
+	if err != nil {
+		t.Fatalf("Failed to create article: %v", err)
+	}
+	post := func(roleID int64, email string) (database.Comment, error) {
+		userID, err := database.CreateUser(database.User{Email: email, PasswordHash: "hash", RoleId: roleID})
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		ctx := WithUser(context.Background(), database.User{UserId: userID, Email: email})
+		return PostComment(ctx, articleID, 0, "", "Hello from "+email)
+	}
+
+	comment, err := post(database.RoleViewer, "new-commenter@example.com")
+	if err != nil || comment.Status != database.CommentPending {
+		t.Errorf("A newly registered user's comment got status %q (%v), want %s", comment.Status, err, database.CommentPending)
+	}
+	comment, err = post(database.RoleAuthor, "author-commenter@example.com")
+	if err != nil || comment.Status != database.CommentApproved {
+		t.Errorf("An author's comment got status %q (%v), want %s", comment.Status, err, database.CommentApproved)
+	}
+
+	defer func(limiter *RateLimiter) { memberCommentLimiter = limiter }(memberCommentLimiter)
+	memberCommentLimiter = NewRateLimiter(1, time.Minute)
+	userID, err := database.CreateUser(database.User{Email: "chatty@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	ctx := WithUser(context.Background(), database.User{UserId: userID})
+	if _, err := PostComment(ctx, articleID, 0, "", "One"); err != nil {
+		t.Fatalf("PostComment returned error: %v", err)
+	}
+	if _, err := PostComment(ctx, articleID, 0, "", "Two"); err != ErrCommentRateLimited {
+		t.Errorf("A signed-in user's comments weren't rate limited: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/mail"
+)
+
+// maxEmailLength is the longest address that can be delivered (RFC 5321)
+const maxEmailLength = 254
+
+// verifyEmailPurpose is the audience suffix of email verification tokens,
+// which keeps them from being used as access tokens and the other way
+// round
+const verifyEmailPurpose = "#verify-email"
+
+// ErrEmailNotVerified is returned when the email verification policy stops
+// a user from doing something until they have verified their address.
+var ErrEmailNotVerified = errors.New("verify your email address to do this")
+
+// ErrInvalidVerificationLink is returned for an email verification link
+// that is forged, expired or for an address the user no longer has.
+var ErrInvalidVerificationLink = errors.New("this verification link is invalid or has expired")
+
+// verifiedPermissions need a verified email address when the policy is on
+var verifiedPermissions = map[Permission]bool{
+	PermCreateArticles: true,
+}
+
+// verificationEmailLimiter limits how often a user can have the
+// verification email sent again
+var verificationEmailLimiter = NewRateLimiter(3, time.Hour)
+
+// NormalizeEmail checks an email address is a plain address, like
+// ann@example.com, and returns it trimmed and lowercased so the same
+// address is always stored and looked up the same way.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	invalid := fmt.Errorf("%q is not a valid email address", email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", invalid
+	}
+	// ParseAddress also accepts forms like "Ann <ann@example.com>"
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", invalid
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", invalid
+	}
+	return strings.ToLower(email), nil
+}
+
+// EmailVerified reports whether the user has verified their email address.
+func EmailVerified(user database.User) (bool, error) {
+	verifiedAt, err := database.GetEmailVerifiedAt(user.UserId)
+	return verifiedAt != nil, err
+}
+
+// RequireVerifiedEmail returns ErrEmailNotVerified if the policy is on and
+// the signed-in user hasn't verified their email address.
+func RequireVerifiedEmail(ctx context.Context) error {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return err
+	}
+	return checkEmailVerified(user)
+}
+
+func checkEmailVerified(user database.User) error {
+	if !accountsConfig.RequireVerifiedEmail {
+		return nil
+	}
+	verified, err := EmailVerified(user)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// SendVerificationEmail emails the user a link that verifies their address.
+// The link is a token signed like access tokens, so nothing is stored until
+// it is used.
+func SendVerificationEmail(ctx context.Context, user database.User) error {
+	if authKeys == nil {
+		return errNoKeys
+	}
+	token, err := authKeys.Sign(jwt.MapClaims{
+		"userId": user.UserId,
+		"email":  user.Email,
+		"iss":    authConfig.Issuer,
+		"aud":    authConfig.Audience + verifyEmailPurpose,
+		"exp":    time.Now().Add(accountsConfig.VerifyEmailTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(accountsConfig.VerifyEmailURL, token)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Please confirm that this is your email address by opening this link:\n\n%s\n\n"+
+			"The link works for %s. If you didn't sign up, you can ignore this email.\n",
+			link, accountsConfig.VerifyEmailTTL),
+	})
+}
+
+// ResendVerificationEmail sends the signed-in user another verification
+// email, a few times an hour at most.
+func ResendVerificationEmail(ctx context.Context) error {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return err
+	}
+	verified, err := EmailVerified(user)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return fmt.Errorf("your email address is already verified")
+	}
+	if !verificationEmailLimiter.Allow(fmt.Sprint(user.UserId)) {
+		return fmt.Errorf("too many verification emails; try again later")
+	}
+	return SendVerificationEmail(ctx, user)
+}
+
+// VerifyEmail checks the token from a verification link and marks the
+// address it was sent to verified.
+func VerifyEmail(token string) (database.User, error) {
+	if authKeys == nil {
+		return database.User{}, errNoKeys
+	}
+	parsed, err := jwt.Parse(token, authKeys.Keyfunc)
+	if err != nil {
+		return database.User{}, ErrInvalidVerificationLink
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid || !claims.VerifyExpiresAt(time.Now().Unix(), true) ||
+		!claims.VerifyIssuer(authConfig.Issuer, true) || !hasAudience(claims, authConfig.Audience+verifyEmailPurpose) {
+		return database.User{}, ErrInvalidVerificationLink
+	}
+	userID, _ := claims["userId"].(float64)
+	email, _ := claims["email"].(string)
+
+	err = database.SetEmailVerified(int64(userID), email, time.Now())
+	if err == sql.ErrNoRows {
+		// The user has gone, or changed their address since
+		return database.User{}, ErrInvalidVerificationLink
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	return database.GetUserByID(int64(userID))
+}
+
+// VerifyEmailHandler is where the link in the verification email leads.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := VerifyEmail(r.URL.Query().Get("token"))
+	if err == ErrInvalidVerificationLink {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.DualLog.Printf("Error verifying email address: %v", err)
+		http.Error(w, "Failed to verify email address", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Thank you, %s is verified.\n", user.Email)
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for input, want := range map[string]string{
+		"ann@example.com":           "ann@example.com",
+		"  Ann.Lee@Example.COM \n":  "ann.lee@example.com",
+		"ann+news@mail.example.org": "ann+news@mail.example.org",
+	} {
+		got, err := NormalizeEmail(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeEmail(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "ann", "ann@", "@example.com", "ann@localhost", "ann@example.", "Ann <ann@example.com>", "ann@example.com, bob@example.com"} {
+		if _, err := NormalizeEmail(input); err == nil {
+			t.Errorf("NormalizeEmail(%q) accepted an invalid address", input)
+		}
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	sent := recordMail(t)
+	defer ConfigureAccounts(accountsConfig)
+	cfg := accountsConfig
+	cfg.RequireVerifiedEmail = true
+	ConfigureAccounts(cfg)
+
+	userID, err := RegisterUser(map[string]interface{}{"email": " New.User@Example.com", "password": "password", "passwordConfirmation": "password"})
+	if err != nil {
+		t.Fatalf("RegisterUser returned error: %v", err)
+	}
+	if _, err := RegisterUser(map[string]interface{}{"email": "new.user@example.com", "password": "password", "passwordConfirmation": "password"}); err == nil {
+		t.Errorf("The same address in another case was registered twice")
+	}
+	if _, err := RegisterUser(map[string]interface{}{"email": "not an address", "password": "password", "passwordConfirmation": "password"}); err == nil {
+		t.Errorf("An invalid address was registered")
+	}
+	if len(sent.messages) != 1 || sent.messages[0].To != "new.user@example.com" {
+		t.Fatalf("Expected a verification email to new.user@example.com, got %+v", sent.messages)
+	}
+	if err := database.SetUserRole(userID, database.RoleAuthor); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := database.GetUserByID(userID)
+	ctx := WithUser(context.Background(), user)
+
+	// Until the address is verified, the user can't create articles
+	if _, err := RequirePermission(ctx, PermCreateArticles); err != ErrEmailNotVerified {
+		t.Errorf("An unverified user creating articles returned %v", err)
+	}
+	if _, err := RequirePermission(ctx, PermWriteTasks); err != nil {
+		t.Errorf("The policy should only cover articles: %v", err)
+	}
+	if err := ResendVerificationEmail(ctx); err != nil || len(sent.messages) != 2 {
+		t.Fatalf("ResendVerificationEmail returned %v", err)
+	}
+
+	// Access tokens can't be used as verification links
+	tokens, err := StartSession(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyEmail(tokens.AccessToken); err != ErrInvalidVerificationLink {
+		t.Errorf("An access token verified an address: %v", err)
+	}
+
+	token := tokenFromLink(t, sent.messages[1])
+	if _, err := ParseToken(token); err == nil {
+		t.Errorf("A verification token was accepted as an access token")
+	}
+	rr := httptest.NewRecorder()
+	VerifyEmailHandler(rr, httptest.NewRequest("GET", "/verify-email?token="+token, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("VerifyEmailHandler returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if verified, _ := EmailVerified(user); !verified {
+		t.Fatalf("The address wasn't marked verified")
+	}
+	if _, err := RequirePermission(ctx, PermCreateArticles); err != nil {
+		t.Errorf("A verified user creating articles returned %v", err)
+	}
+	if err := ResendVerificationEmail(ctx); err == nil {
+		t.Errorf("A verification email was sent for a verified address")
+	}
+
+	rr = httptest.NewRecorder()
+	VerifyEmailHandler(rr, httptest.NewRequest("GET", "/verify-email?token="+token[:len(token)-2]+"xx", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("A forged verification link returned %d", rr.Code)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -59,6 +60,15 @@ func RenderTemplateWithData(w http.ResponseWriter, tmpl string, contentTemplateN
 	logger.DualLog.Println("Starting RenderTemplateWithData function...")
 	defer logger.DualLog.Println("Exiting RenderTemplateWithData function.")
 
+	err := RenderPage(w, tmpl, contentTemplateName, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RenderPage renders a content template inside the base template, writing the
+// result to any writer so pages can also be rendered outside a request.
+func RenderPage(w io.Writer, tmpl string, contentTemplateName string, data interface{}) error {
 	logger.DualLog.Printf("Rendering template: %s", tmpl)
 
 	// Log the loaded template names
@@ -72,8 +82,7 @@ func RenderTemplateWithData(w http.ResponseWriter, tmpl string, contentTemplateN
 	err := templates.ExecuteTemplate(&contentBuf, contentTemplateName, data)
 	if err != nil {
 		logger.DualLog.Printf("Error executing content template: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	// Uncomment the line when troubleshooting
@@ -89,8 +98,9 @@ func RenderTemplateWithData(w http.ResponseWriter, tmpl string, contentTemplateN
 	err = templates.ExecuteTemplate(w, tmpl, templateData)
 	if err != nil {
 		logger.DualLog.Printf("Error executing base template: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
 	}
+	return nil
 }
 
 // internal/handlers.go
@@ -99,7 +109,7 @@ func ArticlesHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("ArticlesHandler called")
 	defer logger.DualLog.Println("ArticlesHandler exited")
 
-	articles, err := database.GetArticles()
+	articles, err := database.GetPublishedArticles()
 	if err != nil {
 		logger.DualLog.Printf("Error fetching articles: %s", err.Error()) // Log the error with DualLog
 		http.Error(w, "Error fetching articles", http.StatusInternalServerError)
@@ -7,13 +7,15 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
-	//"github.com/rmacdiarmid/GPTSite/logger"
-	"github.com/rmacdiarmid/GPTSite/pkg/database"
 	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
 )
 
 func init() {
@@ -31,7 +33,7 @@ func init() {
 
 	// Create the "tasks" table in the test database
 	createTableSQL := `
-	CREATE TABLE tasks (
+	CREATE TABLE IF NOT EXISTS tasks (
 		id INTEGER PRIMARY KEY,
 		title TEXT NOT NULL,
 		description TEXT,
@@ -45,6 +47,11 @@ func init() {
 
 	// Replace the global DB variable with the test database
 	database.DB = testDB
+
+	// Sign access tokens with a test key
+	if err := ConfigureAuth(testAuthConfig); err != nil {
+		logger.DualLog.Fatalf("Failed to configure auth: %v", err)
+	}
 }
 
 func TestCreateTaskHandler(t *testing.T) {
@@ -83,7 +90,7 @@ func TestCreateTaskHandler(t *testing.T) {
 		t.Fatalf("Failed to parse response body: %v", err)
 	}
 
-	if resp["Title"] != task["title"] || resp["Description"] != task["description"] {
+	if resp["title"] != task["title"] || resp["description"] != task["description"] {
 		t.Errorf("CreateTaskHandler returned incorrect task: got %v, want %v", resp, task)
 	}
 }
@@ -121,7 +128,7 @@ func TestReadTaskHandler(t *testing.T) {
 		t.Fatalf("Failed to parse response body: %v", err)
 	}
 
-	if resp["ID"].(float64) != float64(taskID) || resp["Title"] != task.Title || resp["Description"] != task.Description {
+	if resp["id"].(float64) != float64(taskID) || resp["title"] != task.Title || resp["description"] != task.Description {
 		t.Errorf("ReadTaskHandler returned incorrect task: got %v, want %v", resp, task)
 	}
 }
@@ -214,6 +221,303 @@ func TestDeleteTaskHandler(t *testing.T) {
 	}
 }
 
+// signedIn returns req as sent by a signed-in author.
+func signedIn(t *testing.T, req *http.Request) *http.Request {
+	const email = "member@example.com"
+	member, err := database.GetUserByEmail(email)
+	if err != nil {
+		id, err := database.CreateUser(database.User{Email: email, PasswordHash: "hash", RoleId: database.RoleAuthor})
+		if err != nil {
+			t.Fatalf("Failed to create member: %v", err)
+		}
+		member = database.User{UserId: id, Email: email}
+	}
+	return req.WithContext(WithUser(req.Context(), member))
+}
+
+func TestTaskAPI(t *testing.T) {
+	router := mux.NewRouter()
+	RegisterTaskAPI(router.PathPrefix("/api/v1").Subrouter())
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, signedIn(t, req))
+		return rr
+	}
+	errorMessage := func(rr *httptest.ResponseRecorder) string {
+		var body apiError
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Error response is not JSON: %s", rr.Body.String())
+		}
+		return body.Error.Message
+	}
+
+	for i := 1; i <= 3; i++ {
+		rr := serve("POST", "/api/v1/tasks", fmt.Sprintf(`{"title": "Paged API task %d", "description": "paging"}`, i))
+		if rr.Code != http.StatusCreated || rr.Header().Get("Location") == "" {
+			t.Fatalf("Creating a task returned %d with Location %q", rr.Code, rr.Header().Get("Location"))
+		}
+	}
+
+	rr := serve("GET", "/api/v1/tasks?q=paged+api&limit=2&offset=1", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Listing tasks returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var page TaskPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse task page: %v", err)
+	}
+	if page.Total != 3 || len(page.Tasks) != 2 || page.Tasks[0].Title != "Paged API task 2" {
+		t.Errorf("Listing tasks returned the wrong page: %+v", page)
+	}
+
+	anonymous := httptest.NewRecorder()
+	router.ServeHTTP(anonymous, httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"title": "Anonymous task"}`)))
+	if anonymous.Code != http.StatusUnauthorized || errorMessage(anonymous) != ErrUnauthenticated.Error() {
+		t.Errorf("An anonymous task was accepted with %d: %s", anonymous.Code, anonymous.Body.String())
+	}
+	for _, target := range []string{"/api/v1/tasks", fmt.Sprintf("/api/v1/tasks/%d", page.Tasks[0].ID)} {
+		anonymous := httptest.NewRecorder()
+		router.ServeHTTP(anonymous, httptest.NewRequest("GET", target, nil))
+		if anonymous.Code != http.StatusUnauthorized || errorMessage(anonymous) != ErrUnauthenticated.Error() {
+			t.Errorf("An anonymous read of %s returned %d: %s", target, anonymous.Code, anonymous.Body.String())
+		}
+	}
+	viewerID, err := database.CreateUser(database.User{Email: "api-viewer@example.com", PasswordHash: "hash", RoleId: database.RoleViewer})
+	if err != nil {
+		t.Fatalf("Failed to create viewer: %v", err)
+	}
+	viewer := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"title": "Viewer task"}`))
+	router.ServeHTTP(viewer, req.WithContext(WithUser(req.Context(), database.User{UserId: viewerID})))
+	if viewer.Code != http.StatusForbidden || errorMessage(viewer) != ErrForbidden.Error() {
+		t.Errorf("A viewer's task was accepted with %d: %s", viewer.Code, viewer.Body.String())
+	}
+	viewer = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	router.ServeHTTP(viewer, req.WithContext(WithUser(req.Context(), database.User{UserId: viewerID})))
+	if viewer.Code != http.StatusOK {
+		t.Errorf("A viewer couldn't list tasks: %d %s", viewer.Code, viewer.Body.String())
+	}
+
+	if rr := serve("GET", "/api/v1/tasks?limit=1000", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("An oversized page returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve("POST", "/api/v1/tasks", `{"description": "no title"}`); rr.Code != http.StatusUnprocessableEntity || errorMessage(rr) != "title is required" {
+		t.Errorf("A task without a title returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("POST", "/api/v1/tasks", `{"title": `); rr.Code != http.StatusBadRequest {
+		t.Errorf("Malformed JSON returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve("GET", "/api/v1/tasks/999999", ""); rr.Code != http.StatusNotFound || errorMessage(rr) != "Task not found" {
+		t.Errorf("A missing task returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve("PUT", "/api/v1/tasks/999999", `{"title": "Missing"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Updating a missing task returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := serve("DELETE", "/api/v1/tasks/999999", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Deleting a missing task returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := serve("PATCH", "/api/v1/tasks", ""); rr.Code != http.StatusMethodNotAllowed || errorMessage(rr) != "Method not allowed" {
+		t.Errorf("An unsupported method returned %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTaskAPIFiltersAndSorting(t *testing.T) {
+	router := mux.NewRouter()
+	RegisterTaskAPI(router.PathPrefix("/api/v1").Subrouter())
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, signedIn(t, req))
+		return rr
+	}
+	titles := func(target string) []string {
+		rr := serve("GET", target, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Listing %s returned %d: %s", target, rr.Code, rr.Body.String())
+		}
+		var page TaskPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to parse task page: %v", err)
+		}
+		var titles []string
+		for _, task := range page.Tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+
+	assignee, err := database.CreateUser(database.User{Email: "triage@example.com", PasswordHash: "x"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	rr := serve("POST", "/api/v1/tasks", `{"title": "Triage A"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Creating a task returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var created Task
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse created task: %v", err)
+	}
+	if created.Status != database.TaskTodo || created.Priority != database.TaskPriorityMedium || created.DueDate != nil || created.AssigneeID != nil || created.CreatedAt.IsZero() {
+		t.Errorf("A new task didn't get the defaults: %+v", created)
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"title": "Triage B", "status": "doing", "priority": "high", "dueDate": "2030-01-10", "assigneeId": %d}`, assignee),
+		`{"title": "Triage C", "status": "done", "priority": "low", "dueDate": "2030-01-05"}`,
+	} {
+		if rr := serve("POST", "/api/v1/tasks", body); rr.Code != http.StatusCreated {
+			t.Fatalf("Creating a task returned %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"status=doing", []string{"Triage B"}},
+		{"priority=low", []string{"Triage C"}},
+		{fmt.Sprintf("assignee=%d", assignee), []string{"Triage B"}},
+		{"assignee=none", []string{"Triage A", "Triage C"}},
+		{"due_after=2030-01-06", []string{"Triage B"}},
+		{"due_before=2030-01-06", []string{"Triage C"}},
+		{"sort=-priority", []string{"Triage B", "Triage A", "Triage C"}},
+		{"sort=due_date", []string{"Triage C", "Triage B", "Triage A"}},
+		{"sort=-due_date", []string{"Triage B", "Triage C", "Triage A"}},
+		{"sort=-status", []string{"Triage C", "Triage B", "Triage A"}},
+		{"created_after=2000-01-01&updated_before=2100-01-01", []string{"Triage A", "Triage B", "Triage C"}},
+	}
+	for _, test := range tests {
+		got := titles("/api/v1/tasks?q=triage&" + test.query)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Listing tasks with %s returned %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"status=blocked", "priority=urgent", "assignee=someone", "due_after=tomorrow", "sort=colour"} {
+		if rr := serve("GET", "/api/v1/tasks?"+query, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Listing tasks with %s returned %d, want %d", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+	for _, body := range []string{
+		`{"title": "Bad status", "status": "blocked"}`,
+		`{"title": "Bad due date", "dueDate": "10/01/2030"}`,
+		`{"title": "Bad assignee", "assigneeId": 999999}`,
+	} {
+		if rr := serve("POST", "/api/v1/tasks", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Creating %s returned %d, want %d", body, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+
+	// PUT replaces the task, so leaving out the due date clears it
+	rr = serve("PUT", fmt.Sprintf("/api/v1/tasks/%d", created.ID), `{"title": "Triage A", "status": "done", "priority": "high"}`)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Updating a task returned %d: %s", rr.Code, rr.Body.String())
+	}
+	task, err := database.ReadTask(int(created.ID))
+	if err != nil {
+		t.Fatalf("Failed to read updated task: %v", err)
+	}
+	if task.Status != database.TaskDone || task.Priority != database.TaskPriorityHigh || task.UpdatedAt.Before(task.CreatedAt) {
+		t.Errorf("The task wasn't updated: %+v", task)
+	}
+}
+
+func TestTaskBoardMoves(t *testing.T) {
+	router := mux.NewRouter()
+	RegisterTaskAPI(router.PathPrefix("/api/v1").Subrouter())
+
+	move := func(id int64, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/tasks/%d/move", id), strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, signedIn(t, req))
+		return rr
+	}
+	ids := map[string]int64{}
+	for _, title := range []string{"Board A", "Board B", "Board C"} {
+		id, err := database.CreateTask(title, "")
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		ids[title] = id
+	}
+	column := func(status string) []string {
+		tasks, _, err := database.ListTasks(database.TaskFilter{Query: "board ", Status: status, Sort: database.TaskSortRank, Limit: MaxTaskPageSize})
+		if err != nil {
+			t.Fatalf("Failed to list tasks: %v", err)
+		}
+		var titles []string
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+	mustMove := func(title, body string) {
+		if rr := move(ids[title], body); rr.Code != http.StatusOK {
+			t.Fatalf("Moving %s with %s returned %d: %s", title, body, rr.Code, rr.Body.String())
+		}
+	}
+
+	mustMove("Board C", fmt.Sprintf(`{"status": "todo", "beforeId": %d}`, ids["Board A"]))
+	if got, want := column("todo"), []string{"Board C", "Board A", "Board B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After moving C to the top, todo is %v, want %v", got, want)
+	}
+
+	mustMove("Board A", `{"status": "doing"}`)
+	mustMove("Board B", fmt.Sprintf(`{"status": "doing", "afterId": %d}`, ids["Board A"]))
+	mustMove("Board C", fmt.Sprintf(`{"status": "doing", "afterId": %d, "beforeId": %d}`, ids["Board A"], ids["Board B"]))
+	if got, want := column("doing"), []string{"Board A", "Board C", "Board B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After moving every card, doing is %v, want %v", got, want)
+	}
+	if got := column("todo"); len(got) != 0 {
+		t.Errorf("Moved cards are still in todo: %v", got)
+	}
+
+	// Moving into the same gap over and over eventually renumbers the column
+	for i := 0; i < 60; i++ {
+		moved, other := "Board B", "Board C"
+		if i%2 == 1 {
+			moved, other = other, moved
+		}
+		mustMove(moved, fmt.Sprintf(`{"status": "doing", "afterId": %d, "beforeId": %d}`, ids["Board A"], ids[other]))
+	}
+	if got, want := column("doing"), []string{"Board A", "Board C", "Board B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After repeated moves, doing is %v, want %v", got, want)
+	}
+
+	for _, body := range []string{
+		`{"status": "blocked"}`,
+		fmt.Sprintf(`{"status": "todo", "afterId": %d}`, ids["Board A"]),
+		fmt.Sprintf(`{"status": "doing", "afterId": %d}`, ids["Board C"]),
+		fmt.Sprintf(`{"status": "doing", "afterId": %d, "beforeId": %d}`, ids["Board B"], ids["Board A"]),
+		`{"status": "doing", "beforeId": 999999}`,
+	} {
+		if rr := move(ids["Board C"], body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Moving with %s returned %d, want %d: %s", body, rr.Code, http.StatusUnprocessableEntity, rr.Body.String())
+		}
+	}
+	if rr := move(999999, `{"status": "todo"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Moving a missing task returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	// Changing a task's status through an update puts it at the bottom
+	// of its new column
+	if err := database.UpdateTaskRecord(database.Task{ID: ids["Board A"], Title: "Board A", Status: database.TaskDone, Priority: database.TaskPriorityMedium}); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	mustMove("Board B", `{"status": "done"}`)
+	if got, want := column("done"), []string{"Board A", "Board B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After updating A's status, done is %v, want %v", got, want)
+	}
+}
+
 func TestArticlesHandler(t *testing.T) {
 	// Create an article to be retrieved
 	article := database.Article{
@@ -394,3 +698,53 @@ func TestRenderTemplateWithData(t *testing.T) {
 	// Check that the response writer contains the expected output
 	// ... (rest of the code)
 }
+
+func TestAcceptArticleHandler(t *testing.T) {
+	text := "Generated first line.\nGenerated second line."
+	generation, err := signGeneration("Write about signing", "test-model", text)
+	if err != nil {
+		t.Fatalf("signGeneration returned error: %v", err)
+	}
+
+	accept := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/accept-article", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		AcceptArticleHandler(rr, signedIn(t, req))
+		return rr
+	}
+
+	// The prompt and model a submitter adds are ignored, and the browser's
+	// line endings don't matter
+	rr := accept(url.Values{
+		"title":        {"Signed Generation"},
+		"article_text": {strings.ReplaceAll(text, "\n", "\r\n")},
+		"generation":   {generation},
+		"prompt":       {"Something else"},
+		"model":        {"other-model"},
+	})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AcceptArticleHandler returned %d: %s", rr.Code, rr.Body.String())
+	}
+	articles, err := database.GetArticles()
+	if err != nil {
+		t.Fatalf("GetArticles returned error: %v", err)
+	}
+	for _, article := range articles {
+		if article.Title == "Signed Generation" {
+			if article.Prompt != "Write about signing" || article.Model != "test-model" {
+				t.Errorf("The accepted article has prompt %q and model %q", article.Prompt, article.Model)
+			}
+			_ = database.DeleteArticle(article.ID)
+		}
+	}
+
+	for name, form := range map[string]url.Values{
+		"no token":     {"title": {"Unsigned"}, "article_text": {text}, "prompt": {"Made up"}},
+		"changed text": {"title": {"Changed"}, "article_text": {"Different text"}, "generation": {generation}},
+	} {
+		if rr := accept(form); rr.Code != http.StatusBadRequest {
+			t.Errorf("AcceptArticleHandler with %s returned %d, want %d", name, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -11,7 +11,7 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("IndexHandler called")
 	defer logger.DualLog.Println("Indexhandler exited")
 
-	articles, err := database.GetArticles()
+	articles, err := database.GetPublishedArticles()
 	if err != nil {
 		http.Error(w, "Error fetching articles", http.StatusInternalServerError)
 		return
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+)
+
+const (
+	defaultMaxLoginFailures   = 5
+	defaultMaxIPLoginFailures = 20
+	defaultLockoutDuration    = time.Minute
+	defaultMaxLockoutDuration = time.Hour
+)
+
+// forgetLoginFailuresAfter is how long after the last failure a key's
+// failures are forgotten
+const forgetLoginFailuresAfter = 24 * time.Hour
+
+// Sign-ins are throttled for each account, by email address so unknown
+// addresses are treated the same, and for each client IP address
+var (
+	accountLoginThrottle = NewLoginThrottle(defaultMaxLoginFailures, defaultLockoutDuration, defaultMaxLockoutDuration)
+	ipLoginThrottle      = NewLoginThrottle(defaultMaxIPLoginFailures, defaultLockoutDuration, defaultMaxLockoutDuration)
+)
+
+// configureLoginThrottles applies the throttle settings of the accounts
+// config, filling in defaults for unset values, and starts the throttles
+// over with them.
+func configureLoginThrottles(cfg *config.AccountsConfig) {
+	if cfg.MaxLoginFailures <= 0 {
+		cfg.MaxLoginFailures = defaultMaxLoginFailures
+	}
+	if cfg.MaxIPLoginFailures <= 0 {
+		cfg.MaxIPLoginFailures = defaultMaxIPLoginFailures
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = defaultLockoutDuration
+	}
+	if cfg.MaxLockoutDuration < cfg.LockoutDuration {
+		cfg.MaxLockoutDuration = defaultMaxLockoutDuration
+		if cfg.MaxLockoutDuration < cfg.LockoutDuration {
+			cfg.MaxLockoutDuration = cfg.LockoutDuration
+		}
+	}
+	accountLoginThrottle = NewLoginThrottle(cfg.MaxLoginFailures, cfg.LockoutDuration, cfg.MaxLockoutDuration)
+	ipLoginThrottle = NewLoginThrottle(cfg.MaxIPLoginFailures, cfg.LockoutDuration, cfg.MaxLockoutDuration)
+}
+
+// LoginThrottle locks a key, such as an account or an IP address, out of
+// signing in after too many failures in a row. Each failure past the
+// threshold doubles the lockout, up to a maximum. Like RateLimiter, state
+// is kept in memory, for at most maxTrackedKeys keys.
+type LoginThrottle struct {
+	mu         sync.Mutex
+	threshold  int
+	lockout    time.Duration
+	maxLockout time.Duration
+	entries    map[string]*loginFailures
+	maxKeys    int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func NewLoginThrottle(threshold int, lockout, maxLockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		threshold:  threshold,
+		lockout:    lockout,
+		maxLockout: maxLockout,
+		entries:    map[string]*loginFailures{},
+		maxKeys:    maxTrackedKeys,
+		now:        time.Now,
+	}
+}
+
+// sweep forgets failures that are old, and makes room for a new key if
+// there are too many. The caller holds the lock.
+func (l *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.lastSweep = now
+		for key, entry := range l.entries {
+			if now.Sub(entry.last) > forgetLoginFailuresAfter {
+				delete(l.entries, key)
+			}
+		}
+	}
+	if len(l.entries) < l.maxKeys {
+		return
+	}
+	keys := make([]string, 0, len(l.entries))
+	for key := range l.entries {
+		keys = append(keys, key)
+	}
+	dropOldestKeys(keys, func(key string) time.Time { return l.entries[key].last },
+		func(key string) { delete(l.entries, key) })
+}
+
+// entry returns the failures of key, forgetting them if they are old. The
+// caller holds the lock.
+func (l *LoginThrottle) entry(key string) *loginFailures {
+	entry, ok := l.entries[key]
+	if ok && l.now().Sub(entry.last) > forgetLoginFailuresAfter {
+		delete(l.entries, key)
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
+	return entry
+}
+
+// Locked reports whether key is locked out, and for how much longer.
+func (l *LoginThrottle) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.entry(key)
+	if entry == nil {
+		return 0, false
+	}
+	remaining := entry.lockedUntil.Sub(l.now())
+	return remaining, remaining > 0
+}
+
+// Failure records a failed sign-in for key, locking it out once there have
+// been threshold of them in a row.
+func (l *LoginThrottle) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	entry := l.entry(key)
+	if entry == nil {
+		l.sweep(now)
+		entry = &loginFailures{}
+		l.entries[key] = entry
+	}
+	entry.count++
+	entry.last = now
+	if entry.count < l.threshold {
+		return
+	}
+	lockout := l.lockout
+	for i := l.threshold; i < entry.count && lockout < l.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.maxLockout {
+		lockout = l.maxLockout
+	}
+	entry.lockedUntil = now.Add(lockout)
+}
+
+// Success forgets the failures of key.
+func (l *LoginThrottle) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
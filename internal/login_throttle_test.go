@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := NewLoginThrottle(3, time.Minute, 5*time.Minute)
+	throttle.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		throttle.Failure("ann")
+	}
+	if _, locked := throttle.Locked("ann"); locked {
+		t.Fatalf("Locked out before reaching the threshold")
+	}
+	// Each failure from the threshold on doubles the lockout, up to the
+	// maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		throttle.Failure("ann")
+		if remaining, locked := throttle.Locked("ann"); !locked || remaining != want {
+			t.Errorf("Locked out for %v, %v; want %v", remaining, locked, want)
+		}
+	}
+	if _, locked := throttle.Locked("bob"); locked {
+		t.Errorf("Another key was locked out")
+	}
+
+	now = now.Add(5 * time.Minute)
+	if _, locked := throttle.Locked("ann"); locked {
+		t.Errorf("Still locked out after the lockout ended")
+	}
+	throttle.Success("ann")
+	throttle.Failure("ann")
+	if _, locked := throttle.Locked("ann"); locked {
+		t.Errorf("A success should start the count again")
+	}
+
+	throttle.Failure("bob")
+	throttle.Failure("bob")
+	now = now.Add(forgetLoginFailuresAfter + time.Minute)
+	throttle.Failure("bob")
+	if _, locked := throttle.Locked("bob"); locked {
+		t.Errorf("Old failures should be forgotten")
+	}
+
+	// Keys that aren't seen again are swept away too, and the number kept
+	// is capped, so made-up keys can't fill memory
+	throttle.Failure("carol")
+	now = now.Add(forgetLoginFailuresAfter + time.Minute)
+	throttle.Failure("dave")
+	if _, ok := throttle.entries["carol"]; ok {
+		t.Errorf("Old failures of a key that wasn't seen again were kept")
+	}
+	throttle.maxKeys = 4
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		now = now.Add(time.Second)
+		throttle.Failure(key)
+	}
+	if len(throttle.entries) > 4 {
+		t.Errorf("The throttle kept %d keys, over its maximum", len(throttle.entries))
+	}
+	if _, ok := throttle.entries["k5"]; !ok {
+		t.Errorf("The throttle dropped the newest key")
+	}
+}
+
+func TestLoginProtection(t *testing.T) {
+	defer ConfigureAccounts(accountsConfig)
+	ConfigureAccounts(config.AccountsConfig{MaxLoginFailures: 3, MaxIPLoginFailures: 5})
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	userID, err := database.CreateUser(database.User{Email: "locked@example.com", PasswordHash: string(hash)})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	fromIP := func(ip string) context.Context {
+		return context.WithValue(context.Background(), clientIPContextKey, ip)
+	}
+	login := func(ctx context.Context, email, password string) error {
+		_, err := LoginUser(ctx, map[string]interface{}{"email": email, "password": password})
+		return err
+	}
+
+	// Unknown addresses and wrong passwords look the same
+	if err := login(fromIP("192.0.2.1"), "nobody@example.com", "password"); err != ErrInvalidCredentials {
+		t.Errorf("An unknown address returned %v", err)
+	}
+	if err := login(fromIP("192.0.2.1"), "locked@example.com", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("A wrong password returned %v", err)
+	}
+	if err := login(fromIP("192.0.2.2"), "Locked@Example.com ", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("A wrong password returned %v", err)
+	}
+	if err := login(fromIP("192.0.2.3"), "locked@example.com", "password"); err != nil {
+		t.Fatalf("The right password returned %v", err)
+	}
+
+	// Failures from different addresses add up for the account
+	for _, ip := range []string{"192.0.2.4", "192.0.2.5", "192.0.2.6"} {
+		login(fromIP(ip), "locked@example.com", "wrong")
+	}
+	if err := login(fromIP("192.0.2.7"), "locked@example.com", "password"); err != ErrLoginLocked {
+		t.Errorf("A locked account returned %v", err)
+	}
+
+	// And failures from one address add up across accounts
+	for i := 0; i < 4; i++ {
+		login(fromIP("198.51.100.1"), "nobody@example.com", "password")
+		login(fromIP("198.51.100.1"), "someone@example.com", "password")
+	}
+	if err := login(fromIP("198.51.100.1"), "another@example.com", "password"); err != ErrLoginLocked {
+		t.Errorf("A locked IP address returned %v", err)
+	}
+
+	attempts, err := database.GetLoginAttempts("locked@example.com", 100)
+	if err != nil {
+		t.Fatalf("GetLoginAttempts returned error: %v", err)
+	}
+	outcomes := map[string]int{}
+	for _, attempt := range attempts {
+		outcomes[attempt.Outcome]++
+		// Locked out attempts are turned away before the account is looked up
+		if attempt.Outcome != database.LoginLockedOut && attempt.UserID != userID {
+			t.Errorf("An attempt wasn't linked to the user: %+v", attempt)
+		}
+	}
+	want := map[string]int{database.LoginSucceeded: 1, database.LoginBadPassword: 5, database.LoginLockedOut: 1}
+	for outcome, n := range want {
+		if outcomes[outcome] != n {
+			t.Errorf("Recorded %d %s attempts, want %d", outcomes[outcome], outcome, n)
+		}
+	}
+	if attempts[0].Outcome != database.LoginLockedOut || attempts[0].IPAddress != "192.0.2.7" {
+		t.Errorf("The newest attempt should come first: %+v", attempts[0])
+	}
+
+	if _, err := LoginAttempts(WithUser(context.Background(), database.User{UserId: userID}), "", 10); err != ErrForbidden {
+		t.Errorf("A viewer listing sign-in attempts returned %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/mail"
+)
+
+// mailer sends the site's email. Until ConfigureMail is called it is nil
+// and email is printed to the log.
+var mailer mail.Sender
+
+// backgroundMailTimeout bounds how long an email sent in the background
+// may take
+const backgroundMailTimeout = time.Minute
+
+// backgroundMail tracks the emails being sent in the background
+var backgroundMail sync.WaitGroup
+
+// SetMailer replaces how email is sent, for example with a recorder in
+// tests.
+func SetMailer(sender mail.Sender) {
+	mailer = sender
+}
+
+// ConfigureMail chooses how email is sent from the config file.
+func ConfigureMail(cfg config.MailConfig) error {
+	if cfg.Driver == "" {
+		cfg.Driver = "log"
+	}
+	switch cfg.Driver {
+	case "log":
+		mailer = mail.LogSender{Logger: logger.DualLog}
+	case "file":
+		if cfg.Dir == "" {
+			return fmt.Errorf("the file mail driver needs a dir to write to")
+		}
+		mailer = mail.FileSender{Dir: cfg.Dir, From: cfg.From}
+	case "smtp":
+		if cfg.SMTP.Host == "" || cfg.From == "" {
+			return fmt.Errorf("the smtp mail driver needs a host and a from address")
+		}
+		mailer = mail.SMTPSender{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		}
+	default:
+		return fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+	logger.DualLog.Printf("Sending email with the %q driver", cfg.Driver)
+	return nil
+}
+
+// sendMail sends a message with the configured mailer.
+func sendMail(ctx context.Context, msg mail.Message) error {
+	sender := mailer
+	if sender == nil {
+		sender = mail.LogSender{Logger: logger.DualLog}
+	}
+	return sender.Send(ctx, msg)
+}
+
+// sendMailInBackground sends a message without making the caller wait for
+// the mail server, for requests whose response time mustn't depend on
+// whether an email was sent. Errors are logged.
+func sendMailInBackground(msg mail.Message, description string) {
+	backgroundMail.Add(1)
+	go func() {
+		defer backgroundMail.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundMailTimeout)
+		defer cancel()
+		if err := sendMail(ctx, msg); err != nil {
+			logger.DualLog.Printf("Error sending %s: %v", description, err)
+		}
+	}()
+}
+
+// WaitForBackgroundMail waits until the emails being sent in the
+// background have been sent, before the server exits or a test checks
+// what was sent.
+func WaitForBackgroundMail() {
+	backgroundMail.Wait()
+}
@@ -0,0 +1,368 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/oidc"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oidcPurpose is added to the audience of the token that carries a
+	// sign-in's state while the user is at the provider
+	oidcPurpose = "#oidc"
+	// oidcFlowCookie holds that token
+	oidcFlowCookie = "oidc_flow"
+	// oidcFlowTTL is how long the user has to sign in at the provider
+	oidcFlowTTL              = 10 * time.Minute
+	defaultOIDCLoginRedirect = "/"
+)
+
+// ErrUnknownProvider is returned for a provider that isn't configured.
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// ErrProviderEmailNotVerified is returned when a provider account that
+// isn't linked yet has no email address the provider has verified, so it
+// can't be matched to a user.
+var ErrProviderEmailNotVerified = errors.New("your identity provider hasn't verified your email address")
+
+// ErrNoLinkedAccount is returned when no one here has the email address of
+// a provider account, and accounts aren't made for new users.
+var ErrNoLinkedAccount = errors.New("no account uses your email address; sign up first")
+
+// oidcProvider is a configured provider and its client.
+type oidcProvider struct {
+	config config.OIDCProviderConfig
+	client *oidc.Client
+}
+
+var (
+	oidcConfig    = config.OIDCConfig{LoginRedirectURL: defaultOIDCLoginRedirect}
+	oidcProviders = map[string]*oidcProvider{}
+)
+
+// ConfigureOIDC sets up the OpenID Connect providers users can sign in
+// with. Providers are only contacted once someone signs in with them.
+func ConfigureOIDC(cfg config.OIDCConfig) error {
+	return configureOIDC(cfg, nil)
+}
+
+// configureOIDC is ConfigureOIDC with the HTTP client to reach providers
+// with, for tests.
+func configureOIDC(cfg config.OIDCConfig, httpClient *http.Client) error {
+	if cfg.LoginRedirectURL == "" {
+		cfg.LoginRedirectURL = defaultOIDCLoginRedirect
+	}
+	providers := make(map[string]*oidcProvider, len(cfg.Providers))
+	for _, provider := range cfg.Providers {
+		if provider.Name == "" || url.PathEscape(provider.Name) != provider.Name {
+			return fmt.Errorf("identity provider name %q must be usable in a URL path", provider.Name)
+		}
+		if _, ok := providers[provider.Name]; ok {
+			return fmt.Errorf("identity provider %q is configured twice", provider.Name)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"email", "profile"}
+		}
+		client, err := oidc.NewClient(oidc.Config{
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return fmt.Errorf("identity provider %q: %v", provider.Name, err)
+		}
+		providers[provider.Name] = &oidcProvider{config: provider, client: client}
+	}
+	oidcConfig = cfg
+	oidcProviders = providers
+	return nil
+}
+
+// IdentityProviders lists the names of the providers users can sign in
+// with.
+func IdentityProviders() []string {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OIDCLoginPath is where the browser goes to sign in with a provider.
+func OIDCLoginPath(provider string) string {
+	return "/auth/oidc/" + url.PathEscape(provider)
+}
+
+// OIDCLoginHandler starts signing in with the provider in the URL: it
+// sends the browser to the provider, remembering in a cookie what to check
+// when it comes back.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := oidcProviders[name]
+	if !ok {
+		http.Error(w, ErrUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+	if authKeys == nil {
+		http.Error(w, errNoKeys.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var state, nonce, verifier string
+	var err error
+	for _, secret := range []*string{&state, &nonce, &verifier} {
+		if *secret, err = oidc.NewVerifier(); err != nil {
+			http.Error(w, "Failed to start signing in", http.StatusInternalServerError)
+			return
+		}
+	}
+	expiresAt := time.Now().Add(oidcFlowTTL)
+	flow, err := authKeys.Sign(jwt.MapClaims{
+		"provider": name,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"iss":      authConfig.Issuer,
+		"aud":      authConfig.Audience + oidcPurpose,
+		"exp":      expiresAt.Unix(),
+	})
+	if err != nil {
+		logger.DualLog.Printf("Error signing OIDC flow token: %v", err)
+		http.Error(w, "Failed to start signing in", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := provider.client.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		logger.DualLog.Printf("Error reaching identity provider %s: %v", name, err)
+		http.Error(w, "The identity provider can't be reached", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    flow,
+		Path:     OIDCLoginPath(name),
+		Expires:  expiresAt,
+		Secure:   strings.HasPrefix(provider.config.RedirectURL, "https:"),
+		HttpOnly: true,
+		// Lax, so the cookie comes back with the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler is where the provider sends the browser back to. It
+// signs the user in and sends the browser on to the login redirect page,
+// with the tokens in the fragment as access_token, refresh_token and
+// expires_at, or two_factor_token if the user has to enter a code. If
+// signing in failed, error is set instead.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	// The flow is over either way
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: OIDCLoginPath(name), MaxAge: -1, HttpOnly: true})
+
+	tokens, err := completeOIDCLogin(r, name)
+	fragment := url.Values{}
+	switch {
+	case err != nil:
+		fragment.Set("error", err.Error())
+	case tokens.TwoFactorToken != "":
+		fragment.Set("two_factor_token", tokens.TwoFactorToken)
+	default:
+		fragment.Set("access_token", tokens.AccessToken)
+		fragment.Set("refresh_token", tokens.RefreshToken)
+		fragment.Set("expires_at", tokens.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	http.Redirect(w, r, oidcConfig.LoginRedirectURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// completeOIDCLogin checks the provider's redirect against the flow cookie,
+// exchanges the code, and signs in the user the ID token is for.
+func completeOIDCLogin(r *http.Request, name string) (TokenPair, error) {
+	provider, ok := oidcProviders[name]
+	if !ok {
+		return TokenPair{}, ErrUnknownProvider
+	}
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		logger.DualLog.Printf("Identity provider %s refused sign-in: %s: %s", name, providerError, query.Get("error_description"))
+		return TokenPair{}, fmt.Errorf("the identity provider didn't sign you in")
+	}
+
+	errExpired := errors.New("signing in took too long or was started elsewhere; please try again")
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil || authKeys == nil {
+		return TokenPair{}, errExpired
+	}
+	parsed, err := jwt.Parse(cookie.Value, authKeys.Keyfunc)
+	if err != nil {
+		return TokenPair{}, errExpired
+	}
+	flow, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid || !flow.VerifyExpiresAt(time.Now().Unix(), true) ||
+		!flow.VerifyIssuer(authConfig.Issuer, true) || !hasAudience(flow, authConfig.Audience+oidcPurpose) {
+		return TokenPair{}, errExpired
+	}
+	flowProvider, _ := flow["provider"].(string)
+	state, _ := flow["state"].(string)
+	nonce, _ := flow["nonce"].(string)
+	verifier, _ := flow["verifier"].(string)
+	// The state ties the redirect to this browser's sign-in, so no one can
+	// sign someone else in as themselves
+	if flowProvider != name || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		return TokenPair{}, errExpired
+	}
+
+	ctx := r.Context()
+	tokens, err := provider.client.Exchange(ctx, query.Get("code"), verifier)
+	if err != nil {
+		logger.DualLog.Printf("Error exchanging code with identity provider %s: %v", name, err)
+		return TokenPair{}, fmt.Errorf("the identity provider didn't sign you in")
+	}
+	claims, err := provider.client.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		logger.DualLog.Printf("Error verifying ID token from identity provider %s: %v", name, err)
+		return TokenPair{}, fmt.Errorf("the identity provider didn't sign you in")
+	}
+	return signInWithProvider(ctx, name, claims)
+}
+
+// signInWithProvider signs in the user a provider's account belongs to,
+// linking it to them first if it's new. Users with two-factor
+// authentication still have to enter a code.
+func signInWithProvider(ctx context.Context, provider string, claims oidc.Claims) (TokenPair, error) {
+	attempt := database.LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(claims.Email)),
+		IPAddress: ClientIPFromContext(ctx),
+		UserAgent: UserAgentFromContext(ctx),
+	}
+	user, err := providerUser(provider, claims)
+	if err == ErrNoLinkedAccount || err == ErrProviderEmailNotVerified {
+		recordLoginAttempt(attempt, database.LoginNoSuchUser)
+		return TokenPair{}, err
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	attempt.Email = user.Email
+	attempt.UserID = user.UserId
+
+	twoFactor, err := TwoFactorEnabled(user.UserId)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error checking two-factor authentication: %v", err)
+	}
+	if twoFactor {
+		token, err := issueTwoFactorToken(user)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		recordLoginAttempt(attempt, database.LoginNeedsCode)
+		return TokenPair{TwoFactorToken: token}, nil
+	}
+
+	tokens, err := StartSession(ctx, user)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error starting session: %v", err)
+	}
+	recordLoginAttempt(attempt, database.LoginSucceeded)
+	return tokens, nil
+}
+
+// providerUser finds the user a provider's account is linked to. An
+// account that isn't linked yet is linked to the user with its email
+// address, if the provider has verified it, or to a new user if that is
+// allowed.
+func providerUser(provider string, claims oidc.Claims) (database.User, error) {
+	identity, err := database.GetExternalIdentity(provider, claims.Subject)
+	if err == nil {
+		return database.GetUserByID(identity.UserID)
+	}
+	if err != sql.ErrNoRows {
+		return database.User{}, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return database.User{}, ErrProviderEmailNotVerified
+	}
+	email, err := NormalizeEmail(claims.Email)
+	if err != nil {
+		return database.User{}, ErrProviderEmailNotVerified
+	}
+	user, err := database.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !oidcConfig.RegisterUsers {
+			return database.User{}, ErrNoLinkedAccount
+		}
+		user, err = registerProviderUser(email)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	now := time.Now()
+	_, err = database.LinkExternalIdentity(database.ExternalIdentity{
+		Provider:  provider,
+		Subject:   claims.Subject,
+		UserID:    user.UserId,
+		Email:     email,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	// The provider has verified the address for us
+	if verified, err := EmailVerified(user); err == nil && !verified {
+		if err := database.SetEmailVerified(user.UserId, user.Email, now); err != nil {
+			logger.DualLog.Printf("Error marking email of user %d verified: %v", user.UserId, err)
+		}
+	}
+	return user, nil
+}
+
+// registerProviderUser makes an account for someone signing in with a
+// provider. It has a random password, which they can reset to sign in
+// without the provider.
+func registerProviderUser(email string) (database.User, error) {
+	password, err := newSecretToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return database.User{}, err
+	}
+	userID, err := database.CreateUser(database.User{Email: email, PasswordHash: string(hash)})
+	if err != nil {
+		return database.User{}, fmt.Errorf("error creating user: %v", err)
+	}
+	return database.GetUserByID(userID)
+}
+
+// LinkedIdentities lists the provider accounts linked to the signed-in
+// user.
+func LinkedIdentities(ctx context.Context) ([]database.ExternalIdentity, error) {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetExternalIdentities(user.UserId)
+}
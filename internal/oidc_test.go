@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/oidc/oidctest"
+	"github.com/rmacdiarmid/gptback/pkg/totp"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	provider := oidctest.NewProvider("gptback", "client secret")
+	defer provider.Close()
+	configure := func(registerUsers bool) {
+		err := configureOIDC(config.OIDCConfig{
+			Providers: []config.OIDCProviderConfig{{
+				Name:         "corp",
+				Issuer:       provider.Issuer,
+				ClientID:     "gptback",
+				ClientSecret: "client secret",
+				RedirectURL:  "https://gptback.example.com/auth/oidc/corp/callback",
+			}},
+			RegisterUsers:    registerUsers,
+			LoginRedirectURL: "https://gptback.example.com/app",
+		}, provider.Client())
+		if err != nil {
+			t.Fatalf("configureOIDC returned error: %v", err)
+		}
+	}
+	configure(false)
+	defer configureOIDC(config.OIDCConfig{}, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/oidc/{provider}", OIDCLoginHandler)
+	router.HandleFunc("/auth/oidc/{provider}/callback", OIDCCallbackHandler)
+	browser := provider.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	// start signs in at the provider, returning the flow cookie and the
+	// callback URL the provider sends the browser back to
+	start := func(identity oidctest.Identity) (*http.Cookie, *url.URL) {
+		provider.SignIn(identity)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/corp", nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Starting to sign in returned %d: %s", rr.Code, rr.Body)
+		}
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure {
+			t.Fatalf("Unexpected flow cookies: %v", cookies)
+		}
+		resp, err := browser.Get(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Signing in at the provider failed: %v", err)
+		}
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || err != nil {
+			t.Fatalf("The provider returned %d, %v", resp.StatusCode, err)
+		}
+		return cookies[0], callback
+	}
+	// finish follows the provider's redirect back, returning the fragment
+	// of the page the browser ends up at
+	finish := func(cookie *http.Cookie, callback *url.URL) url.Values {
+		req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if rr.Code != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), "https://gptback.example.com/app#") {
+			t.Fatalf("The callback returned %d to %q", rr.Code, rr.Header().Get("Location"))
+		}
+		fragment, _ := url.ParseQuery(location.EscapedFragment())
+		return fragment
+	}
+	signIn := func(identity oidctest.Identity) url.Values {
+		return finish(start(identity))
+	}
+
+	user := createUserWithRole(t, "oidc-user@example.com", database.RoleAuthor)
+	corp := oidctest.Identity{Subject: "corp-1", Email: "OIDC-User@example.com", EmailVerified: true}
+
+	// The account is linked to the user with its verified address
+	fragment := signIn(corp)
+	if fragment.Get("error") != "" || fragment.Get("refresh_token") == "" {
+		t.Fatalf("Signing in returned %v", fragment)
+	}
+	claims, err := ParseToken(fragment.Get("access_token"))
+	if err != nil || claims.UserID != user.UserId {
+		t.Fatalf("The access token is for %+v, %v", claims, err)
+	}
+	if verified, _ := EmailVerified(user); !verified {
+		t.Errorf("The provider's verified address wasn't marked verified")
+	}
+	linked, err := LinkedIdentities(WithUser(context.Background(), user))
+	if err != nil || len(linked) != 1 || linked[0].Provider != "corp" {
+		t.Errorf("LinkedIdentities returned %+v, %v", linked, err)
+	}
+
+	// Once linked, the account signs in whatever its address becomes
+	corp.Email, corp.EmailVerified = "renamed@example.com", false
+	if claims, err := ParseToken(signIn(corp).Get("access_token")); err != nil || claims.UserID != user.UserId {
+		t.Errorf("A linked account didn't sign in as its user: %+v, %v", claims, err)
+	}
+
+	// Unlinked accounts need a verified address that someone has
+	unverified := oidctest.Identity{Subject: "corp-2", Email: "oidc-user@example.com"}
+	if got := signIn(unverified).Get("error"); got != ErrProviderEmailNotVerified.Error() {
+		t.Errorf("An unverified address returned %q", got)
+	}
+	stranger := oidctest.Identity{Subject: "corp-3", Email: "oidc-new@example.com", EmailVerified: true}
+	if got := signIn(stranger).Get("error"); got != ErrNoLinkedAccount.Error() {
+		t.Errorf("An unknown address returned %q", got)
+	}
+	configure(true)
+	claims, err = ParseToken(signIn(stranger).Get("access_token"))
+	if err != nil {
+		t.Fatalf("A new user wasn't registered: %v", err)
+	}
+	if registered, err := database.GetUserByEmail("oidc-new@example.com"); err != nil || registered.UserId != claims.UserID {
+		t.Errorf("The new user is %+v, %v", registered, err)
+	}
+
+	// The redirect has to come back to the browser that started signing in
+	cookie, callback := start(corp)
+	if got := finish(nil, callback).Get("error"); got == "" {
+		t.Errorf("The callback worked without the flow cookie")
+	}
+	_, otherCallback := start(corp)
+	if got := finish(cookie, otherCallback).Get("error"); got == "" {
+		t.Errorf("The callback worked with another sign-in's state")
+	}
+	if got := finish(cookie, callback).Get("access_token"); got == "" {
+		t.Errorf("The callback failed with its own cookie")
+	}
+	if got := finish(cookie, callback).Get("error"); got == "" {
+		t.Errorf("A code was used twice")
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("An unknown provider returned %d", rr.Code)
+	}
+
+	// Users with two-factor authentication still need a code
+	ctx := WithSession(WithUser(context.Background(), user), "session")
+	enrollment, err := EnrollTwoFactor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	if _, err := ConfirmTwoFactor(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	fragment = signIn(corp)
+	if fragment.Get("access_token") != "" || fragment.Get("two_factor_token") == "" {
+		t.Errorf("A user with two-factor authentication got %v", fragment)
+	}
+}
+
+func TestConfigureOIDC(t *testing.T) {
+	defer configureOIDC(config.OIDCConfig{}, nil)
+	provider := config.OIDCProviderConfig{Name: "corp", Issuer: "https://idp.example.com", ClientID: "id", RedirectURL: "https://gptback.example.com/auth/oidc/corp/callback"}
+
+	if err := ConfigureOIDC(config.OIDCConfig{Providers: []config.OIDCProviderConfig{provider}}); err != nil {
+		t.Fatalf("ConfigureOIDC returned error: %v", err)
+	}
+	if names := IdentityProviders(); len(names) != 1 || names[0] != "corp" {
+		t.Errorf("IdentityProviders returned %v", names)
+	}
+	if oidcConfig.LoginRedirectURL != defaultOIDCLoginRedirect {
+		t.Errorf("The login redirect page defaulted to %q", oidcConfig.LoginRedirectURL)
+	}
+
+	badName, noClient := provider, provider
+	badName.Name = "corp/idp"
+	noClient.ClientID = ""
+	for name, providers := range map[string][]config.OIDCProviderConfig{
+		"twice":            {provider, provider},
+		"with a bad name":  {badName},
+		"without a client": {noClient},
+	} {
+		if err := ConfigureOIDC(config.OIDCConfig{Providers: providers}); err == nil {
+			t.Errorf("A provider configured %s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPasswordResetTTL = time.Hour
+	defaultPasswordResetURL = "http://localhost:8080/reset-password"
+	defaultVerifyEmailTTL   = 72 * time.Hour
+	defaultVerifyEmailURL   = "http://localhost:8080/verify-email"
+	// minPasswordLength is the fewest characters a new password may have
+	minPasswordLength = 8
+)
+
+// ErrInvalidResetToken is returned for a password reset token that is
+// unknown, already used or expired. The user has to ask for a new one.
+var ErrInvalidResetToken = errors.New("this password reset link is invalid or has expired")
+
+var accountsConfig = config.AccountsConfig{
+	PasswordResetTTL: defaultPasswordResetTTL,
+	PasswordResetURL: defaultPasswordResetURL,
+	VerifyEmailTTL:   defaultVerifyEmailTTL,
+	VerifyEmailURL:   defaultVerifyEmailURL,
+
+	MaxLoginFailures:   defaultMaxLoginFailures,
+	MaxIPLoginFailures: defaultMaxIPLoginFailures,
+	LockoutDuration:    defaultLockoutDuration,
+	MaxLockoutDuration: defaultMaxLockoutDuration,
+}
+
+// passwordResetLimiter keeps anyone from flooding an inbox with reset
+// emails
+var passwordResetLimiter = NewRateLimiter(3, time.Hour)
+
+// ConfigureAccounts applies the account settings from the config file.
+// Unset values keep their defaults.
+func ConfigureAccounts(cfg config.AccountsConfig) {
+	if cfg.PasswordResetTTL <= 0 {
+		cfg.PasswordResetTTL = defaultPasswordResetTTL
+	}
+	if cfg.PasswordResetURL == "" {
+		cfg.PasswordResetURL = defaultPasswordResetURL
+	}
+	if cfg.VerifyEmailTTL <= 0 {
+		cfg.VerifyEmailTTL = defaultVerifyEmailTTL
+	}
+	if cfg.VerifyEmailURL == "" {
+		cfg.VerifyEmailURL = defaultVerifyEmailURL
+	}
+	configureLoginThrottles(&cfg)
+	accountsConfig = cfg
+}
+
+// validatePassword checks a new password is acceptable.
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	return nil
+}
+
+// RequestPasswordReset emails a link to reset the password of the account
+// with the given address. Whether there is such an account is never
+// revealed: the result is the same either way, so the form can't be used to
+// find out who has signed up. The email is sent in the background, since
+// waiting for the mail server would make the response slower for
+// addresses that have an account.
+func RequestPasswordReset(ctx context.Context, email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if !passwordResetLimiter.Allow(email) {
+		logger.DualLog.Printf("Too many password reset requests for %s; ignoring", email)
+		return nil
+	}
+	user, err := database.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.DualLog.Printf("Password reset requested for unknown address %s", email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := database.CreatePasswordResetToken(user.UserId, hashToken(token), now, now.Add(accountsConfig.PasswordResetTTL)); err != nil {
+		return err
+	}
+	link, err := linkWithToken(accountsConfig.PasswordResetURL, token)
+	if err != nil {
+		return err
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new one, open this link:\n\n%s\n\n"+
+			"The link works once, within %s. If you didn't ask for it, you can ignore this email; your password hasn't changed.\n",
+			link, accountsConfig.PasswordResetTTL),
+	}
+	sendMailInBackground(msg, fmt.Sprintf("password reset email to user %d", user.UserId))
+	return nil
+}
+
+// linkWithToken is a link for an email, to a page with a token.
+func linkWithToken(pageURL, token string) (string, error) {
+	link, err := url.Parse(pageURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid link %q: %v", pageURL, err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// ResetPassword sets a new password with a token from a reset email. The
+// token can't be used again, and every session of the user is ended so
+// whoever knew the old password is signed out.
+func ResetPassword(ctx context.Context, token, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %v", err)
+	}
+	userID, err := database.ResetPassword(hashToken(token), string(hash), time.Now())
+	if err == sql.ErrNoRows {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := database.RevokeUserSessions(userID); err != nil {
+		logger.DualLog.Printf("Error ending sessions of user %d after a password reset: %v", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mailRecorder keeps the email sent in a test instead of sending it
+type mailRecorder struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (r *mailRecorder) Send(ctx context.Context, msg mail.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// recordMail records the email sent until the test ends
+func recordMail(t *testing.T) *mailRecorder {
+	recorder := &mailRecorder{}
+	previous := mailer
+	SetMailer(recorder)
+	t.Cleanup(func() { SetMailer(previous) })
+	return recorder
+}
+
+var emailLink = regexp.MustCompile(`https?://\S+`)
+
+// tokenFromLink returns the token parameter of the link in an email
+func tokenFromLink(t *testing.T, msg mail.Message) string {
+	link, err := url.Parse(emailLink.FindString(msg.Body))
+	if err != nil {
+		t.Fatalf("The email has no link: %q", msg.Body)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	sent := recordMail(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old password"), bcrypt.MinCost)
+	userID, err := database.CreateUser(database.User{Email: "forgetful@example.com", PasswordHash: string(hash)})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	old, err := StartSession(context.Background(), database.User{UserId: userID})
+	if err != nil {
+		t.Fatalf("Failed to start session: %v", err)
+	}
+
+	// Unknown addresses look the same to the caller, but get no email
+	if err := RequestPasswordReset(context.Background(), "nobody@example.com"); err != nil {
+		t.Errorf("RequestPasswordReset for an unknown address returned %v", err)
+	}
+	WaitForBackgroundMail()
+	if len(sent.messages) != 0 {
+		t.Fatalf("An email was sent to an unknown address")
+	}
+
+	if err := RequestPasswordReset(context.Background(), "forgetful@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset returned error: %v", err)
+	}
+	WaitForBackgroundMail()
+	if err := RequestPasswordReset(context.Background(), " forgetful@example.com "); err != nil {
+		t.Fatalf("RequestPasswordReset returned error: %v", err)
+	}
+	WaitForBackgroundMail()
+	if len(sent.messages) != 2 || sent.messages[0].To != "forgetful@example.com" {
+		t.Fatalf("Expected two emails to forgetful@example.com, got %+v", sent.messages)
+	}
+	superseded := tokenFromLink(t, sent.messages[0])
+	token := tokenFromLink(t, sent.messages[1])
+
+	if err := ResetPassword(context.Background(), superseded, "new password"); err != ErrInvalidResetToken {
+		t.Errorf("A superseded token returned %v", err)
+	}
+	if err := ResetPassword(context.Background(), token, "short"); err == nil {
+		t.Errorf("A short password was accepted")
+	}
+	if err := ResetPassword(context.Background(), token, "new password"); err != nil {
+		t.Fatalf("ResetPassword returned error: %v", err)
+	}
+	if err := ResetPassword(context.Background(), token, "another password"); err != ErrInvalidResetToken {
+		t.Errorf("A used token returned %v", err)
+	}
+
+	if _, err := LoginUser(context.Background(), map[string]interface{}{"email": "forgetful@example.com", "password": "new password"}); err != nil {
+		t.Errorf("The new password doesn't work: %v", err)
+	}
+	if _, err := LoginUser(context.Background(), map[string]interface{}{"email": "forgetful@example.com", "password": "old password"}); err == nil {
+		t.Errorf("The old password still works")
+	}
+	if _, err := RefreshSession(context.Background(), old.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("A session from before the reset is still active: %v", err)
+	}
+
+	// Too many requests stop sending email, without saying so
+	for i := 0; i < 3; i++ {
+		RequestPasswordReset(context.Background(), "forgetful@example.com")
+	}
+	WaitForBackgroundMail()
+	if len(sent.messages) != 3 {
+		t.Errorf("Expected the rate limit to stop at 3 emails, got %d", len(sent.messages))
+	}
+}
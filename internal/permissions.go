@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// Permission is something a role allows its users to do.
+type Permission string
+
+const (
+	PermCreateArticles   Permission = "articles:create"
+	PermEditOwnArticles  Permission = "articles:edit-own"
+	PermEditAnyArticle   Permission = "articles:edit-any"
+	PermImportArticles   Permission = "articles:import"
+	PermExportArticles   Permission = "articles:export"
+	PermModerateComments Permission = "comments:moderate"
+	PermViewReports      Permission = "reports:view"
+	PermViewTrash        Permission = "trash:view"
+	PermWriteTasks       Permission = "tasks:write"
+	PermManageLogs       Permission = "logs:manage"
+	PermManageUsers      Permission = "users:manage"
+)
+
+var authorPermissions = []Permission{
+	PermCreateArticles,
+	PermEditOwnArticles,
+	PermExportArticles,
+	PermWriteTasks,
+}
+
+var editorPermissions = append([]Permission{
+	PermEditAnyArticle,
+	PermImportArticles,
+	PermModerateComments,
+	PermViewReports,
+	PermViewTrash,
+}, authorPermissions...)
+
+var adminPermissions = append([]Permission{
+	PermManageLogs,
+	PermManageUsers,
+}, editorPermissions...)
+
+// rolePermissions lists what each role may do. Viewers can only read, and
+// comment and rate like anonymous visitors.
+var rolePermissions = map[int64][]Permission{
+	database.RoleAdmin:  adminPermissions,
+	database.RoleEditor: editorPermissions,
+	database.RoleAuthor: authorPermissions,
+	database.RoleViewer: nil,
+}
+
+// ErrLastAdmin is returned when a change would leave no admins.
+var ErrLastAdmin = fmt.Errorf("the last admin can't be given another role")
+
+// RolePermissions returns the permissions of a role, sorted by name.
+func RolePermissions(roleID int64) []Permission {
+	permissions := make([]Permission, len(rolePermissions[roleID]))
+	copy(permissions, rolePermissions[roleID])
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+	return permissions
+}
+
+// RoleHas reports whether a role has a permission.
+func RoleHas(roleID int64, permission Permission) bool {
+	for _, p := range rolePermissions[roleID] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// UserRole returns the user's current role. It is read from the database
+// rather than the access token, so a change of role applies at once.
+func UserRole(user database.User) (int64, error) {
+	return database.GetUserRoleID(user.UserId)
+}
+
+// Can reports whether the user's role has a permission.
+func Can(user database.User, permission Permission) bool {
+	roleID, err := UserRole(user)
+	if err != nil {
+		logger.DualLog.Printf("Error reading role of user %d: %v", user.UserId, err)
+		return false
+	}
+	return RoleHas(roleID, permission)
+}
+
+// RequirePermission returns the signed-in user if their role has a
+// permission. Otherwise it returns ErrUnauthenticated when nobody is signed
+// in, or ErrForbidden.
+func RequirePermission(ctx context.Context, permission Permission) (database.User, error) {
+	user, err := RequireUser(ctx)
+	if err != nil {
+		return database.User{}, err
+	}
+	if !Can(user, permission) || !apiKeyAllows(ctx, permission) {
+		return database.User{}, ErrForbidden
+	}
+	if verifiedPermissions[permission] {
+		if err := checkEmailVerified(user); err != nil {
+			return database.User{}, err
+		}
+	}
+	return user, nil
+}
+
+// RequirePermissionHandler is RequireAuth for users whose role has a
+// permission: anonymous requests get 401 and other users 403.
+func RequirePermissionHandler(permission Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := RequirePermission(r.Context(), permission); err != nil {
+			if err == ErrUnauthenticated {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BootstrapAdmin makes the account with the given address an admin, for
+// the accounts.admin_email setting. Nothing happens for an empty address,
+// and an address no one has registered yet is only logged, since whoever
+// registers it first shouldn't become an admin by doing so.
+func BootstrapAdmin(email string) error {
+	if email == "" {
+		return nil
+	}
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return fmt.Errorf("invalid admin email: %v", err)
+	}
+	user, err := database.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.DualLog.Printf("No account has the admin email %s yet; register it and restart the server to make it an admin", email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.DualLog.Printf("Making user %d with the admin email an admin", user.UserId)
+	return database.SetUserRole(user.UserId, database.RoleAdmin)
+}
+
+// SetUserRole gives a user another role on behalf of the admin in ctx. The
+// last admin can't be demoted, so there is always someone to manage roles.
+func SetUserRole(ctx context.Context, userID, roleID int64) error {
+	if _, err := RequirePermission(ctx, PermManageUsers); err != nil {
+		return err
+	}
+	if _, ok := database.RoleNames[roleID]; !ok {
+		return fmt.Errorf("unknown role %d", roleID)
+	}
+
+	current, err := database.GetUserRoleID(userID)
+	if err != nil {
+		return fmt.Errorf("user %d not found", userID)
+	}
+	if current == database.RoleAdmin && roleID != database.RoleAdmin {
+		admins, err := database.CountUsersWithRole(database.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return ErrLastAdmin
+		}
+	}
+	return database.SetUserRole(userID, roleID)
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func createUserWithRole(t *testing.T, email string, roleID int64) database.User {
+	id, err := database.CreateUser(database.User{Email: email, PasswordHash: "hash", RoleId: roleID})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return database.User{UserId: id, Email: email}
+}
+
+func TestPermissions(t *testing.T) {
+	admin := createUserWithRole(t, "perm-admin@example.com", database.RoleAdmin)
+	editor := createUserWithRole(t, "perm-editor@example.com", database.RoleEditor)
+	author := createUserWithRole(t, "perm-author@example.com", database.RoleAuthor)
+	viewer := createUserWithRole(t, "perm-viewer@example.com", 0)
+
+	if role, _ := UserRole(viewer); role != database.DefaultRole {
+		t.Errorf("A user created without a role has role %d, want %d", role, database.DefaultRole)
+	}
+
+	for _, c := range []struct {
+		user       database.User
+		permission Permission
+		want       bool
+	}{
+		{admin, PermManageUsers, true},
+		{admin, PermWriteTasks, true},
+		{editor, PermEditAnyArticle, true},
+		{editor, PermManageLogs, false},
+		{author, PermCreateArticles, true},
+		{author, PermWriteTasks, true},
+		{author, PermEditAnyArticle, false},
+		{viewer, PermCreateArticles, false},
+		{viewer, PermWriteTasks, false},
+		{database.User{UserId: 99999}, PermWriteTasks, false},
+	} {
+		if got := Can(c.user, c.permission); got != c.want {
+			t.Errorf("Can(%s, %s) = %v, want %v", c.user.Email, c.permission, got, c.want)
+		}
+	}
+
+	// Authors may only edit their own articles; articles nobody wrote are
+	// left to editors
+	as := func(user database.User) context.Context { return WithUser(context.Background(), user) }
+	own := database.Article{AuthorID: author.UserId}
+	others := database.Article{AuthorID: admin.UserId}
+	if err := AuthorizeArticleEdit(as(author), own); err != nil {
+		t.Errorf("An author couldn't edit their own article: %v", err)
+	}
+	if err := AuthorizeArticleEdit(as(author), database.Article{}); err != ErrForbidden {
+		t.Errorf("An author could edit an article without an author: %v", err)
+	}
+	if err := AuthorizeArticleEdit(as(editor), database.Article{}); err != nil {
+		t.Errorf("An editor couldn't edit an article without an author: %v", err)
+	}
+	if err := AuthorizeArticleEdit(as(author), others); err != ErrForbidden {
+		t.Errorf("An author could edit someone else's article: %v", err)
+	}
+	if err := AuthorizeArticleEdit(as(editor), others); err != nil {
+		t.Errorf("An editor couldn't edit someone else's article: %v", err)
+	}
+	if err := AuthorizeArticleEdit(as(viewer), database.Article{}); err != ErrForbidden {
+		t.Errorf("A viewer could edit an article: %v", err)
+	}
+	if err := AuthorizeArticleEdit(context.Background(), database.Article{}); err != ErrUnauthenticated {
+		t.Errorf("An anonymous user could edit an article: %v", err)
+	}
+
+	// An API key only edits what its scopes allow
+	ownOnly := WithAPIKey(as(editor), database.APIKey{Scopes: []string{string(PermEditOwnArticles)}})
+	if err := AuthorizeArticleEdit(ownOnly, others); err != ErrForbidden {
+		t.Errorf("A key scoped to the editor's own articles could edit someone else's: %v", err)
+	}
+	if err := AuthorizeArticleEdit(ownOnly, database.Article{AuthorID: editor.UserId}); err != nil {
+		t.Errorf("A key scoped to the editor's own articles couldn't edit one: %v", err)
+	}
+	anyArticle := WithAPIKey(as(editor), database.APIKey{Scopes: []string{string(PermEditAnyArticle)}})
+	if err := AuthorizeArticleEdit(anyArticle, others); err != nil {
+		t.Errorf("A key scoped to any article couldn't edit someone else's: %v", err)
+	}
+
+	// Access tokens name the role
+	token, _, err := IssueToken(editor, "session")
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	parsed, _ := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return []byte("test secret"), nil })
+	if role := parsed.Claims.(jwt.MapClaims)["role"]; role != "editor" {
+		t.Errorf("The token's role claim is %v", role)
+	}
+
+	// Handlers turn away anonymous users and users without the permission
+	protected := RequirePermissionHandler(PermWriteTasks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range []struct {
+		ctx  context.Context
+		want int
+	}{
+		{context.Background(), http.StatusUnauthorized},
+		{as(viewer), http.StatusForbidden},
+		{as(author), http.StatusOK},
+	} {
+		rr := httptest.NewRecorder()
+		protected.ServeHTTP(rr, httptest.NewRequest("POST", "/", nil).WithContext(c.ctx))
+		if rr.Code != c.want {
+			t.Errorf("RequirePermissionHandler returned %d, want %d", rr.Code, c.want)
+		}
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	admin := createUserWithRole(t, "role-admin@example.com", database.RoleAdmin)
+	user := createUserWithRole(t, "role-user@example.com", database.RoleViewer)
+	ctx := WithUser(context.Background(), admin)
+
+	if err := SetUserRole(WithUser(context.Background(), user), user.UserId, database.RoleAdmin); err != ErrForbidden {
+		t.Errorf("A viewer could change roles: %v", err)
+	}
+	if err := SetUserRole(ctx, user.UserId, 42); err == nil {
+		t.Errorf("SetUserRole accepted an unknown role")
+	}
+	if err := SetUserRole(ctx, user.UserId, database.RoleEditor); err != nil {
+		t.Fatalf("SetUserRole returned error: %v", err)
+	}
+	if role, _ := UserRole(user); role != database.RoleEditor {
+		t.Errorf("The user has role %d after being made an editor", role)
+	}
+
+	// Make this the only admin; other tests create their own
+	_, err := database.DB.Exec("UPDATE user_account_6007 SET RoleId = ? WHERE RoleId = ? AND UserId != ?", database.RoleEditor, database.RoleAdmin, admin.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetUserRole(ctx, admin.UserId, database.RoleViewer); err != ErrLastAdmin {
+		t.Errorf("The last admin could be demoted: %v", err)
+	}
+	if err := SetUserRole(ctx, user.UserId, database.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole returned error: %v", err)
+	}
+	if err := SetUserRole(ctx, admin.UserId, database.RoleViewer); err != nil {
+		t.Errorf("An admin couldn't be demoted while there is another: %v", err)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	user := createUserWithRole(t, "bootstrap-admin@example.com", database.RoleViewer)
+
+	if err := BootstrapAdmin(""); err != nil {
+		t.Errorf("BootstrapAdmin without an address returned error: %v", err)
+	}
+	if err := BootstrapAdmin("nobody-yet@example.com"); err != nil {
+		t.Errorf("BootstrapAdmin for an unregistered address returned error: %v", err)
+	}
+	if _, err := database.GetUserByEmail("nobody-yet@example.com"); err == nil {
+		t.Errorf("BootstrapAdmin created an account")
+	}
+
+	if err := BootstrapAdmin(" Bootstrap-Admin@example.com"); err != nil {
+		t.Fatalf("BootstrapAdmin returned error: %v", err)
+	}
+	if role, _ := UserRole(user); role != database.RoleAdmin {
+		t.Errorf("The admin email's account has role %d", role)
+	}
+	// Restarting leaves the admin an admin
+	if err := BootstrapAdmin("bootstrap-admin@example.com"); err != nil {
+		t.Errorf("BootstrapAdmin returned error for an admin: %v", err)
+	}
+}
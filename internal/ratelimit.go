@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTrackedKeys caps how many keys a RateLimiter, LoginThrottle or
+	// ViewRecorder keeps, so requests with made-up email addresses, spoofed
+	// IP addresses or rotating user agents can't use up memory. Past it, the keys seen longest ago
+	// are dropped.
+	maxTrackedKeys = 100000
+	// sweepInterval is how often keys that no longer matter are cleared
+	// away
+	sweepInterval = time.Minute
+)
+
+// dropOldestKeys deletes the older half of the keys of a map, going by
+// when each was last seen. It is how the limiters and the view recorder
+// make room when they reach maxTrackedKeys.
+func dropOldestKeys(keys []string, lastSeen func(string) time.Time, drop func(string)) {
+	sort.Slice(keys, func(i, j int) bool { return lastSeen(keys[i]).Before(lastSeen(keys[j])) })
+	for _, key := range keys[:len(keys)/2] {
+		drop(key)
+	}
+}
+
+// RateLimiter allows at most limit events per key within a sliding window.
+// State is kept in memory, so limits reset when the server restarts.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	maxKeys   int
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		events:  map[string][]time.Time{},
+		maxKeys: maxTrackedKeys,
+		now:     time.Now,
+	}
+}
+
+// sweep forgets keys without events in the window, and makes room for a
+// new key if there are too many. The caller holds the lock.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.lastSweep = now
+		cutoff := now.Add(-l.window)
+		for key, events := range l.events {
+			if len(events) == 0 || !events[len(events)-1].After(cutoff) {
+				delete(l.events, key)
+			}
+		}
+	}
+	if len(l.events) < l.maxKeys {
+		return
+	}
+	keys := make([]string, 0, len(l.events))
+	for key := range l.events {
+		keys = append(keys, key)
+	}
+	dropOldestKeys(keys, func(key string) time.Time {
+		events := l.events[key]
+		if len(events) == 0 {
+			return time.Time{}
+		}
+		return events[len(events)-1]
+	}, func(key string) { delete(l.events, key) })
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+// Rejected events are not recorded.
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if _, ok := l.events[key]; !ok {
+		l.sweep(now)
+	}
+	cutoff := now.Add(-l.window)
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false
+	}
+	l.events[key] = append(recent, now)
+	return true
+}
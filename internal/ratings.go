@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// guestRaterKey is the secret guests' IP addresses are hashed with. A plain
+// hash of an IPv4 address can be reversed by trying them all.
+var guestRaterKey = randomRaterKey()
+
+func randomRaterKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logger.DualLog.Printf("Error generating guest rater key: %v", err)
+	}
+	return key
+}
+
+// ConfigureRatings applies the rating settings from the config file.
+func ConfigureRatings(cfg config.RatingsConfig) {
+	if cfg.GuestKey == "" {
+		logger.DualLog.Println("ratings.guest_key is not set; guests will be able to rate articles again after a restart")
+		return
+	}
+	guestRaterKey = []byte(cfg.GuestKey)
+}
+
+// raterKey identifies the reader rating an article: the signed-in user, or
+// an HMAC of a guest's IP address with the guest key, so addresses aren't
+// stored and can't be worked out from the database alone.
+func raterKey(ctx context.Context) string {
+	if user, ok := UserFromContext(ctx); ok {
+		return fmt.Sprintf("user:%d", user.UserId)
+	}
+	mac := hmac.New(sha256.New, guestRaterKey)
+	mac.Write([]byte(ClientIPFromContext(ctx)))
+	return "ip:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ThumbScore converts a thumbs up or down into a rating score.
+func ThumbScore(up bool) int {
+	if up {
+		return database.MaxRatingScore
+	}
+	return database.MinRatingScore
+}
+
+// RateArticle records the current reader's score for an article. Rating the
+// same article again replaces the earlier score.
+func RateArticle(ctx context.Context, articleID int64, score int) error {
+	if score < database.MinRatingScore || score > database.MaxRatingScore {
+		return fmt.Errorf("rating must be between %d and %d", database.MinRatingScore, database.MaxRatingScore)
+	}
+	if _, err := database.ReadArticle(articleID); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("article %d not found", articleID)
+		}
+		return err
+	}
+	return database.RateArticle(articleID, raterKey(ctx), score)
+}
+
+// RateArticleHandler accepts the rating form on an article page, either a
+// 1-5 score or a thumbs up/down.
+func RateArticleHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("RateArticleHandler called")
+	defer logger.DualLog.Println("RateArticleHandler exited")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := mux.Vars(r)["slug"]
+	article, err := database.GetArticleBySlug(slug)
+	if err != nil {
+		NotFoundHandler(w, r)
+		return
+	}
+
+	var score int
+	switch r.FormValue("thumb") {
+	case "up":
+		score = ThumbScore(true)
+	case "down":
+		score = ThumbScore(false)
+	default:
+		score, _ = strconv.Atoi(r.FormValue("score"))
+	}
+
+	if err := RateArticle(r.Context(), article.ID, score); err != nil {
+		logger.DualLog.Printf("Error rating article %s: %v", slug, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/articles/"+slug+"#rating", http.StatusSeeOther)
+}
+
+// RatingReportHandler shows editors how the prompts and models behind
+// generated articles are rated.
+func RatingReportHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("RatingReportHandler called")
+	defer logger.DualLog.Println("RatingReportHandler exited")
+
+	if _, err := RequirePermission(r.Context(), PermViewReports); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group")
+	if groupBy == "" {
+		groupBy = database.GroupByPromptAndModel
+	}
+	minRatings, _ := strconv.Atoi(r.URL.Query().Get("min"))
+
+	report, err := database.GetPromptRatings(groupBy, minRatings)
+	if err != nil {
+		http.Error(w, "Error fetching ratings", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"ContentTemplateName": "adminRatings",
+		"GroupBy":             groupBy,
+		"MinRatings":          minRatings,
+		"Report":              report,
+	}
+
+	RenderTemplateWithData(w, "base.gohtml", "adminRatingsContent", data)
+}
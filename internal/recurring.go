@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/rrule"
+)
+
+const defaultRecurrenceInterval = 5 * time.Minute
+
+// ValidateTaskTemplate checks a recurring task template from the API and
+// returns it with its rule in canonical form.
+func ValidateTaskTemplate(template database.TaskTemplate) (database.TaskTemplate, error) {
+	task := database.Task{Title: template.Title, Status: database.TaskTodo, Priority: template.Priority, AssigneeID: template.AssigneeID}
+	if err := ValidateTask(task); err != nil {
+		return template, err
+	}
+	rule, err := rrule.Parse(template.Rule)
+	if err != nil {
+		return template, err
+	}
+	template.Rule = rule.String()
+	return template, nil
+}
+
+// MaterializeTaskTemplate generates the next task for a template if it's
+// time to. That's when the template has no open task, because the last one
+// was done or deleted or none has been generated yet, or when the date of
+// the next occurrence has arrived. Occurrences that were missed, such as
+// while the server was down, are skipped in favour of the latest one that
+// has arrived. It reports whether a task was created.
+//
+// Occurrences are unique per template, so calling it again, concurrently or
+// after a restart, never duplicates a task.
+func MaterializeTaskTemplate(template database.TaskTemplate, now time.Time) (bool, error) {
+	rule, err := rrule.Parse(template.Rule)
+	if err != nil {
+		return false, err
+	}
+	today := now.UTC()
+
+	last, found, err := database.GetLastOccurrence(template.ID)
+	if err != nil {
+		return false, err
+	}
+	var next time.Time
+	var ok bool
+	if found {
+		next, ok = rule.Next(template.StartDate, last.Date)
+	} else {
+		next, ok = rule.First(template.StartDate)
+	}
+	if !ok {
+		return false, nil
+	}
+	if found && last.Open && next.After(today) {
+		return false, nil
+	}
+	for {
+		following, ok := rule.Next(template.StartDate, next)
+		if !ok || following.After(today) {
+			break
+		}
+		next = following
+	}
+
+	return database.CreateOccurrence(template, next)
+}
+
+// MaterializeRecurringTasks runs MaterializeTaskTemplate for every template
+// and returns how many tasks were created. A template that fails is logged
+// and skipped.
+func MaterializeRecurringTasks(now time.Time) (int, error) {
+	templates, err := database.GetTaskTemplates()
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, template := range templates {
+		ok, err := MaterializeTaskTemplate(template, now)
+		if err != nil {
+			logger.DualLog.Printf("Error generating the next task for template %d: %v", template.ID, err)
+			continue
+		}
+		if ok {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// StartTaskScheduler generates the tasks for recurring task templates every
+// Interval until ctx is cancelled.
+func StartTaskScheduler(ctx context.Context, cfg config.RecurrenceConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultRecurrenceInterval
+	}
+	logger.DualLog.Printf("Generating recurring tasks every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if created, err := MaterializeRecurringTasks(time.Now()); err != nil {
+				logger.DualLog.Printf("Error generating recurring tasks: %v", err)
+			} else if created > 0 {
+				logger.DualLog.Printf("Generated %d recurring tasks", created)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestMaterializeTaskTemplate(t *testing.T) {
+	template, err := ValidateTaskTemplate(database.TaskTemplate{
+		Title:     "Weekly content review",
+		Priority:  database.TaskPriorityMedium,
+		Rule:      "freq=weekly;byday=mo",
+		StartDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("ValidateTaskTemplate failed: %v", err)
+	}
+	if template.Rule != "FREQ=WEEKLY;BYDAY=MO" {
+		t.Errorf("The rule wasn't normalized: %s", template.Rule)
+	}
+	template.ID, err = database.CreateTaskTemplate(template)
+	if err != nil {
+		t.Fatalf("Failed to create task template: %v", err)
+	}
+
+	occurrences := func() []string {
+		tasks, _, err := database.ListTasks(database.TaskFilter{Query: template.Title, Limit: MaxTaskPageSize})
+		if err != nil {
+			t.Fatalf("Failed to list tasks: %v", err)
+		}
+		var dates []string
+		for _, task := range tasks {
+			if task.TemplateID != template.ID || task.OccurrenceDate == nil || task.DueDate == nil || !task.DueDate.Equal(*task.OccurrenceDate) {
+				t.Fatalf("Generated task is not linked to its occurrence: %+v", task)
+			}
+			dates = append(dates, task.OccurrenceDate.Format(database.TaskDueDateFormat))
+		}
+		return dates
+	}
+	materialize := func(now string, want bool) {
+		at, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created, err := MaterializeTaskTemplate(template, at)
+		if err != nil {
+			t.Fatalf("MaterializeTaskTemplate at %s failed: %v", now, err)
+		}
+		if created != want {
+			t.Errorf("MaterializeTaskTemplate at %s created a task: %v, want %v", now, created, want)
+		}
+	}
+
+	// The first occurrence is generated straight away, then nothing
+	// until its date arrives
+	materialize("2023-12-20T09:00:00Z", true)
+	materialize("2023-12-21T09:00:00Z", false)
+	materialize("2024-01-08T09:00:00Z", true)
+	materialize("2024-01-08T10:00:00Z", false)
+	if got, want := occurrences(), []string{"2024-01-01", "2024-01-08"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Occurrences are %v, want %v", got, want)
+	}
+
+	// Completing the latest task generates the next one ahead of its date
+	tasks, _, err := database.ListTasks(database.TaskFilter{Query: template.Title, Sort: database.TaskSortDueDate, Descending: true, Limit: 1})
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Failed to find the latest occurrence: %v", err)
+	}
+	latest := tasks[0]
+	latest.Status = database.TaskDone
+	if err := database.UpdateTaskRecord(latest); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	materialize("2024-01-09T09:00:00Z", true)
+
+	// Missed occurrences are skipped in favour of the latest one
+	materialize("2024-02-07T09:00:00Z", true)
+	if got, want := occurrences(), []string{"2024-01-01", "2024-01-08", "2024-01-15", "2024-02-05"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Occurrences are %v, want %v", got, want)
+	}
+
+	// Generating an occurrence twice, as after a restart, does nothing
+	created, err := database.CreateOccurrence(template, time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil || created {
+		t.Errorf("Creating an existing occurrence returned %v, %v", created, err)
+	}
+
+	if _, err := ValidateTaskTemplate(database.TaskTemplate{Title: "Bad rule", Priority: database.TaskPriorityLow, Rule: "FREQ=HOURLY"}); err == nil {
+		t.Errorf("An unsupported rule was accepted")
+	}
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// ErrInvalidRefreshToken is returned for a refresh token that is unknown,
+// already used, or whose session has ended. The client has to sign in
+// again.
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// TokenPair is what a client gets on signing in: a short-lived access token
+// to send with requests, and a refresh token to exchange for a new pair
+// before the access token expires.
+type TokenPair struct {
+	AccessToken string
+	// ExpiresAt is when the access token expires
+	ExpiresAt    time.Time
+	RefreshToken string
+	// TwoFactorToken is set instead of the other fields when the password
+	// was right but the user also needs to enter a code. It is exchanged
+	// with the code by CompleteTwoFactorLogin.
+	TwoFactorToken string
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// StartSession signs a user in on the device that made the request in ctx,
+// returning the session's first tokens and setting the auth cookie to the
+// access token. Sessions of the user's that have ended are cleared away.
+func StartSession(ctx context.Context, user database.User) (TokenPair, error) {
+	if err := database.DeleteEndedSessions(user.UserId, time.Now()); err != nil {
+		logger.DualLog.Printf("Error clearing ended sessions of user %d: %v", user.UserId, err)
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := newSecretToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now()
+	session := database.Session{
+		ID:         id,
+		UserID:     user.UserId,
+		UserAgent:  UserAgentFromContext(ctx),
+		IPAddress:  ClientIPFromContext(ctx),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(authConfig.RefreshTTL),
+	}
+	if err := database.CreateSession(session, hashToken(refreshToken)); err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, expiresAt, err := IssueToken(user, id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	setAuthCookie(ctx, accessToken, expiresAt)
+	return TokenPair{AccessToken: accessToken, ExpiresAt: expiresAt, RefreshToken: refreshToken}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new pair of tokens. Each
+// refresh token can only be used once. A used one being presented again
+// means it was copied, so its whole session is revoked: whichever of the
+// client and the copier refreshes next finds itself signed out. The auth
+// cookie is set to the new access token.
+func RefreshSession(ctx context.Context, refreshToken string) (TokenPair, error) {
+	oldHash := hashToken(refreshToken)
+	token, err := database.GetRefreshToken(oldHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	session, err := database.GetSession(token.SessionID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now()
+	if !session.Active(now) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	if token.UsedAt != nil {
+		revokeReusedSession(session)
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	user, err := database.GetUserByID(session.UserID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	newToken, err := newSecretToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	session.UserAgent = UserAgentFromContext(ctx)
+	session.IPAddress = ClientIPFromContext(ctx)
+	err = database.RotateRefreshToken(oldHash, hashToken(newToken), session, now, now.Add(authConfig.RefreshTTL))
+	if err == sql.ErrNoRows {
+		// Another request used the token first
+		revokeReusedSession(session)
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, expiresAt, err := IssueToken(user, session.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	setAuthCookie(ctx, accessToken, expiresAt)
+	return TokenPair{AccessToken: accessToken, ExpiresAt: expiresAt, RefreshToken: newToken}, nil
+}
+
+func revokeReusedSession(session database.Session) {
+	logger.DualLog.Printf("Refresh token of session %s was used twice; revoking the session of user %d", session.ID, session.UserID)
+	if err := database.RevokeSession(session.UserID, session.ID); err != nil && err != sql.ErrNoRows {
+		logger.DualLog.Printf("Error revoking session %s: %v", session.ID, err)
+	}
+}
+
+// Logout ends the session the request in ctx was made in. Its access
+// tokens stop working at once and its refresh token can't be used.
+func Logout(ctx context.Context) error {
+	clearAuthCookie(ctx)
+	return RevokeSession(ctx, SessionIDFromContext(ctx))
+}
+
+// LogoutAll ends every session of the signed-in user, on all their
+// devices, and returns how many there were.
+func LogoutAll(ctx context.Context) (int64, error) {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return 0, err
+	}
+	clearAuthCookie(ctx)
+	return database.RevokeUserSessions(user.UserId)
+}
+
+// RevokeSession ends one of the signed-in user's sessions, such as one on a
+// lost device.
+func RevokeSession(ctx context.Context, sessionID string) error {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return err
+	}
+	err = database.RevokeSession(user.UserId, sessionID)
+	if err == sql.ErrNoRows {
+		return errors.New("there is no such session to end")
+	}
+	return err
+}
+
+// ListSessions returns the signed-in user's active sessions.
+func ListSessions(ctx context.Context) ([]database.Session, error) {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetActiveSessions(user.UserId, time.Now())
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSessions(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	userID, err := database.CreateUser(database.User{Email: "sessions@example.com", PasswordHash: string(hash)})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	login := func(agent string) TokenPair {
+		ctx := context.WithValue(context.Background(), userAgentContextKey, agent)
+		tokens, err := LoginUser(ctx, map[string]interface{}{"email": "sessions@example.com", "password": "password"})
+		if err != nil {
+			t.Fatalf("LoginUser returned error: %v", err)
+		}
+		return tokens
+	}
+	// signedIn reports whether an access token is accepted, and the
+	// request context it was accepted into
+	signedIn := func(accessToken string) (context.Context, bool) {
+		var ctx context.Context
+		handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() }))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return ctx, rr.Code == http.StatusOK
+	}
+
+	phone := login("Phone")
+	laptop := login("Laptop")
+	ctx, ok := signedIn(laptop.AccessToken)
+	if !ok {
+		t.Fatalf("A fresh access token was rejected")
+	}
+	sessions, err := ListSessions(ctx)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("ListSessions returned %d sessions, %v", len(sessions), err)
+	}
+	if sessions[0].UserAgent != "Laptop" || sessions[0].ID != SessionIDFromContext(ctx) {
+		t.Errorf("The most recent session should be first: %+v", sessions[0])
+	}
+
+	// Refresh tokens are rotated
+	refreshed, err := RefreshSession(context.Background(), phone.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshSession returned error: %v", err)
+	}
+	if refreshed.RefreshToken == phone.RefreshToken {
+		t.Errorf("Refreshing didn't rotate the refresh token")
+	}
+	if _, ok := signedIn(refreshed.AccessToken); !ok {
+		t.Errorf("A refreshed access token was rejected")
+	}
+	if _, err := RefreshSession(context.Background(), "made-up"); err != ErrInvalidRefreshToken {
+		t.Errorf("An unknown refresh token returned %v", err)
+	}
+
+	// Using a refresh token twice revokes its whole family
+	if _, err := RefreshSession(context.Background(), phone.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("A used refresh token returned %v", err)
+	}
+	if _, err := RefreshSession(context.Background(), refreshed.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("A refresh token of a revoked session returned %v", err)
+	}
+	if _, ok := signedIn(refreshed.AccessToken); ok {
+		t.Errorf("An access token of a revoked session was accepted")
+	}
+	if _, ok := signedIn(laptop.AccessToken); !ok {
+		t.Errorf("Revoking one session signed out another")
+	}
+
+	// Logging out ends the current session, and logging out everywhere ends
+	// the rest
+	if err := Logout(ctx); err != nil {
+		t.Fatalf("Logout returned error: %v", err)
+	}
+	if _, ok := signedIn(laptop.AccessToken); ok {
+		t.Errorf("An access token was accepted after logging out")
+	}
+	if _, err := RefreshSession(context.Background(), laptop.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("A refresh token was accepted after logging out: %v", err)
+	}
+
+	tablet := login("Tablet")
+	desktop := login("Desktop")
+	ctx, _ = signedIn(desktop.AccessToken)
+	if n, err := LogoutAll(ctx); err != nil || n != 2 {
+		t.Errorf("LogoutAll ended %d sessions, %v", n, err)
+	}
+	if _, err := RefreshSession(context.Background(), tablet.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("A refresh token was accepted after logging out everywhere: %v", err)
+	}
+	if sessions, _ := database.GetActiveSessions(userID, tablet.ExpiresAt); len(sessions) != 0 {
+		t.Errorf("%d sessions are still active after logging out everywhere", len(sessions))
+	}
+}
@@ -0,0 +1,330 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// StaticSiteOptions configures ExportStaticSite.
+type StaticSiteOptions struct {
+	// OutDir receives the rendered pages, feed, sitemap and assets
+	OutDir string
+	// StaticDir is copied to OutDir/static
+	StaticDir string
+	// BaseURL is the public address of the mirror, used for the absolute
+	// links the feed and sitemap require
+	BaseURL string
+}
+
+type staticPage struct {
+	urlPath         string
+	contentTemplate string
+	data            map[string]interface{}
+}
+
+// ExportStaticSite renders the index, about page, every article, every
+// author and every tag through the site templates and writes them, together with an RSS
+// feed, a sitemap and the static assets, into OutDir. Links between exported
+// pages are rewritten to relative paths so the output can be served from any
+// location or opened straight from disk; links to pages that need the server
+// are left as is.
+func ExportStaticSite(opts StaticSiteOptions) error {
+	logger.DualLog.Printf("Exporting static site to %s", opts.OutDir)
+
+	if opts.BaseURL == "" {
+		return fmt.Errorf("a base URL is required for the feed and sitemap")
+	}
+	baseURL := strings.TrimSuffix(opts.BaseURL, "/")
+
+	articles, err := database.GetPublishedArticles()
+	if err != nil {
+		return fmt.Errorf("error fetching articles: %v", err)
+	}
+
+	tags, err := database.GetAllArticleTags()
+	if err != nil {
+		return fmt.Errorf("error fetching tags: %v", err)
+	}
+
+	pages := []staticPage{
+		{urlPath: "/", contentTemplate: "indexContent", data: map[string]interface{}{
+			"ContentTemplateName": "index",
+			"Articles":            articles,
+		}},
+		{urlPath: "/about", contentTemplate: "aboutContent", data: map[string]interface{}{
+			"ContentTemplateName": "about",
+		}},
+	}
+	authored := map[int64][]database.Article{}
+	var authorIDs []int64
+	for _, article := range articles {
+		pages = append(pages, staticPage{
+			urlPath:         articlePath(article),
+			contentTemplate: "articleContent",
+			data:            articlePageData(article),
+		})
+		if article.AuthorID != 0 {
+			if _, seen := authored[article.AuthorID]; !seen {
+				authorIDs = append(authorIDs, article.AuthorID)
+			}
+			authored[article.AuthorID] = append(authored[article.AuthorID], article)
+		}
+	}
+	for _, authorID := range authorIDs {
+		author, err := database.GetUserByID(authorID)
+		if err != nil {
+			return fmt.Errorf("error fetching author %d: %v", authorID, err)
+		}
+		pages = append(pages, staticPage{
+			urlPath:         fmt.Sprintf("/authors/%d", authorID),
+			contentTemplate: "authorContent",
+			data:            authorPageData(author, authored[authorID]),
+		})
+	}
+
+	for _, group := range groupArticlesByTag(articles, tags) {
+		pages = append(pages, staticPage{
+			urlPath:         group.Path,
+			contentTemplate: "tagContent",
+			data:            tagPageData(group),
+		})
+	}
+
+	exported := map[string]bool{}
+	for _, page := range pages {
+		exported[page.urlPath] = true
+	}
+
+	for _, page := range pages {
+		var buf bytes.Buffer
+		err := RenderPage(&buf, "base.gohtml", page.contentTemplate, page.data)
+		if err != nil {
+			return fmt.Errorf("error rendering %s: %v", page.urlPath, err)
+		}
+		html := relativizeLinks(buf.Bytes(), page.urlPath, exported)
+		err = writeStaticFile(opts.OutDir, pageFile(page.urlPath), html)
+		if err != nil {
+			return err
+		}
+	}
+
+	var notFound bytes.Buffer
+	err = RenderPage(&notFound, "base.gohtml", "404Content", map[string]interface{}{"ContentTemplateName": "404"})
+	if err != nil {
+		return fmt.Errorf("error rendering 404 page: %v", err)
+	}
+	// The 404 page is served for arbitrary paths, so it keeps absolute links
+	err = writeStaticFile(opts.OutDir, "404.html", notFound.Bytes())
+	if err != nil {
+		return err
+	}
+
+	feed, err := renderFeed(baseURL, articles)
+	if err != nil {
+		return fmt.Errorf("error rendering feed: %v", err)
+	}
+	if err := writeStaticFile(opts.OutDir, "feed.xml", feed); err != nil {
+		return err
+	}
+
+	sitemap, err := renderSitemap(baseURL, pages)
+	if err != nil {
+		return fmt.Errorf("error rendering sitemap: %v", err)
+	}
+	if err := writeStaticFile(opts.OutDir, "sitemap.xml", sitemap); err != nil {
+		return err
+	}
+
+	if opts.StaticDir != "" {
+		err = copyDir(opts.StaticDir, filepath.Join(opts.OutDir, "static"))
+		if err != nil {
+			return fmt.Errorf("error copying static assets: %v", err)
+		}
+		favicon := filepath.Join(opts.StaticDir, "images", "favicon.ico")
+		if _, err := os.Stat(favicon); err == nil {
+			if err := copyFile(favicon, filepath.Join(opts.OutDir, "favicon.ico")); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.DualLog.Printf("Exported %d pages to %s", len(pages), opts.OutDir)
+	return nil
+}
+
+func articlePath(article database.Article) string {
+	return "/articles/" + article.Slug
+}
+
+// pageFile maps a URL path to the file holding it, using directory indexes so
+// the mirror keeps the server's URLs.
+func pageFile(urlPath string) string {
+	if urlPath == "/" {
+		return "index.html"
+	}
+	return path.Join(strings.TrimPrefix(urlPath, "/"), "index.html")
+}
+
+var rootRelativeLink = regexp.MustCompile(`(href|src)="(/[^"]*)"`)
+
+// relativizeLinks rewrites root-relative href and src attributes in a page
+// rendered for pagePath so they point at the exported files.
+func relativizeLinks(html []byte, pagePath string, exported map[string]bool) []byte {
+	prefix := strings.Repeat("../", strings.Count(pageFile(pagePath), "/"))
+
+	return rootRelativeLink.ReplaceAllFunc(html, func(match []byte) []byte {
+		parts := rootRelativeLink.FindSubmatch(match)
+		attr, target := string(parts[1]), string(parts[2])
+		if strings.HasPrefix(target, "//") {
+			return match
+		}
+
+		suffix := ""
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target, suffix = target[:i], target[i:]
+		}
+
+		var file string
+		switch {
+		case strings.HasPrefix(target, "/static/") || path.Ext(target) != "":
+			file = strings.TrimPrefix(target, "/")
+		case exported[target]:
+			file = pageFile(target)
+		default:
+			return match
+		}
+		return []byte(fmt.Sprintf(`%s="%s%s%s"`, attr, prefix, file, suffix))
+	})
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	Description string `xml:"description"`
+}
+
+func renderFeed(baseURL string, articles []database.Article) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "myFireGPT",
+			Link:        baseURL + "/",
+			Description: "Articles from myFireGPT",
+		},
+	}
+	for _, article := range articles {
+		link := baseURL + articlePath(article) + "/"
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       article.Title,
+			Link:        link,
+			GUID:        link,
+			Description: article.Preview,
+		})
+	}
+	return marshalXML(feed)
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+func renderSitemap(baseURL string, pages []staticPage) ([]byte, error) {
+	sitemap := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range pages {
+		loc := baseURL + page.urlPath
+		if page.urlPath != "/" {
+			loc += "/"
+		}
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{Loc: loc})
+	}
+	return marshalXML(sitemap)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// writeStaticFile writes a file of the export. Names come from slugs, so
+// one that would land outside outDir is refused rather than written.
+func writeStaticFile(outDir, rel string, data []byte) error {
+	name := filepath.Join(outDir, rel)
+	inside, err := filepath.Rel(outDir, name)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to write %q outside %s", rel, outDir)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), os.ModePerm)
+		}
+		return copyFile(name, filepath.Join(dst, rel))
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+func TestRelativizeLinks(t *testing.T) {
+	exported := map[string]bool{"/": true, "/articles/dragons": true}
+	html := `<a href="/">Home</a><a href="/contact">Contact</a><link href="/static/css/main.css"><img src="/favicon.ico"><a href="/articles/dragons#top">D</a><img src="//cdn.example.com/x.png">`
+
+	got := string(relativizeLinks([]byte(html), "/articles/dragons", exported))
+	want := `<a href="../../index.html">Home</a><a href="/contact">Contact</a><link href="../../static/css/main.css"><img src="../../favicon.ico"><a href="../../articles/dragons/index.html#top">D</a><img src="//cdn.example.com/x.png">`
+	if got != want {
+		t.Errorf("relativizeLinks returned wrong result:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestExportStaticSite(t *testing.T) {
+	articleID, err := database.CreateArticle("Static Export Article", "static.jpg", "Static preview", "Static text")
+	if err != nil {
+		t.Fatalf("Failed to create article for testing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(articleID)
+	})
+	article, err := database.ReadArticle(articleID)
+	if err != nil {
+		t.Fatalf("Failed to read article: %v", err)
+	}
+	draftID, err := database.CreateArticle("Static Export Draft", "draft.jpg", "Draft preview", "Draft text")
+	if err != nil {
+		t.Fatalf("Failed to create article for testing: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(draftID)
+	})
+	draft, _ := database.ReadArticle(draftID)
+	batch, err := database.BeginArticleBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch.AddTags(articleID, []string{"Static Export"})
+	batch.AddTags(draftID, []string{"Static Export"})
+	batch.SetPublished(draftID, false)
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := t.TempDir()
+	err = ExportStaticSite(StaticSiteOptions{OutDir: outDir, BaseURL: "https://mirror.example.com/"})
+	if err != nil {
+		t.Fatalf("ExportStaticSite returned error: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(outDir, "articles", article.Slug, "index.html"))
+	if err != nil {
+		t.Fatalf("Article page was not written: %v", err)
+	}
+	if !strings.Contains(string(page), "Static text") || !strings.Contains(string(page), `href="../../static/css/main.css"`) {
+		t.Errorf("Article page has unexpected content: %s", page)
+	}
+	if !strings.Contains(string(page), `href="../../tags/static-export/index.html"`) {
+		t.Errorf("Article page doesn't link its tag: %s", page)
+	}
+
+	tagPage, err := os.ReadFile(filepath.Join(outDir, "tags", "static-export", "index.html"))
+	if err != nil {
+		t.Fatalf("Tag page was not written: %v", err)
+	}
+	if !strings.Contains(string(tagPage), "Static preview") || strings.Contains(string(tagPage), "Draft preview") {
+		t.Errorf("Tag page has unexpected content: %s", tagPage)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "articles", draft.Slug)); !os.IsNotExist(err) {
+		t.Errorf("An unpublished article was exported")
+	}
+
+	for _, name := range []string{"index.html", "about/index.html", "404.html", "feed.xml", "sitemap.xml"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("Expected %s to be exported: %v", name, err)
+		}
+	}
+
+	sitemap, _ := os.ReadFile(filepath.Join(outDir, "sitemap.xml"))
+	if !strings.Contains(string(sitemap), "<loc>https://mirror.example.com/articles/"+article.Slug+"/</loc>") {
+		t.Errorf("Sitemap is missing the article: %s", sitemap)
+	}
+}
+
+func TestWriteStaticFileStaysInOutDir(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "site")
+	if err := writeStaticFile(outDir, pageFile("/articles/../../../escaped"), []byte("x")); err == nil {
+		t.Errorf("A page was written outside the output directory")
+	}
+	if err := writeStaticFile(outDir, pageFile("/articles/fine"), []byte("x")); err != nil {
+		t.Errorf("writeStaticFile returned error: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// TaskColumn is one status column of the task board, in rank order. Total
+// counts every task in the column, which may be more than are shown.
+type TaskColumn struct {
+	Status string
+	Tasks  []database.Task
+	Total  int
+}
+
+// MoveTask moves a task to the board column for status, directly before
+// beforeID and after afterID. Either neighbour may be 0; with neither the
+// task goes to the bottom of the column.
+func MoveTask(id int64, status string, beforeID, afterID int64) error {
+	if !containsString(database.TaskStatuses, status) {
+		return fmt.Errorf("%w: status must be one of %s", database.ErrInvalidMove, strings.Join(database.TaskStatuses, ", "))
+	}
+	return database.MoveTask(id, status, beforeID, afterID)
+}
+
+// taskMove is the body of a move request.
+type taskMove struct {
+	Status   string `json:"status"`
+	BeforeID int64  `json:"beforeId"`
+	AfterID  int64  `json:"afterId"`
+}
+
+// MoveTaskHandler moves a task on the board and returns it.
+func MoveTaskHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("Starting MoveTaskHandler function...")
+
+	id, err := taskID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var move taskMove
+	if err := json.NewDecoder(r.Body).Decode(&move); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	err = MoveTask(int64(id), move.Status, move.BeforeID, move.AfterID)
+	switch {
+	case err == sql.ErrNoRows:
+		writeJSONError(w, http.StatusNotFound, "Task not found")
+		return
+	case errors.Is(err, database.ErrInvalidMove):
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "Error moving task")
+		return
+	}
+
+	task, err := database.ReadTask(id)
+	if err != nil {
+		logger.DualLog.Printf("Error reading moved task: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error moving task")
+		return
+	}
+	writeJSON(w, http.StatusOK, taskFromDatabase(task))
+}
+
+// TaskBoardHandler renders the tasks as a board with a column per status.
+// It accepts the task list's filters, apart from status.
+func TaskBoardHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("Starting TaskBoardHandler function...")
+	defer logger.DualLog.Println("Exiting TaskBoardHandler function.")
+
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Sort, filter.Descending = database.TaskSortRank, false
+	filter.Limit, filter.Offset = MaxTaskPageSize, 0
+
+	columns := make([]TaskColumn, 0, len(database.TaskStatuses))
+	for _, status := range database.TaskStatuses {
+		filter.Status = status
+		tasks, total, err := database.ListTasks(filter)
+		if err != nil {
+			logger.DualLog.Printf("Error listing %s tasks: %v", status, err)
+			http.Error(w, "Error listing tasks", http.StatusInternalServerError)
+			return
+		}
+		columns = append(columns, TaskColumn{Status: status, Tasks: tasks, Total: total})
+	}
+
+	users, err := database.GetUsers()
+	if err != nil {
+		http.Error(w, "Error listing users", http.StatusInternalServerError)
+		return
+	}
+	assignees := make(map[int64]string, len(users))
+	for _, user := range users {
+		assignees[user.UserId] = user.DisplayName()
+	}
+
+	data := map[string]interface{}{
+		"Columns":    columns,
+		"Query":      r.URL.Query(),
+		"Users":      users,
+		"Assignees":  assignees,
+		"Priorities": database.TaskPriorities,
+	}
+
+	RenderTemplateWithData(w, "base.gohtml", "taskBoardContent", data)
+}
@@ -3,42 +3,413 @@ package internal
 import (
 	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rmacdiarmid/gptback/logger"
 	"github.com/rmacdiarmid/gptback/pkg/database"
 )
 
+const (
+	defaultTaskPageSize = 20
+	// MaxTaskPageSize is the most tasks returned by one list request
+	MaxTaskPageSize    = 100
+	maxTaskTitleLength = 200
+)
+
+// Task is the JSON representation of a task in the tasks API. DueDate is a
+// YYYY-MM-DD date; it and AssigneeID are null when unset. Rank, CreatedAt,
+// UpdatedAt and the template fields are ignored in requests; tasks are
+// reordered by moving them.
 type Task struct {
-	ID          int64  `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Priority    string    `json:"priority"`
+	DueDate     *string   `json:"dueDate"`
+	AssigneeID  *int64    `json:"assigneeId"`
+	Rank        float64   `json:"rank"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// TemplateID and OccurrenceDate are set on tasks generated by a
+	// recurring task template
+	TemplateID     *int64  `json:"templateId,omitempty"`
+	OccurrenceDate *string `json:"occurrenceDate,omitempty"`
+}
+
+func taskFromDatabase(task database.Task) Task {
+	result := Task{
+		ID:          task.ID,
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		Priority:    task.Priority,
+		Rank:        task.Rank,
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+	}
+	if task.DueDate != nil {
+		due := task.DueDate.Format(database.TaskDueDateFormat)
+		result.DueDate = &due
+	}
+	if task.AssigneeID != 0 {
+		assignee := task.AssigneeID
+		result.AssigneeID = &assignee
+	}
+	if task.TemplateID != 0 {
+		template := task.TemplateID
+		result.TemplateID = &template
+	}
+	if task.OccurrenceDate != nil {
+		occurrence := task.OccurrenceDate.Format(database.TaskDueDateFormat)
+		result.OccurrenceDate = &occurrence
+	}
+	return result
+}
+
+// toDatabase converts a task from a request body. An empty status or
+// priority means the default.
+func (t Task) toDatabase() (database.Task, error) {
+	task := database.Task{
+		ID:          t.ID,
+		Title:       t.Title,
+		Description: t.Description,
+		Status:      t.Status,
+		Priority:    t.Priority,
+	}
+	if task.Status == "" {
+		task.Status = database.TaskTodo
+	}
+	if task.Priority == "" {
+		task.Priority = database.TaskPriorityMedium
+	}
+	if t.DueDate != nil && *t.DueDate != "" {
+		due, err := ParseTaskDate(*t.DueDate)
+		if err != nil {
+			return database.Task{}, fmt.Errorf("dueDate: %v", err)
+		}
+		task.DueDate = &due
+	}
+	if t.AssigneeID != nil {
+		task.AssigneeID = *t.AssigneeID
+	}
+	return task, nil
+}
+
+// TaskPage is one page of the task list.
+type TaskPage struct {
+	Tasks  []Task `json:"tasks"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// apiError is the body of every error response from the JSON API.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.DualLog.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Status: status, Message: message}})
+}
+
+// requireAPIUser is RequireAuth for the JSON API: anonymous requests get a
+// 401 JSON error instead of reaching next.
+func requireAPIUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := RequireUser(r.Context()); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAPIPermission is RequirePermissionHandler for the JSON API:
+// requests from anonymous users get a 401 JSON error, and from users whose
+// role lacks permission a 403, instead of reaching next.
+func requireAPIPermission(permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := RequirePermission(r.Context(), permission); err != nil {
+			if err == ErrUnauthenticated {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RegisterTaskAPI mounts the tasks resource on a versioned API router, such
+// as /api/v1.
+func RegisterTaskAPI(api *mux.Router) {
+	api.HandleFunc("/tasks", requireAPIUser(GetTasksHandler)).Methods("GET")
+	api.HandleFunc("/tasks", requireAPIPermission(PermWriteTasks, CreateTaskHandler)).Methods("POST")
+	api.HandleFunc("/tasks/{id:[0-9]+}", requireAPIUser(ReadTaskHandler)).Methods("GET")
+	api.HandleFunc("/tasks/{id:[0-9]+}", requireAPIPermission(PermWriteTasks, UpdateTaskHandler)).Methods("PUT")
+	api.HandleFunc("/tasks/{id:[0-9]+}", requireAPIPermission(PermWriteTasks, DeleteTaskHandler)).Methods("DELETE")
+	api.HandleFunc("/tasks/{id:[0-9]+}/move", requireAPIPermission(PermWriteTasks, MoveTaskHandler)).Methods("POST")
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// decodeTask reads a task from a JSON request body.
+func decodeTask(r *http.Request) (Task, error) {
+	var task Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		return Task{}, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return task, nil
+}
+
+// ValidateTask checks a task from the REST or GraphQL API: its title, that
+// its status and priority are known, and that its assignee exists.
+func ValidateTask(task database.Task) error {
+	if err := ValidateTaskTitle(task.Title); err != nil {
+		return err
+	}
+	if !containsString(database.TaskStatuses, task.Status) {
+		return fmt.Errorf("status must be one of %s", strings.Join(database.TaskStatuses, ", "))
+	}
+	if !containsString(database.TaskPriorities, task.Priority) {
+		return fmt.Errorf("priority must be one of %s", strings.Join(database.TaskPriorities, ", "))
+	}
+	if task.AssigneeID != 0 {
+		if _, err := database.GetUserByID(task.AssigneeID); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("assignee %d not found", task.AssigneeID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTaskTitle checks a task title from the REST or GraphQL API.
+func ValidateTaskTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if len(title) > maxTaskTitleLength {
+		return fmt.Errorf("title is longer than %d characters", maxTaskTitleLength)
+	}
+	return nil
+}
+
+func taskID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid task ID")
+	}
+	return id, nil
+}
+
+// ParseTaskDate parses a YYYY-MM-DD date, such as a due date.
+func ParseTaskDate(value string) (time.Time, error) {
+	date, err := time.Parse(database.TaskDueDateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD date", value)
+	}
+	return date, nil
+}
+
+// ParseTaskTime parses an RFC 3339 timestamp, or a YYYY-MM-DD date meaning
+// midnight UTC.
+func ParseTaskTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(database.TaskDueDateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or YYYY-MM-DD date", value)
+	}
+	return t, nil
+}
+
+// parseTaskFilter reads the task list filters from a query string:
+//
+//	q                            text in the title or description
+//	status, priority             exact matches
+//	assignee                     a user ID, or "none" for unassigned tasks
+//	due_after, due_before        YYYY-MM-DD dates
+//	created_after, created_before,
+//	updated_after, updated_before  RFC 3339 times or YYYY-MM-DD dates
+//	sort                         a database.TaskSort order, prefixed with "-"
+//	                             to reverse it
+//	limit, offset                the page
+//
+// After bounds are inclusive and before bounds exclusive.
+func parseTaskFilter(query url.Values) (database.TaskFilter, error) {
+	filter := database.TaskFilter{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Status:   query.Get("status"),
+		Priority: query.Get("priority"),
+		Limit:    defaultTaskPageSize,
+	}
+	if filter.Status != "" && !containsString(database.TaskStatuses, filter.Status) {
+		return filter, fmt.Errorf("status must be one of %s", strings.Join(database.TaskStatuses, ", "))
+	}
+	if filter.Priority != "" && !containsString(database.TaskPriorities, filter.Priority) {
+		return filter, fmt.Errorf("priority must be one of %s", strings.Join(database.TaskPriorities, ", "))
+	}
+
+	switch value := query.Get("assignee"); value {
+	case "":
+	case "none":
+		filter.Unassigned = true
+	default:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("assignee must be a user ID or none")
+		}
+		filter.AssigneeID = id
+	}
+
+	for _, param := range []struct {
+		name  string
+		bound **time.Time
+		parse func(string) (time.Time, error)
+	}{
+		{"due_after", &filter.DueAfter, ParseTaskDate},
+		{"due_before", &filter.DueBefore, ParseTaskDate},
+		{"created_after", &filter.CreatedAfter, ParseTaskTime},
+		{"created_before", &filter.CreatedBefore, ParseTaskTime},
+		{"updated_after", &filter.UpdatedAfter, ParseTaskTime},
+		{"updated_before", &filter.UpdatedBefore, ParseTaskTime},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := param.parse(value)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %v", param.name, err)
+		}
+		*param.bound = &t
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		filter.Descending = strings.HasPrefix(sort, "-")
+		filter.Sort = strings.TrimPrefix(sort, "-")
+		if !database.ValidTaskSort(filter.Sort) {
+			return filter, fmt.Errorf("cannot sort tasks by %q", filter.Sort)
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxTaskPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", MaxTaskPageSize)
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
+
+// GetTasksHandler lists a page of tasks, filtered and sorted by the query
+// parameters described at parseTaskFilter.
+func GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("Starting the GetTasksHandler function...")
+	defer logger.DualLog.Println("Exiting the GetTasksHandler function.")
+
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasks, total, err := database.ListTasks(filter)
+	if err != nil {
+		logger.DualLog.Printf("Error listing tasks: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error listing tasks")
+		return
+	}
+
+	page := TaskPage{Tasks: make([]Task, 0, len(tasks)), Total: total, Limit: filter.Limit, Offset: filter.Offset}
+	for _, task := range tasks {
+		page.Tasks = append(page.Tasks, taskFromDatabase(task))
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("Starting CreateTaskHandler function...")
 
-	var task database.Task
-	err := json.NewDecoder(r.Body).Decode(&task)
+	task, err := decodeTask(r)
 	if err != nil {
 		logger.DualLog.Printf("Error decoding JSON request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	record, err := task.toDatabase()
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := ValidateTask(record); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	id, err := database.CreateTask(task.Title, task.Description)
+	id, err := database.CreateTaskRecord(record)
 	if err != nil {
 		logger.DualLog.Printf("Error creating task: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Error creating task")
 		return
 	}
 
-	task.ID = id
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(task)
+	created, err := database.ReadTask(int(id))
+	if err != nil {
+		logger.DualLog.Printf("Error reading created task: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Error creating task")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), id))
+	writeJSON(w, http.StatusCreated, taskFromDatabase(created))
 
 	logger.DualLog.Println("CreateTaskHandler function completed successfully.")
 }
@@ -46,73 +417,92 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 func ReadTaskHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("Starting ReadTaskHandler function...")
 
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := taskID(r)
 	if err != nil {
-		logger.DualLog.Printf("Error converting ID to integer: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	task, err := database.ReadTask(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			logger.DualLog.Println("Task not found.")
-			http.Error(w, "Task not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Task not found")
 		} else {
 			logger.DualLog.Printf("Error reading task: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, "Error reading task")
 		}
 		return
 	}
 
-	json.NewEncoder(w).Encode(task)
+	writeJSON(w, http.StatusOK, taskFromDatabase(task))
 
 	logger.DualLog.Println("ReadTaskHandler function completed successfully.")
 }
 
-func UpdateTaskHandler(w http.ResponseWriter, req *http.Request) {
-	log.Println("Starting UpdateTaskHandler function...")
+// UpdateTaskHandler replaces a task. Like a new task, one sent without a
+// status or priority gets the defaults, and one without a due date or
+// assignee has them cleared.
+func UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	logger.DualLog.Println("Starting UpdateTaskHandler function...")
 
-	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	id, err := taskID(r)
 	if err != nil {
-		log.Printf("Error converting ID to integer: %v", err)
-		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var task Task
-	err = json.NewDecoder(req.Body).Decode(&task)
+	task, err := decodeTask(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if task.ID != 0 && task.ID != int64(id) {
+		writeJSONError(w, http.StatusBadRequest, "task ID in the body does not match the URL")
+		return
+	}
+	task.ID = int64(id)
+	record, err := task.toDatabase()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := ValidateTask(record); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	err = database.UpdateTask(database.DB, id, task.Title, task.Description)
+	err = database.UpdateTaskRecord(record)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			writeJSONError(w, http.StatusNotFound, "Task not found")
+		} else {
+			logger.DualLog.Printf("Error updating task %d: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "Error updating task")
+		}
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-	log.Println("UpdateTaskHandler function completed successfully.")
+	logger.DualLog.Println("UpdateTaskHandler function completed successfully.")
 }
 
 func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	logger.DualLog.Println("Starting DeleteTaskHandler function...")
 
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := taskID(r)
 	if err != nil {
-		logger.DualLog.Printf("Error converting ID to integer: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	err = database.DeleteTask(database.DB, id)
 	if err != nil {
-		logger.DualLog.Printf("Error deleting task with ID %d: %v", id, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			writeJSONError(w, http.StatusNotFound, "Task not found")
+		} else {
+			logger.DualLog.Printf("Error deleting task with ID %d: %v", id, err)
+			writeJSONError(w, http.StatusInternalServerError, "Error deleting task")
+		}
 		return
 	}
 
@@ -7,21 +7,72 @@ import (
 	"github.com/rmacdiarmid/gptback/pkg/database"
 )
 
+// taskSorts are the orders offered by the task list page, with their labels.
+var taskSorts = []struct{ Value, Label string }{
+	{database.TaskSortID, "ID"},
+	{database.TaskSortTitle, "Title"},
+	{database.TaskSortStatus, "Status"},
+	{database.TaskSortPriority, "Priority"},
+	{database.TaskSortDueDate, "Due date"},
+	{database.TaskSortCreatedAt, "Created"},
+	{database.TaskSortUpdatedAt, "Updated"},
+	{database.TaskSortRank, "Board order"},
+}
+
+// TaskListHandler renders the first page of the task list, filtered by the
+// same query parameters as the tasks API. The page's script takes over from
+// there.
 func TaskListHandler(w http.ResponseWriter, r *http.Request) {
 	// Log message for starting TaskListHandler function
 	logger.DualLog.Println("Starting TaskListHandler function...")
 	defer logger.DualLog.Println("All tasks read successfully.")
 
-	// Get all tasks from the database
-	tasks, err := database.ReadAllTasks()
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, total, err := database.ListTasks(filter)
+	if err != nil {
+		logger.DualLog.Printf("Error listing tasks: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	users, err := database.GetUsers()
 	if err != nil {
-		logger.DualLog.Printf("Error reading all tasks: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	assignees := make(map[int64]string, len(users))
+	for _, user := range users {
+		assignees[user.UserId] = user.DisplayName()
+	}
+
+	activity, err := database.GetActivity(recentActivitySize, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The page's script follows the activity stream on from the newest
+	// entry shown
+	var activitySince int64
+	if len(activity) > 0 {
+		activitySince = activity[0].ID
+	}
 
 	data := map[string]interface{}{
-		"Tasks": tasks,
+		"Activity":      activity,
+		"ActivitySince": activitySince,
+		"Tasks":         tasks,
+		"Total":         total,
+		"Query":         r.URL.Query(),
+		"Users":         users,
+		"Assignees":     assignees,
+		"Statuses":      database.TaskStatuses,
+		"Priorities":    database.TaskPriorities,
+		"Sorts":         taskSorts,
 	}
 
 	RenderTemplateWithData(w, "base.gohtml", "taskListContent", data)
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/config"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+)
+
+// RestoreArticle takes an article out of the trash on behalf of the user in
+// ctx, who must be allowed to edit it.
+func RestoreArticle(ctx context.Context, id int64) (database.Article, error) {
+	article, err := database.GetDeletedArticle(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return database.Article{}, fmt.Errorf("article %d is not in the trash", id)
+		}
+		return database.Article{}, err
+	}
+	if err := AuthorizeArticleEdit(ctx, article); err != nil {
+		return database.Article{}, err
+	}
+
+	if err := database.RestoreArticle(id); err != nil {
+		return database.Article{}, err
+	}
+	return database.ReadArticle(id)
+}
+
+// RestoreTask takes a task out of the trash.
+func RestoreTask(id int64) (database.Task, error) {
+	if err := database.RestoreTask(id); err != nil {
+		if err == sql.ErrNoRows {
+			return database.Task{}, fmt.Errorf("task %d is not in the trash", id)
+		}
+		return database.Task{}, err
+	}
+	return database.ReadTask(int(id))
+}
+
+// StartTrashPurger permanently deletes articles and tasks once they have
+// been in the trash longer than the configured retention, checking every
+// PurgeInterval until ctx is cancelled.
+func StartTrashPurger(ctx context.Context, cfg config.TrashConfig) {
+	retention := time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	if retention <= 0 {
+		retention = defaultTrashRetention
+	}
+	interval := cfg.PurgeInterval
+	if interval <= 0 {
+		interval = defaultTrashPurgeInterval
+	}
+	logger.DualLog.Printf("Purging trash older than %s every %s", retention, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, _, err := database.PurgeDeleted(time.Now().Add(-retention)); err != nil {
+				logger.DualLog.Printf("Error purging trash: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
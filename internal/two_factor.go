@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/totp"
+)
+
+const (
+	// twoFactorPurpose is the audience suffix of the tokens that stand in
+	// for a session between the password and the code
+	twoFactorPurpose = "#two-factor"
+	// twoFactorTokenTTL is how long the user has to enter their code
+	twoFactorTokenTTL = 5 * time.Minute
+	// totpSkew is how many 30 second steps a code may be early or late
+	totpSkew = 1
+	// recoveryCodeCount is how many recovery codes a user is given
+	recoveryCodeCount = 10
+)
+
+// ErrInvalidTwoFactorCode is returned for a code that doesn't match, or was
+// already used.
+var ErrInvalidTwoFactorCode = errors.New("invalid authentication code")
+
+// ErrInvalidTwoFactorToken is returned when the token from the password step
+// of signing in is forged or has expired. The user has to sign in again.
+var ErrInvalidTwoFactorToken = errors.New("sign-in has expired; enter your password again")
+
+// twoFactorLimiter limits guesses at a user's codes
+var twoFactorLimiter = NewRateLimiter(5, 5*time.Minute)
+
+// TwoFactorEnrollment is what a user needs to set up an authenticator app.
+type TwoFactorEnrollment struct {
+	Secret string
+	// URI is the otpauth:// URI to show as a QR code
+	URI string
+}
+
+// TwoFactorEnabled reports whether the user signs in with a code as well as
+// their password.
+func TwoFactorEnabled(userID int64) (bool, error) {
+	tf, err := database.GetTwoFactor(userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && tf.ConfirmedAt != nil, err
+}
+
+// EnrollTwoFactor starts setting up two-factor authentication for the
+// signed-in user with a new secret. It takes effect once ConfirmTwoFactor
+// is given a code from the authenticator app.
+func EnrollTwoFactor(ctx context.Context) (TwoFactorEnrollment, error) {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	err = database.SetPendingTwoFactor(user.UserId, secret, time.Now())
+	if err == sql.ErrNoRows {
+		return TwoFactorEnrollment{}, fmt.Errorf("two-factor authentication is already on")
+	}
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	return TwoFactorEnrollment{Secret: secret, URI: totp.URI(authConfig.Issuer, user.Email, secret)}, nil
+}
+
+// ConfirmTwoFactor turns on two-factor authentication for the signed-in user
+// once they enter a code from their newly set up app. It returns their
+// recovery codes, which are shown this once.
+func ConfirmTwoFactor(ctx context.Context, code string) ([]string, error) {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := database.GetTwoFactor(user.UserId)
+	if err == sql.ErrNoRows || (err == nil && tf.ConfirmedAt != nil) {
+		return nil, fmt.Errorf("there is no two-factor enrolment to confirm")
+	}
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := totp.Validate(tf.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	if err := database.ConfirmTwoFactor(user.UserId, counter, hashes, time.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code like abcd-efgh-ijkl-mnop. It has 80 random
+// bits, so it is stored with the fast hash like other secret tokens.
+func newRecoveryCode() (string, error) {
+	random := make([]byte, 10)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(random))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// normalizeRecoveryCode forgives case, spaces and dashes in a typed code.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// checkTwoFactorCode checks a code from the user's app, or one of their
+// recovery codes, which then can't be used again.
+func checkTwoFactorCode(userID int64, code string) error {
+	if !twoFactorLimiter.Allow(fmt.Sprint(userID)) {
+		return fmt.Errorf("too many attempts; try again in a few minutes")
+	}
+	tf, err := database.GetTwoFactor(userID)
+	if err == sql.ErrNoRows || (err == nil && tf.ConfirmedAt == nil) {
+		return ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+	if counter, ok := totp.Validate(tf.Secret, code, time.Now(), totpSkew); ok {
+		err = database.UseTOTPCounter(userID, counter)
+	} else {
+		err = database.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)), time.Now())
+	}
+	if err == sql.ErrNoRows {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+// DisableTwoFactor turns off two-factor authentication for the signed-in
+// user, who has to enter a code to show it is them.
+func DisableTwoFactor(ctx context.Context, code string) error {
+	user, err := RequireSessionUser(ctx)
+	if err != nil {
+		return err
+	}
+	enabled, err := TwoFactorEnabled(user.UserId)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("two-factor authentication is not on")
+	}
+	if err := checkTwoFactorCode(user.UserId, code); err != nil {
+		return err
+	}
+	return database.DeleteTwoFactor(user.UserId)
+}
+
+// ResetTwoFactor turns off two-factor authentication for a user who has
+// lost their app and recovery codes, on behalf of the admin in ctx. The
+// user's sessions are ended, so they sign in again with just their
+// password.
+func ResetTwoFactor(ctx context.Context, userID int64) error {
+	admin, err := RequirePermission(ctx, PermManageUsers)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteTwoFactor(userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user %d doesn't have two-factor authentication", userID)
+	}
+	if err != nil {
+		return err
+	}
+	logger.DualLog.Printf("User %d reset two-factor authentication of user %d", admin.UserId, userID)
+	if _, err := database.RevokeUserSessions(userID); err != nil {
+		logger.DualLog.Printf("Error ending sessions of user %d: %v", userID, err)
+	}
+	return nil
+}
+
+// issueTwoFactorToken returns the token a user with two-factor
+// authentication gets for their password, to exchange with a code for a
+// session.
+func issueTwoFactorToken(user database.User) (string, error) {
+	if authKeys == nil {
+		return "", errNoKeys
+	}
+	return authKeys.Sign(jwt.MapClaims{
+		"userId": user.UserId,
+		"iss":    authConfig.Issuer,
+		"aud":    authConfig.Audience + twoFactorPurpose,
+		"exp":    time.Now().Add(twoFactorTokenTTL).Unix(),
+	})
+}
+
+// CompleteTwoFactorLogin finishes signing in a user with two-factor
+// authentication: the token LoginUser gave for their password and a code
+// from their app or a recovery code start their session.
+func CompleteTwoFactorLogin(ctx context.Context, token, code string) (TokenPair, error) {
+	if authKeys == nil {
+		return TokenPair{}, errNoKeys
+	}
+	parsed, err := jwt.Parse(token, authKeys.Keyfunc)
+	if err != nil {
+		return TokenPair{}, ErrInvalidTwoFactorToken
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid || !claims.VerifyExpiresAt(time.Now().Unix(), true) ||
+		!claims.VerifyIssuer(authConfig.Issuer, true) || !hasAudience(claims, authConfig.Audience+twoFactorPurpose) {
+		return TokenPair{}, ErrInvalidTwoFactorToken
+	}
+	userID, _ := claims["userId"].(float64)
+	user, err := database.GetUserByID(int64(userID))
+	if err != nil {
+		return TokenPair{}, ErrInvalidTwoFactorToken
+	}
+
+	attempt := database.LoginAttempt{Email: user.Email, UserID: user.UserId, IPAddress: ClientIPFromContext(ctx), UserAgent: UserAgentFromContext(ctx)}
+	if err := checkTwoFactorCode(user.UserId, code); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			recordLoginAttempt(attempt, database.LoginBadCode)
+		}
+		return TokenPair{}, err
+	}
+	tokens, err := StartSession(ctx, user)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	recordLoginAttempt(attempt, database.LoginSucceeded)
+	return tokens, nil
+}
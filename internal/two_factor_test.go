@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTwoFactor(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	userID, err := database.CreateUser(database.User{Email: "two-factor@example.com", PasswordHash: string(hash), RoleId: database.RoleEditor})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user := database.User{UserId: userID, Email: "two-factor@example.com"}
+	ctx := WithUser(context.Background(), user)
+	login := func() TokenPair {
+		tokens, err := LoginUser(context.Background(), map[string]interface{}{"email": "two-factor@example.com", "password": "password"})
+		if err != nil {
+			t.Fatalf("LoginUser returned error: %v", err)
+		}
+		return tokens
+	}
+	code := func(secret string, step int64) string {
+		c, _ := totp.Code(secret, totp.Counter(time.Now())+step)
+		return c
+	}
+
+	enrollment, err := EnrollTwoFactor(ctx)
+	if err != nil {
+		t.Fatalf("EnrollTwoFactor returned error: %v", err)
+	}
+	if enabled, _ := TwoFactorEnabled(userID); enabled {
+		t.Errorf("Two-factor authentication was on before being confirmed")
+	}
+	if tokens := login(); tokens.AccessToken == "" {
+		t.Errorf("An unconfirmed enrolment asked for a code at sign-in")
+	}
+	if _, err := ConfirmTwoFactor(ctx, "000000"); err != ErrInvalidTwoFactorCode {
+		t.Errorf("A wrong confirmation code returned %v", err)
+	}
+	recoveryCodes, err := ConfirmTwoFactor(ctx, code(enrollment.Secret, -1))
+	if err != nil || len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("ConfirmTwoFactor returned %d codes, %v", len(recoveryCodes), err)
+	}
+	if _, err := EnrollTwoFactor(ctx); err == nil {
+		t.Errorf("Enrolling again replaced a confirmed secret")
+	}
+
+	// Signing in now takes two steps
+	partial := login()
+	if partial.AccessToken != "" || partial.TwoFactorToken == "" {
+		t.Fatalf("LoginUser should only return a two-factor token: %+v", partial)
+	}
+	if _, err := ParseToken(partial.TwoFactorToken); err == nil {
+		t.Errorf("A two-factor token was accepted as an access token")
+	}
+	if _, err := CompleteTwoFactorLogin(context.Background(), "made-up", code(enrollment.Secret, 0)); err != ErrInvalidTwoFactorToken {
+		t.Errorf("A made-up two-factor token returned %v", err)
+	}
+	current := code(enrollment.Secret, 0)
+	tokens, err := CompleteTwoFactorLogin(context.Background(), partial.TwoFactorToken, current)
+	if err != nil || tokens.AccessToken == "" {
+		t.Fatalf("CompleteTwoFactorLogin returned %v", err)
+	}
+	if _, err := CompleteTwoFactorLogin(context.Background(), partial.TwoFactorToken, current); err != ErrInvalidTwoFactorCode {
+		t.Errorf("A code was accepted twice: %v", err)
+	}
+
+	// Each recovery code works once, however it is typed
+	spaced := "  " + recoveryCodes[0][:9] + " " + recoveryCodes[0][10:] + " "
+	if _, err := CompleteTwoFactorLogin(context.Background(), partial.TwoFactorToken, spaced); err != nil {
+		t.Errorf("A recovery code was rejected: %v", err)
+	}
+	if _, err := CompleteTwoFactorLogin(context.Background(), partial.TwoFactorToken, recoveryCodes[0]); err != ErrInvalidTwoFactorCode {
+		t.Errorf("A recovery code was accepted twice: %v", err)
+	}
+	if n, _ := database.CountRecoveryCodes(userID); n != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes are left, want %d", n, recoveryCodeCount-1)
+	}
+
+	// Only admins can reset another user's two-factor authentication
+	if err := ResetTwoFactor(ctx, userID); err != ErrForbidden {
+		t.Errorf("An editor resetting two-factor authentication returned %v", err)
+	}
+	admin := createUserWithRole(t, "two-factor-admin@example.com", database.RoleAdmin)
+	if err := ResetTwoFactor(WithUser(context.Background(), admin), userID); err != nil {
+		t.Fatalf("ResetTwoFactor returned error: %v", err)
+	}
+	if _, err := RefreshSession(context.Background(), tokens.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("Sessions should end when two-factor authentication is reset: %v", err)
+	}
+	if tokens := login(); tokens.AccessToken == "" {
+		t.Errorf("A code was still asked for after a reset")
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -22,6 +25,10 @@ import (
 
 var templates *template.Template
 
+// shutdownTimeout is how long the server waits for open requests when it's
+// interrupted
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// Load configuration from the config file
 	cfg, err := config.LoadConfig()
@@ -62,7 +69,7 @@ func main() {
 	corsMiddleware := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", internal.APIKeyHeader}),
 	)
 	//Initiate GraphQl
 	graphqlschema.InitSchema()
@@ -110,6 +117,30 @@ func main() {
 	}
 	logger.DualLog.Println("Environmental variables loaded successfully")
 
+	if err := internal.ConfigureAuth(cfg.JWT); err != nil {
+		logger.DualLog.Fatalf("Failed to configure access tokens: %v", err)
+	}
+	if err := internal.ConfigureMail(cfg.Mail); err != nil {
+		logger.DualLog.Fatalf("Failed to configure email: %v", err)
+	}
+	internal.ConfigureAccounts(cfg.Accounts)
+	if err := internal.BootstrapAdmin(cfg.Accounts.AdminEmail); err != nil {
+		logger.DualLog.Fatalf("Failed to make the admin email's account an admin: %v", err)
+	}
+	if err := internal.ConfigureOIDC(cfg.OIDC); err != nil {
+		logger.DualLog.Fatalf("Failed to configure identity providers: %v", err)
+	}
+	internal.ConfigureComments(cfg.Comments)
+	internal.ConfigureRatings(cfg.Ratings)
+	internal.ConfigureBatches(cfg.Batch)
+	// Background jobs stop when the server is interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	internal.StartTrashPurger(ctx, cfg.Trash)
+	internal.StartViewRecorder(ctx, cfg.Analytics)
+	internal.StartTaskScheduler(ctx, cfg.Recurrence)
+
 	// Add the logger usage that was removed from the handlers package
 	logger.DualLog.Println("Internal handlers package initialized")
 
@@ -122,21 +153,41 @@ func main() {
 
 	// Create the router and add the routes
 	r := mux.NewRouter()
+	r.Use(internal.ClientIPMiddleware)
+	r.Use(internal.AuthMiddleware)
 
 	// GraphQL Router
-	r.Handle("/graphql", h)
+	r.Handle("/graphql", graphqlschema.RejectMutationsOnGET(h))
+
+	// JSON API
+	internal.RegisterTaskAPI(r.PathPrefix("/api/v1").Subrouter())
 
 	// Route handlers
 	r.HandleFunc("/", internal.IndexHandler)
+	r.HandleFunc("/articles/{slug}", internal.ArticleHandler)
+	r.HandleFunc("/articles/{slug}/comments", internal.PostCommentHandler).Methods("POST")
+	r.HandleFunc("/articles/{slug}/rating", internal.RateArticleHandler).Methods("POST")
+	r.HandleFunc("/authors/{id}", internal.AuthorHandler)
+	r.HandleFunc("/tags/{tag}", internal.TagHandler)
+	r.HandleFunc("/admin/comments", internal.CommentQueueHandler)
+	r.HandleFunc("/admin/comments/{id}", internal.ModerateCommentHandler).Methods("POST")
+	r.HandleFunc("/admin/ratings", internal.RatingReportHandler)
 	r.HandleFunc("/about", internal.AboutHandler)
 	r.HandleFunc("/contact", internal.ContactHandler)
-	//r.HandleFunc("/activity", handlers.ActivityHandler)
-	r.HandleFunc("/task_list", internal.TaskListHandler)
+	r.Handle("/activity", internal.RequireAuth(http.HandlerFunc(internal.ActivityHandler)))
+	r.Handle("/activity/stream", internal.RequireAuth(http.HandlerFunc(internal.ActivityStreamHandler)))
+	r.Handle("/task_list", internal.RequireAuth(http.HandlerFunc(internal.TaskListHandler)))
+	r.Handle("/task_board", internal.RequireAuth(http.HandlerFunc(internal.TaskBoardHandler)))
+	r.HandleFunc("/.well-known/jwks.json", internal.JWKSHandler).Methods("GET")
+	r.HandleFunc("/verify-email", internal.VerifyEmailHandler).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}", internal.OIDCLoginHandler).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", internal.OIDCCallbackHandler).Methods("GET")
+	r.HandleFunc(internal.CalendarFeedPath, internal.CalendarFeedHandler).Methods("GET", "HEAD")
 	r.HandleFunc("/success", internal.SuccessHandler)
 
 	// New routes for generating and accepting articles
-	r.HandleFunc("/generate-article", internal.GenerateArticleHandler)
-	r.HandleFunc("/accept-article", internal.AcceptArticleHandler)
+	r.Handle("/generate-article", internal.RequirePermissionHandler(internal.PermCreateArticles, http.HandlerFunc(internal.GenerateArticleHandler)))
+	r.Handle("/accept-article", internal.RequirePermissionHandler(internal.PermCreateArticles, http.HandlerFunc(internal.AcceptArticleHandler)))
 	r.HandleFunc("/article-generator", internal.ArticleGeneratorHandler)
 
 	r.NotFoundHandler = http.HandlerFunc(internal.NotFoundHandler)
@@ -148,7 +199,28 @@ func main() {
 
 	// Start the server
 	logger.DualLog.Println("Starting server on :8080...")
-	if err := http.ListenAndServe(":8080", corsMiddleware(r)); err != nil {
+	server := &http.Server{Addr: ":8080", Handler: corsMiddleware(r)}
+	// ListenAndServe returns as soon as Shutdown starts, so wait for the
+	// requests in flight before writing out what they recorded. Open
+	// activity streams only get shutdownTimeout to finish.
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		logger.DualLog.Println("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.DualLog.Printf("Error shutting down server: %s", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.DualLog.Fatalf("Error starting server: %s", err)
 	}
+	<-shutdown
+
+	// Write the views recorded since the last flush
+	internal.FlushArticleViews()
+	// Finish sending the email requests started
+	internal.WaitForBackgroundMail()
 }
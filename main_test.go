@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/handler"
+	"github.com/rmacdiarmid/gptback/config"
 	"github.com/rmacdiarmid/gptback/graphqlschema"
+	"github.com/rmacdiarmid/gptback/internal"
 	"github.com/rmacdiarmid/gptback/logger"
 	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/rmacdiarmid/gptback/pkg/mail"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestMain(m *testing.M) {
@@ -21,6 +34,11 @@ func TestMain(m *testing.M) {
 	database.InitDB(":memory:")
 
 	graphqlschema.InitSchema()
+
+	// Sign access tokens with a test key
+	if err := internal.ConfigureAuth(config.JWTConfig{Phrase: "test secret"}); err != nil {
+		logger.DualLog.Fatalf("Failed to configure auth: %v", err)
+	}
 	os.Exit(m.Run())
 }
 
@@ -170,7 +188,7 @@ func TestGraphQLCreateArticleMutation(t *testing.T) {
             }
         }
     `
-	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation}
+	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: signedIn(t)}
 	result := graphql.Do(params)
 	if len(result.Errors) > 0 {
 		logger.DualLog.Printf("Failed to execute GraphQL mutation: %v", result.Errors)
@@ -211,7 +229,7 @@ func TestGraphQLCreateArticleMutation(t *testing.T) {
 }
 
 func TestGraphQLUpdateArticleMutation(t *testing.T) {
-	articleID, err := database.CreateArticle("Test title", "Test image", "Test preview", "Test text")
+	articleID, err := createMemberArticle(t, "Test title", "Test image", "Test preview", "Test text")
 	assert.Nil(t, err, "Failed to create test article")
 	t.Cleanup(func() {
 		_ = database.DeleteArticle(int64(articleID)) // Convert the int to int64
@@ -229,7 +247,7 @@ func TestGraphQLUpdateArticleMutation(t *testing.T) {
 		}
 	`, articleID)
 
-	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation}
+	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: signedIn(t)}
 	result := graphql.Do(params)
 
 	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
@@ -262,7 +280,7 @@ func TestGraphQLUpdateArticleMutation(t *testing.T) {
 }
 
 func TestGraphQLDeleteArticleMutation(t *testing.T) {
-	articleID, err := database.CreateArticle("Test title", "Test image", "Test preview", "Test text")
+	articleID, err := createMemberArticle(t, "Test title", "Test image", "Test preview", "Test text")
 	assert.Nil(t, err, "Failed to create test article")
 
 	mutation := fmt.Sprintf(`
@@ -271,8 +289,11 @@ func TestGraphQLDeleteArticleMutation(t *testing.T) {
 		}
 	`, articleID)
 
-	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation}
-	result := graphql.Do(params)
+	result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation})
+	assert.NotEmpty(t, result.Errors, "Deleting an article should require signing in")
+
+	params := graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: signedIn(t)}
+	result = graphql.Do(params)
 
 	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
 
@@ -282,3 +303,605 @@ func TestGraphQLDeleteArticleMutation(t *testing.T) {
 
 	assert.Equal(t, expected, result.Data, "GraphQL mutation result doesn't match expected output")
 }
+
+func TestGraphQLMutationOverGET(t *testing.T) {
+	articleID, err := createMemberArticle(t, "Linked title", "Linked image", "Linked preview", "Linked text")
+	assert.Nil(t, err, "Failed to create test article")
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(articleID)
+	})
+	member, _ := internal.UserFromContext(signedIn(t))
+	tokens, err := internal.StartSession(context.Background(), member)
+	assert.Nil(t, err, "Failed to start session")
+
+	// The member follows links from another site while signed in
+	h := internal.AuthMiddleware(graphqlschema.RejectMutationsOnGET(handler.New(&handler.Config{Schema: &graphqlschema.Schema})))
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(query), nil)
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: tokens.AccessToken})
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(fmt.Sprintf(`mutation { deleteArticle(id: %d) }`, articleID))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, "A mutation in a link should be rejected")
+	_, err = database.ReadArticle(articleID)
+	assert.Nil(t, err, "The article should not have been deleted")
+
+	rr = get(fmt.Sprintf(`query Read { article(id: %d) { title } } mutation Delete { deleteArticle(id: %d) }`, articleID, articleID))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, "A mutation next to a query should be rejected too")
+
+	rr = get(fmt.Sprintf(`{ article(id: %d) { title } }`, articleID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Linked title", "Queries should still work over GET")
+}
+
+func TestGraphQLArticleAuthorship(t *testing.T) {
+	authorID, err := database.CreateUser(database.User{Email: "author@example.com", PasswordHash: "hash", RoleId: database.RoleAuthor})
+	assert.Nil(t, err, "Failed to create author")
+	otherID, err := database.CreateUser(database.User{Email: "other@example.com", PasswordHash: "hash", RoleId: database.RoleAuthor})
+	assert.Nil(t, err, "Failed to create other user")
+	author, _ := database.GetUserByID(authorID)
+	other, _ := database.GetUserByID(otherID)
+
+	mutation := `
+		mutation {
+			createArticle(title: "Authored Article", image: "authored.jpg", preview: "Authored preview", text: "Authored text") {
+				id
+				author {
+					id
+					displayName
+				}
+			}
+		}
+	`
+	result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: internal.WithUser(context.Background(), author)})
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+
+	created := result.Data.(map[string]interface{})["createArticle"].(map[string]interface{})
+	articleID, err := convertID(created["id"])
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int(authorID), "displayName": "author"}, created["author"], "Only the author's public details should be shown")
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(int64(articleID))
+	})
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: fmt.Sprintf(`{ article(id: %d) { author { email } } }`, articleID)})
+	assert.NotEmpty(t, result.Errors, "Authors' email addresses should not be public")
+
+	deletion := fmt.Sprintf(`mutation { deleteArticle(id: %d) }`, articleID)
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: deletion, Context: internal.WithUser(context.Background(), other)})
+	assert.NotEmpty(t, result.Errors, "Another user should not be able to delete the article")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: deletion})
+	assert.NotEmpty(t, result.Errors, "An anonymous user should not be able to delete the article")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: deletion, Context: internal.WithUser(context.Background(), author)})
+	assert.Empty(t, result.Errors, "The author should be able to delete the article")
+}
+
+func TestGraphQLRateArticle(t *testing.T) {
+	articleID, err := database.CreateArticleRecord(database.Article{Title: "Rated Article", Text: "Rated text", Prompt: "Write about rating", Model: "test-model"})
+	assert.Nil(t, err, "Failed to create article")
+	t.Cleanup(func() {
+		_ = database.DeleteArticle(articleID)
+	})
+
+	editor := createEditor(t, "rating-editor@example.com")
+	reader := database.User{UserId: editor.UserId + 1000}
+
+	rate := func(ctx context.Context, args string) *graphql.Result {
+		mutation := fmt.Sprintf(`mutation { rateArticle(articleId: %d, %s) { average count } }`, articleID, args)
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: ctx})
+	}
+
+	result := rate(internal.WithUser(context.Background(), reader), "score: 6")
+	assert.NotEmpty(t, result.Errors, "Scores above 5 should be rejected")
+
+	result = rate(internal.WithUser(context.Background(), reader), "score: 1")
+	assert.Empty(t, result.Errors)
+	result = rate(internal.WithUser(context.Background(), reader), "score: 3")
+	assert.Empty(t, result.Errors)
+	result = rate(internal.WithUser(context.Background(), editor), "thumbsUp: true")
+	assert.Empty(t, result.Errors)
+	rating := result.Data.(map[string]interface{})["rateArticle"].(map[string]interface{})
+	assert.Equal(t, 4.0, rating["average"], "Rating again should replace the reader's earlier score")
+	assert.Equal(t, 2, rating["count"])
+
+	query := `{ promptRatingReport(groupBy: PROMPT_AND_MODEL) { prompt model ratings average } }`
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: query, Context: internal.WithUser(context.Background(), reader)})
+	assert.NotEmpty(t, result.Errors, "Only editors should see the report")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: query, Context: internal.WithUser(context.Background(), editor)})
+	assert.Empty(t, result.Errors)
+	report := result.Data.(map[string]interface{})["promptRatingReport"].([]interface{})
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"prompt":  "Write about rating",
+		"model":   "test-model",
+		"ratings": 2,
+		"average": 4.0,
+	}}, report)
+}
+
+// signedIn returns a context for a signed-in author, for the mutations that
+// need one.
+func signedIn(t *testing.T) context.Context {
+	const email = "member@example.com"
+	member, err := database.GetUserByEmail(email)
+	if err != nil {
+		id, err := database.CreateUser(database.User{Email: email, PasswordHash: "hash", RoleId: database.RoleAuthor})
+		assert.Nil(t, err, "Failed to create member")
+		member = database.User{UserId: id, Email: email}
+	}
+	return internal.WithUser(context.Background(), member)
+}
+
+// createMemberArticle creates an article written by the signedIn member, so
+// they may change it.
+func createMemberArticle(t *testing.T, title, image, preview, text string) (int64, error) {
+	member, _ := internal.UserFromContext(signedIn(t))
+	return database.CreateAuthoredArticle(member.UserId, title, image, preview, text)
+}
+
+func createEditor(t *testing.T, email string) database.User {
+	editorID, err := database.CreateUser(database.User{Email: email, PasswordHash: "hash", RoleId: database.RoleEditor})
+	assert.Nil(t, err, "Failed to create editor")
+	editor, _ := database.GetUserByID(editorID)
+	return editor
+}
+
+func TestGraphQLTrash(t *testing.T) {
+	editor := createEditor(t, "trash-editor@example.com")
+	ctx := internal.WithUser(context.Background(), editor)
+
+	articleID, err := database.CreateArticle("Trashed Article", "trashed.jpg", "Trashed preview", "Trashed text")
+	assert.Nil(t, err, "Failed to create article")
+	taskID, err := database.CreateTask("Trashed Task", "Trashed description")
+	assert.Nil(t, err, "Failed to create task")
+
+	assert.Nil(t, database.DeleteArticle(articleID))
+	assert.Nil(t, database.DeleteTask(database.DB, int(taskID)))
+
+	_, err = database.ReadArticle(articleID)
+	assert.Equal(t, sql.ErrNoRows, err, "Deleted articles should not be readable")
+	_, err = database.ReadTask(int(taskID))
+	assert.Equal(t, sql.ErrNoRows, err, "Deleted tasks should not be readable")
+
+	result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `{ trash { articles { id deletedAt } tasks { id title } } }`, Context: ctx})
+	assert.Empty(t, result.Errors)
+	trash := result.Data.(map[string]interface{})["trash"].(map[string]interface{})
+	var trashed map[string]interface{}
+	for _, article := range trash["articles"].([]interface{}) {
+		if article.(map[string]interface{})["id"] == int(articleID) {
+			trashed = article.(map[string]interface{})
+		}
+	}
+	assert.NotNil(t, trashed, "The deleted article should be in the trash")
+	assert.NotNil(t, trashed["deletedAt"])
+	assert.Contains(t, trash["tasks"], map[string]interface{}{"id": int(taskID), "title": "Trashed Task"})
+
+	mutation := fmt.Sprintf(`mutation { restoreArticle(id: %d) { title deletedAt } restoreTask(id: %d) { title } }`, articleID, taskID)
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: ctx})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"restoreArticle": map[string]interface{}{"title": "Trashed Article", "deletedAt": nil},
+		"restoreTask":    map[string]interface{}{"title": "Trashed Task"},
+	}, result.Data)
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: ctx})
+	assert.NotEmpty(t, result.Errors, "Restoring an item that isn't in the trash should fail")
+
+	assert.Nil(t, database.DeleteArticle(articleID))
+	assert.Nil(t, database.DeleteTask(database.DB, int(taskID)))
+
+	articles, tasks, err := database.PurgeDeleted(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0, 0}, []int64{articles, tasks}, "Recently deleted items should be kept")
+
+	articles, tasks, err = database.PurgeDeleted(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, articles, int64(1))
+	assert.Equal(t, int64(1), tasks)
+	_, err = database.GetDeletedArticle(articleID)
+	assert.Equal(t, sql.ErrNoRows, err, "Purged articles should be gone")
+}
+
+func TestGraphQLBulkArticleMutations(t *testing.T) {
+	var ids []int64
+	for _, title := range []string{"Bulk One", "Bulk Two"} {
+		id, err := createMemberArticle(t, title, "bulk.jpg", "Bulk preview", "Bulk text")
+		assert.Nil(t, err, "Failed to create article")
+		ids = append(ids, id)
+	}
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_ = database.DeleteArticle(id)
+		}
+	})
+
+	run := func(mutation string) map[string]interface{} {
+		result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: mutation, Context: signedIn(t)})
+		assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+		return result.Data.(map[string]interface{})
+	}
+
+	data := run(fmt.Sprintf(`mutation {
+		tagArticles(ids: [%d, %d], add: ["Go", "bulk"]) { id ok }
+		publishArticles(ids: [%d, %d], published: false) { id ok }
+	}`, ids[0], ids[1], ids[0], ids[1]))
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": int(ids[0]), "ok": true},
+		map[string]interface{}{"id": int(ids[1]), "ok": true},
+	}, data["publishArticles"])
+
+	article, err := database.ReadArticle(ids[0])
+	assert.Nil(t, err)
+	assert.False(t, article.Published)
+
+	query := fmt.Sprintf(`{ article(id: %d) { id } articles { id } }`, ids[0])
+	result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: query})
+	assert.Empty(t, result.Errors)
+	assert.Nil(t, result.Data.(map[string]interface{})["article"], "Unpublished articles should be hidden from readers")
+	assert.NotContains(t, result.Data.(map[string]interface{})["articles"], map[string]interface{}{"id": int(ids[0])})
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: query, Context: signedIn(t)})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{"id": int(ids[0])}, result.Data.(map[string]interface{})["article"], "Authors should still see their unpublished articles")
+	assert.Contains(t, result.Data.(map[string]interface{})["articles"], map[string]interface{}{"id": int(ids[0])})
+	tags, err := database.GetArticleTags(ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bulk", "go"}, tags)
+
+	data = run(fmt.Sprintf(`mutation {
+		updateArticles(articles: [{id: %d, title: "Renamed"}, {id: 999999, title: "Missing"}]) { id ok error }
+	}`, ids[0]))
+	results := data["updateArticles"].([]interface{})
+	assert.Equal(t, false, results[0].(map[string]interface{})["ok"], "A failed item should roll back the whole batch")
+	assert.Equal(t, "article 999999 not found", results[1].(map[string]interface{})["error"])
+	article, _ = database.ReadArticle(ids[0])
+	assert.Equal(t, "Bulk One", article.Title)
+
+	data = run(fmt.Sprintf(`mutation {
+		updateArticles(articles: [{id: %d, title: "Renamed"}]) { ok }
+		deleteArticles(ids: [%d]) { ok }
+	}`, ids[0], ids[1]))
+	article, _ = database.ReadArticle(ids[0])
+	assert.Equal(t, "Renamed", article.Title)
+	assert.Equal(t, "Bulk text", article.Text, "Omitted fields should be left unchanged")
+	_, err = database.ReadArticle(ids[1])
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	internal.ConfigureBatches(config.BatchConfig{MaxSize: 1})
+	defer internal.ConfigureBatches(config.BatchConfig{MaxSize: 100})
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: fmt.Sprintf(`mutation { publishArticles(ids: [%d, %d]) { ok } }`, ids[0], ids[1]), Context: signedIn(t)})
+	assert.NotEmpty(t, result.Errors, "Batches over the maximum size should be rejected")
+}
+
+func TestGraphQLTasks(t *testing.T) {
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: signedIn(t)})
+	}
+
+	result := run(`mutation { createTask(title: "GraphQL task", description: "Created over GraphQL") { id title description } }`)
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	created := result.Data.(map[string]interface{})["createTask"].(map[string]interface{})
+	taskID, err := convertID(created["id"])
+	assert.Nil(t, err)
+	assert.Equal(t, "Created over GraphQL", created["description"])
+
+	result = run(`mutation { createTask(title: "  ") { id } }`)
+	assert.NotEmpty(t, result.Errors, "A blank title should be rejected")
+
+	result = run(fmt.Sprintf(`mutation { updateTask(id: %d, title: "Renamed GraphQL task") { title description } }`, taskID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"title": "Renamed GraphQL task", "description": "Created over GraphQL"}, result.Data.(map[string]interface{})["updateTask"])
+
+	result = run(`{ tasks(query: "renamed graphql") { id title } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": taskID, "title": "Renamed GraphQL task"}}, result.Data.(map[string]interface{})["tasks"])
+
+	result = run(fmt.Sprintf(`mutation { deleteTask(id: %d) }`, taskID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+
+	result = run(fmt.Sprintf(`{ task(id: %d) { id } }`, taskID))
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Nil(t, result.Data.(map[string]interface{})["task"], "Deleted tasks should not be returned")
+
+	result = run(fmt.Sprintf(`mutation { deleteTask(id: %d) }`, taskID))
+	assert.NotEmpty(t, result.Errors, "Deleting a task twice should fail")
+}
+
+func TestGraphQLTaskFields(t *testing.T) {
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: signedIn(t)})
+	}
+
+	assigneeID, err := database.CreateUser(database.User{Email: "graphql-assignee@example.com", PasswordHash: "x"})
+	assert.Nil(t, err)
+
+	result := run(fmt.Sprintf(`mutation { createTask(title: "Sprint task", status: DOING, priority: HIGH, dueDate: "2031-03-01", assigneeId: %d) { id status priority dueDate assigneeId assignee { displayName } createdAt updatedAt } }`, assigneeID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	created := result.Data.(map[string]interface{})["createTask"].(map[string]interface{})
+	taskID, err := convertID(created["id"])
+	assert.Nil(t, err)
+	assert.Equal(t, "DOING", created["status"])
+	assert.Equal(t, "HIGH", created["priority"])
+	assert.Equal(t, "2031-03-01", created["dueDate"])
+	assert.Equal(t, map[string]interface{}{"displayName": "graphql-assignee"}, created["assignee"])
+	assert.NotEmpty(t, created["createdAt"])
+
+	result = run(`mutation { createTask(title: "Sprint chore", priority: LOW) { status priority dueDate assigneeId } }`)
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"status": "TODO", "priority": "LOW", "dueDate": nil, "assigneeId": nil}, result.Data.(map[string]interface{})["createTask"])
+
+	result = run(`mutation { createTask(title: "Sprint typo", dueDate: "next week") { id } }`)
+	assert.NotEmpty(t, result.Errors, "An invalid due date should be rejected")
+
+	for _, query := range []string{`{ tasks(query: "sprint") { title } }`, fmt.Sprintf(`{ task(id: %d) { title } }`, taskID)} {
+		result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: query})
+		assert.NotEmpty(t, result.Errors, "Anonymous users should not see tasks")
+	}
+
+	result = run(`{ tasks(query: "sprint", sort: PRIORITY, descending: true) { title } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"title": "Sprint task"},
+		map[string]interface{}{"title": "Sprint chore"},
+	}, result.Data.(map[string]interface{})["tasks"])
+
+	result = run(fmt.Sprintf(`{ tasks(query: "sprint", assigneeId: %d, status: DOING, dueBefore: "2031-03-02") { title } }`, assigneeID))
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"title": "Sprint task"}}, result.Data.(map[string]interface{})["tasks"])
+
+	result = run(`{ tasks(query: "sprint", unassigned: true) { title } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"title": "Sprint chore"}}, result.Data.(map[string]interface{})["tasks"])
+
+	result = run(fmt.Sprintf(`mutation { updateTask(id: %d, status: DONE, dueDate: "", assigneeId: 0) { title status dueDate assigneeId } }`, taskID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"title": "Sprint task", "status": "DONE", "dueDate": nil, "assigneeId": nil}, result.Data.(map[string]interface{})["updateTask"])
+}
+
+func TestGraphQLMoveTask(t *testing.T) {
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: signedIn(t)})
+	}
+
+	first, err := database.CreateTask("GraphQL card 1", "")
+	assert.Nil(t, err)
+	second, err := database.CreateTask("GraphQL card 2", "")
+	assert.Nil(t, err)
+
+	result := run(fmt.Sprintf(`mutation { moveTask(id: %d, status: TODO, beforeId: %d) { status } }`, second, first))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"status": "TODO"}, result.Data.(map[string]interface{})["moveTask"])
+
+	result = run(`{ tasks(query: "graphql card", sort: RANK) { title } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"title": "GraphQL card 2"},
+		map[string]interface{}{"title": "GraphQL card 1"},
+	}, result.Data.(map[string]interface{})["tasks"])
+
+	result = run(fmt.Sprintf(`mutation { moveTask(id: %d, status: DONE, afterId: %d) { id } }`, first, second))
+	assert.NotEmpty(t, result.Errors, "A neighbour in another column should be rejected")
+
+	result = run(fmt.Sprintf(`mutation { moveTask(id: %d, status: DONE) { status } }`, first))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"status": "DONE"}, result.Data.(map[string]interface{})["moveTask"])
+}
+
+func TestGraphQLTaskTemplates(t *testing.T) {
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: signedIn(t)})
+	}
+
+	start := time.Now().UTC().Format("2006-01-02")
+	result := run(fmt.Sprintf(`mutation { createTaskTemplate(title: "Monthly prompt audit", priority: HIGH, rrule: "FREQ=MONTHLY;BYDAY=1MO", startDate: "%s") { id rrule priority } }`, start))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	created := result.Data.(map[string]interface{})["createTaskTemplate"].(map[string]interface{})
+	templateID, err := convertID(created["id"])
+	assert.Nil(t, err)
+	assert.Equal(t, "FREQ=MONTHLY;BYDAY=1MO", created["rrule"])
+	assert.Equal(t, "HIGH", created["priority"])
+
+	result = run(`{ tasks(query: "monthly prompt audit") { priority occurrenceDate template { id } } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	tasks := result.Data.(map[string]interface{})["tasks"].([]interface{})
+	if assert.Len(t, tasks, 1, "The first occurrence should be generated") {
+		task := tasks[0].(map[string]interface{})
+		assert.Equal(t, "HIGH", task["priority"])
+		assert.NotNil(t, task["occurrenceDate"])
+		assert.Equal(t, map[string]interface{}{"id": templateID}, task["template"])
+	}
+
+	result = run(fmt.Sprintf(`mutation { updateTaskTemplate(id: %d, rrule: "FREQ=YEARLY") { id } }`, templateID))
+	assert.NotEmpty(t, result.Errors, "An unsupported rule should be rejected")
+
+	result = run(fmt.Sprintf(`mutation { updateTaskTemplate(id: %d, rrule: "FREQ=WEEKLY;INTERVAL=2") { rrule } }`, templateID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"rrule": "FREQ=WEEKLY;INTERVAL=2"}, result.Data.(map[string]interface{})["updateTaskTemplate"])
+
+	result = run(fmt.Sprintf(`mutation { deleteTaskTemplate(id: %d) }`, templateID))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+
+	result = run(`{ tasks(query: "monthly prompt audit") { template { id } } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"template": nil}}, result.Data.(map[string]interface{})["tasks"], "Generated tasks should be kept")
+}
+
+func TestGraphQLActivity(t *testing.T) {
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: signedIn(t)})
+	}
+
+	id, err := createMemberArticle(t, "Activity article", "activity.jpg", "Activity preview", "Activity text")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = database.DeleteArticle(id) })
+
+	result := run(fmt.Sprintf(`mutation { publishArticles(ids: [%d], published: false) { ok } }`, id))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+
+	result = run(`{ activity(limit: 2) { id subjectType subjectId action summary } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	entries := result.Data.(map[string]interface{})["activity"].([]interface{})
+	assert.Len(t, entries, 2)
+	newest := entries[0].(map[string]interface{})
+	assert.Equal(t, "ARTICLE", newest["subjectType"])
+	assert.Equal(t, int(id), newest["subjectId"])
+	assert.Equal(t, "unpublished", newest["action"])
+	assert.Equal(t, `Article "Activity article" unpublished`, newest["summary"])
+	assert.Equal(t, `Article "Activity article" created`, entries[1].(map[string]interface{})["summary"])
+
+	// The next page starts after the last entry
+	result = run(fmt.Sprintf(`{ activity(limit: 1, after: %d) { summary } }`, newest["id"]))
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"summary": `Article "Activity article" created`}}, result.Data.(map[string]interface{})["activity"])
+
+	result = run(`{ activity(limit: 500) { id } }`)
+	assert.NotEmpty(t, result.Errors, "A limit over the maximum should be rejected")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `{ activity { id } }`})
+	assert.NotEmpty(t, result.Errors, "Anonymous users should not see the activity log")
+}
+
+func TestGraphQLCalendarFeed(t *testing.T) {
+	user := createEditor(t, "calendar-feed@example.com")
+
+	result := graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `mutation { createCalendarFeed }`})
+	assert.NotEmpty(t, result.Errors, "Creating a feed should require signing in")
+
+	ctx := internal.WithUser(context.Background(), user)
+	withKey := internal.WithAPIKey(ctx, database.APIKey{Scopes: []string{string(internal.PermWriteTasks)}})
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `mutation { createCalendarFeed }`, Context: withKey})
+	assert.NotEmpty(t, result.Errors, "An API key shouldn't create a feed")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `mutation { createCalendarFeed }`, Context: ctx})
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	path := result.Data.(map[string]interface{})["createCalendarFeed"].(string)
+	assert.True(t, strings.HasPrefix(path, "/tasks.ics?token="), path)
+
+	rr := httptest.NewRecorder()
+	internal.CalendarFeedHandler(rr, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "BEGIN:VCALENDAR\r\n")
+
+	result = graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: `mutation { revokeCalendarFeed }`, Context: ctx})
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	rr = httptest.NewRecorder()
+	internal.CalendarFeedHandler(rr, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestGraphQLRoles(t *testing.T) {
+	adminID, err := database.CreateUser(database.User{Email: "roles-admin@example.com", PasswordHash: "hash", RoleId: database.RoleAdmin})
+	assert.Nil(t, err, "Failed to create admin")
+	viewerID, err := database.CreateUser(database.User{Email: "roles-viewer@example.com", PasswordHash: "hash"})
+	assert.Nil(t, err, "Failed to create viewer")
+	admin := internal.WithUser(context.Background(), database.User{UserId: adminID, Email: "roles-admin@example.com"})
+	viewer := internal.WithUser(context.Background(), database.User{UserId: viewerID, Email: "roles-viewer@example.com"})
+	run := func(ctx context.Context, request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: ctx})
+	}
+
+	result := run(viewer, `{ me { user { email } role permissions } can(permission: "tasks:write") }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, map[string]interface{}{
+		"me":  map[string]interface{}{"user": map[string]interface{}{"email": "roles-viewer@example.com"}, "role": "VIEWER", "permissions": []interface{}{}},
+		"can": false,
+	}, result.Data)
+
+	// Viewers can't change anything, and only admins can manage logs
+	result = run(viewer, `mutation { createTask(title: "Viewer task") { id } }`)
+	assert.NotEmpty(t, result.Errors, "A viewer should not be able to create tasks")
+	result = run(signedIn(t), `mutation { deleteFrontendLog(id: 1) }`)
+	assert.NotEmpty(t, result.Errors, "An author should not be able to delete frontend logs")
+
+	// Admins hand out roles
+	promotion := fmt.Sprintf(`mutation { setUserRole(userId: %d, role: AUTHOR) { email role } }`, viewerID)
+	result = run(viewer, promotion)
+	assert.NotEmpty(t, result.Errors, "A viewer should not be able to change roles")
+	result = run(admin, promotion)
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	assert.Equal(t, map[string]interface{}{"email": "roles-viewer@example.com", "role": "AUTHOR"}, result.Data.(map[string]interface{})["setUserRole"])
+
+	result = run(viewer, `{ can(permission: "tasks:write") }`)
+	assert.Equal(t, true, result.Data.(map[string]interface{})["can"], "The new role should apply at once")
+
+	result = run(nil, `{ me { role } }`)
+	assert.Empty(t, result.Errors)
+	assert.Nil(t, result.Data.(map[string]interface{})["me"])
+}
+
+func TestGraphQLSessions(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	_, err := database.CreateUser(database.User{Email: "graphql-sessions@example.com", PasswordHash: string(hash)})
+	assert.Nil(t, err, "Failed to create user")
+	run := func(ctx context.Context, request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: ctx})
+	}
+
+	result := run(context.Background(), `mutation { login(input: {email: "graphql-sessions@example.com", password: "password"}) { accessToken refreshToken expiresAt } }`)
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	login := result.Data.(map[string]interface{})["login"].(map[string]interface{})
+
+	result = run(context.Background(), fmt.Sprintf(`mutation { refreshToken(refreshToken: %q) { accessToken refreshToken } }`, login["refreshToken"]))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	refreshed := result.Data.(map[string]interface{})["refreshToken"].(map[string]interface{})
+	assert.NotEqual(t, login["refreshToken"], refreshed["refreshToken"], "The refresh token should be rotated")
+
+	claims, err := internal.ParseToken(refreshed["accessToken"].(string))
+	assert.Nil(t, err)
+	user, _ := database.GetUserByID(claims.UserID)
+	ctx := internal.WithSession(internal.WithUser(context.Background(), user), claims.SessionID)
+
+	result = run(ctx, `{ sessions { id current } }`)
+	assert.Empty(t, result.Errors, "GraphQL query returned errors")
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": claims.SessionID, "current": true}}, result.Data.(map[string]interface{})["sessions"])
+
+	result = run(ctx, `mutation { logout }`)
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	result = run(context.Background(), fmt.Sprintf(`mutation { refreshToken(refreshToken: %q) { accessToken } }`, refreshed["refreshToken"]))
+	assert.NotEmpty(t, result.Errors, "A refresh token should stop working after logging out")
+}
+
+// sentMail keeps the email sent by a test
+type sentMail []mail.Message
+
+func (s *sentMail) Send(ctx context.Context, msg mail.Message) error {
+	*s = append(*s, msg)
+	return nil
+}
+
+func TestGraphQLPasswordReset(t *testing.T) {
+	var sent sentMail
+	internal.SetMailer(&sent)
+	defer internal.SetMailer(nil)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	_, err := database.CreateUser(database.User{Email: "graphql-reset@example.com", PasswordHash: string(hash)})
+	assert.Nil(t, err, "Failed to create user")
+	run := func(request string) *graphql.Result {
+		return graphql.Do(graphql.Params{Schema: graphqlschema.Schema, RequestString: request, Context: context.Background()})
+	}
+
+	// Known and unknown addresses get the same answer
+	for _, email := range []string{"graphql-reset@example.com", "graphql-nobody@example.com"} {
+		result := run(fmt.Sprintf(`mutation { requestPasswordReset(email: %q) }`, email))
+		assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+		assert.Equal(t, map[string]interface{}{"requestPasswordReset": true}, result.Data)
+	}
+	internal.WaitForBackgroundMail()
+	assert.Len(t, sent, 1, "Only the known address should get an email")
+	link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(sent[0].Body))
+	assert.Nil(t, err)
+
+	result := run(`mutation { resetPassword(token: "made-up", newPassword: "new password") }`)
+	assert.NotEmpty(t, result.Errors, "A made-up token was accepted")
+	result = run(fmt.Sprintf(`mutation { resetPassword(token: %q, newPassword: "new password") }`, link.Query().Get("token")))
+	assert.Empty(t, result.Errors, "GraphQL mutation returned errors")
+	result = run(`mutation { login(input: {email: "graphql-reset@example.com", password: "new password"}) { accessToken } }`)
+	assert.Empty(t, result.Errors, "The new password should work")
+}
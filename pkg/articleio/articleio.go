@@ -0,0 +1,271 @@
+package articleio
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+)
+
+// Format identifies one of the supported interchange formats.
+type Format string
+
+const (
+	FormatJSONL    Format = "jsonl"
+	FormatMarkdown Format = "markdown"
+	FormatCSV      Format = "csv"
+)
+
+// ParseFormat accepts a format name in any case.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatJSONL, "json":
+		return FormatJSONL, nil
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	}
+	return "", fmt.Errorf("unknown article format: %s", name)
+}
+
+// ConflictPolicy decides what Import does with a record whose slug already
+// exists in the database.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch ConflictPolicy(strings.ToLower(name)) {
+	case ConflictSkip:
+		return ConflictSkip, nil
+	case ConflictOverwrite:
+		return ConflictOverwrite, nil
+	case ConflictFail:
+		return ConflictFail, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy: %s", name)
+}
+
+// Record is the portable form of an article. Articles are matched across
+// environments by slug, so database IDs are not exported. Files written
+// before tags and status were exported have no published field; the
+// readers treat those articles as published.
+type Record struct {
+	Slug      string   `json:"slug" yaml:"slug"`
+	Title     string   `json:"title" yaml:"title"`
+	Image     string   `json:"image" yaml:"image"`
+	Preview   string   `json:"preview" yaml:"preview"`
+	Tags      []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Published bool     `json:"published" yaml:"published"`
+	Text      string   `json:"text" yaml:"-"`
+}
+
+// ValidSlug reports whether slug is one Slugify would produce: lowercase
+// letters and digits separated by single hyphens. Slugs become URL segments
+// and file names, so anything else, such as "../x", is refused.
+func ValidSlug(slug string) bool {
+	return slug != "" && database.Slugify(slug) == slug
+}
+
+func recordFromArticle(article database.Article, tags []string) Record {
+	return Record{
+		Slug:      article.Slug,
+		Title:     article.Title,
+		Image:     article.Image,
+		Preview:   article.Preview,
+		Tags:      normalizeTags(tags),
+		Published: article.Published,
+		Text:      article.Text,
+	}
+}
+
+// normalizeTags puts tags in the form the database keeps them: normalized,
+// without duplicates and sorted. No tags is nil.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	var normalized []string
+	for _, tag := range tags {
+		if tag = database.NormalizeTag(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// sameRecord compares records whose tags are normalized.
+func sameRecord(a, b Record) bool {
+	if len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for i := range a.Tags {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+	return a.Slug == b.Slug && a.Title == b.Title && a.Image == b.Image && a.Preview == b.Preview &&
+		a.Published == b.Published && a.Text == b.Text
+}
+
+// Export returns every article as a record.
+func Export() ([]Record, error) {
+	articles, err := database.GetArticles()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := database.GetAllArticleTags()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(articles))
+	for _, article := range articles {
+		records = append(records, recordFromArticle(article, tags[article.ID]))
+	}
+	return records, nil
+}
+
+// setStatus publishes or unpublishes an article and replaces its tags to
+// match a record.
+func setStatus(id int64, record Record) error {
+	batch, err := database.BeginArticleBatch()
+	if err != nil {
+		return err
+	}
+	if err := batch.SetPublished(id, record.Published); err != nil {
+		batch.Rollback()
+		return err
+	}
+	if err := batch.SetTags(id, record.Tags); err != nil {
+		batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// ItemError reports a record that could not be imported.
+type ItemError struct {
+	Slug    string
+	Message string
+}
+
+// Result summarises an import run.
+type Result struct {
+	Created int
+	Updated int
+	Skipped int
+	Errors  []ItemError
+}
+
+// Import writes records to the database, matching existing articles by slug.
+// Importing the same records twice leaves the database unchanged the second
+// time. With ConflictFail nothing is written if any slug already exists.
+// An article in the trash still holds its slug: overwriting restores it,
+// and otherwise it is reported as an error.
+func Import(records []Record, policy ConflictPolicy) (Result, error) {
+	logger.DualLog.Printf("Importing %d articles with conflict policy %s", len(records), policy)
+
+	var result Result
+
+	// Records without a slug are matched by the slug their title gives
+	prepared := make([]Record, len(records))
+	for i, record := range records {
+		if record.Slug == "" {
+			record.Slug = database.Slugify(record.Title)
+		}
+		record.Tags = normalizeTags(record.Tags)
+		prepared[i] = record
+	}
+
+	if policy == ConflictFail {
+		for _, record := range prepared {
+			existing, err := database.GetArticleBySlugWithDeleted(record.Slug)
+			if err == nil && existing.DeletedAt != nil {
+				return result, fmt.Errorf("article with slug %q is in the trash", record.Slug)
+			}
+			if err == nil {
+				return result, fmt.Errorf("article with slug %q already exists", record.Slug)
+			}
+			if err != sql.ErrNoRows {
+				return result, err
+			}
+		}
+	}
+
+	for _, record := range prepared {
+		if record.Title == "" {
+			result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: "title is required"})
+			continue
+		}
+		if !ValidSlug(record.Slug) {
+			result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: "slug may only contain lowercase letters, digits and single hyphens"})
+			continue
+		}
+
+		// Trashed articles keep their slug, so they are matched too
+		existing, err := database.GetArticleBySlugWithDeleted(record.Slug)
+		if err == sql.ErrNoRows {
+			id, err := database.CreateArticleWithSlug(record.Slug, record.Title, record.Image, record.Preview, record.Text)
+			if err == nil {
+				err = setStatus(id, record)
+			}
+			if err != nil {
+				result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: err.Error()})
+				continue
+			}
+			result.Created++
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: err.Error()})
+			continue
+		}
+
+		tags, err := database.GetArticleTags(existing.ID)
+		if err != nil {
+			result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: err.Error()})
+			continue
+		}
+		unchanged := sameRecord(recordFromArticle(existing, tags), record)
+
+		if existing.DeletedAt != nil {
+			if policy != ConflictOverwrite {
+				result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: "article is in the trash; restore it or import with overwrite"})
+				continue
+			}
+			if err := database.RestoreArticle(existing.ID); err != nil {
+				result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: err.Error()})
+				continue
+			}
+			if unchanged {
+				result.Updated++
+				continue
+			}
+		} else if policy != ConflictOverwrite || unchanged {
+			result.Skipped++
+			continue
+		}
+
+		_, err = database.UpdateArticle(existing.ID, record.Title, record.Image, record.Preview, record.Text)
+		if err == nil {
+			err = setStatus(existing.ID, record)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, ItemError{Slug: record.Slug, Message: err.Error()})
+			continue
+		}
+		result.Updated++
+	}
+
+	logger.DualLog.Printf("Imported articles: %d created, %d updated, %d skipped, %d errors", result.Created, result.Updated, result.Skipped, len(result.Errors))
+	return result, nil
+}
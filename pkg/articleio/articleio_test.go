@@ -0,0 +1,197 @@
+package articleio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmacdiarmid/gptback/logger"
+	"github.com/rmacdiarmid/gptback/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// Initialize logger with a dummy logger
+	logger.DualLog = log.New(ioutil.Discard, "", 0)
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	record := Record{
+		Slug:      "dragons-at-dawn",
+		Title:     "Dragons: at dawn",
+		Image:     "dragon1.jpg",
+		Preview:   "A short preview",
+		Tags:      []string{"dragons", "fantasy"},
+		Published: true,
+		Text:      "First paragraph.\n\n---\n\nSecond paragraph.",
+	}
+
+	data, err := MarshalMarkdown(record)
+	assert.Nil(t, err)
+
+	parsed, err := UnmarshalMarkdown(data)
+	assert.Nil(t, err)
+	assert.Equal(t, record, parsed)
+
+	// The text comes back byte for byte, whatever it ends with
+	for _, text := range []string{"", "ends with a newline\n", "two\n\n", "windows\r\nlines\r\n", "\nstarts blank"} {
+		record.Text = text
+		data, err := MarshalMarkdown(record)
+		assert.Nil(t, err)
+		parsed, err := UnmarshalMarkdown(data)
+		assert.Nil(t, err)
+		assert.Equal(t, text, parsed.Text)
+	}
+
+	crlf := "---\r\ntitle: By hand\r\n---\r\n\r\nSome text\r\n"
+	parsed, err = UnmarshalMarkdown([]byte(crlf))
+	assert.Nil(t, err)
+	assert.Equal(t, Record{Title: "By hand", Published: true, Text: "Some text"}, parsed)
+
+	draft := "---\ntitle: Draft\npublished: false\n---\n\ntext\n"
+	parsed, err = UnmarshalMarkdown([]byte(draft))
+	assert.Nil(t, err)
+	assert.False(t, parsed.Published)
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	records := []Record{
+		{Slug: "one", Title: "One", Tags: []string{"go", "web"}, Published: true, Text: "t"},
+		{Slug: "two", Title: "Two", Text: "draft"},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteJSONL(&buf, records))
+	parsed, err := ReadJSONL(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, records, parsed)
+
+	// Exports from before articles had a status are published
+	parsed, err = ReadJSONL(bytes.NewBufferString(`{"slug":"old","title":"Old","text":"t"}` + "\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{Slug: "old", Title: "Old", Published: true, Text: "t"}}, parsed)
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	records := []Record{
+		{Slug: "one", Title: "One", Image: "1.jpg", Preview: "p, with comma", Tags: []string{"go", "web dev"}, Published: true, Text: "line one\nline two"},
+		{Slug: "two", Title: "Two \"quoted\"", Image: "2.jpg", Preview: "p", Text: "t"},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCSV(&buf, records))
+
+	parsed, err := ReadCSV(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, records, parsed)
+
+	parsed, err = ReadCSV(bytes.NewBufferString("title,text\nOld,t\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{Title: "Old", Published: true, Text: "t"}}, parsed)
+	_, err = ReadCSV(bytes.NewBufferString("title,published\nBad,maybe\n"))
+	assert.NotNil(t, err)
+}
+
+func TestImportIsIdempotent(t *testing.T) {
+	_, err := database.InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	records := []Record{
+		{Slug: "first", Title: "First", Image: "a.jpg", Preview: "a", Tags: []string{"go", "testing"}, Published: true, Text: "aaa"},
+		{Slug: "second", Title: "Second", Image: "b.jpg", Preview: "b", Text: "bbb"},
+	}
+
+	result, err := Import(records, ConflictSkip)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Created: 2}, result)
+
+	result, err = Import(records, ConflictOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Skipped: 2}, result)
+
+	records[1].Text = "changed"
+	result, err = Import(records, ConflictOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Updated: 1, Skipped: 1}, result)
+
+	// Tags and status are imported like the other fields
+	records[0].Tags = []string{"go"}
+	records[1].Published = true
+	result, err = Import(records, ConflictOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Updated: 2}, result)
+	second, err := database.GetArticleBySlug("second")
+	assert.Nil(t, err)
+	assert.True(t, second.Published)
+
+	_, err = Import(records, ConflictFail)
+	assert.NotNil(t, err)
+	_, err = Import([]Record{{Title: "First", Text: "untitled slug"}}, ConflictFail)
+	assert.NotNil(t, err, "A record without a slug skipped the conflict check")
+
+	exported, err := Export()
+	assert.Nil(t, err)
+	assert.Equal(t, records, exported)
+}
+
+func TestImportRejectsUnsafeSlugs(t *testing.T) {
+	_, err := database.InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	records := []Record{
+		{Slug: "../../evil", Title: "Evil", Text: "x"},
+		{Slug: "Upper-Case", Title: "Upper", Text: "x"},
+		{Slug: "fine-slug", Title: "Fine", Text: "x"},
+	}
+	result, err := Import(records, ConflictSkip)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Len(t, result.Errors, 2)
+
+	dir := t.TempDir()
+	err = WriteMarkdownDir(filepath.Join(dir, "out"), []Record{{Slug: "../escaped", Title: "Escaped"}})
+	assert.NotNil(t, err, "A record was written outside the export directory")
+	_, err = os.Stat(filepath.Join(dir, "escaped.md"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImportMatchesTrashedArticles(t *testing.T) {
+	_, err := database.InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	records := []Record{{Slug: "trashed", Title: "Trashed", Image: "t.jpg", Preview: "t", Text: "ttt"}}
+	_, err = Import(records, ConflictSkip)
+	assert.Nil(t, err)
+	article, err := database.GetArticleBySlug("trashed")
+	assert.Nil(t, err)
+	assert.Nil(t, database.DeleteArticle(article.ID))
+
+	// Importing again neither creates slug-2 nor brings the article back
+	for i := 0; i < 2; i++ {
+		result, err := Import(records, ConflictSkip)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, result.Created)
+		assert.Len(t, result.Errors, 1)
+	}
+	_, err = Import(records, ConflictFail)
+	assert.NotNil(t, err)
+
+	result, err := Import(records, ConflictOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Updated: 1}, result)
+	restored, err := database.GetArticleBySlug("trashed")
+	assert.Nil(t, err)
+	assert.Equal(t, article.ID, restored.ID)
+	exported, err := Export()
+	assert.Nil(t, err)
+	assert.Equal(t, records, exported)
+}
@@ -0,0 +1,237 @@
+package articleio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var csvHeader = []string{"slug", "title", "image", "preview", "tags", "published", "text"}
+
+// csvTagSeparator joins an article's tags in one CSV column.
+const csvTagSeparator = ","
+
+func WriteJSONL(w io.Writer, records []Record) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadJSONL(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := Record{Published: true}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func WriteCSV(w io.Writer, records []Record) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		err := writer.Write([]string{
+			record.Slug, record.Title, record.Image, record.Preview,
+			strings.Join(record.Tags, csvTagSeparator), strconv.FormatBool(record.Published), record.Text,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadCSV expects a header row; columns are matched by name so they may
+// appear in any order. An empty or missing published column means
+// published.
+func ReadCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, fmt.Errorf("CSV header has no title column")
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := Record{
+			Slug:      field(row, "slug"),
+			Title:     field(row, "title"),
+			Image:     field(row, "image"),
+			Preview:   field(row, "preview"),
+			Published: true,
+			Text:      field(row, "text"),
+		}
+		if tags := strings.TrimSpace(field(row, "tags")); tags != "" {
+			record.Tags = strings.Split(tags, csvTagSeparator)
+		}
+		if published := strings.TrimSpace(field(row, "published")); published != "" {
+			record.Published, err = strconv.ParseBool(published)
+			if err != nil {
+				line, _ := reader.FieldPos(0)
+				return nil, fmt.Errorf("line %d: published must be true or false, not %q", line, published)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// MarshalMarkdown renders a record as Markdown with YAML front matter holding
+// everything but the article text. The text follows a blank line and is
+// always ended with one newline, which UnmarshalMarkdown takes off again,
+// so the text comes back exactly as it was.
+func MarshalMarkdown(record Record) ([]byte, error) {
+	frontMatter, err := yaml.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(frontMatter)
+	buf.WriteString("---\n\n")
+	buf.WriteString(record.Text)
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// UnmarshalMarkdown parses a file written by MarshalMarkdown, or by hand
+// with the same layout and either line ending. The text is kept byte for
+// byte, apart from the blank line after the front matter and the final
+// line ending.
+func UnmarshalMarkdown(data []byte) (Record, error) {
+	content := string(data)
+	newline := "\n"
+	if strings.HasPrefix(content, "---\r\n") {
+		newline = "\r\n"
+	}
+	start := "---" + newline
+	if !strings.HasPrefix(content, start) {
+		return Record{}, fmt.Errorf("missing front matter")
+	}
+	delimiter := newline + "---" + newline
+	end := strings.Index(content[len(start):], delimiter)
+	if end < 0 {
+		return Record{}, fmt.Errorf("unterminated front matter")
+	}
+
+	record := Record{Published: true}
+	if err := yaml.Unmarshal([]byte(content[len(start):len(start)+end]), &record); err != nil {
+		return Record{}, fmt.Errorf("parsing front matter: %v", err)
+	}
+	body := content[len(start)+end+len(delimiter):]
+	record.Text = strings.TrimSuffix(strings.TrimPrefix(body, newline), newline)
+	return record, nil
+}
+
+// MarkdownFileName is the file an article is written to inside an export
+// directory.
+func MarkdownFileName(record Record) string {
+	return record.Slug + ".md"
+}
+
+// pathInside joins name to dir, refusing names that would land outside it.
+func pathInside(dir, name string) (string, error) {
+	joined := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("refusing to write %q outside %s", name, dir)
+	}
+	return joined, nil
+}
+
+func WriteMarkdownDir(dir string, records []Record) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, record := range records {
+		data, err := MarshalMarkdown(record)
+		if err != nil {
+			return err
+		}
+		name, err := pathInside(dir, MarkdownFileName(record))
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(name, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadMarkdownDir(dir string) ([]Record, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		record, err := UnmarshalMarkdown(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		if record.Slug == "" {
+			record.Slug = strings.TrimSuffix(filepath.Base(path), ".md")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
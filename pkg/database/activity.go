@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// Kinds of thing an activity entry is about
+const (
+	ActivityTask    = "task"
+	ActivityArticle = "article"
+	ActivityUser    = "user"
+)
+
+// Activity actions
+const (
+	ActivityCreated     = "created"
+	ActivityUpdated     = "updated"
+	ActivityMoved       = "moved"
+	ActivityDeleted     = "deleted"
+	ActivityRestored    = "restored"
+	ActivityPublished   = "published"
+	ActivityUnpublished = "unpublished"
+)
+
+// activityRecurring is the Detail of a task generated from a template.
+const activityRecurring = "recurring"
+
+// Activity is an entry in the activity log, written whenever a task, article
+// or user changes.
+type Activity struct {
+	ID          int64
+	SubjectType string
+	SubjectID   int64
+	// SubjectTitle is the title of the task or article, or the display name
+	// of the user, at the time of the change
+	SubjectTitle string
+	Action       string
+	// Detail qualifies the action, such as the status a task was moved to
+	// or a user's new role
+	Detail    string
+	CreatedAt time.Time
+}
+
+// Summary describes the change in a sentence, for the activity feed.
+func (a Activity) Summary() string {
+	switch {
+	case a.SubjectType == ActivityUser && a.Action == ActivityCreated:
+		return fmt.Sprintf("%s joined", a.SubjectTitle)
+	case a.SubjectType == ActivityUser && a.Action == ActivityUpdated:
+		return fmt.Sprintf("%s's role changed to %s", a.SubjectTitle, a.Detail)
+	case a.SubjectType == ActivityTask && a.Action == ActivityCreated && a.Detail == activityRecurring:
+		return fmt.Sprintf("Recurring task %q created", a.SubjectTitle)
+	case a.Action == ActivityMoved:
+		return fmt.Sprintf("Task %q moved to %s", a.SubjectTitle, a.Detail)
+	}
+	noun := map[string]string{ActivityTask: "Task", ActivityArticle: "Article", ActivityUser: "User"}[a.SubjectType]
+	return fmt.Sprintf("%s %q %s", noun, a.SubjectTitle, a.Action)
+}
+
+func createActivityTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS activity (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subject_type TEXT NOT NULL,
+			subject_id INTEGER NOT NULL,
+			subject_title TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating activity table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// activityTitles look up the current title of a subject when the caller
+// doesn't have it to hand.
+var activityTitles = map[string]string{
+	ActivityTask:    "SELECT title FROM tasks WHERE id = ?",
+	ActivityArticle: "SELECT title FROM articles WHERE id = ?",
+}
+
+// recordActivity adds an entry to the activity log. An empty SubjectTitle is
+// read from the subject, so it must be called before a subject is removed.
+// Changes made in a transaction record their activity in the same one.
+func recordActivity(db execer, activity Activity) error {
+	title := "?"
+	args := []interface{}{activity.SubjectType, activity.SubjectID, activity.SubjectTitle}
+	if lookup, ok := activityTitles[activity.SubjectType]; ok && activity.SubjectTitle == "" {
+		title = "COALESCE((" + lookup + "), '')"
+		args[2] = activity.SubjectID
+	}
+	args = append(args, activity.Action, activity.Detail, time.Now().UTC())
+
+	_, err := db.Exec(`
+		INSERT INTO activity(subject_type, subject_id, subject_title, action, detail, created_at)
+		VALUES (?, ?, `+title+`, ?, ?, ?)`, args...)
+	if err != nil {
+		logger.DualLog.Printf("Error recording %s %d %s: %s", activity.SubjectType, activity.SubjectID, activity.Action, err.Error())
+	}
+	return err
+}
+
+// noteActivity records activity for a change that has already been saved.
+// A failure is only logged, so it never fails the change itself.
+func noteActivity(subjectType string, subjectID int64, action string) {
+	recordActivity(DB, Activity{SubjectType: subjectType, SubjectID: subjectID, Action: action})
+}
+
+const activityColumns = "id, subject_type, subject_id, subject_title, action, detail, created_at"
+
+func queryActivity(query string, args ...interface{}) ([]Activity, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching activity: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Activity
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.ID, &a.SubjectType, &a.SubjectID, &a.SubjectTitle, &a.Action, &a.Detail, &a.CreatedAt); err != nil {
+			logger.DualLog.Printf("Error scanning activity: %s", err.Error())
+			return nil, err
+		}
+		entries = append(entries, a)
+	}
+	return entries, rows.Err()
+}
+
+// GetActivity returns up to limit entries of the activity log, newest first.
+// With after set to the ID of the last entry of a page it returns the next,
+// older, page.
+func GetActivity(limit int, after int64) ([]Activity, error) {
+	if after > 0 {
+		return queryActivity("SELECT "+activityColumns+" FROM activity WHERE id < ? ORDER BY id DESC LIMIT ?", after, limit)
+	}
+	return queryActivity("SELECT "+activityColumns+" FROM activity ORDER BY id DESC LIMIT ?", limit)
+}
+
+// GetActivitySince returns up to limit entries newer than the entry with
+// the given ID, oldest first, for following the log as it grows.
+func GetActivitySince(id int64, limit int) ([]Activity, error) {
+	return queryActivity("SELECT "+activityColumns+" FROM activity WHERE id > ? ORDER BY id LIMIT ?", id, limit)
+}
+
+// GetLatestActivityID returns the ID of the newest entry, or 0 if the log is
+// empty.
+func GetLatestActivityID() (int64, error) {
+	var id sql.NullInt64
+	err := DB.QueryRow("SELECT MAX(id) FROM activity").Scan(&id)
+	return id.Int64, err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// ViewDayFormat is the layout of article_views.day.
+const ViewDayFormat = "2006-01-02"
+
+func createArticleViewsTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS article_views (
+			article_id INTEGER NOT NULL REFERENCES articles(id),
+			day TEXT NOT NULL,
+			views INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (article_id, day)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_views_day ON article_views(day);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating article_views table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// ArticleDay identifies an article's views on one day, formatted with
+// ViewDayFormat.
+type ArticleDay struct {
+	ArticleID int64
+	Day       string
+}
+
+// AddArticleViews adds the given counts to the daily totals in one
+// transaction.
+func AddArticleViews(counts map[ArticleDay]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, views := range counts {
+		_, err := tx.Exec(`
+			INSERT INTO article_views(article_id, day, views) VALUES (?, ?, ?)
+			ON CONFLICT(article_id, day) DO UPDATE SET views = views + excluded.views`,
+			key.ArticleID, key.Day, views)
+		if err != nil {
+			logger.DualLog.Printf("Error recording views of article %d: %s", key.ArticleID, err.Error())
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetArticleViewCount returns the total recorded views of an article.
+func GetArticleViewCount(articleID int64) (int, error) {
+	var views int
+	err := DB.QueryRow("SELECT COALESCE(SUM(views), 0) FROM article_views WHERE article_id = ?", articleID).Scan(&views)
+	if err != nil {
+		logger.DualLog.Printf("Error reading views of article %d: %s", articleID, err.Error())
+		return 0, err
+	}
+	return views, nil
+}
+
+// GetPopularArticles returns the published articles with the most views
+// since the given day, most viewed first. A zero since counts all views.
+func GetPopularArticles(since time.Time, limit int) ([]PopularArticle, error) {
+	sinceDay := ""
+	if !since.IsZero() {
+		sinceDay = since.UTC().Format(ViewDayFormat)
+	}
+
+	rows, err := DB.Query(`
+		SELECT * FROM (
+			SELECT `+articleColumns+`,
+				(SELECT COALESCE(SUM(views), 0) FROM article_views WHERE article_id = articles.id AND day >= ?) AS total
+			FROM articles
+			WHERE published = 1 AND deleted_at IS NULL
+		)
+		WHERE total > 0
+		ORDER BY total DESC, id
+		LIMIT ?`, sinceDay, limit)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching popular articles: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var popular []PopularArticle
+	for rows.Next() {
+		var views int
+		article, err := scanArticle(rows, &views)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning popular article: %s", err.Error())
+			return nil, err
+		}
+		popular = append(popular, PopularArticle{Article: article, Views: views})
+	}
+	return popular, rows.Err()
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// APIKey is a key a user made for scripts to call the API as them. The key
+// itself is only shown when it is made: what is stored is its prefix, to
+// find it by, and a hash of the whole key.
+type APIKey struct {
+	ID     int64
+	UserID int64
+	Name   string
+	Prefix string
+	// KeyHash is the hash of the whole key
+	KeyHash string
+	// Scopes are the permissions the key may use, of those the user's role
+	// has
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Active reports whether the key can still be used at now.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
+
+// createAPIKeysTable creates the table of API keys.
+func createAPIKeysTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES user_account_6007(UserId),
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL UNIQUE,
+			key_hash TEXT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating api_keys table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// CreateAPIKey stores a new API key and returns its ID.
+func CreateAPIKey(key APIKey) (int64, error) {
+	logger.DualLog.Printf("Creating API key %q for user %d", key.Name, key.UserID)
+
+	result, err := DB.Exec(`
+		INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), key.CreatedAt.UTC(), key.ExpiresAt.UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error creating API key: %s", err.Error())
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.CreatedAt, &k.ExpiresAt, &lastUsedAt, &revokedAt)
+	k.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return k, err
+}
+
+// GetAPIKeyByPrefix returns the API key with the given prefix, revoked or
+// not, or sql.ErrNoRows.
+func GetAPIKeyByPrefix(prefix string) (APIKey, error) {
+	return scanAPIKey(DB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?", prefix))
+}
+
+// GetAPIKeys lists the user's API keys that haven't been revoked, newest
+// first.
+func GetAPIKeys(userID int64) ([]APIKey, error) {
+	rows, err := DB.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? AND revoked_at IS NULL ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching API keys: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning API key: %s", err.Error())
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that a key was used at now. To save a write on every
+// request, the time is only updated once it is a minute old.
+func TouchAPIKey(id int64, now time.Time) error {
+	_, err := DB.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now.UTC(), id, now.Add(-time.Minute).UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error updating API key: %s", err.Error())
+	}
+	return err
+}
+
+// RevokeAPIKey stops one of a user's API keys working. It returns
+// sql.ErrNoRows if they have no such key that is still usable.
+func RevokeAPIKey(userID, id int64) error {
+	logger.DualLog.Printf("Revoking API key %d of user %d", id, userID)
+
+	result, err := DB.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, userID)
+	if err != nil {
+		logger.DualLog.Printf("Error revoking API key: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// ArticleBatch applies several article changes in one transaction, so a
+// bulk operation either succeeds for every article or changes nothing.
+type ArticleBatch struct {
+	tx *sql.Tx
+}
+
+// ArticleChanges holds the fields to change in an article. Nil fields are
+// left as they are.
+type ArticleChanges struct {
+	Title   *string
+	Image   *string
+	Preview *string
+	Text    *string
+}
+
+// BeginArticleBatch starts a batch. It must be finished with Commit or
+// Rollback.
+func BeginArticleBatch() (*ArticleBatch, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		logger.DualLog.Printf("Error starting article batch: %s", err.Error())
+		return nil, err
+	}
+	return &ArticleBatch{tx: tx}, nil
+}
+
+func (b *ArticleBatch) Commit() error {
+	return b.tx.Commit()
+}
+
+func (b *ArticleBatch) Rollback() error {
+	return b.tx.Rollback()
+}
+
+// ReadArticle reads an article as the batch sees it, or returns
+// sql.ErrNoRows if it doesn't exist or is in the trash.
+func (b *ArticleBatch) ReadArticle(id int64) (Article, error) {
+	return scanArticle(b.tx.QueryRow("SELECT "+articleColumns+" FROM articles WHERE id = ? AND deleted_at IS NULL", id))
+}
+
+// SetPublished publishes or unpublishes an article. Activity is only
+// recorded if that changes it.
+func (b *ArticleBatch) SetPublished(id int64, published bool) error {
+	result, err := b.tx.Exec("UPDATE articles SET published = ? WHERE id = ? AND published != ?", published, id, published)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+	action := ActivityUnpublished
+	if published {
+		action = ActivityPublished
+	}
+	return recordActivity(b.tx, Activity{SubjectType: ActivityArticle, SubjectID: id, Action: action})
+}
+
+// DeleteArticle moves an article to the trash, like the DeleteArticle
+// function.
+func (b *ArticleBatch) DeleteArticle(id int64) error {
+	return softDeleteArticle(b.tx, id)
+}
+
+func (b *ArticleBatch) AddTags(id int64, tags []string) error {
+	if err := addArticleTags(b.tx, id, tags); err != nil {
+		return err
+	}
+	return b.recordUpdate(id)
+}
+
+func (b *ArticleBatch) RemoveTags(id int64, tags []string) error {
+	if err := removeArticleTags(b.tx, id, tags); err != nil {
+		return err
+	}
+	return b.recordUpdate(id)
+}
+
+// SetTags replaces an article's tags. Activity is only recorded if that
+// changes them.
+func (b *ArticleBatch) SetTags(id int64, tags []string) error {
+	rows, err := b.tx.Query("SELECT tag FROM article_tags WHERE article_id = ?", id)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			rows.Close()
+			return err
+		}
+		current[tag] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" {
+			wanted[tag] = true
+		}
+	}
+	if len(wanted) == len(current) {
+		same := true
+		for tag := range wanted {
+			same = same && current[tag]
+		}
+		if same {
+			return nil
+		}
+	}
+
+	if _, err := b.tx.Exec("DELETE FROM article_tags WHERE article_id = ?", id); err != nil {
+		return err
+	}
+	if err := addArticleTags(b.tx, id, tags); err != nil {
+		return err
+	}
+	return b.recordUpdate(id)
+}
+
+func (b *ArticleBatch) recordUpdate(id int64) error {
+	return recordActivity(b.tx, Activity{SubjectType: ActivityArticle, SubjectID: id, Action: ActivityUpdated})
+}
+
+// UpdateArticle applies the non-nil fields of changes to an article.
+func (b *ArticleBatch) UpdateArticle(id int64, changes ArticleChanges) error {
+	var sets []string
+	var args []interface{}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"title", changes.Title},
+		{"image", changes.Image},
+		{"preview", changes.Preview},
+		{"text", changes.Text},
+	} {
+		if field.value != nil {
+			sets = append(sets, field.column+" = ?")
+			args = append(args, *field.value)
+		}
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	_, err := b.tx.Exec("UPDATE articles SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, id)...)
+	if err != nil {
+		return err
+	}
+	return b.recordUpdate(id)
+}
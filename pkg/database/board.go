@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// ErrInvalidMove is wrapped by the errors MoveTask returns when the
+// neighbours it's given can't be used.
+var ErrInvalidMove = errors.New("invalid task move")
+
+// lastRankQuery finds the rank at the bottom of the board column for the
+// status bound to it, 0 if the column is empty.
+const lastRankQuery = "SELECT COALESCE(MAX(rank), 0) FROM tasks WHERE status = ? AND deleted_at IS NULL"
+
+// minRankGap is how close two neighbouring ranks may get before their column
+// is renumbered.
+const minRankGap = 1e-9
+
+// MoveTask moves a task into the board column for status, between two of the
+// tasks already there. The task goes directly before beforeID and after
+// afterID; either may be 0, and with neither the task goes to the bottom of
+// the column.
+//
+// Only the moved task's rank changes, except in the rare case that repeated
+// moves to the same spot leave no room between its neighbours, when the
+// column is renumbered. It returns sql.ErrNoRows if the task doesn't exist or
+// is deleted.
+func MoveTask(id int64, status string, beforeID, afterID int64) error {
+	logger.DualLog.Printf("Moving task %d to %s between %d and %d", id, status, afterID, beforeID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from string
+	err = tx.QueryRow("SELECT status FROM tasks WHERE id = ? AND deleted_at IS NULL", id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	rank, ok, err := moveRank(tx, id, status, beforeID, afterID)
+	if err == nil && !ok {
+		if err = renumberColumn(tx, status, id); err == nil {
+			rank, _, err = moveRank(tx, id, status, beforeID, afterID)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, ErrInvalidMove) {
+			logger.DualLog.Printf("Error moving task %d: %s", id, err.Error())
+		}
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE tasks SET status = ?, rank = ?, updated_at = ? WHERE id = ?", status, rank, time.Now().UTC(), id)
+	if err != nil {
+		logger.DualLog.Printf("Error moving task %d: %s", id, err.Error())
+		return err
+	}
+	// Reordering a column isn't worth a place in the activity feed
+	if status != from {
+		err = recordActivity(tx, Activity{SubjectType: ActivityTask, SubjectID: id, Action: ActivityMoved, Detail: status})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// moveRank works out the rank that places task id between its new
+// neighbours. It reports false if they are too close together to fit
+// another rank between them.
+func moveRank(tx *sql.Tx, id int64, status string, beforeID, afterID int64) (float64, bool, error) {
+	neighbour := func(neighbourID int64) (float64, error) {
+		if neighbourID == id {
+			return 0, fmt.Errorf("%w: a task can't be moved next to itself", ErrInvalidMove)
+		}
+		var rank float64
+		var neighbourStatus string
+		err := tx.QueryRow("SELECT COALESCE(rank, id), status FROM tasks WHERE id = ? AND deleted_at IS NULL", neighbourID).Scan(&rank, &neighbourStatus)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: task %d not found", ErrInvalidMove, neighbourID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if neighbourStatus != status {
+			return 0, fmt.Errorf("%w: task %d is not in the %s column", ErrInvalidMove, neighbourID, status)
+		}
+		return rank, nil
+	}
+	// closest finds the rank next to bound in the column, ignoring the
+	// task being moved
+	closest := func(query string, bound float64) (sql.NullFloat64, error) {
+		var rank sql.NullFloat64
+		err := tx.QueryRow(query, status, id, bound).Scan(&rank)
+		return rank, err
+	}
+
+	var lower, upper float64
+	switch {
+	case afterID != 0 && beforeID != 0:
+		var err error
+		if lower, err = neighbour(afterID); err != nil {
+			return 0, false, err
+		}
+		if upper, err = neighbour(beforeID); err != nil {
+			return 0, false, err
+		}
+		if lower >= upper {
+			return 0, false, fmt.Errorf("%w: task %d is not above task %d", ErrInvalidMove, afterID, beforeID)
+		}
+	case afterID != 0:
+		var err error
+		if lower, err = neighbour(afterID); err != nil {
+			return 0, false, err
+		}
+		next, err := closest("SELECT MIN(rank) FROM tasks WHERE status = ? AND id != ? AND deleted_at IS NULL AND rank > ?", lower)
+		if err != nil {
+			return 0, false, err
+		}
+		if !next.Valid {
+			return lower + 1, true, nil
+		}
+		upper = next.Float64
+	case beforeID != 0:
+		var err error
+		if upper, err = neighbour(beforeID); err != nil {
+			return 0, false, err
+		}
+		previous, err := closest("SELECT MAX(rank) FROM tasks WHERE status = ? AND id != ? AND deleted_at IS NULL AND rank < ?", upper)
+		if err != nil {
+			return 0, false, err
+		}
+		if !previous.Valid {
+			return upper - 1, true, nil
+		}
+		lower = previous.Float64
+	default:
+		var last sql.NullFloat64
+		err := tx.QueryRow("SELECT MAX(rank) FROM tasks WHERE status = ? AND id != ? AND deleted_at IS NULL", status, id).Scan(&last)
+		if err != nil {
+			return 0, false, err
+		}
+		return last.Float64 + 1, true, nil
+	}
+
+	if upper-lower < minRankGap {
+		return 0, false, nil
+	}
+	return lower + (upper-lower)/2, true, nil
+}
+
+// renumberColumn spaces out the ranks in a board column, keeping its order.
+func renumberColumn(tx *sql.Tx, status string, exclude int64) error {
+	logger.DualLog.Printf("Renumbering the %s column", status)
+
+	rows, err := tx.Query("SELECT id FROM tasks WHERE status = ? AND id != ? AND deleted_at IS NULL ORDER BY rank, id", status, exclude)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if _, err := tx.Exec("UPDATE tasks SET rank = ? WHERE id = ?", i+1, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
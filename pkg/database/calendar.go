@@ -0,0 +1,65 @@
+package database
+
+import (
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// createCalendarTokensTable creates the table of calendar feed tokens. Each
+// user has at most one; only a hash of it is stored.
+func createCalendarTokensTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS calendar_tokens (
+			user_id INTEGER PRIMARY KEY REFERENCES user_account_6007(UserId),
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL
+		);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating calendar_tokens table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// SetCalendarToken stores the hash of a user's calendar feed token,
+// replacing their previous one.
+func SetCalendarToken(userID int64, tokenHash string) error {
+	logger.DualLog.Printf("Setting calendar token of user %d", userID)
+
+	_, err := DB.Exec(`
+		INSERT INTO calendar_tokens(user_id, token_hash, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = excluded.created_at`,
+		userID, tokenHash, time.Now().UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error setting calendar token: %s", err.Error())
+	}
+	return err
+}
+
+// GetUserByCalendarToken returns the user whose calendar token has the given
+// hash, or sql.ErrNoRows.
+func GetUserByCalendarToken(tokenHash string) (User, error) {
+	var user User
+	err := DB.QueryRow(`
+		SELECT uld.UserId, uld.EmailAddress FROM calendar_tokens AS ct
+		JOIN user_login_data_4231 AS uld ON uld.UserId = ct.user_id
+		WHERE ct.token_hash = ?`, tokenHash).Scan(&user.UserId, &user.Email)
+	return user, err
+}
+
+// DeleteCalendarToken revokes a user's calendar token. It returns
+// sql.ErrNoRows if they don't have one.
+func DeleteCalendarToken(userID int64) error {
+	logger.DualLog.Printf("Deleting calendar token of user %d", userID)
+
+	result, err := DB.Exec("DELETE FROM calendar_tokens WHERE user_id = ?", userID)
+	if err != nil {
+		logger.DualLog.Printf("Error deleting calendar token: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+func createCommentsTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER NOT NULL REFERENCES articles(id),
+			parent_id INTEGER REFERENCES comments(id),
+			author_id INTEGER REFERENCES user_account_6007(UserId),
+			guest_name TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_comments_article ON comments(article_id, status);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating comments table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+const commentColumns = "id, article_id, COALESCE(parent_id, 0), COALESCE(author_id, 0), guest_name, body, status, created_at"
+
+func scanComment(row rowScanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(&comment.ID, &comment.ArticleID, &comment.ParentID, &comment.AuthorID, &comment.GuestName, &comment.Body, &comment.Status, &comment.CreatedAt)
+	return comment, err
+}
+
+func CreateComment(comment Comment) (int64, error) {
+	logger.DualLog.Printf("Creating comment on article %d with status %s", comment.ArticleID, comment.Status)
+
+	if comment.CreatedAt.IsZero() {
+		comment.CreatedAt = time.Now().UTC()
+	}
+	result, err := DB.Exec("INSERT INTO comments(article_id, parent_id, author_id, guest_name, body, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		comment.ArticleID,
+		sql.NullInt64{Int64: comment.ParentID, Valid: comment.ParentID != 0},
+		sql.NullInt64{Int64: comment.AuthorID, Valid: comment.AuthorID != 0},
+		comment.GuestName, comment.Body, comment.Status, comment.CreatedAt)
+	if err != nil {
+		logger.DualLog.Printf("Error creating comment: %s", err.Error())
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.DualLog.Printf("Error getting last insert id: %s", err.Error())
+		return 0, err
+	}
+
+	logger.DualLog.Printf("Created comment with ID: %d", id)
+	return id, nil
+}
+
+func GetComment(id int64) (Comment, error) {
+	comment, err := scanComment(DB.QueryRow("SELECT "+commentColumns+" FROM comments WHERE id = ?", id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.DualLog.Printf("Error reading comment: %s", err.Error())
+		}
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// GetCommentsByArticle returns an article's comments in posting order. An
+// empty status returns comments of every status.
+func GetCommentsByArticle(articleID int64, status string) ([]Comment, error) {
+	if status == "" {
+		return queryComments("SELECT "+commentColumns+" FROM comments WHERE article_id = ? ORDER BY created_at, id", articleID)
+	}
+	return queryComments("SELECT "+commentColumns+" FROM comments WHERE article_id = ? AND status = ? ORDER BY created_at, id", articleID, status)
+}
+
+// GetCommentsByStatus returns comments across all articles, oldest first, for
+// the moderation queue.
+func GetCommentsByStatus(status string) ([]Comment, error) {
+	return queryComments("SELECT "+commentColumns+" FROM comments WHERE status = ? ORDER BY created_at, id", status)
+}
+
+func queryComments(query string, args ...interface{}) ([]Comment, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching comments: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning comment: %s", err.Error())
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		logger.DualLog.Printf("Error iterating through rows: %s", err.Error())
+		return nil, err
+	}
+	return comments, nil
+}
+
+func UpdateCommentStatus(id int64, status string) error {
+	logger.DualLog.Printf("Setting status of comment %d to %s", id, status)
+
+	result, err := DB.Exec("UPDATE comments SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		logger.DualLog.Printf("Error updating comment status: %s", err.Error())
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteComment removes a comment together with all replies beneath it.
+func DeleteComment(id int64) error {
+	logger.DualLog.Printf("Deleting comment with ID: %d", id)
+
+	_, err := DB.Exec(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comments WHERE id = ?
+			UNION ALL
+			SELECT comments.id FROM comments JOIN thread ON comments.parent_id = thread.id
+		)
+		DELETE FROM comments WHERE id IN (SELECT id FROM thread)`, id)
+	if err != nil {
+		logger.DualLog.Printf("Error deleting comment: %s", err.Error())
+		return err
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rmacdiarmid/gptback/logger"
@@ -28,6 +31,7 @@ func InitDB(dbPath string) (*sql.DB, error) {
 	createTableQuery := `
     CREATE TABLE IF NOT EXISTS articles (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
+        slug TEXT NOT NULL DEFAULT '',
         title TEXT NOT NULL,
         image TEXT NOT NULL,
         preview TEXT NOT NULL,
@@ -41,18 +45,322 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	err = migrateArticleSlugs()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createUserTables()
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateUserRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("user_login_data_4231", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("articles", "author_id", "INTEGER REFERENCES user_account_6007(UserId)")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("articles", "prompt", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("articles", "model", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("articles", "deleted_at", "DATETIME")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addColumnIfMissing("articles", "published", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return nil, err
+	}
+
+	err = createArticleTagsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createArticleViewsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createTasksTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createTaskTemplatesTable()
+	if err != nil {
+		return nil, err
+	}
+
 	err = createFrontendLogsTable()
 	if err != nil {
 		return nil, err
 	}
 
+	err = createCommentsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createRatingsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createActivityTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createCalendarTokensTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createSessionTables()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createPasswordResetTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createTwoFactorTables()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createLoginAttemptsTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createAPIKeysTable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createExternalIdentitiesTable()
+	if err != nil {
+		return nil, err
+	}
+
 	logger.DualLog.Printf("Database initialized successfully")
 	return DB, nil
 }
 
+// createTasksTable creates the tasks table, and adds the columns that were
+// introduced after it was first created.
+func createTasksTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS tasks (
+			id INTEGER PRIMARY KEY,
+			title TEXT NOT NULL,
+			description TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME
+		);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating tasks table: %s", err.Error())
+		return err
+	}
+
+	for _, column := range []struct{ name, definition string }{
+		{"deleted_at", "DATETIME"},
+		{"status", "TEXT NOT NULL DEFAULT '" + TaskTodo + "'"},
+		{"priority", "TEXT NOT NULL DEFAULT '" + TaskPriorityMedium + "'"},
+		{"due_date", "DATE"},
+		{"assignee_id", "INTEGER REFERENCES user_account_6007(UserId)"},
+		{"updated_at", "TIMESTAMP"},
+		{"rank", "REAL"},
+	} {
+		if err := addColumnIfMissing("tasks", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err = DB.Exec(`
+		UPDATE tasks SET updated_at = created_at WHERE updated_at IS NULL;
+		UPDATE tasks SET rank = id WHERE rank IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+		CREATE INDEX IF NOT EXISTS idx_tasks_assignee ON tasks(assignee_id);
+		CREATE INDEX IF NOT EXISTS idx_tasks_rank ON tasks(status, rank);
+	`)
+	if err != nil {
+		logger.DualLog.Printf("Error migrating tasks table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// taskColumns lists the columns read by every task query, in the order
+// expected by scanTask.
+const taskColumns = "id, title, COALESCE(description, ''), status, priority, due_date, COALESCE(assignee_id, 0), COALESCE(rank, id), created_at, updated_at, deleted_at, COALESCE(template_id, 0), occurrence_date"
+
+func scanTask(row rowScanner) (Task, error) {
+	var task Task
+	var dueDate, createdAt, updatedAt, deletedAt, occurrenceDate sql.NullTime
+	err := row.Scan(&task.ID, &task.Title, &task.Description, &task.Status, &task.Priority, &dueDate, &task.AssigneeID, &task.Rank,
+		&createdAt, &updatedAt, &deletedAt, &task.TemplateID, &occurrenceDate)
+	if dueDate.Valid {
+		task.DueDate = &dueDate.Time
+	}
+	task.CreatedAt = createdAt.Time
+	task.UpdatedAt = updatedAt.Time
+	if !updatedAt.Valid {
+		task.UpdatedAt = task.CreatedAt
+	}
+	if deletedAt.Valid {
+		task.DeletedAt = &deletedAt.Time
+	}
+	if occurrenceDate.Valid {
+		task.OccurrenceDate = &occurrenceDate.Time
+	}
+	return task, err
+}
+
 func ReadAllTasks() ([]Task, error) {
 	logger.DualLog.Printf("Fetching all tasks")
-	rows, err := DB.Query("SELECT id, title, description FROM tasks")
+	return queryTasks("SELECT " + taskColumns + " FROM tasks WHERE deleted_at IS NULL")
+}
+
+// taskSortExpressions maps the orders accepted by TaskFilter.Sort to SQL.
+// Statuses and priorities sort in workflow order rather than alphabetically.
+var taskSortExpressions = map[string]string{
+	TaskSortID:        "id",
+	TaskSortTitle:     "title COLLATE NOCASE",
+	TaskSortStatus:    "CASE status WHEN '" + TaskTodo + "' THEN 0 WHEN '" + TaskDoing + "' THEN 1 ELSE 2 END",
+	TaskSortPriority:  "CASE priority WHEN '" + TaskPriorityLow + "' THEN 0 WHEN '" + TaskPriorityMedium + "' THEN 1 ELSE 2 END",
+	TaskSortDueDate:   "due_date",
+	TaskSortCreatedAt: "created_at",
+	TaskSortUpdatedAt: "updated_at",
+	TaskSortRank:      "rank",
+}
+
+// ValidTaskSort reports whether sort is accepted by TaskFilter.Sort.
+func ValidTaskSort(sort string) bool {
+	_, ok := taskSortExpressions[sort]
+	return ok
+}
+
+// ListTasks returns a page of the tasks matching filter, in the order it
+// asks for, and the total number of matching tasks.
+func ListTasks(filter TaskFilter) ([]Task, int, error) {
+	logger.DualLog.Printf("Listing tasks: %+v", filter)
+
+	where := "deleted_at IS NULL"
+	var args []interface{}
+	if filter.Query != "" {
+		where += " AND (title LIKE ? ESCAPE '\\' OR description LIKE ? ESCAPE '\\')"
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if filter.Status != "" {
+		where += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.Priority != "" {
+		where += " AND priority = ?"
+		args = append(args, filter.Priority)
+	}
+	if filter.HasDueDate {
+		where += " AND due_date IS NOT NULL"
+	}
+	if filter.Unassigned {
+		where += " AND assignee_id IS NULL"
+	} else if filter.AssigneeID != 0 {
+		where += " AND assignee_id = ?"
+		args = append(args, filter.AssigneeID)
+	}
+	// Due dates are stored as plain dates, the timestamps as UTC text; both
+	// compare correctly as strings in those forms
+	for _, bound := range []struct {
+		condition string
+		value     *time.Time
+		date      bool
+	}{
+		{"due_date >= ?", filter.DueAfter, true},
+		{"due_date < ?", filter.DueBefore, true},
+		{"created_at >= ?", filter.CreatedAfter, false},
+		{"created_at < ?", filter.CreatedBefore, false},
+		{"updated_at >= ?", filter.UpdatedAfter, false},
+		{"updated_at < ?", filter.UpdatedBefore, false},
+	} {
+		if bound.value == nil {
+			continue
+		}
+		where += " AND " + bound.condition
+		if bound.date {
+			args = append(args, bound.value.Format(TaskDueDateFormat))
+		} else {
+			args = append(args, bound.value.UTC())
+		}
+	}
+
+	sort := filter.Sort
+	if sort == "" {
+		sort = TaskSortID
+	}
+	expression, ok := taskSortExpressions[sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown task sort %q", sort)
+	}
+	direction := "ASC"
+	if filter.Descending {
+		direction = "DESC"
+	}
+	// Tasks without a due date go last whichever way they're sorted
+	order := expression + " " + direction + ", id"
+	if sort == TaskSortDueDate {
+		order = "due_date IS NULL, " + order
+	}
+
+	var total int
+	err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+where, args...).Scan(&total)
+	if err != nil {
+		logger.DualLog.Printf("Error counting tasks: %s", err.Error())
+		return nil, 0, err
+	}
+
+	tasks, err := queryTasks("SELECT "+taskColumns+" FROM tasks WHERE "+where+" ORDER BY "+order+" LIMIT ? OFFSET ?", append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tasks, total, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes the LIKE wildcards in s, for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func queryTasks(query string, args ...interface{}) ([]Task, error) {
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		logger.DualLog.Printf("Error fetching tasks: %s", err.Error())
 		return nil, err
@@ -61,8 +369,7 @@ func ReadAllTasks() ([]Task, error) {
 
 	var tasks []Task
 	for rows.Next() {
-		var task Task
-		err := rows.Scan(&task.ID, &task.Title, &task.Description)
+		task, err := scanTask(rows)
 		if err != nil {
 			logger.DualLog.Printf("Error scanning task: %s", err.Error())
 			return nil, err
@@ -83,9 +390,26 @@ func ReadAllTasks() ([]Task, error) {
 }
 
 func CreateTask(title string, description string) (int64, error) {
-	logger.DualLog.Printf("Creating task with title: %s, description: %s", title, description)
+	return CreateTaskRecord(Task{Title: title, Description: description})
+}
 
-	result, err := DB.Exec("INSERT INTO tasks(title, description) VALUES (?, ?)", title, description)
+// CreateTaskRecord inserts a task at the bottom of its board column and
+// returns its ID. An empty status or priority defaults to TaskTodo or
+// TaskPriorityMedium.
+func CreateTaskRecord(task Task) (int64, error) {
+	logger.DualLog.Printf("Creating task with title: %s, description: %s", task.Title, task.Description)
+
+	if task.Status == "" {
+		task.Status = TaskTodo
+	}
+	if task.Priority == "" {
+		task.Priority = TaskPriorityMedium
+	}
+	now := time.Now().UTC()
+	result, err := DB.Exec(`
+		INSERT INTO tasks(title, description, status, priority, due_date, assignee_id, rank, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, (`+lastRankQuery+`) + 1, ?, ?)`,
+		task.Title, task.Description, task.Status, task.Priority, taskDueDate(task.DueDate), nullableID(task.AssigneeID), task.Status, now, now)
 	if err != nil {
 		logger.DualLog.Printf("Error creating task: %s", err.Error())
 		return 0, err
@@ -97,46 +421,101 @@ func CreateTask(title string, description string) (int64, error) {
 		return 0, err
 	}
 
-	logger.DualLog.Printf("Created task with ID: %d, title: %s, description: %s", id, title, description)
+	noteActivity(ActivityTask, id, ActivityCreated)
+	logger.DualLog.Printf("Created task with ID: %d, title: %s, description: %s", id, task.Title, task.Description)
 	return id, nil
 }
 
+// taskDueDate converts a due date to the value stored in tasks.due_date.
+func taskDueDate(due *time.Time) interface{} {
+	if due == nil {
+		return nil
+	}
+	return due.Format(TaskDueDateFormat)
+}
+
+// nullableID stores an optional reference to another row, 0 meaning none, as
+// NULL.
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// UpdateTask changes a task's title and description. It returns
+// sql.ErrNoRows if the task doesn't exist or is deleted.
 func UpdateTask(db *sql.DB, id int, title string, description string) error {
 	logger.DualLog.Printf("Updating task with ID: %d, title: %s, description: %s", id, title, description)
 
-	stmt, err := db.Prepare("UPDATE tasks SET title = ?, description = ? WHERE id = ?")
+	stmt, err := db.Prepare("UPDATE tasks SET title = ?, description = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL")
 	if err != nil {
 		logger.DualLog.Printf("Error preparing statement: %s", err.Error())
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(title, description, id)
+	result, err := stmt.Exec(title, description, time.Now().UTC(), id)
 	if err != nil {
 		logger.DualLog.Printf("Error executing statement: %s", err.Error())
 		return err
 	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
 
+	noteActivity(ActivityTask, int64(id), ActivityUpdated)
 	logger.DualLog.Printf("Updated task with ID: %d, title: %s, description: %s", id, title, description)
 	return nil
 }
 
+// UpdateTaskRecord saves every editable field of a task: its title,
+// description, status, priority, due date and assignee. A task whose status
+// changes goes to the bottom of its new board column. It returns
+// sql.ErrNoRows if the task doesn't exist or is deleted.
+func UpdateTaskRecord(task Task) error {
+	logger.DualLog.Printf("Updating task with ID: %d, title: %s, status: %s", task.ID, task.Title, task.Status)
+
+	result, err := DB.Exec(`
+		UPDATE tasks SET title = ?, description = ?, priority = ?, due_date = ?, assignee_id = ?, updated_at = ?,
+			rank = CASE WHEN status = ? THEN rank ELSE (`+lastRankQuery+`) + 1 END,
+			status = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		task.Title, task.Description, task.Priority, taskDueDate(task.DueDate), nullableID(task.AssigneeID), time.Now().UTC(),
+		task.Status, task.Status, task.Status, task.ID)
+	if err != nil {
+		logger.DualLog.Printf("Error updating task: %s", err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	noteActivity(ActivityTask, task.ID, ActivityUpdated)
+	logger.DualLog.Printf("Updated task with ID: %d", task.ID)
+	return nil
+}
+
+// DeleteTask moves a task to the trash. It can be brought back with
+// RestoreTask until the trash is purged. It returns sql.ErrNoRows if the
+// task doesn't exist or is already deleted.
 func DeleteTask(db *sql.DB, id int) error {
 	logger.DualLog.Printf("Deleting task with ID: %d", id)
 
-	stmt, err := db.Prepare("DELETE FROM tasks WHERE id = ?")
+	stmt, err := db.Prepare("UPDATE tasks SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL")
 	if err != nil {
 		logger.DualLog.Printf("Error preparing statement: %s", err.Error())
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	result, err := stmt.Exec(time.Now().UTC(), id)
 	if err != nil {
 		logger.DualLog.Printf("Error executing statement: %s", err.Error())
 		return err
 	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
 
+	noteActivity(ActivityTask, int64(id), ActivityDeleted)
 	logger.DualLog.Printf("Deleted task with ID: %d", id)
 	return nil
 }
@@ -144,8 +523,7 @@ func DeleteTask(db *sql.DB, id int) error {
 func ReadTask(id int) (Task, error) {
 	logger.DualLog.Printf("Reading task with ID: %d", id)
 
-	var task Task
-	err := DB.QueryRow("SELECT id, title, description FROM tasks WHERE id = ?", id).Scan(&task.ID, &task.Title, &task.Description)
+	task, err := scanTask(DB.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ? AND deleted_at IS NULL", id))
 	if err != nil {
 		logger.DualLog.Printf("Error reading task: %s", err.Error())
 		return Task{}, err
@@ -155,10 +533,66 @@ func ReadTask(id int) (Task, error) {
 	return task, nil
 }
 
+// articleColumns lists the columns read by every article query, in the order
+// expected by scanArticle.
+const articleColumns = "id, slug, title, image, preview, text, COALESCE(author_id, 0), prompt, model, published, deleted_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx, so statements can run
+// inside or outside a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// scanArticle reads the articleColumns of a row, followed by any extra
+// columns into extra.
+func scanArticle(row rowScanner, extra ...interface{}) (Article, error) {
+	var article Article
+	var deletedAt sql.NullTime
+	dest := []interface{}{&article.ID, &article.Slug, &article.Title, &article.Image, &article.Preview, &article.Text, &article.AuthorID, &article.Prompt, &article.Model, &article.Published, &deletedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if deletedAt.Valid {
+		article.DeletedAt = &deletedAt.Time
+	}
+	return article, err
+}
+
 func CreateArticle(title, image, preview, text string) (int64, error) {
-	logger.DualLog.Printf("Creating article with title: %s, image: %s, preview: %s, text: %s", title, image, preview, text)
+	return CreateArticleRecord(Article{Title: title, Image: image, Preview: preview, Text: text})
+}
+
+// CreateArticleWithSlug inserts an article under the given slug. An empty slug
+// is derived from the title, and a numeric suffix is appended if it is taken.
+func CreateArticleWithSlug(slug, title, image, preview, text string) (int64, error) {
+	return CreateArticleRecord(Article{Slug: slug, Title: title, Image: image, Preview: preview, Text: text})
+}
+
+// CreateAuthoredArticle inserts an article owned by the given user.
+func CreateAuthoredArticle(authorID int64, title, image, preview, text string) (int64, error) {
+	return CreateArticleRecord(Article{Title: title, Image: image, Preview: preview, Text: text, AuthorID: authorID})
+}
+
+// CreateArticleRecord inserts an article with all of its fields except the
+// ID. The slug is derived from the title when empty and made unique.
+func CreateArticleRecord(article Article) (int64, error) {
+	logger.DualLog.Printf("Creating article with title: %s, image: %s, preview: %s, text: %s, author: %d", article.Title, article.Image, article.Preview, article.Text, article.AuthorID)
 
-	result, err := DB.Exec("INSERT INTO articles(title, image, preview, text) VALUES (?, ?, ?, ?)", title, image, preview, text)
+	slug := article.Slug
+	if slug == "" {
+		slug = Slugify(article.Title)
+	}
+	slug, err := uniqueArticleSlug(slug, 0)
+	if err != nil {
+		logger.DualLog.Printf("Error generating article slug: %s", err.Error())
+		return 0, err
+	}
+
+	author := sql.NullInt64{Int64: article.AuthorID, Valid: article.AuthorID != 0}
+	result, err := DB.Exec("INSERT INTO articles(slug, title, image, preview, text, author_id, prompt, model) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		slug, article.Title, article.Image, article.Preview, article.Text, author, article.Prompt, article.Model)
 	if err != nil {
 		logger.DualLog.Printf("Error creating article: %s", err.Error())
 		return 0, err
@@ -170,15 +604,15 @@ func CreateArticle(title, image, preview, text string) (int64, error) {
 		return 0, err
 	}
 
-	logger.DualLog.Printf("Created article with ID: %d, title: %s, image: %s, preview: %s, text: %s", id, title, image, preview, text)
+	noteActivity(ActivityArticle, id, ActivityCreated)
+	logger.DualLog.Printf("Created article with ID: %d, slug: %s, title: %s", id, slug, article.Title)
 	return id, nil
 }
 
 func ReadArticle(id int64) (Article, error) {
 	logger.DualLog.Printf("Reading article with ID: %d", id)
 
-	var article Article
-	err := DB.QueryRow("SELECT id, title, image, preview, text FROM articles WHERE id = ?", id).Scan(&article.ID, &article.Title, &article.Image, &article.Preview, &article.Text)
+	article, err := scanArticle(DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE id = ? AND deleted_at IS NULL", id))
 	if err != nil {
 		logger.DualLog.Printf("Error reading article: %s", err.Error())
 		return Article{}, err
@@ -188,25 +622,56 @@ func ReadArticle(id int64) (Article, error) {
 	return article, nil
 }
 
-func DeleteArticle(id int64) error {
-	stmt, err := DB.Prepare("DELETE FROM articles WHERE id = ?")
+// GetArticleBySlug returns the article with the given slug, or sql.ErrNoRows.
+func GetArticleBySlug(slug string) (Article, error) {
+	logger.DualLog.Printf("Reading article with slug: %s", slug)
+
+	article, err := scanArticle(DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE slug = ? AND deleted_at IS NULL", slug))
 	if err != nil {
-		return err
+		if err != sql.ErrNoRows {
+			logger.DualLog.Printf("Error reading article: %s", err.Error())
+		}
+		return Article{}, err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	return article, nil
+}
+
+// GetArticleBySlugWithDeleted is GetArticleBySlug including articles in the
+// trash, which keep their slug until they are purged. DeletedAt tells them
+// apart.
+func GetArticleBySlugWithDeleted(slug string) (Article, error) {
+	article, err := scanArticle(DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE slug = ?", slug))
 	if err != nil {
-		return err
+		if err != sql.ErrNoRows {
+			logger.DualLog.Printf("Error reading article: %s", err.Error())
+		}
+		return Article{}, err
 	}
+	return article, nil
+}
 
-	return nil
+// DeleteArticle moves an article to the trash. Its comments and ratings are
+// kept so RestoreArticle can bring it back whole; PurgeArticle removes them.
+func DeleteArticle(id int64) error {
+	return softDeleteArticle(DB, id)
+}
+
+func softDeleteArticle(db execer, id int64) error {
+	result, err := db.Exec("UPDATE articles SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+	return recordActivity(db, Activity{SubjectType: ActivityArticle, SubjectID: id, Action: ActivityDeleted})
 }
 
 // UpdateArticle updates an existing article with the given ID and returns the updated article
 func UpdateArticle(id int64, title, image, preview, text string) (*Article, error) {
 	// Replace this with your own implementation to update the article in the database
-	stmt, err := DB.Prepare("UPDATE articles SET title=?, image=?, preview=?, text=? WHERE id=?")
+	stmt, err := DB.Prepare("UPDATE articles SET title=?, image=?, preview=?, text=? WHERE id=? AND deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +685,7 @@ func UpdateArticle(id int64, title, image, preview, text string) (*Article, erro
 	if err != nil {
 		return nil, err
 	}
+	noteActivity(ActivityArticle, id, ActivityUpdated)
 
 	return &updatedArticle, nil
 
@@ -227,8 +693,23 @@ func UpdateArticle(id int64, title, image, preview, text string) (*Article, erro
 
 func GetArticles() ([]Article, error) {
 	logger.DualLog.Printf("Fetching articles")
+	return queryArticles("SELECT " + articleColumns + " FROM articles WHERE deleted_at IS NULL")
+}
 
-	rows, err := DB.Query("SELECT id, title, image, preview, text FROM articles")
+// GetPublishedArticles returns the articles shown on the public site.
+func GetPublishedArticles() ([]Article, error) {
+	logger.DualLog.Printf("Fetching published articles")
+	return queryArticles("SELECT " + articleColumns + " FROM articles WHERE published = 1 AND deleted_at IS NULL")
+}
+
+// GetArticlesByAuthor returns the articles owned by the given user.
+func GetArticlesByAuthor(authorID int64) ([]Article, error) {
+	logger.DualLog.Printf("Fetching articles by author: %d", authorID)
+	return queryArticles("SELECT "+articleColumns+" FROM articles WHERE author_id = ? AND deleted_at IS NULL ORDER BY id", authorID)
+}
+
+func queryArticles(query string, args ...interface{}) ([]Article, error) {
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		logger.DualLog.Printf("Error fetching articles: %s", err.Error())
 		return nil, err
@@ -237,8 +718,7 @@ func GetArticles() ([]Article, error) {
 
 	var articles []Article
 	for rows.Next() {
-		var article Article
-		err := rows.Scan(&article.ID, &article.Title, &article.Image, &article.Preview, &article.Text)
+		article, err := scanArticle(rows)
 		if err != nil {
 			logger.DualLog.Printf("Error scanning article: %s", err.Error())
 			return nil, err
@@ -261,20 +741,153 @@ func GetArticles() ([]Article, error) {
 func InsertArticle(title, image, preview, text string) (int64, error) {
 	logger.DualLog.Printf("Inserting article with title: %s, image: %s, preview: %s, text: %s", title, image, preview, text)
 
-	result, err := DB.Exec("INSERT INTO articles(title, image, preview, text) VALUES (?, ?, ?, ?)", title, image, preview, text)
+	id, err := CreateArticle(title, image, preview, text)
 	if err != nil {
 		logger.DualLog.Printf("Error inserting article: %s", err.Error())
 		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	logger.DualLog.Printf("Inserted article with ID: %d, title: %s, image: %s, preview: %s, text: %s", id, title, image, preview, text)
+	return id, nil
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a title into a lowercase, hyphen separated URL segment.
+func Slugify(title string) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		slug = "article"
+	}
+	return slug
+}
+
+// uniqueArticleSlug returns slug, or slug with the first free numeric suffix,
+// ignoring the article identified by exceptID.
+func uniqueArticleSlug(slug string, exceptID int64) (string, error) {
+	candidate := slug
+	for i := 2; ; i++ {
+		var count int
+		err := DB.QueryRow("SELECT COUNT(*) FROM articles WHERE slug = ? AND id != ?", candidate, exceptID).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", slug, i)
+	}
+}
+
+// migrateArticleSlugs adds the slug column to databases created before it
+// existed and backfills a slug for every article that lacks one.
+func migrateArticleSlugs() error {
+	err := addColumnIfMissing("articles", "slug", "TEXT NOT NULL DEFAULT ''")
 	if err != nil {
-		logger.DualLog.Printf("Error getting last insert id: %s", err.Error())
-		return 0, err
+		return err
 	}
 
-	logger.DualLog.Printf("Inserted article with ID: %d, title: %s, image: %s, preview: %s, text: %s", id, title, image, preview, text)
-	return id, nil
+	rows, err := DB.Query("SELECT id, title FROM articles WHERE slug = ''")
+	if err != nil {
+		logger.DualLog.Printf("Error fetching articles without slug: %s", err.Error())
+		return err
+	}
+	missing := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			rows.Close()
+			return err
+		}
+		missing[id] = title
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, title := range missing {
+		slug, err := uniqueArticleSlug(Slugify(title), id)
+		if err != nil {
+			return err
+		}
+		if _, err := DB.Exec("UPDATE articles SET slug = ? WHERE id = ?", slug, id); err != nil {
+			logger.DualLog.Printf("Error backfilling slug for article %d: %s", id, err.Error())
+			return err
+		}
+	}
+
+	_, err = DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_articles_slug ON articles(slug)")
+	if err != nil {
+		logger.DualLog.Printf("Error creating article slug index: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so the current columns are checked first.
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		logger.DualLog.Printf("Error reading columns of %s: %s", table, err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		logger.DualLog.Printf("Error adding column %s to %s: %s", column, table, err.Error())
+		return err
+	}
+
+	logger.DualLog.Printf("Added column %s to %s", column, table)
+	return nil
+}
+
+// createUserTables creates the account tables used by registration and login
+// when they haven't been created by a migration.
+func createUserTables() error {
+	createTablesQuery := `
+		CREATE TABLE IF NOT EXISTS user_account_6007 (
+			UserId INTEGER PRIMARY KEY AUTOINCREMENT,
+			RoleId INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS user_login_data_4231 (
+			UserId INTEGER PRIMARY KEY REFERENCES user_account_6007(UserId),
+			PasswordHash TEXT NOT NULL,
+			EmailAddress TEXT NOT NULL UNIQUE
+		);
+	`
+
+	_, err := DB.Exec(createTablesQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating user tables: %s", err.Error())
+		return err
+	}
+	return nil
 }
 
 // Add this function to create the frontend_logs table
@@ -411,8 +1024,13 @@ func CreateUser(user User) (int64, error) {
 		return 0, err
 	}
 
+	roleID := user.RoleId
+	if roleID == 0 {
+		roleID = DefaultRole
+	}
+
 	// Insert data into user_account_6007 table
-	result, err := tx.Exec(`INSERT INTO user_account_6007 (RoleId) VALUES (?)`, user.UserId)
+	result, err := tx.Exec(`INSERT INTO user_account_6007 (RoleId) VALUES (?)`, roleID)
 	if err != nil {
 		tx.Rollback()
 		logger.DualLog.Printf("Error creating user: %s", err.Error())
@@ -435,6 +1053,12 @@ func CreateUser(user User) (int64, error) {
 		return 0, err
 	}
 
+	err = recordActivity(tx, Activity{SubjectType: ActivityUser, SubjectID: userId, SubjectTitle: user.DisplayName(), Action: ActivityCreated})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		logger.DualLog.Printf("Error committing transaction: %s", err.Error())
@@ -445,18 +1069,19 @@ func CreateUser(user User) (int64, error) {
 	return userId, nil
 }
 
-// GetUserByEmail retrieves a user from the database using their email
+// GetUserByEmail retrieves a user from the database using their email,
+// ignoring case
 func GetUserByEmail(email string) (User, error) {
 	var user User
 
 	query := `SELECT uld.UserId, uld.PasswordHash, uld.EmailAddress
         FROM user_login_data_4231 AS uld
-        WHERE uld.EmailAddress = ?`
+        WHERE uld.EmailAddress = ? COLLATE NOCASE`
 
 	err := DB.QueryRow(query, email).Scan(&user.UserId, &user.PasswordHash, &user.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return User{}, fmt.Errorf("User not found with email: %s", email)
+			return User{}, fmt.Errorf("User not found with email: %s: %w", email, err)
 		}
 		return User{}, fmt.Errorf("Error getting user by email: %v", err)
 	}
@@ -480,3 +1105,126 @@ func GetUserByID(userID int64) (User, error) {
 
 	return user, nil
 }
+
+// GetUserRoleID returns the RoleId stored for the user's account.
+func GetUserRoleID(userID int64) (int64, error) {
+	var roleID int64
+	err := DB.QueryRow("SELECT RoleId FROM user_account_6007 WHERE UserId = ?", userID).Scan(&roleID)
+	if err != nil {
+		return 0, err
+	}
+	return roleID, nil
+}
+
+// GetEmailVerifiedAt returns when the user verified their email address, or
+// nil if they haven't.
+func GetEmailVerifiedAt(userID int64) (*time.Time, error) {
+	var verifiedAt sql.NullTime
+	err := DB.QueryRow("SELECT email_verified_at FROM user_login_data_4231 WHERE UserId = ?", userID).Scan(&verifiedAt)
+	if err != nil || !verifiedAt.Valid {
+		return nil, err
+	}
+	return &verifiedAt.Time, nil
+}
+
+// SetEmailVerified records that the user has verified their email address,
+// as long as it still is email; verifying again keeps the first time. It
+// returns sql.ErrNoRows if the user doesn't have that address.
+func SetEmailVerified(userID int64, email string, at time.Time) error {
+	logger.DualLog.Printf("Marking email address of user %d verified", userID)
+
+	result, err := DB.Exec(`UPDATE user_login_data_4231 SET email_verified_at = COALESCE(email_verified_at, ?)
+		WHERE UserId = ? AND EmailAddress = ? COLLATE NOCASE`, at.UTC(), userID, email)
+	if err != nil {
+		logger.DualLog.Printf("Error marking email address verified: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// SetUserRole changes a user's role. It returns sql.ErrNoRows if there is no
+// such user.
+func SetUserRole(userID, roleID int64) error {
+	logger.DualLog.Printf("Setting role of user %d to %d", userID, roleID)
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE user_account_6007 SET RoleId = ? WHERE UserId = ? AND RoleId != ?", roleID, userID, roleID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error setting user role: %s", err.Error())
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// They already have the role
+		return tx.Rollback()
+	}
+	err = recordActivity(tx, Activity{SubjectType: ActivityUser, SubjectID: userID, SubjectTitle: user.DisplayName(), Action: ActivityUpdated, Detail: RoleNames[roleID]})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountUsersWithRole returns how many users have a role.
+func CountUsersWithRole(roleID int64) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM user_account_6007 WHERE RoleId = ?", roleID).Scan(&count)
+	return count, err
+}
+
+// migrateUserRoles gives a role to accounts created before roles were
+// enforced, which were stored with RoleId 0. They become authors, so they
+// can still write the articles and tasks they could before.
+func migrateUserRoles() error {
+	result, err := DB.Exec("UPDATE user_account_6007 SET RoleId = ? WHERE RoleId = 0", RoleAuthor)
+	if err != nil {
+		logger.DualLog.Printf("Error migrating user roles: %s", err.Error())
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		logger.DualLog.Printf("Made %d existing users authors", affected)
+	}
+	return nil
+}
+
+// GetUsers lists every user by email address. Password hashes aren't read.
+func GetUsers() ([]User, error) {
+	rows, err := DB.Query("SELECT UserId, EmailAddress FROM user_login_data_4231 ORDER BY EmailAddress")
+	if err != nil {
+		logger.DualLog.Printf("Error fetching users: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.UserId, &user.Email); err != nil {
+			logger.DualLog.Printf("Error scanning user: %s", err.Error())
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// requireAffected returns sql.ErrNoRows if a statement changed no rows.
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
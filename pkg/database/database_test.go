@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"io/ioutil"
 	"log"
 	"testing"
@@ -41,7 +42,7 @@ func TestGetUserByEmail(t *testing.T) {
 	mock.ExpectQuery("SELECT uld.UserId, uld.PasswordHash, uld.EmailAddress FROM user_login_data_4231 AS uld WHERE uld.EmailAddress = ?").WithArgs(email).WillReturnError(sql.ErrNoRows)
 
 	_, err = GetUserByEmail(email)
-	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, sql.ErrNoRows), "A missing user should be reported as sql.ErrNoRows")
 }
 
 func TestCreateUser(t *testing.T) {
@@ -59,12 +60,17 @@ func TestCreateUser(t *testing.T) {
 	mock.ExpectBegin()
 
 	mock.ExpectExec("INSERT INTO user_account_6007").
+		WithArgs(DefaultRole).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectExec("INSERT INTO user_login_data_4231").
 		WithArgs(1, "hashedpassword", "john.doe@example.com").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
+	mock.ExpectExec("INSERT INTO activity").
+		WithArgs(ActivityUser, 1, "john.doe", ActivityCreated, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	mock.ExpectCommit()
 
 	testUser := User{
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// ExternalIdentity links a user to their account at an identity provider
+// they sign in with, such as the company's OpenID Connect provider.
+type ExternalIdentity struct {
+	ID int64
+	// Provider is the name the provider is configured under
+	Provider string
+	// Subject is the provider's ID for the account, which never changes
+	Subject string
+	UserID  int64
+	// Email is the address the provider gave when the account was linked
+	Email     string
+	CreatedAt time.Time
+}
+
+// createExternalIdentitiesTable creates the table of accounts at identity
+// providers that users sign in with.
+func createExternalIdentitiesTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS external_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES user_account_6007(UserId),
+			email TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE(provider, subject)
+		);
+		CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities(user_id);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating external_identities table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// LinkExternalIdentity links an account at an identity provider to a user.
+// An account can only be linked to one user.
+func LinkExternalIdentity(identity ExternalIdentity) (int64, error) {
+	logger.DualLog.Printf("Linking %s account %s to user %d", identity.Provider, identity.Subject, identity.UserID)
+
+	result, err := DB.Exec(`
+		INSERT INTO external_identities(provider, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt.UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error linking external identity: %s", err.Error())
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const externalIdentityColumns = "id, provider, subject, user_id, email, created_at"
+
+func scanExternalIdentity(row rowScanner) (ExternalIdentity, error) {
+	var identity ExternalIdentity
+	err := row.Scan(&identity.ID, &identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	return identity, err
+}
+
+// GetExternalIdentity returns the link to a provider's account, or
+// sql.ErrNoRows if it isn't linked to anyone.
+func GetExternalIdentity(provider, subject string) (ExternalIdentity, error) {
+	return scanExternalIdentity(DB.QueryRow("SELECT "+externalIdentityColumns+" FROM external_identities WHERE provider = ? AND subject = ?",
+		provider, subject))
+}
+
+// GetExternalIdentities lists the provider accounts linked to a user.
+func GetExternalIdentities(userID int64) ([]ExternalIdentity, error) {
+	rows, err := DB.Query("SELECT "+externalIdentityColumns+" FROM external_identities WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching external identities: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []ExternalIdentity
+	for rows.Next() {
+		identity, err := scanExternalIdentity(rows)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning external identity: %s", err.Error())
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
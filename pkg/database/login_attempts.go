@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// Outcomes of a sign-in attempt
+const (
+	LoginSucceeded = "succeeded"
+	// LoginNeedsCode means the password was right and a two-factor code
+	// was asked for
+	LoginNeedsCode   = "needs_code"
+	LoginBadCode     = "bad_code"
+	LoginBadPassword = "bad_password"
+	LoginNoSuchUser  = "unknown_user"
+	LoginLockedOut   = "locked_out"
+)
+
+// LoginAttempt is a record of someone trying to sign in, kept for auditing.
+// UserID is 0 when the email address doesn't belong to anyone.
+type LoginAttempt struct {
+	ID        int64
+	Email     string
+	UserID    int64
+	IPAddress string
+	UserAgent string
+	Outcome   string
+	CreatedAt time.Time
+}
+
+// createLoginAttemptsTable creates the audit log of sign-in attempts.
+func createLoginAttemptsTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email TEXT NOT NULL,
+			user_id INTEGER REFERENCES user_account_6007(UserId),
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			outcome TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating login_attempts table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// RecordLoginAttempt adds a sign-in attempt to the audit log.
+func RecordLoginAttempt(attempt LoginAttempt) error {
+	_, err := DB.Exec(`
+		INSERT INTO login_attempts(email, user_id, ip_address, user_agent, outcome, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		attempt.Email, nullableID(attempt.UserID), attempt.IPAddress, attempt.UserAgent, attempt.Outcome, attempt.CreatedAt.UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error recording login attempt: %s", err.Error())
+	}
+	return err
+}
+
+// GetLoginAttempts returns the most recent sign-in attempts, newest first,
+// for one email address or, if email is empty, for all of them.
+func GetLoginAttempts(email string, limit int) ([]LoginAttempt, error) {
+	rows, err := DB.Query(`
+		SELECT id, email, user_id, ip_address, user_agent, outcome, created_at FROM login_attempts
+		WHERE ? = '' OR email = ?
+		ORDER BY created_at DESC, id DESC LIMIT ?`, email, email, limit)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching login attempts: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		var userID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.Email, &userID, &a.IPAddress, &a.UserAgent, &a.Outcome, &a.CreatedAt); err != nil {
+			logger.DualLog.Printf("Error scanning login attempt: %s", err.Error())
+			return nil, err
+		}
+		a.UserID = userID.Int64
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
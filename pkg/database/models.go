@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -13,16 +14,126 @@ type Database interface {
 
 type Article struct {
 	ID      int64
+	Slug    string
 	Title   string
 	Image   string
 	Preview string
 	Text    string
+	// AuthorID is the owning user, or 0 for articles created anonymously
+	AuthorID int64
+	// Prompt and Model record how a generated article was produced; both
+	// are empty for articles written by hand
+	Prompt string
+	Model  string
+	// Published articles are listed on the public site; new and existing
+	// articles are published by default
+	Published bool
+	// DeletedAt is set while the article is in the trash
+	DeletedAt *time.Time
 }
 
 type Task struct {
 	ID          int64
 	Title       string
 	Description string
+	// Status is one of TaskTodo, TaskDoing or TaskDone
+	Status string
+	// Priority is one of TaskPriorityLow, TaskPriorityMedium or
+	// TaskPriorityHigh
+	Priority string
+	// DueDate is the day the task is due, or nil if it has no due date
+	DueDate *time.Time
+	// AssigneeID is the user working on the task, or 0 if it's unassigned
+	AssigneeID int64
+	// Rank orders the tasks within a board column, lowest first. Ranks are
+	// fractional so a move only rewrites the task being moved.
+	Rank      float64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// DeletedAt is set while the task is in the trash
+	DeletedAt *time.Time
+	// TemplateID is the recurring task template the task was generated
+	// from, and OccurrenceDate the occurrence it's for; both are unset for
+	// tasks created by hand
+	TemplateID     int64
+	OccurrenceDate *time.Time
+}
+
+// TaskTemplate describes a recurring task. Its rule is an RFC 5545 RRULE
+// subset, parsed by the rrule package.
+type TaskTemplate struct {
+	ID          int64
+	Title       string
+	Description string
+	Priority    string
+	AssigneeID  int64
+	Rule        string
+	// StartDate is the first day the rule can occur on
+	StartDate time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Task statuses, in workflow order
+const (
+	TaskTodo  = "todo"
+	TaskDoing = "doing"
+	TaskDone  = "done"
+)
+
+// TaskStatuses lists the task statuses in workflow order.
+var TaskStatuses = []string{TaskTodo, TaskDoing, TaskDone}
+
+// Task priorities, lowest first
+const (
+	TaskPriorityLow    = "low"
+	TaskPriorityMedium = "medium"
+	TaskPriorityHigh   = "high"
+)
+
+// TaskPriorities lists the task priorities, lowest first.
+var TaskPriorities = []string{TaskPriorityLow, TaskPriorityMedium, TaskPriorityHigh}
+
+// TaskDueDateFormat is the layout of tasks.due_date.
+const TaskDueDateFormat = "2006-01-02"
+
+// Orders accepted by TaskFilter.Sort
+const (
+	TaskSortID        = "id"
+	TaskSortTitle     = "title"
+	TaskSortStatus    = "status"
+	TaskSortPriority  = "priority"
+	TaskSortDueDate   = "due_date"
+	TaskSortCreatedAt = "created_at"
+	TaskSortUpdatedAt = "updated_at"
+	TaskSortRank      = "rank"
+)
+
+// TaskFilter selects a page of tasks for ListTasks. Zero values match every
+// task. The After bounds are inclusive and the Before bounds exclusive.
+type TaskFilter struct {
+	// Query matches tasks whose title or description contains it
+	Query    string
+	Status   string
+	Priority string
+	// AssigneeID matches the tasks assigned to a user; Unassigned matches
+	// the tasks assigned to nobody instead
+	AssigneeID int64
+	Unassigned bool
+	// HasDueDate matches only the tasks with a due date
+	HasDueDate    bool
+	DueAfter      *time.Time
+	DueBefore     *time.Time
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	// Sort is one of the TaskSort orders, TaskSortID if empty. Tasks
+	// without a due date sort last by due date in either direction.
+	Sort       string
+	Descending bool
+	Limit      int
+	Offset     int
 }
 
 type FrontendLog struct {
@@ -35,6 +146,37 @@ type User struct {
 	UserId       int64
 	Email        string
 	PasswordHash string
+	// RoleId is only used when creating a user; 0 gives them DefaultRole
+	RoleId int64
+}
+
+// Role IDs stored in user_account_6007.RoleId
+const (
+	RoleAdmin  int64 = 1
+	RoleEditor int64 = 2
+	RoleAuthor int64 = 3
+	RoleViewer int64 = 4
+)
+
+// DefaultRole is given to users who sign themselves up. An admin can
+// promote them.
+const DefaultRole = RoleViewer
+
+// RoleNames names each role, as used in access tokens and the API.
+var RoleNames = map[int64]string{
+	RoleAdmin:  "admin",
+	RoleEditor: "editor",
+	RoleAuthor: "author",
+	RoleViewer: "viewer",
+}
+
+// DisplayName is the public name shown for a user, the local part of their
+// email address.
+func (u User) DisplayName() string {
+	if i := strings.Index(u.Email, "@"); i > 0 {
+		return u.Email[:i]
+	}
+	return u.Email
 }
 
 type NewUser struct {
@@ -42,3 +184,46 @@ type NewUser struct {
 	Password             string
 	PasswordConfirmation string
 }
+
+type Comment struct {
+	ID        int64
+	ArticleID int64
+	// ParentID is the comment being replied to, or 0 for a top-level comment
+	ParentID int64
+	// AuthorID is the signed-in commenter, or 0 for a guest
+	AuthorID  int64
+	GuestName string
+	Body      string
+	Status    string
+	CreatedAt time.Time
+}
+
+// Comment statuses
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+)
+
+// Ratings are scored from MinRatingScore to MaxRatingScore. Thumbs up and
+// down are stored as the maximum and minimum.
+const (
+	MinRatingScore = 1
+	MaxRatingScore = 5
+)
+
+// PromptRating aggregates the ratings of articles generated with a prompt
+// and model.
+type PromptRating struct {
+	Prompt   string
+	Model    string
+	Articles int
+	Ratings  int
+	Average  float64
+}
+
+// PopularArticle is an article with its views over a period.
+type PopularArticle struct {
+	Article Article
+	Views   int
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// createPasswordResetTable creates the table of password reset tokens. Only
+// a hash of each token is stored.
+func createPasswordResetTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES user_account_6007(UserId),
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating password_reset_tokens table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// CreatePasswordResetToken stores the hash of a password reset token for a
+// user, valid until expiresAt. Their earlier unused tokens stop working, so
+// only the latest email's link resets the password.
+func CreatePasswordResetToken(userID int64, tokenHash string, createdAt, expiresAt time.Time) error {
+	logger.DualLog.Printf("Creating password reset token for user %d", userID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting password reset tokens: %s", err.Error())
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO password_reset_tokens(token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, userID, createdAt.UTC(), expiresAt.UTC())
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error storing password reset token: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetPassword uses up the password reset token with the given hash and
+// sets its user's password hash, returning the user's ID. It returns
+// sql.ErrNoRows if the token is unknown, used or had expired by now.
+func ResetPassword(tokenHash, passwordHash string, now time.Time) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var userID int64
+	err = tx.QueryRow("SELECT user_id FROM password_reset_tokens WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		tokenHash, now.UTC()).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	// Two requests racing with the same token can't both use it
+	result, err := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL", now.UTC(), tokenHash)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error using password reset token: %s", err.Error())
+		return 0, err
+	}
+	if err := requireAffected(result); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE user_login_data_4231 SET PasswordHash = ? WHERE UserId = ?", passwordHash, userID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error updating password: %s", err.Error())
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting password reset tokens: %s", err.Error())
+		return 0, err
+	}
+	logger.DualLog.Printf("Reset password of user %d", userID)
+	return userID, tx.Commit()
+}
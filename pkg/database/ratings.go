@@ -0,0 +1,121 @@
+package database
+
+import (
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+func createRatingsTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS article_ratings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER NOT NULL REFERENCES articles(id),
+			rater TEXT NOT NULL,
+			score INTEGER NOT NULL CHECK (score BETWEEN 1 AND 5),
+			prompt TEXT NOT NULL DEFAULT '',
+			model TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			UNIQUE (article_id, rater)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_ratings_prompt ON article_ratings(prompt, model);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating article_ratings table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// RateArticle stores a reader's score for an article, replacing any score the
+// same rater gave before. The article's prompt and model are copied onto the
+// rating so the report still groups it correctly if the article is edited.
+func RateArticle(articleID int64, rater string, score int) error {
+	logger.DualLog.Printf("Rating article %d with score %d", articleID, score)
+
+	_, err := DB.Exec(`
+		INSERT INTO article_ratings(article_id, rater, score, prompt, model, created_at)
+		SELECT id, ?, ?, prompt, model, ? FROM articles WHERE id = ?
+		ON CONFLICT(article_id, rater) DO UPDATE SET score = excluded.score, created_at = excluded.created_at`,
+		rater, score, time.Now().UTC(), articleID)
+	if err != nil {
+		logger.DualLog.Printf("Error rating article: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetArticleRating returns the average score and number of ratings of an
+// article. The average is 0 when it has no ratings.
+func GetArticleRating(articleID int64) (float64, int, error) {
+	var average float64
+	var count int
+	err := DB.QueryRow("SELECT COALESCE(AVG(score), 0), COUNT(*) FROM article_ratings WHERE article_id = ?", articleID).Scan(&average, &count)
+	if err != nil {
+		logger.DualLog.Printf("Error reading rating of article %d: %s", articleID, err.Error())
+		return 0, 0, err
+	}
+	return average, count, nil
+}
+
+// GetRaterScore returns the score a rater gave an article, or 0 if they
+// haven't rated it.
+func GetRaterScore(articleID int64, rater string) (int, error) {
+	var score int
+	err := DB.QueryRow("SELECT COALESCE(MAX(score), 0) FROM article_ratings WHERE article_id = ? AND rater = ?", articleID, rater).Scan(&score)
+	if err != nil {
+		logger.DualLog.Printf("Error reading score of article %d: %s", articleID, err.Error())
+		return 0, err
+	}
+	return score, nil
+}
+
+// Groupings accepted by GetPromptRatings.
+const (
+	GroupByPrompt         = "prompt"
+	GroupByModel          = "model"
+	GroupByPromptAndModel = "prompt_model"
+)
+
+// GetPromptRatings ranks the prompts and models that generated rated
+// articles by average score, then by number of ratings. Groups with fewer
+// than minRatings ratings are left out. Articles written by hand have no
+// prompt and aren't included.
+func GetPromptRatings(groupBy string, minRatings int) ([]PromptRating, error) {
+	var prompt, model string
+	switch groupBy {
+	case GroupByPrompt:
+		prompt, model = "prompt", "''"
+	case GroupByModel:
+		prompt, model = "''", "model"
+	default:
+		prompt, model = "prompt", "model"
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+prompt+`, `+model+`, COUNT(DISTINCT article_id), COUNT(*), AVG(score)
+		FROM article_ratings
+		WHERE prompt != '' AND article_id IN (SELECT id FROM articles WHERE deleted_at IS NULL)
+		GROUP BY 1, 2
+		HAVING COUNT(*) >= ?
+		ORDER BY AVG(score) DESC, COUNT(*) DESC, 1, 2`, minRatings)
+	if err != nil {
+		logger.DualLog.Printf("Error reading prompt ratings: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratings []PromptRating
+	for rows.Next() {
+		var rating PromptRating
+		err := rows.Scan(&rating.Prompt, &rating.Model, &rating.Articles, &rating.Ratings, &rating.Average)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning prompt rating: %s", err.Error())
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+	return ratings, rows.Err()
+}
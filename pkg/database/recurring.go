@@ -0,0 +1,208 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// createTaskTemplatesTable creates the task_templates table and the tasks
+// columns linking each generated task to its template. The unique index on
+// (template_id, occurrence_date) makes generating an occurrence idempotent.
+func createTaskTemplatesTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS task_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			priority TEXT NOT NULL DEFAULT 'medium',
+			assignee_id INTEGER REFERENCES user_account_6007(UserId),
+			rrule TEXT NOT NULL,
+			start_date DATE NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating task_templates table: %s", err.Error())
+		return err
+	}
+
+	err = addColumnIfMissing("tasks", "template_id", "INTEGER REFERENCES task_templates(id)")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing("tasks", "occurrence_date", "DATE")
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_occurrence ON tasks(template_id, occurrence_date)")
+	if err != nil {
+		logger.DualLog.Printf("Error creating tasks occurrence index: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+const taskTemplateColumns = "id, title, description, priority, COALESCE(assignee_id, 0), rrule, start_date, created_at, updated_at"
+
+func scanTaskTemplate(row rowScanner) (TaskTemplate, error) {
+	var template TaskTemplate
+	err := row.Scan(&template.ID, &template.Title, &template.Description, &template.Priority, &template.AssigneeID,
+		&template.Rule, &template.StartDate, &template.CreatedAt, &template.UpdatedAt)
+	return template, err
+}
+
+// CreateTaskTemplate stores a recurring task template and returns its ID.
+// The rule isn't checked here.
+func CreateTaskTemplate(template TaskTemplate) (int64, error) {
+	logger.DualLog.Printf("Creating task template %q with rule %s", template.Title, template.Rule)
+
+	if template.Priority == "" {
+		template.Priority = TaskPriorityMedium
+	}
+	now := time.Now().UTC()
+	result, err := DB.Exec(`
+		INSERT INTO task_templates(title, description, priority, assignee_id, rrule, start_date, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		template.Title, template.Description, template.Priority, nullableID(template.AssigneeID), template.Rule,
+		template.StartDate.Format(TaskDueDateFormat), now, now)
+	if err != nil {
+		logger.DualLog.Printf("Error creating task template: %s", err.Error())
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetTaskTemplate returns a task template, or sql.ErrNoRows.
+func GetTaskTemplate(id int64) (TaskTemplate, error) {
+	template, err := scanTaskTemplate(DB.QueryRow("SELECT "+taskTemplateColumns+" FROM task_templates WHERE id = ?", id))
+	if err != nil && err != sql.ErrNoRows {
+		logger.DualLog.Printf("Error reading task template %d: %s", id, err.Error())
+	}
+	return template, err
+}
+
+// GetTaskTemplates lists every task template in the order they were created.
+func GetTaskTemplates() ([]TaskTemplate, error) {
+	rows, err := DB.Query("SELECT " + taskTemplateColumns + " FROM task_templates ORDER BY id")
+	if err != nil {
+		logger.DualLog.Printf("Error fetching task templates: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []TaskTemplate
+	for rows.Next() {
+		template, err := scanTaskTemplate(rows)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning task template: %s", err.Error())
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+// UpdateTaskTemplate saves a template's fields. Tasks already generated
+// from it are left as they are. It returns sql.ErrNoRows if the template
+// doesn't exist.
+func UpdateTaskTemplate(template TaskTemplate) error {
+	logger.DualLog.Printf("Updating task template %d", template.ID)
+
+	result, err := DB.Exec(`
+		UPDATE task_templates SET title = ?, description = ?, priority = ?, assignee_id = ?, rrule = ?, start_date = ?, updated_at = ?
+		WHERE id = ?`,
+		template.Title, template.Description, template.Priority, nullableID(template.AssigneeID), template.Rule,
+		template.StartDate.Format(TaskDueDateFormat), time.Now().UTC(), template.ID)
+	if err != nil {
+		logger.DualLog.Printf("Error updating task template: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// DeleteTaskTemplate stops a task recurring. The tasks generated from it
+// are kept as ordinary tasks. It returns sql.ErrNoRows if the template
+// doesn't exist.
+func DeleteTaskTemplate(id int64) error {
+	logger.DualLog.Printf("Deleting task template %d", id)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE tasks SET template_id = NULL WHERE template_id = ?", id); err != nil {
+		logger.DualLog.Printf("Error detaching tasks from template %d: %s", id, err.Error())
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM task_templates WHERE id = ?", id)
+	if err != nil {
+		logger.DualLog.Printf("Error deleting task template %d: %s", id, err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TemplateOccurrence is the latest task generated from a template.
+type TemplateOccurrence struct {
+	Date time.Time
+	// Open is true until the task is done or deleted
+	Open bool
+}
+
+// GetLastOccurrence returns the latest task generated from a template, by
+// occurrence date, including tasks in the trash. It reports false if none
+// has been generated yet.
+func GetLastOccurrence(templateID int64) (TemplateOccurrence, bool, error) {
+	var occurrence TemplateOccurrence
+	err := DB.QueryRow(`
+		SELECT occurrence_date, status != ? AND deleted_at IS NULL FROM tasks
+		WHERE template_id = ? ORDER BY occurrence_date DESC LIMIT 1`, TaskDone, templateID).Scan(&occurrence.Date, &occurrence.Open)
+	if err == sql.ErrNoRows {
+		return TemplateOccurrence{}, false, nil
+	}
+	if err != nil {
+		logger.DualLog.Printf("Error reading last occurrence of template %d: %s", templateID, err.Error())
+		return TemplateOccurrence{}, false, err
+	}
+	return occurrence, true, nil
+}
+
+// CreateOccurrence adds the task for one occurrence of a template, due on
+// that date, at the bottom of the todo column. It reports false without
+// changing anything if the occurrence was already generated.
+func CreateOccurrence(template TaskTemplate, date time.Time) (bool, error) {
+	day := date.Format(TaskDueDateFormat)
+	now := time.Now().UTC()
+	result, err := DB.Exec(`
+		INSERT INTO tasks(title, description, status, priority, due_date, assignee_id, rank, created_at, updated_at, template_id, occurrence_date)
+		VALUES (?, ?, ?, ?, ?, ?, (`+lastRankQuery+`) + 1, ?, ?, ?, ?)
+		ON CONFLICT(template_id, occurrence_date) DO NOTHING`,
+		template.Title, template.Description, TaskTodo, template.Priority, day, nullableID(template.AssigneeID),
+		TaskTodo, now, now, template.ID, day)
+	if err != nil {
+		logger.DualLog.Printf("Error creating occurrence %s of template %d: %s", day, template.ID, err.Error())
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
+		logger.DualLog.Printf("Created occurrence %s of task template %d", day, template.ID)
+		if id, err := result.LastInsertId(); err == nil {
+			recordActivity(DB, Activity{SubjectType: ActivityTask, SubjectID: id, Action: ActivityCreated, Detail: activityRecurring})
+		}
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// Session is a sign-in on one device. It lasts as long as its refresh
+// tokens keep being exchanged before ExpiresAt, until it is revoked.
+type Session struct {
+	ID         string
+	UserID     int64
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// Active reports whether the session can still be used at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RefreshToken is a single-use token that renews a session. Only a hash of
+// it is stored. UsedAt is set once it has been exchanged for the next one.
+type RefreshToken struct {
+	TokenHash string
+	SessionID string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// createSessionTables creates the sessions table and the refresh tokens
+// issued for them. The tokens of a session form a family: each replaces
+// the one before.
+func createSessionTables() error {
+	createTablesQuery := `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES user_account_6007(UserId),
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL REFERENCES sessions(id),
+			created_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+	`
+
+	_, err := DB.Exec(createTablesQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating session tables: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// CreateSession stores a new session along with the hash of its first
+// refresh token.
+func CreateSession(session Session, tokenHash string) error {
+	logger.DualLog.Printf("Creating session for user %d", session.UserID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO sessions(id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.UserAgent, session.IPAddress,
+		session.CreatedAt.UTC(), session.LastUsedAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error creating session: %s", err.Error())
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO refresh_tokens(token_hash, session_id, created_at) VALUES (?, ?, ?)",
+		tokenHash, session.ID, session.CreatedAt.UTC())
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error storing refresh token: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+const sessionColumns = "id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at"
+
+func scanSession(row rowScanner) (Session, error) {
+	var s Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &revokedAt)
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return s, err
+}
+
+// GetSession returns a session, revoked or not, or sql.ErrNoRows.
+func GetSession(id string) (Session, error) {
+	return scanSession(DB.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id))
+}
+
+// GetActiveSessions lists the user's sessions that are neither revoked nor
+// expired at now, most recently used first.
+func GetActiveSessions(userID int64, now time.Time) ([]Session, error) {
+	rows, err := DB.Query("SELECT "+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC`, userID, now.UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error fetching sessions: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			logger.DualLog.Printf("Error scanning session: %s", err.Error())
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// GetRefreshToken returns the refresh token with the given hash, or
+// sql.ErrNoRows.
+func GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	var token RefreshToken
+	var usedAt sql.NullTime
+	err := DB.QueryRow("SELECT token_hash, session_id, created_at, used_at FROM refresh_tokens WHERE token_hash = ?", tokenHash).
+		Scan(&token.TokenHash, &token.SessionID, &token.CreatedAt, &usedAt)
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return token, err
+}
+
+// RotateRefreshToken marks a refresh token used and stores the one that
+// replaces it, extending its session to expiresAt. It returns sql.ErrNoRows
+// if the old token has already been used, so two clients racing with the
+// same token can't both succeed.
+func RotateRefreshToken(oldHash, newHash string, session Session, now, expiresAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL", now.UTC(), oldHash)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error using refresh token: %s", err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO refresh_tokens(token_hash, session_id, created_at) VALUES (?, ?, ?)", newHash, session.ID, now.UTC())
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error storing refresh token: %s", err.Error())
+		return err
+	}
+	_, err = tx.Exec("UPDATE sessions SET last_used_at = ?, expires_at = ?, user_agent = ?, ip_address = ? WHERE id = ?",
+		now.UTC(), expiresAt.UTC(), session.UserAgent, session.IPAddress, session.ID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error updating session: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeSession ends one of a user's sessions. It returns sql.ErrNoRows if
+// they have no such active session.
+func RevokeSession(userID int64, id string) error {
+	logger.DualLog.Printf("Revoking session %s of user %d", id, userID)
+
+	result, err := DB.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, userID)
+	if err != nil {
+		logger.DualLog.Printf("Error revoking session: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// RevokeUserSessions ends all of a user's sessions and returns how many it
+// revoked.
+func RevokeUserSessions(userID int64) (int64, error) {
+	logger.DualLog.Printf("Revoking all sessions of user %d", userID)
+
+	result, err := DB.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().UTC(), userID)
+	if err != nil {
+		logger.DualLog.Printf("Error revoking sessions: %s", err.Error())
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteEndedSessions removes a user's sessions that were revoked or had
+// expired by now, with their refresh tokens.
+func DeleteEndedSessions(userID int64, now time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	const ended = "SELECT id FROM sessions WHERE user_id = ? AND (revoked_at IS NOT NULL OR expires_at <= ?)"
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE session_id IN ("+ended+")", userID, now.UTC())
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM sessions WHERE id IN ("+ended+")", userID, now.UTC())
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting ended sessions: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
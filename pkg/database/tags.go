@@ -0,0 +1,97 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+func createArticleTagsTable() error {
+	createTableQuery := `
+		CREATE TABLE IF NOT EXISTS article_tags (
+			article_id INTEGER NOT NULL REFERENCES articles(id),
+			tag TEXT NOT NULL,
+			PRIMARY KEY (article_id, tag)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_tags_tag ON article_tags(tag);
+	`
+
+	_, err := DB.Exec(createTableQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating article_tags table: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// NormalizeTag trims and lowercases a tag so "Go " and "go" are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// GetArticleTags returns an article's tags in alphabetical order.
+func GetArticleTags(articleID int64) ([]string, error) {
+	rows, err := DB.Query("SELECT tag FROM article_tags WHERE article_id = ? ORDER BY tag", articleID)
+	if err != nil {
+		logger.DualLog.Printf("Error fetching tags of article %d: %s", articleID, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func addArticleTags(db execer, articleID int64, tags []string) error {
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag == "" {
+			continue
+		}
+		_, err := db.Exec("INSERT OR IGNORE INTO article_tags(article_id, tag) VALUES (?, ?)", articleID, tag)
+		if err != nil {
+			logger.DualLog.Printf("Error tagging article %d: %s", articleID, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func removeArticleTags(db execer, articleID int64, tags []string) error {
+	for _, tag := range tags {
+		_, err := db.Exec("DELETE FROM article_tags WHERE article_id = ? AND tag = ?", articleID, NormalizeTag(tag))
+		if err != nil {
+			logger.DualLog.Printf("Error untagging article %d: %s", articleID, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllArticleTags returns the tags of every article, keyed by article ID,
+// with each article's tags in alphabetical order.
+func GetAllArticleTags() (map[int64][]string, error) {
+	rows, err := DB.Query("SELECT article_id, tag FROM article_tags ORDER BY article_id, tag")
+	if err != nil {
+		logger.DualLog.Printf("Error fetching article tags: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[int64][]string{}
+	for rows.Next() {
+		var articleID int64
+		var tag string
+		if err := rows.Scan(&articleID, &tag); err != nil {
+			return nil, err
+		}
+		tags[articleID] = append(tags[articleID], tag)
+	}
+	return tags, rows.Err()
+}
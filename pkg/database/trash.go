@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// GetDeletedArticle returns an article in the trash, or sql.ErrNoRows.
+func GetDeletedArticle(id int64) (Article, error) {
+	article, err := scanArticle(DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE id = ? AND deleted_at IS NOT NULL", id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.DualLog.Printf("Error reading deleted article: %s", err.Error())
+		}
+		return Article{}, err
+	}
+	return article, nil
+}
+
+// GetDeletedArticles lists the trash, most recently deleted first.
+func GetDeletedArticles() ([]Article, error) {
+	logger.DualLog.Printf("Fetching deleted articles")
+	return queryArticles("SELECT " + articleColumns + " FROM articles WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+}
+
+// RestoreArticle takes an article out of the trash. It returns sql.ErrNoRows
+// if the article isn't in the trash.
+func RestoreArticle(id int64) error {
+	logger.DualLog.Printf("Restoring article with ID: %d", id)
+	if err := restore("articles", id); err != nil {
+		return err
+	}
+	noteActivity(ActivityArticle, id, ActivityRestored)
+	return nil
+}
+
+// GetDeletedTasks lists deleted tasks, most recently deleted first.
+func GetDeletedTasks() ([]Task, error) {
+	logger.DualLog.Printf("Fetching deleted tasks")
+	return queryTasks("SELECT " + taskColumns + " FROM tasks WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+}
+
+// RestoreTask takes a task out of the trash. It returns sql.ErrNoRows if the
+// task isn't in the trash.
+func RestoreTask(id int64) error {
+	logger.DualLog.Printf("Restoring task with ID: %d", id)
+	if err := restore("tasks", id); err != nil {
+		return err
+	}
+	noteActivity(ActivityTask, id, ActivityRestored)
+	return nil
+}
+
+func restore(table string, id int64) error {
+	result, err := DB.Exec("UPDATE "+table+" SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		logger.DualLog.Printf("Error restoring %s %d: %s", table, id, err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// PurgeDeleted permanently deletes the articles, with their comments,
+// ratings, tags and views, and the tasks that were moved to the trash before the given time.
+// It returns how many articles and tasks were removed.
+func PurgeDeleted(before time.Time) (int64, int64, error) {
+	// deleted_at is stored as text, which only compares correctly in UTC
+	before = before.UTC()
+	logger.DualLog.Printf("Purging items deleted before %s", before.Format(time.RFC3339))
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	const purgedArticles = "SELECT id FROM articles WHERE deleted_at IS NOT NULL AND deleted_at < ?"
+	for _, query := range []string{
+		"DELETE FROM comments WHERE article_id IN (" + purgedArticles + ")",
+		"DELETE FROM article_ratings WHERE article_id IN (" + purgedArticles + ")",
+		"DELETE FROM article_tags WHERE article_id IN (" + purgedArticles + ")",
+		"DELETE FROM article_views WHERE article_id IN (" + purgedArticles + ")",
+	} {
+		if _, err := tx.Exec(query, before); err != nil {
+			logger.DualLog.Printf("Error purging deleted articles: %s", err.Error())
+			return 0, 0, err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM articles WHERE deleted_at IS NOT NULL AND deleted_at < ?", before)
+	if err != nil {
+		logger.DualLog.Printf("Error purging deleted articles: %s", err.Error())
+		return 0, 0, err
+	}
+	articles, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	result, err = tx.Exec("DELETE FROM tasks WHERE deleted_at IS NOT NULL AND deleted_at < ?", before)
+	if err != nil {
+		logger.DualLog.Printf("Error purging deleted tasks: %s", err.Error())
+		return 0, 0, err
+	}
+	tasks, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	logger.DualLog.Printf("Purged %d articles and %d tasks", articles, tasks)
+	return articles, tasks, nil
+}
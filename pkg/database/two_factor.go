@@ -0,0 +1,178 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rmacdiarmid/gptback/logger"
+)
+
+// TwoFactor is a user's authenticator app secret. Until ConfirmedAt is set
+// the user is still enrolling and it isn't asked for at sign-in.
+type TwoFactor struct {
+	UserID      int64
+	Secret      string
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+	// LastCounter is the time step of the last code accepted, which can't
+	// be used again
+	LastCounter int64
+}
+
+// createTwoFactorTables creates the table of authenticator secrets and the
+// recovery codes for when the authenticator is lost. Only hashes of the
+// recovery codes are stored.
+func createTwoFactorTables() error {
+	createTablesQuery := `
+		CREATE TABLE IF NOT EXISTS two_factor (
+			user_id INTEGER PRIMARY KEY REFERENCES user_account_6007(UserId),
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			confirmed_at TIMESTAMP,
+			last_counter INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			user_id INTEGER NOT NULL REFERENCES user_account_6007(UserId),
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP,
+			PRIMARY KEY (user_id, code_hash)
+		);
+	`
+
+	_, err := DB.Exec(createTablesQuery)
+	if err != nil {
+		logger.DualLog.Printf("Error creating two-factor tables: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetTwoFactor returns the user's authenticator secret, confirmed or not,
+// or sql.ErrNoRows if they have none.
+func GetTwoFactor(userID int64) (TwoFactor, error) {
+	var tf TwoFactor
+	var confirmedAt sql.NullTime
+	err := DB.QueryRow("SELECT user_id, secret, created_at, confirmed_at, last_counter FROM two_factor WHERE user_id = ?", userID).
+		Scan(&tf.UserID, &tf.Secret, &tf.CreatedAt, &confirmedAt, &tf.LastCounter)
+	if confirmedAt.Valid {
+		tf.ConfirmedAt = &confirmedAt.Time
+	}
+	return tf, err
+}
+
+// SetPendingTwoFactor stores a new, unconfirmed secret for the user,
+// replacing an earlier unconfirmed one. It returns sql.ErrNoRows if the
+// user already has a confirmed secret.
+func SetPendingTwoFactor(userID int64, secret string, now time.Time) error {
+	logger.DualLog.Printf("Starting two-factor enrolment of user %d", userID)
+
+	result, err := DB.Exec(`
+		INSERT INTO two_factor(user_id, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+		WHERE two_factor.confirmed_at IS NULL`,
+		userID, secret, now.UTC())
+	if err != nil {
+		logger.DualLog.Printf("Error storing two-factor secret: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// ConfirmTwoFactor turns on two-factor authentication for the user once
+// they have entered a code for the time step counter, replacing their
+// recovery codes with ones with the given hashes.
+func ConfirmTwoFactor(userID, counter int64, codeHashes []string, now time.Time) error {
+	logger.DualLog.Printf("Turning on two-factor authentication for user %d", userID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE two_factor SET confirmed_at = ?, last_counter = ? WHERE user_id = ? AND confirmed_at IS NULL",
+		now.UTC(), counter, userID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error confirming two-factor secret: %s", err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting recovery codes: %s", err.Error())
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes(user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			tx.Rollback()
+			logger.DualLog.Printf("Error storing recovery code: %s", err.Error())
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPCounter records that a code for the time step counter was used.
+// It returns sql.ErrNoRows if that step or a later one was used already,
+// so an intercepted code can't be replayed.
+func UseTOTPCounter(userID, counter int64) error {
+	result, err := DB.Exec("UPDATE two_factor SET last_counter = ? WHERE user_id = ? AND last_counter < ?", counter, userID, counter)
+	if err != nil {
+		logger.DualLog.Printf("Error using two-factor code: %s", err.Error())
+		return err
+	}
+	return requireAffected(result)
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. It returns
+// sql.ErrNoRows if they have no unused code with that hash.
+func UseRecoveryCode(userID int64, codeHash string, now time.Time) error {
+	result, err := DB.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		now.UTC(), userID, codeHash)
+	if err != nil {
+		logger.DualLog.Printf("Error using recovery code: %s", err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+	logger.DualLog.Printf("User %d used a recovery code", userID)
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// DeleteTwoFactor turns off two-factor authentication for the user,
+// removing their secret and recovery codes. It returns sql.ErrNoRows if they
+// had no secret.
+func DeleteTwoFactor(userID int64) error {
+	logger.DualLog.Printf("Turning off two-factor authentication for user %d", userID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting recovery codes: %s", err.Error())
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID)
+	if err != nil {
+		tx.Rollback()
+		logger.DualLog.Printf("Error deleting two-factor secret: %s", err.Error())
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
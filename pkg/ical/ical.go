@@ -0,0 +1,109 @@
+// Package ical writes iCalendar (RFC 5545) calendars of all-day events.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineLength is the longest a content line may be, in octets, before it
+// is folded.
+const maxLineLength = 75
+
+const dateFormat = "20060102"
+const dateTimeFormat = "20060102T150405Z"
+
+// Calendar is a calendar to publish, such as a feed subscribed to by a
+// calendar app.
+type Calendar struct {
+	// ProductID identifies the program that made the calendar, as a
+	// formal public identifier such as "-//Example//Tasks//EN"
+	ProductID string
+	// Name is shown by apps that support the X-WR-CALNAME extension
+	Name   string
+	Events []Event
+}
+
+// Event is an all-day event.
+type Event struct {
+	// UID identifies the event across versions of the calendar, so a
+	// changed event replaces the old one instead of being added again
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	Categories  []string
+	// Modified is when the event last changed
+	Modified time.Time
+}
+
+// Encode writes the calendar to w.
+func (c Calendar) Encode(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(out, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProductID)
+	line("CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escapeText(c.Name))
+	}
+	for _, event := range c.Events {
+		modified := event.Modified.UTC().Format(dateTimeFormat)
+		line("BEGIN", "VEVENT")
+		line("UID", escapeText(event.UID))
+		line("DTSTAMP", modified)
+		line("LAST-MODIFIED", modified)
+		line("DTSTART;VALUE=DATE", event.Date.Format(dateFormat))
+		line("DTEND;VALUE=DATE", event.Date.AddDate(0, 0, 1).Format(dateFormat))
+		line("SUMMARY", escapeText(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", escapeText(event.Description))
+		}
+		if len(event.Categories) > 0 {
+			categories := make([]string, len(event.Categories))
+			for i, category := range event.Categories {
+				categories[i] = escapeText(category)
+			}
+			line("CATEGORIES", strings.Join(categories, ","))
+		}
+		// Deadlines don't make anyone busy
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return out.Flush()
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// escapeText escapes a TEXT property value.
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// writeLine writes a content line ended by CRLF, folding it so no line is
+// longer than maxLineLength octets. Continuation lines start with a space,
+// and lines are only split between characters.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space counts towards the next line's length
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
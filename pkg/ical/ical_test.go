@@ -0,0 +1,67 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	calendar := Calendar{
+		ProductID: "-//Test//Tasks//EN",
+		Name:      "Tasks",
+		Events: []Event{{
+			UID:         "task-1@test",
+			Date:        time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			Summary:     "Ship it; then, celebrate",
+			Description: "Line one\nC:\\path",
+			Categories:  []string{"todo", "high"},
+			Modified:    time.Date(2024, 2, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600)),
+		}},
+	}
+
+	var out bytes.Buffer
+	assert.Nil(t, calendar.Encode(&out))
+	assert.Equal(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Test//Tasks//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Tasks",
+		"BEGIN:VEVENT",
+		"UID:task-1@test",
+		"DTSTAMP:20240201T083000Z",
+		"LAST-MODIFIED:20240201T083000Z",
+		"DTSTART;VALUE=DATE:20240229",
+		"DTEND;VALUE=DATE:20240301",
+		`SUMMARY:Ship it\; then\, celebrate`,
+		`DESCRIPTION:Line one\nC:\\path`,
+		"CATEGORIES:todo,high",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), out.String())
+}
+
+func TestLongLinesAreFolded(t *testing.T) {
+	summary := strings.Repeat("é", 100)
+	var out bytes.Buffer
+	assert.Nil(t, Calendar{Events: []Event{{Summary: summary}}}.Encode(&out))
+
+	var unfolded string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineLength, line)
+		assert.True(t, utf8.ValidString(line), "A character was split: %q", line)
+		if strings.HasPrefix(line, " ") {
+			unfolded += line[1:]
+		} else {
+			unfolded += "\n" + line
+		}
+	}
+	assert.Contains(t, unfolded, "\nSUMMARY:"+summary+"\n")
+}
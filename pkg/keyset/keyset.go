@@ -0,0 +1,279 @@
+// Package keyset signs and verifies JWTs with a set of keys, identified by
+// the kid header, so keys can be rotated: tokens are signed with one key
+// while those signed with the others are still accepted.
+package keyset
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Supported algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// minRSABits is the smallest RSA key accepted
+const minRSABits = 2048
+
+// Key is a key tokens are signed or verified with.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private signs tokens: an HMAC secret or a private key. It is nil for
+	// a key that only verifies, such as the public half of a retiring key.
+	private interface{}
+	// public verifies tokens: an HMAC secret or a public key
+	public interface{}
+}
+
+// CanSign reports whether the key can sign tokens as well as verify them.
+func (k Key) CanSign() bool {
+	return k.private != nil
+}
+
+// NewHMACKey returns an HS256 key for a shared secret.
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// LoadKey reads a key for an algorithm from a file; see ParseKey.
+func LoadKey(id, algorithm, path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParseKey(id, algorithm, data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// ParseKey parses a key for an algorithm. An HS256 key is the secret
+// itself, without surrounding whitespace. RS256 and EdDSA keys are PEM:
+// a private key in PKCS #8 (or PKCS #1 for RSA) form, which can sign, or a
+// PKIX public key, which can only verify.
+func ParseKey(id, algorithm string, data []byte) (Key, error) {
+	if id == "" {
+		return Key{}, fmt.Errorf("key has no ID")
+	}
+	switch algorithm {
+	case HS256:
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return Key{}, fmt.Errorf("HS256 secret is empty")
+		}
+		return NewHMACKey(id, secret), nil
+	case RS256, EdDSA:
+	default:
+		return Key{}, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("no PEM data found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	switch algorithm {
+	case RS256:
+		switch k := parsed.(type) {
+		case *rsa.PrivateKey:
+			if k.N.BitLen() < minRSABits {
+				return Key{}, fmt.Errorf("RSA key has %d bits; at least %d are needed", k.N.BitLen(), minRSABits)
+			}
+			return Key{ID: id, Method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+		case *rsa.PublicKey:
+			if k.N.BitLen() < minRSABits {
+				return Key{}, fmt.Errorf("RSA key has %d bits; at least %d are needed", k.N.BitLen(), minRSABits)
+			}
+			return Key{ID: id, Method: jwt.SigningMethodRS256, public: k}, nil
+		}
+	case EdDSA:
+		switch k := parsed.(type) {
+		case ed25519.PrivateKey:
+			return Key{ID: id, Method: SigningMethodEdDSA, private: k, public: k.Public()}, nil
+		case ed25519.PublicKey:
+			return Key{ID: id, Method: SigningMethodEdDSA, public: k}, nil
+		}
+	}
+	return Key{}, fmt.Errorf("a %T is not a %s key", parsed, algorithm)
+}
+
+// KeySet is the keys tokens are signed and verified with.
+type KeySet struct {
+	keys    []Key
+	byID    map[string]Key
+	signing Key
+}
+
+// NewVerifier returns a key set that only verifies tokens, such as the
+// keys of another issuer. Sign fails.
+func NewVerifier(keys []Key) (*KeySet, error) {
+	ks := &KeySet{keys: keys, byID: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if _, ok := ks.byID[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		ks.byID[key.ID] = key
+	}
+	return ks, nil
+}
+
+// New returns a key set that signs with the key whose ID is signingID, or
+// with the first key if signingID is empty, and verifies with all of them.
+func New(signingID string, keys []Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys")
+	}
+	if signingID == "" {
+		signingID = keys[0].ID
+	}
+	ks, err := NewVerifier(keys)
+	if err != nil {
+		return nil, err
+	}
+	signing, ok := ks.byID[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not one of the keys", signingID)
+	}
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("signing key %q is a public key", signingID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// SigningKeyID is the ID of the key new tokens are signed with.
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.ID
+}
+
+// Sign returns a token with the claims, signed by the signing key and
+// naming it in the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if !ks.signing.CanSign() {
+		return "", fmt.Errorf("key set has no signing key")
+	}
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc finds the key to verify a token with, for jwt.Parse. The token
+// must name one of the keys in its kid header and use that key's
+// algorithm, so a public key can't be passed off as an HMAC secret.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.Method.Alg(), token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key (RFC 7517) form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and public key of an EdDSA key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key returns the public key the JWK describes, which can only verify.
+// Keys without an alg are taken to be for the algorithm their type is
+// used with here.
+func (jwk JWK) Key() (Key, error) {
+	if jwk.KeyID == "" {
+		return Key{}, fmt.Errorf("key has no ID")
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return Key{}, fmt.Errorf("key %q is for %q, not signing", jwk.KeyID, jwk.Use)
+	}
+	switch {
+	case jwk.KeyType == "RSA" && (jwk.Algorithm == "" || jwk.Algorithm == RS256):
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return Key{}, fmt.Errorf("key %q: bad modulus: %v", jwk.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return Key{}, fmt.Errorf("key %q: bad exponent", jwk.KeyID)
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if public.N.BitLen() < minRSABits {
+			return Key{}, fmt.Errorf("RSA key %q has %d bits; at least %d are needed", jwk.KeyID, public.N.BitLen(), minRSABits)
+		}
+		return Key{ID: jwk.KeyID, Method: jwt.SigningMethodRS256, public: public}, nil
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519" && (jwk.Algorithm == "" || jwk.Algorithm == EdDSA):
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, fmt.Errorf("key %q: bad Ed25519 public key", jwk.KeyID)
+		}
+		return Key{ID: jwk.KeyID, Method: SigningMethodEdDSA, public: ed25519.PublicKey(x)}, nil
+	}
+	return Key{}, fmt.Errorf("key %q: unsupported %s key for %q", jwk.KeyID, jwk.KeyType, jwk.Algorithm)
+}
+
+// JWKS returns the public keys of the set, for clients that verify tokens
+// themselves. HMAC secrets are never included.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
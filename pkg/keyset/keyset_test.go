@@ -0,0 +1,168 @@
+package keyset
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestSignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	edPrivateDER, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	assert.Nil(t, err)
+	edPublicDER, err := x509.MarshalPKIXPublicKey(edPublic)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"hmac.key":   []byte("a shared secret\n"),
+		"rsa.pem":    pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		"ed.pem":     pemBlock("PRIVATE KEY", edPrivateDER),
+		"ed.pub.pem": pemBlock("PUBLIC KEY", edPublicDER),
+	}
+	for name, data := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	load := func(id, algorithm, file string) Key {
+		key, err := LoadKey(id, algorithm, filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("LoadKey(%s) returned error: %v", file, err)
+		}
+		return key
+	}
+	hmacKey := load("hmac", HS256, "hmac.key")
+	rsaSigner := load("rsa", RS256, "rsa.pem")
+	edSigner := load("ed", EdDSA, "ed.pem")
+	edVerifier := load("ed", EdDSA, "ed.pub.pem")
+	assert.False(t, edVerifier.CanSign())
+
+	claims := jwt.MapClaims{"sub": "1"}
+	for _, signer := range []Key{hmacKey, rsaSigner, edSigner} {
+		ks, err := New(signer.ID, []Key{hmacKey, rsaSigner, edSigner})
+		assert.Nil(t, err)
+		token, err := ks.Sign(claims)
+		assert.Nil(t, err, signer.ID)
+
+		parsed, err := jwt.Parse(token, ks.Keyfunc)
+		assert.Nil(t, err, signer.ID)
+		assert.Equal(t, signer.ID, parsed.Header["kid"])
+		assert.Equal(t, signer.Method.Alg(), parsed.Header["alg"])
+	}
+
+	// While a key is being retired, tokens it signed are still accepted
+	old, _ := New("ed", []Key{edSigner})
+	token, _ := old.Sign(claims)
+	rotated, err := New("rsa", []Key{rsaSigner, edVerifier})
+	assert.Nil(t, err)
+	_, err = jwt.Parse(token, rotated.Keyfunc)
+	assert.Nil(t, err, "A token signed by the retiring key was rejected")
+	retired, _ := New("rsa", []Key{rsaSigner})
+	_, err = jwt.Parse(token, retired.Keyfunc)
+	assert.NotNil(t, err, "A token signed by a removed key was accepted")
+
+	// A token can't pick another algorithm for a key, such as using the
+	// RSA public key as an HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa"
+	forgedToken, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	_, err = jwt.Parse(forgedToken, rotated.Keyfunc)
+	assert.NotNil(t, err)
+
+	// Only public keys are published
+	jwks := rotated.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+	assert.Equal(t, JWK{KeyType: "OKP", KeyID: "ed", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: jwks.Keys[1].X}, jwks.Keys[1])
+	hmacOnly, _ := New("", []Key{hmacKey})
+	assert.Empty(t, hmacOnly.JWKS().Keys)
+}
+
+func TestInvalidKeySets(t *testing.T) {
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edPrivateDER, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	edPublicDER, _ := x509.MarshalPKIXPublicKey(edPublic)
+	edPEM := pemBlock("PRIVATE KEY", edPrivateDER)
+
+	_, err = ParseKey("small", RS256, pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(smallKey)))
+	assert.NotNil(t, err, "A 1024-bit RSA key was accepted")
+	_, err = ParseKey("wrong", RS256, edPEM)
+	assert.NotNil(t, err, "An Ed25519 key was accepted for RS256")
+	_, err = ParseKey("empty", HS256, []byte("  \n"))
+	assert.NotNil(t, err, "An empty secret was accepted")
+	_, err = ParseKey("es", "ES256", edPEM)
+	assert.NotNil(t, err, "An unsupported algorithm was accepted")
+
+	a := NewHMACKey("a", []byte("secret"))
+	_, err = New("", nil)
+	assert.NotNil(t, err, "A key set without keys was created")
+	_, err = New("b", []Key{a})
+	assert.NotNil(t, err, "A missing signing key was accepted")
+	_, err = New("", []Key{a, a})
+	assert.NotNil(t, err, "Duplicate key IDs were accepted")
+	public, _ := ParseKey("public", EdDSA, pemBlock("PUBLIC KEY", edPublicDER))
+	_, err = New("public", []Key{public})
+	assert.NotNil(t, err, "A public key was accepted for signing")
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edPrivateDER, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	rsaSigner, err := ParseKey("rsa", RS256, pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)))
+	assert.Nil(t, err)
+	edSigner, err := ParseKey("ed", EdDSA, pemBlock("PRIVATE KEY", edPrivateDER))
+	assert.Nil(t, err)
+
+	for _, signer := range []Key{rsaSigner, edSigner} {
+		signing, err := New("", []Key{signer})
+		assert.Nil(t, err)
+		token, err := signing.Sign(jwt.MapClaims{"sub": "someone"})
+		assert.Nil(t, err)
+
+		var keys []Key
+		for _, jwk := range signing.JWKS().Keys {
+			key, err := jwk.Key()
+			assert.Nil(t, err)
+			assert.False(t, key.CanSign())
+			keys = append(keys, key)
+		}
+		verifying, err := NewVerifier(keys)
+		assert.Nil(t, err)
+		parsed, err := jwt.Parse(token, verifying.Keyfunc)
+		assert.Nil(t, err, "A %s token didn't verify with the key from the JWKS", signer.Method.Alg())
+		assert.True(t, parsed != nil && parsed.Valid)
+		_, err = verifying.Sign(jwt.MapClaims{})
+		assert.NotNil(t, err, "A verify-only key set signed a token")
+	}
+
+	for name, jwk := range map[string]JWK{
+		"without ID":      {KeyType: "OKP", Curve: "Ed25519", X: "AAAA"},
+		"for encryption":  {KeyType: "RSA", KeyID: "enc", Use: "enc"},
+		"of another type": {KeyType: "EC", KeyID: "ec", Algorithm: "ES256"},
+		"short Ed25519":   {KeyType: "OKP", KeyID: "ed", Curve: "Ed25519", X: "AAAA"},
+		"1024-bit RSA":    {KeyType: "RSA", KeyID: "rsa", N: strings.Repeat("A", 171), E: "AQAB"},
+	} {
+		_, err := jwk.Key()
+		assert.NotNil(t, err, "A JWK %s was accepted", name)
+	}
+}
@@ -0,0 +1,142 @@
+// Package mail sends plain-text email. A Sender delivers it: SMTPSender
+// through a mail server, FileSender and LogSender locally, for development
+// and tests.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a plain-text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// validate refuses messages whose headers would let a value inject more
+// headers or recipients.
+func (m Message) validate() error {
+	if m.To == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("message headers contain a line break")
+	}
+	return nil
+}
+
+// Format renders the message in RFC 5322 form, with the body
+// quoted-printable so any text survives transport.
+func (m Message) Format(from string, date time.Time) ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(from, "\r\n") {
+		return nil, fmt.Errorf("sender address contains a line break")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&buf)
+	if _, err := body.Write([]byte(strings.ReplaceAll(m.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SMTPSender sends email through a mail server. It signs in with PLAIN auth
+// when a username is set, which net/smtp only allows over TLS or to
+// localhost.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address
+	From string
+}
+
+// Send delivers a message through the server.
+func (s SMTPSender) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Format(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	port := s.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, strconv.Itoa(port)), auth, s.From, []string{msg.To}, data)
+}
+
+// FileSender writes each message to a file of its own in Dir, where it can
+// be opened with a mail client.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+var fileCount int64
+
+// Send writes a message to a new .eml file.
+func (s FileSender) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := msg.Format(s.From, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405.000000000"), atomic.AddInt64(&fileCount, 1))
+	return os.WriteFile(filepath.Join(s.Dir, name), data, 0600)
+}
+
+// LogSender prints messages to a logger instead of sending them.
+type LogSender struct {
+	Logger *log.Logger
+}
+
+// Send logs a message.
+func (s LogSender) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("Email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
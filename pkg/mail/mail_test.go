@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{To: "ann@example.com", Subject: "Réinitialiser", Body: "Hello,\nyour link: https://example.com/reset?token=abc=="}
+	data, err := msg.Format("site@example.com", time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+
+	parsed, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("The message couldn't be parsed: %v", err)
+	}
+	assert.Equal(t, "ann@example.com", parsed.Header.Get("To"))
+	assert.Equal(t, "site@example.com", parsed.Header.Get("From"))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Equal(t, "Réinitialiser", subject)
+	assert.Contains(t, string(data), "token=3Dabc=3D=3D", "The body should be quoted-printable")
+
+	for _, bad := range []Message{
+		{To: "ann@example.com\r\nBcc: everyone@example.com", Subject: "Hi"},
+		{To: "ann@example.com", Subject: "Hi\nBcc: everyone@example.com"},
+		{Subject: "Hi"},
+	} {
+		_, err := bad.Format("site@example.com", time.Now())
+		assert.NotNil(t, err, "%q was accepted", bad)
+	}
+}
+
+func TestLocalSenders(t *testing.T) {
+	msg := Message{To: "ann@example.com", Subject: "Hello", Body: "Hi Ann"}
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := FileSender{Dir: dir, From: "site@example.com"}
+	assert.Nil(t, sender.Send(context.Background(), msg))
+	assert.Nil(t, sender.Send(context.Background(), msg))
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2, "Each message should get a file of its own")
+	data, _ := os.ReadFile(files[0])
+	assert.Contains(t, string(data), "Hi Ann")
+
+	var buf bytes.Buffer
+	assert.Nil(t, LogSender{Logger: log.New(&buf, "", 0)}.Send(context.Background(), msg))
+	assert.True(t, strings.Contains(buf.String(), "ann@example.com") && strings.Contains(buf.String(), "Hi Ann"))
+}
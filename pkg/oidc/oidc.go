@@ -0,0 +1,405 @@
+// Package oidc signs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE. The provider's endpoints are found
+// by discovery, and ID tokens are checked against the keys it publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/pkg/keyset"
+)
+
+// DiscoveryPath is where a provider publishes its metadata, under its
+// issuer URL.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// leeway is how far the provider's clock may be off from ours
+const leeway = time.Minute
+
+// maxResponseSize is the most read from any response of the provider
+const maxResponseSize = 1 << 20
+
+// ErrInvalidIDToken is returned for an ID token that isn't signed by the
+// provider, isn't for this client, or has expired.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Metadata is the part of a provider's discovery document the client uses.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	// CodeChallengeMethods lists the PKCE methods the provider supports
+	CodeChallengeMethods []string `json:"code_challenge_methods_supported"`
+}
+
+// Discover fetches the metadata of the provider at issuer. The metadata
+// must name the same issuer, so a provider can't speak for another.
+func Discover(ctx context.Context, client *http.Client, issuer string) (Metadata, error) {
+	var metadata Metadata
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+DiscoveryPath, &metadata); err != nil {
+		return Metadata{}, fmt.Errorf("discovering %s: %v", issuer, err)
+	}
+	if metadata.Issuer != issuer {
+		return Metadata{}, fmt.Errorf("discovering %s: metadata is for issuer %q", issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return Metadata{}, fmt.Errorf("discovering %s: metadata is missing endpoints", issuer)
+	}
+	if len(metadata.CodeChallengeMethods) > 0 && !contains(metadata.CodeChallengeMethods, "S256") {
+		return Metadata{}, fmt.Errorf("discovering %s: provider doesn't support S256 PKCE", issuer)
+	}
+	return metadata, nil
+}
+
+// Config is how the client is registered with the provider.
+type Config struct {
+	// Issuer is the provider's issuer URL, which its metadata is found under
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the browser back to, with
+	// the authorization code
+	RedirectURL string
+	// Scopes are requested as well as openid, such as email and profile
+	Scopes []string
+	// HTTPClient makes the requests to the provider; http.DefaultClient if
+	// nil
+	HTTPClient *http.Client
+}
+
+// Client signs users in with one provider. Its metadata and keys are
+// fetched when first needed, and the keys again when the provider starts
+// signing with a new one.
+type Client struct {
+	config Config
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *keyset.KeySet
+}
+
+// NewClient returns a client for a provider. Nothing is fetched until the
+// client is used, so a provider being down doesn't stop anything starting.
+func NewClient(config Config) (*Client, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("issuer, client ID and redirect URL are needed")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &Client{config: config}, nil
+}
+
+// Metadata returns the provider's metadata, discovering it the first time.
+func (c *Client) Metadata(ctx context.Context) (Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata == nil {
+		metadata, err := Discover(ctx, c.config.HTTPClient, c.config.Issuer)
+		if err != nil {
+			return Metadata{}, err
+		}
+		c.metadata = &metadata
+	}
+	return *c.metadata, nil
+}
+
+// NewVerifier returns a random PKCE code verifier, to keep until the
+// authorization code is exchanged. It is also suitable as a state or nonce.
+func NewVerifier() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider's page to send the browser to to sign
+// in. state comes back with the code, to check it is the browser's own
+// sign-in; nonce comes back in the ID token; and verifier is needed to
+// exchange the code.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("bad authorization endpoint: %v", err)
+	}
+	scopes := []string{"openid"}
+	for _, scope := range c.config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// Tokens is the provider's response to exchanging a code.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Exchange trades an authorization code, and the verifier its challenge
+// was made from, for tokens. The ID token still has to be verified.
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (Tokens, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return Tokens{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if c.config.ClientSecret == "" {
+		form.Set("client_id", c.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Tokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("exchanging code: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return Tokens{}, fmt.Errorf("exchanging code: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return Tokens{}, fmt.Errorf("exchanging code: %s: %s", failure.Error, failure.Description)
+		}
+		return Tokens{}, fmt.Errorf("exchanging code: %s", resp.Status)
+	}
+	var tokens Tokens
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return Tokens{}, fmt.Errorf("exchanging code: %v", err)
+	}
+	if tokens.IDToken == "" {
+		return Tokens{}, fmt.Errorf("exchanging code: no ID token returned")
+	}
+	return tokens, nil
+}
+
+// Claims is what an ID token says about the user.
+type Claims struct {
+	Issuer  string
+	Subject string
+	// Email is only known to be the user's if EmailVerified is set
+	Email         string
+	EmailVerified bool
+	Name          string
+	ExpiresAt     time.Time
+}
+
+// VerifyIDToken checks that an ID token was signed by the provider for this
+// client, hasn't expired, and carries the nonce the sign-in was started
+// with, and returns its claims. The token should be one Exchange returned,
+// straight from the provider: one naming a key that isn't known makes the
+// provider's keys be fetched again.
+func (c *Client) VerifyIDToken(ctx context.Context, idToken, nonce string) (Claims, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	keys, err := c.keySet(ctx, false)
+	if err != nil {
+		return Claims{}, err
+	}
+	// The times are checked below, allowing for the provider's clock
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	parsed, err := parser.Parse(idToken, keys.Keyfunc)
+	if err != nil && unknownKey(idToken, keys) {
+		// The provider may have started signing with a new key
+		if keys, err = c.keySet(ctx, true); err != nil {
+			return Claims{}, err
+		}
+		parsed, err = parser.Parse(idToken, keys.Keyfunc)
+	}
+	if err != nil || !parsed.Valid {
+		return Claims{}, ErrInvalidIDToken
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	tokenNonce, _ := claims["nonce"].(string)
+	audience := stringList(claims["aud"])
+	authorizedParty, _ := claims["azp"].(string)
+	expiresAt, hasExpiry := claims["exp"].(float64)
+	issuedAt, _ := claims["iat"].(float64)
+	switch {
+	case issuer != metadata.Issuer, subject == "":
+		return Claims{}, ErrInvalidIDToken
+	case !contains(audience, c.config.ClientID):
+		return Claims{}, ErrInvalidIDToken
+	case len(audience) > 1 && authorizedParty != c.config.ClientID:
+		return Claims{}, ErrInvalidIDToken
+	case !hasExpiry || now.After(time.Unix(int64(expiresAt), 0).Add(leeway)):
+		return Claims{}, ErrInvalidIDToken
+	case time.Unix(int64(issuedAt), 0).After(now.Add(leeway)):
+		return Claims{}, ErrInvalidIDToken
+	case subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1:
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	return Claims{
+		Issuer:        issuer,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: isTrue(claims["email_verified"]),
+		Name:          name,
+		ExpiresAt:     time.Unix(int64(expiresAt), 0),
+	}, nil
+}
+
+// keySet returns the provider's keys, fetching them the first time, or
+// again if refresh is set.
+func (c *Client) keySet(ctx context.Context, refresh bool) (*keyset.KeySet, error) {
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys != nil && !refresh {
+		return c.keys, nil
+	}
+
+	var set keyset.JWKS
+	if err := getJSON(ctx, c.config.HTTPClient, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching provider keys: %v", err)
+	}
+	var keys []keyset.Key
+	for _, jwk := range set.Keys {
+		// Providers may publish keys for algorithms not used here
+		if key, err := jwk.Key(); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	verifier, err := keyset.NewVerifier(keys)
+	if err != nil {
+		return nil, fmt.Errorf("fetching provider keys: %v", err)
+	}
+	c.keys = verifier
+	return c.keys, nil
+}
+
+// unknownKey reports whether a token names a key that isn't in keys.
+func unknownKey(token string, keys *keyset.KeySet) bool {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	_, err = keys.Keyfunc(parsed)
+	return err != nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// stringList reads a claim that may be a string or a list of them.
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// isTrue reads a boolean claim, which some providers send as a string.
+func isTrue(claim interface{}) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rmacdiarmid/gptback/pkg/oidc"
+	"github.com/rmacdiarmid/gptback/pkg/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+const redirectURL = "https://app.example.com/auth/callback"
+
+func newClient(t *testing.T, provider *oidctest.Provider, secret string) *oidc.Client {
+	client, err := oidc.NewClient(oidc.Config{
+		Issuer:       provider.Issuer,
+		ClientID:     provider.ClientID,
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+		HTTPClient:   provider.Client(),
+	})
+	assert.Nil(t, err)
+	return client
+}
+
+// authorize goes to the provider's sign-in page as a browser would, and
+// returns the query it sends the browser back with.
+func authorize(t *testing.T, provider *oidctest.Provider, client *oidc.Client, state, nonce, verifier string) url.Values {
+	authURL, err := client.AuthCodeURL(context.Background(), state, nonce, verifier)
+	assert.Nil(t, err)
+	browser := provider.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := browser.Get(authURL)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	back, err := url.Parse(resp.Header.Get("Location"))
+	assert.Nil(t, err)
+	return back.Query()
+}
+
+func TestSignIn(t *testing.T) {
+	provider := oidctest.NewProvider("gptback", "client secret")
+	defer provider.Close()
+	provider.SignIn(oidctest.Identity{Subject: "u-1", Email: "someone@example.com", EmailVerified: true, Name: "Someone"})
+	client := newClient(t, provider, "client secret")
+	ctx := context.Background()
+
+	verifier, err := oidc.NewVerifier()
+	assert.Nil(t, err)
+	back := authorize(t, provider, client, "the state", "the nonce", verifier)
+	assert.Equal(t, "the state", back.Get("state"))
+
+	_, err = client.Exchange(ctx, back.Get("code"), "not the verifier")
+	assert.NotNil(t, err, "A code was exchanged without its verifier")
+	back = authorize(t, provider, client, "the state", "the nonce", verifier)
+	tokens, err := client.Exchange(ctx, back.Get("code"), verifier)
+	assert.Nil(t, err)
+	_, err = client.Exchange(ctx, back.Get("code"), verifier)
+	assert.NotNil(t, err, "A code was exchanged twice")
+
+	_, err = client.VerifyIDToken(ctx, tokens.IDToken, "another nonce")
+	assert.Equal(t, oidc.ErrInvalidIDToken, err, "An ID token with the wrong nonce was accepted")
+	claims, err := client.VerifyIDToken(ctx, tokens.IDToken, "the nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, oidc.Claims{
+		Issuer:        provider.Issuer,
+		Subject:       "u-1",
+		Email:         "someone@example.com",
+		EmailVerified: true,
+		Name:          "Someone",
+		ExpiresAt:     claims.ExpiresAt,
+	}, claims)
+
+	// A new signing key is picked up from the provider
+	assert.Nil(t, provider.RotateKey())
+	back = authorize(t, provider, client, "state", "nonce", verifier)
+	tokens, err = client.Exchange(ctx, back.Get("code"), verifier)
+	assert.Nil(t, err)
+	_, err = client.VerifyIDToken(ctx, tokens.IDToken, "nonce")
+	assert.Nil(t, err, "An ID token signed with a new key was rejected")
+
+	wrongSecret := newClient(t, provider, "wrong secret")
+	back = authorize(t, provider, wrongSecret, "state", "nonce", verifier)
+	_, err = wrongSecret.Exchange(ctx, back.Get("code"), verifier)
+	assert.NotNil(t, err, "A code was exchanged with the wrong client secret")
+}
+
+func TestInvalidIDTokens(t *testing.T) {
+	provider := oidctest.NewProvider("gptback", "")
+	defer provider.Close()
+	client := newClient(t, provider, "")
+	ctx := context.Background()
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   provider.Issuer,
+			"sub":   "u-1",
+			"aud":   provider.ClientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+	token, err := provider.SignIDToken(valid())
+	assert.Nil(t, err)
+	_, err = client.VerifyIDToken(ctx, token, "nonce")
+	assert.Nil(t, err)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"from another issuer":         func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"for another client":          func(c jwt.MapClaims) { c["aud"] = "another client" },
+		"for several other parties":   func(c jwt.MapClaims) { c["aud"] = []string{provider.ClientID, "another client"} },
+		"without a subject":           func(c jwt.MapClaims) { delete(c, "sub") },
+		"that has expired":            func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"without an expiry":           func(c jwt.MapClaims) { delete(c, "exp") },
+		"issued in the future":        func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"without the sign-in's nonce": func(c jwt.MapClaims) { delete(c, "nonce") },
+	} {
+		claims := valid()
+		change(claims)
+		token, err := provider.SignIDToken(claims)
+		assert.Nil(t, err)
+		_, err = client.VerifyIDToken(ctx, token, "nonce")
+		assert.Equal(t, oidc.ErrInvalidIDToken, err, "An ID token %s was accepted", name)
+	}
+
+	// The audience may list other parties if the token was issued to us
+	claims := valid()
+	claims["aud"] = []string{provider.ClientID, "another client"}
+	claims["azp"] = provider.ClientID
+	token, _ = provider.SignIDToken(claims)
+	_, err = client.VerifyIDToken(ctx, token, "nonce")
+	assert.Nil(t, err)
+
+	// A token signed with a key the provider doesn't publish
+	other := oidctest.NewProvider("gptback", "")
+	defer other.Close()
+	token, _ = other.SignIDToken(valid())
+	_, err = client.VerifyIDToken(ctx, token, "nonce")
+	assert.Equal(t, oidc.ErrInvalidIDToken, err, "An ID token signed by another key was accepted")
+}
+
+func TestDiscover(t *testing.T) {
+	provider := oidctest.NewProvider("gptback", "")
+	defer provider.Close()
+
+	metadata, err := oidc.Discover(context.Background(), provider.Client(), provider.Issuer)
+	assert.Nil(t, err)
+	assert.Equal(t, provider.Issuer+"/token", metadata.TokenEndpoint)
+
+	// The metadata has to be for the issuer asked about
+	_, err = oidc.Discover(context.Background(), provider.Client(), provider.Issuer+"/other")
+	assert.NotNil(t, err)
+
+	_, err = oidc.NewClient(oidc.Config{Issuer: provider.Issuer, ClientID: "gptback"})
+	assert.NotNil(t, err, "A client without a redirect URL was made")
+}
@@ -0,0 +1,312 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules used by
+// recurring tasks: daily, weekly and monthly frequencies with INTERVAL,
+// BYDAY, COUNT and UNTIL. Recurrences are by date; times of day are ignored
+// and every date is midnight UTC.
+package rrule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// Weekday is one BYDAY entry. N picks the Nth such weekday of the month,
+// counting from the end when negative; 0 means every one. N is only allowed
+// in monthly rules.
+type Weekday struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []Weekday
+	// Count limits the number of occurrences, and Until is the last date
+	// that may occur; at most one of them is set
+	Count int
+	Until *time.Time
+}
+
+// maxPeriods bounds the search for the next occurrence, so rules that
+// can't occur again, like the fifth Monday of every twelfth month, end.
+const maxPeriods = 10000
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH". An
+// "RRULE:" prefix is allowed.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+	if s == "" {
+		return Rule{}, fmt.Errorf("empty recurrence rule")
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+		if seen[name] {
+			return Rule{}, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			rule.Freq = Frequency(value)
+			if rule.Freq != Daily && rule.Freq != Weekly && rule.Freq != Monthly {
+				return Rule{}, fmt.Errorf("unsupported FREQ %s; use DAILY, WEEKLY or MONTHLY", value)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return Rule{}, fmt.Errorf("INTERVAL must be a positive integer")
+			}
+			rule.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return Rule{}, fmt.Errorf("COUNT must be a positive integer")
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return Rule{}, err
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, err := parseWeekday(code)
+				if err != nil {
+					return Rule{}, err
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "WKST":
+			// Weeks always start on Monday, the default
+			if value != "MO" {
+				return Rule{}, fmt.Errorf("only WKST=MO is supported")
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported recurrence rule part %s", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return Rule{}, fmt.Errorf("FREQ is required")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return Rule{}, fmt.Errorf("COUNT and UNTIL can't both be given")
+	}
+	if rule.Freq != Monthly {
+		for _, day := range rule.ByDay {
+			if day.N != 0 {
+				return Rule{}, fmt.Errorf("numbered BYDAY entries are only allowed with FREQ=MONTHLY")
+			}
+		}
+	}
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102", "20060102T150405Z", "20060102T150405"} {
+		if until, err := time.Parse(layout, value); err == nil {
+			return date(until), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must be a date such as 20240131")
+}
+
+func parseWeekday(code string) (Weekday, error) {
+	if len(code) < 2 {
+		return Weekday{}, fmt.Errorf("invalid BYDAY entry %q", code)
+	}
+	day, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("invalid BYDAY entry %q", code)
+	}
+	var n int
+	if prefix := code[:len(code)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Weekday{}, fmt.Errorf("invalid BYDAY entry %q", code)
+		}
+	}
+	return Weekday{N: n, Day: day}, nil
+}
+
+// String formats the rule in its canonical form.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			codes[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+	return strings.Join(parts, ";")
+}
+
+func (d Weekday) String() string {
+	code := strings.ToUpper(d.Day.String()[:2])
+	if d.N != 0 {
+		return strconv.Itoa(d.N) + code
+	}
+	return code
+}
+
+// Next returns the first date after the given one on which the rule, starting
+// on start, occurs. It returns false once the rule has no more occurrences.
+// The start date is always the first occurrence if it matches the rule.
+func (r Rule) Next(start, after time.Time) (time.Time, bool) {
+	start, after = date(start), date(after)
+	occurrences := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, day := range r.period(start, period) {
+			if day.Before(start) {
+				continue
+			}
+			if r.Until != nil && day.After(*r.Until) {
+				return time.Time{}, false
+			}
+			occurrences++
+			if r.Count > 0 && occurrences > r.Count {
+				return time.Time{}, false
+			}
+			if day.After(after) {
+				return day, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// First returns the first occurrence of the rule starting on start.
+func (r Rule) First(start time.Time) (time.Time, bool) {
+	return r.Next(start, date(start).AddDate(0, 0, -1))
+}
+
+// period lists the dates in order that the rule picks from the nth period
+// (day, week or month) after the one containing start.
+func (r Rule) period(start time.Time, n int) []time.Time {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	switch r.Freq {
+	case Daily:
+		day := start.AddDate(0, 0, n*interval)
+		if len(r.ByDay) > 0 && !r.hasWeekday(day.Weekday()) {
+			return nil
+		}
+		return []time.Time{day}
+
+	case Weekly:
+		monday := start.AddDate(0, 0, -mondayOffset(start.Weekday())+7*n*interval)
+		if len(r.ByDay) == 0 {
+			return []time.Time{monday.AddDate(0, 0, mondayOffset(start.Weekday()))}
+		}
+		days := make([]time.Time, 0, len(r.ByDay))
+		for _, day := range r.ByDay {
+			days = append(days, monday.AddDate(0, 0, mondayOffset(day.Day)))
+		}
+		return sortDays(days)
+
+	case Monthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(n*interval), 1, 0, 0, 0, 0, time.UTC)
+		if len(r.ByDay) == 0 {
+			day := first.AddDate(0, 0, start.Day()-1)
+			// Months without the start's day of the month are skipped
+			if day.Month() != first.Month() {
+				return nil
+			}
+			return []time.Time{day}
+		}
+		var days []time.Time
+		for _, weekday := range r.ByDay {
+			var matches []time.Time
+			for day := first; day.Month() == first.Month(); day = day.AddDate(0, 0, 1) {
+				if day.Weekday() == weekday.Day {
+					matches = append(matches, day)
+				}
+			}
+			switch {
+			case weekday.N == 0:
+				days = append(days, matches...)
+			case weekday.N > 0 && weekday.N <= len(matches):
+				days = append(days, matches[weekday.N-1])
+			case weekday.N < 0 && -weekday.N <= len(matches):
+				days = append(days, matches[len(matches)+weekday.N])
+			}
+		}
+		return sortDays(days)
+	}
+	return nil
+}
+
+func (r Rule) hasWeekday(weekday time.Weekday) bool {
+	for _, day := range r.ByDay {
+		if day.Day == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// mondayOffset is how many days into a Monday-first week the weekday falls.
+func mondayOffset(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+// sortDays sorts dates and drops duplicates.
+func sortDays(days []time.Time) []time.Time {
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	unique := days[:0]
+	for _, day := range days {
+		if len(unique) == 0 || !day.Equal(unique[len(unique)-1]) {
+			unique = append(unique, day)
+		}
+	}
+	return unique
+}
+
+// date truncates t to midnight UTC on the same calendar day.
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,89 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// occurrences lists the first n occurrences of rule from start.
+func occurrences(t *testing.T, rule string, start string, n int) []string {
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", rule, err)
+	}
+	var dates []string
+	next, ok := r.First(day(start))
+	for ; ok && len(dates) < n; next, ok = r.Next(day(start), next) {
+		dates = append(dates, next.Format("2006-01-02"))
+	}
+	return dates
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		rule  string
+		start string
+		want  []string
+	}{
+		{"FREQ=DAILY", "2024-02-28", []string{"2024-02-28", "2024-02-29", "2024-03-01"}},
+		{"FREQ=DAILY;INTERVAL=3", "2024-01-01", []string{"2024-01-01", "2024-01-04", "2024-01-07"}},
+		// 2024-01-01 is a Monday
+		{"FREQ=DAILY;BYDAY=MO,FR", "2024-01-02", []string{"2024-01-05", "2024-01-08", "2024-01-12"}},
+		{"FREQ=WEEKLY", "2024-01-03", []string{"2024-01-03", "2024-01-10", "2024-01-17"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TH,MO", "2024-01-03", []string{"2024-01-04", "2024-01-15", "2024-01-18", "2024-01-29"}},
+		{"FREQ=WEEKLY;BYDAY=SU", "2024-01-01", []string{"2024-01-07", "2024-01-14"}},
+		{"FREQ=MONTHLY", "2024-01-31", []string{"2024-01-31", "2024-03-31", "2024-05-31"}},
+		{"FREQ=MONTHLY;INTERVAL=2", "2024-01-15", []string{"2024-01-15", "2024-03-15", "2024-05-15"}},
+		{"FREQ=MONTHLY;BYDAY=1MO", "2024-01-01", []string{"2024-01-01", "2024-02-05", "2024-03-04"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "2024-01-01", []string{"2024-01-26", "2024-02-23", "2024-03-29"}},
+		{"FREQ=MONTHLY;BYDAY=1TU,3TU", "2024-01-10", []string{"2024-01-16", "2024-02-06", "2024-02-20"}},
+		{"FREQ=DAILY;COUNT=2", "2024-01-01", []string{"2024-01-01", "2024-01-02"}},
+		{"FREQ=WEEKLY;UNTIL=20240115", "2024-01-01", []string{"2024-01-01", "2024-01-08", "2024-01-15"}},
+		{"RRULE:freq=weekly;byday=we", "2024-01-01", []string{"2024-01-03", "2024-01-10"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, occurrences(t, test.rule, test.start, len(test.want)+2)[:len(test.want)], test.rule)
+	}
+
+	assert.Len(t, occurrences(t, "FREQ=DAILY;COUNT=2", "2024-01-01", 10), 2)
+	assert.Len(t, occurrences(t, "FREQ=WEEKLY;UNTIL=20240115", "2024-01-01", 10), 3)
+
+	// Next skips to the first occurrence after the given date
+	r, _ := Parse("FREQ=WEEKLY;BYDAY=MO")
+	next, ok := r.Next(day("2024-01-01"), day("2024-03-13"))
+	assert.True(t, ok)
+	assert.Equal(t, day("2024-03-18"), next)
+}
+
+func TestParse(t *testing.T) {
+	r, err := Parse("FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR;UNTIL=20241231T235959Z")
+	assert.Nil(t, err)
+	assert.Equal(t, "FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR;UNTIL=20241231", r.String())
+
+	for _, rule := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=DAILY;COUNT=3;UNTIL=20240101",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ",
+	} {
+		_, err := Parse(rule)
+		assert.NotNil(t, err, rule)
+	}
+}
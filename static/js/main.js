@@ -1,24 +1,109 @@
+const TASKS_API = "/api/v1/tasks";
+const TASK_PAGE_SIZE = 20;
+const RECENT_ACTIVITY_SIZE = 10;
+
+let taskFilters = new URLSearchParams();
+let taskOffset = 0;
+
 document.addEventListener("DOMContentLoaded", function () {
     // Attach event listener to the form
     var form = document.getElementById("taskForm");
     if (form) {
       form.addEventListener("submit", submitTaskForm);
     }
-  
+
+    var search = document.getElementById("task-search");
+    if (search) {
+      taskFilters = readTaskFilters(search);
+      search.addEventListener("submit", function (event) {
+        event.preventDefault();
+        taskFilters = readTaskFilters(search);
+        taskOffset = 0;
+        fetchTaskList();
+      });
+      search.addEventListener("change", function () {
+        search.requestSubmit();
+      });
+    }
+
+    var prev = document.getElementById("task-prev");
+    var next = document.getElementById("task-next");
+    if (prev && next) {
+      prev.addEventListener("click", function () {
+        taskOffset = Math.max(0, taskOffset - TASK_PAGE_SIZE);
+        fetchTaskList();
+      });
+      next.addEventListener("click", function () {
+        taskOffset += TASK_PAGE_SIZE;
+        fetchTaskList();
+      });
+    }
+
     // Fetch the task list if the page contains the task list table
     if (document.getElementById("task-list")) {
       fetchTaskList();
     }
+
+    var board = document.querySelector(".task-board");
+    if (board) {
+      initTaskBoard(board);
+    }
+
+    var activity = document.getElementById("recent-activity");
+    if (activity) {
+      followActivity(activity);
+    }
   });
-  
+
+  // apiError turns a JSON error body from the API into an Error.
+  function apiError(response) {
+    return response
+      .json()
+      .catch(() => ({}))
+      .then((body) => {
+        const message = body.error && body.error.message ? body.error.message : response.statusText;
+        return new Error(message);
+      });
+  }
+
+  // readTaskFilters returns the filled-in fields of the task search form as
+  // tasks API query parameters.
+  function readTaskFilters(form) {
+    const params = new URLSearchParams();
+    new FormData(form).forEach((value, name) => {
+      value = value.trim();
+      if (value) {
+        params.set(name, value);
+      }
+    });
+    return params;
+  }
+
+  // assigneeNames maps user IDs to the names listed in the assignee picker.
+  function assigneeNames() {
+    const names = {};
+    document.querySelectorAll("#assignee option").forEach((option) => {
+      if (option.value) {
+        names[option.value] = option.textContent;
+      }
+    });
+    return names;
+  }
+
   function submitTaskForm(event) {
     event.preventDefault();
-  
-    const title = document.getElementById("title").value;
-    const description = document.getElementById("description").value;
-    const task = { title: title, description: description };
-  
-    fetch("/tasks", {
+
+    const assignee = document.getElementById("assignee").value;
+    const task = {
+      title: document.getElementById("title").value,
+      description: document.getElementById("description").value,
+      status: document.getElementById("status").value,
+      priority: document.getElementById("priority").value,
+      dueDate: document.getElementById("dueDate").value || null,
+      assigneeId: assignee ? Number(assignee) : null,
+    };
+
+    fetch(TASKS_API, {
       method: "POST",
       headers: {
         "Content-Type": "application/json",
@@ -31,51 +116,224 @@ document.addEventListener("DOMContentLoaded", function () {
           document.getElementById("taskForm").reset();
           fetchTaskList(); // Refresh the task list
         } else {
-          alert("Error creating task: " + response.statusText);
+          return apiError(response).then((error) => {
+            throw error;
+          });
         }
       })
       .catch((error) => {
-        alert("Error creating task: " + error);
+        alert("Error creating task: " + error.message);
       });
   }
-  
+
   function fetchTaskList() {
-    fetch("/tasks")
+    const params = new URLSearchParams(taskFilters);
+    params.set("limit", TASK_PAGE_SIZE);
+    params.set("offset", taskOffset);
+
+    fetch(TASKS_API + "?" + params.toString())
       .then((response) => {
         if (response.ok) {
           return response.json();
-        } else {
-          throw new Error("Error fetching task list: " + response.statusText);
         }
+        return apiError(response).then((error) => {
+          throw error;
+        });
       })
-      .then((tasks) => {
-        displayTaskList(tasks);
+      .then((page) => {
+        displayTaskList(page.tasks);
+        displayTaskPager(page);
       })
       .catch((error) => {
-        alert(error);
+        alert("Error fetching task list: " + error.message);
       });
   }
-  
+
   function displayTaskList(tasks) {
     const taskList = document.getElementById("task-list");
+    const names = assigneeNames();
     taskList.innerHTML = "";
-  
+
     tasks.forEach((task) => {
       const row = document.createElement("tr");
-  
-      const idCell = document.createElement("td");
-      idCell.textContent = task.id;
-      row.appendChild(idCell);
-  
-      const titleCell = document.createElement("td");
-      titleCell.textContent = task.title;
-      row.appendChild(titleCell);
-  
-      const descriptionCell = document.createElement("td");
-      descriptionCell.textContent = task.description;
-      row.appendChild(descriptionCell);
-  
+
+      const addCell = (text, className) => {
+        const cell = document.createElement("td");
+        if (className) {
+          const span = document.createElement("span");
+          span.className = className;
+          span.textContent = text;
+          cell.appendChild(span);
+        } else {
+          cell.textContent = text;
+        }
+        row.appendChild(cell);
+        return cell;
+      };
+
+      addCell(task.id);
+      addCell(task.title);
+      addCell(task.description);
+      addCell(task.status, "task-status task-status-" + task.status);
+      addCell(task.priority, "task-priority task-priority-" + task.priority);
+      addCell(task.dueDate || "");
+      addCell(task.assigneeId ? names[task.assigneeId] || "#" + task.assigneeId : "");
+
+      const updated = document.createElement("time");
+      updated.dateTime = task.updatedAt;
+      updated.textContent = new Date(task.updatedAt).toLocaleString();
+      addCell("").appendChild(updated);
+
       taskList.appendChild(row);
     });
   }
-  
\ No newline at end of file
+
+  function displayTaskPager(page) {
+    const info = document.getElementById("task-page-info");
+    if (!info) {
+      return;
+    }
+    const first = page.total === 0 ? 0 : page.offset + 1;
+    const last = page.offset + page.tasks.length;
+    info.textContent = first + "–" + last + " of " + page.total;
+    document.getElementById("task-prev").disabled = page.offset === 0;
+    document.getElementById("task-next").disabled = last >= page.total;
+  }
+
+  // initTaskBoard lets cards be dragged within and between board columns,
+  // saving each move.
+  function initTaskBoard(board) {
+    let dragged = null;
+    let fromList = null;
+
+    board.addEventListener("dragstart", (event) => {
+      dragged = event.target.closest(".board-card");
+      if (!dragged) {
+        return;
+      }
+      fromList = dragged.parentElement;
+      dragged.classList.add("dragging");
+      event.dataTransfer.effectAllowed = "move";
+      event.dataTransfer.setData("text/plain", dragged.dataset.id);
+    });
+
+    board.addEventListener("dragend", () => {
+      if (dragged) {
+        dragged.classList.remove("dragging");
+      }
+      dragged = null;
+    });
+
+    board.addEventListener("dragover", (event) => {
+      const list = event.target.closest(".board-cards");
+      if (!list || !dragged) {
+        return;
+      }
+      event.preventDefault();
+      const below = cardBelow(list, event.clientY);
+      if (below) {
+        list.insertBefore(dragged, below);
+      } else {
+        list.appendChild(dragged);
+      }
+    });
+
+    board.addEventListener("drop", (event) => {
+      if (!dragged) {
+        return;
+      }
+      event.preventDefault();
+      const list = dragged.parentElement;
+      const previous = dragged.previousElementSibling;
+      const next = dragged.nextElementSibling;
+      const move = {
+        status: list.dataset.status,
+        beforeId: next ? Number(next.dataset.id) : 0,
+        afterId: previous ? Number(previous.dataset.id) : 0,
+      };
+      const source = fromList;
+      moveTask(dragged.dataset.id, move).then(() => {
+        if (source !== list) {
+          adjustBoardCount(source, -1);
+          adjustBoardCount(list, 1);
+        }
+      });
+    });
+  }
+
+  // cardBelow returns the card in list that a card dropped at y goes before.
+  function cardBelow(list, y) {
+    const cards = list.querySelectorAll(".board-card:not(.dragging)");
+    for (const card of cards) {
+      const box = card.getBoundingClientRect();
+      if (y < box.top + box.height / 2) {
+        return card;
+      }
+    }
+    return null;
+  }
+
+  function adjustBoardCount(list, delta) {
+    const count = list.parentElement.querySelector(".board-count");
+    if (count) {
+      count.dataset.total = Number(count.dataset.total) + delta;
+      count.textContent = count.dataset.total;
+    }
+  }
+
+  function moveTask(id, move) {
+    return fetch(TASKS_API + "/" + id + "/move", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(move),
+    })
+      .then((response) => {
+        if (!response.ok) {
+          return apiError(response).then((error) => {
+            throw error;
+          });
+        }
+      })
+      .catch((error) => {
+        alert("Error moving task: " + error.message);
+        window.location.reload();
+        throw error;
+      });
+  }
+
+  // followActivity adds activity to the list as it happens, and refreshes
+  // the task list when a task changes. The browser reconnects by itself if
+  // the stream drops, carrying on from the last entry received.
+  function followActivity(list) {
+    const source = new EventSource("/activity/stream?since=" + encodeURIComponent(list.dataset.since || 0));
+    let refresh = null;
+
+    source.addEventListener("activity", (event) => {
+      const entry = JSON.parse(event.data);
+      list.prepend(activityItem(entry));
+      while (list.children.length > RECENT_ACTIVITY_SIZE) {
+        list.lastElementChild.remove();
+      }
+
+      // A burst of changes, such as a board being rearranged, refreshes the
+      // list once
+      if (entry.subjectType === "task" && document.getElementById("task-list")) {
+        clearTimeout(refresh);
+        refresh = setTimeout(fetchTaskList, 250);
+      }
+    });
+  }
+
+  function activityItem(entry) {
+    const item = document.createElement("li");
+    item.className = "activity-" + entry.subjectType;
+
+    const time = document.createElement("time");
+    time.dateTime = entry.createdAt;
+    time.textContent = new Date(entry.createdAt).toLocaleString();
+    item.appendChild(time);
+    item.appendChild(document.createTextNode(entry.summary));
+    return item;
+  }